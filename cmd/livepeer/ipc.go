@@ -0,0 +1,435 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ethereum/go-ethereum/node"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/livepeer/livepeer-swarm/cmd/utils"
+	lp "github.com/livepeer/livepeer-swarm/livepeer"
+	"github.com/livepeer/livepeer-swarm/livepeer/storage"
+	"github.com/livepeer/livepeer-swarm/livepeer/transcoding"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// Subcommands that manage a running node through its IPC endpoint
+
+var (
+	JSONOutputFlag = cli.BoolFlag{
+		Name:  "json",
+		Usage: "Print JSON instead of a table",
+	}
+	TokenTTLFlag = cli.DurationFlag{
+		Name:  "ttl",
+		Usage: "Time the playback token is valid for",
+		Value: time.Hour,
+	}
+	TokenViewerFlag = cli.StringFlag{
+		Name:  "viewer",
+		Usage: "Viewer ID the playback token is bound to, players pass it in the viewer query parameter",
+	}
+	ScrubDeleteFlag = cli.BoolFlag{
+		Name:  "delete",
+		Usage: "Delete the corrupt, missing and orphaned entries",
+	}
+	ScrubRefetchFlag = cli.BoolFlag{
+		Name:  "refetch",
+		Usage: "Retrieve the deleted chunks again from peers, implies --delete",
+	}
+	ExportRootFlag = cli.StringSliceFlag{
+		Name:  "root",
+		Usage: "Only export the chunks under a root hash, can be repeated",
+	}
+	TranscodeProfileFlag = cli.StringSliceFlag{
+		Name:  "profile",
+		Usage: "Transcoding profile to request, can be repeated or comma separated",
+	}
+)
+
+// stdout is where the subcommands print their output
+var stdout io.Writer = os.Stdout
+
+var ipcCommands = []cli.Command{
+	{
+		Name:  "streams",
+		Usage: "Manage the streams of a running node",
+		Subcommands: []cli.Command{
+			{
+				Action:    listStreams,
+				Name:      "ls",
+				Usage:     "List the streams originated or relayed by the node",
+				ArgsUsage: " ",
+				Flags:     []cli.Flag{JSONOutputFlag},
+			},
+			{
+				Action:    streamInfo,
+				Name:      "info",
+				Usage:     "Describe a stream and print its metrics",
+				ArgsUsage: "<streamID>",
+				Flags:     []cli.Flag{JSONOutputFlag},
+			},
+			{
+				Action:    stopStream,
+				Name:      "stop",
+				Usage:     "End a stream",
+				ArgsUsage: "<streamID>",
+			},
+			{
+				Action:    playbackToken,
+				Name:      "token",
+				Usage:     "Sign a playback token for a stream of the node",
+				ArgsUsage: "<streamID>",
+				Flags:     []cli.Flag{TokenTTLFlag, TokenViewerFlag},
+			},
+		},
+	},
+	{
+		Name:  "db",
+		Usage: "Maintain the chunk store of a running node",
+		Subcommands: []cli.Command{
+			{
+				Action:      scrubDb,
+				Name:        "scrub",
+				Usage:       "Check that the stored chunks hash to their keys",
+				ArgsUsage:   " ",
+				Flags:       []cli.Flag{ScrubDeleteFlag, ScrubRefetchFlag, JSONOutputFlag},
+				Description: "Every entry of the chunk store is read and hashed, the node keeps serving while the scrub runs",
+			},
+			{
+				Action:      exportDb,
+				Name:        "export",
+				Usage:       "Write the chunks of the node to an archive",
+				ArgsUsage:   "<file>",
+				Flags:       []cli.Flag{ExportRootFlag},
+				Description: "The archive is a tar file with an entry per chunk named by its hex key, it is written by the node",
+			},
+			{
+				Action:      importDb,
+				Name:        "import",
+				Usage:       "Store the chunks of an archive in the node",
+				ArgsUsage:   "<file>",
+				Description: "Every chunk is checked against its key, the import stops at the first invalid chunk",
+			},
+		},
+	},
+	{
+		Action:    listPeers,
+		Name:      "peers",
+		Usage:     "List the peers of a running node",
+		ArgsUsage: " ",
+		Flags:     []cli.Flag{JSONOutputFlag},
+	},
+	{
+		Action:      transcode,
+		Name:        "transcode",
+		Usage:       "Request transcoding of a stream",
+		ArgsUsage:   "<streamID>",
+		Flags:       []cli.Flag{TranscodeProfileFlag, JSONOutputFlag},
+		Description: "The network is asked to transcode the HLS stream into the given profiles, e.g. --profile P720p30fps4Mbps --profile P360p30fps700Kbps",
+	},
+	{
+		Action:    status,
+		Name:      "status",
+		Usage:     "Print the status of a running node",
+		ArgsUsage: " ",
+		Flags:     []cli.Flag{JSONOutputFlag},
+	},
+}
+
+// attach dials the IPC endpoint of the node running with the same --datadir
+// and --ipcpath
+func attach(ctx *cli.Context) (*rpc.Client, error) {
+	config := &node.Config{DataDir: utils.MakeDataDir(ctx), IPCPath: utils.MakeIPCPath(ctx)}
+	endpoint := config.IPCEndpoint()
+	if endpoint == "" {
+		return nil, fmt.Errorf("IPC is disabled")
+	}
+	client, err := rpc.Dial(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to attach to %v: %v", endpoint, err)
+	}
+	return client, nil
+}
+
+// call attaches to the node and calls a method
+func call(ctx *cli.Context, result interface{}, method string, args ...interface{}) error {
+	client, err := attach(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return client.Call(result, method, args...)
+}
+
+func streamIDArg(ctx *cli.Context) (string, error) {
+	if len(ctx.Args()) != 1 {
+		return "", fmt.Errorf("Need a stream ID")
+	}
+	return ctx.Args().First(), nil
+}
+
+func printJSON(v interface{}) error {
+	js, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, string(js))
+	return nil
+}
+
+func newTable() *tabwriter.Writer {
+	return tabwriter.NewWriter(stdout, 0, 8, 2, ' ', 0)
+}
+
+func listStreams(ctx *cli.Context) error {
+	var streams []lp.StreamInfo
+	if err := call(ctx, &streams, "livepeer_streams"); err != nil {
+		return err
+	}
+	if ctx.Bool(JSONOutputFlag.Name) {
+		return printJSON(streams)
+	}
+	w := newTable()
+	fmt.Fprintln(w, "STREAM\tFORMAT\tSOURCE\tSUBSCRIBERS\tTRANSCODED")
+	for _, s := range streams {
+		fmt.Fprintf(w, "%v\t%v\t%v\t%d\t%d\n", s.StreamID, s.Format, source(s), len(s.Subscribers), len(s.Transcoded))
+	}
+	return w.Flush()
+}
+
+func source(s lp.StreamInfo) string {
+	if s.Local {
+		return "local"
+	}
+	return s.Origin.Hex()[:10]
+}
+
+func streamInfo(ctx *cli.Context) error {
+	id, err := streamIDArg(ctx)
+	if err != nil {
+		return err
+	}
+	var info lp.StreamInfo
+	if err := call(ctx, &info, "livepeer_stream", id); err != nil {
+		return err
+	}
+	var metrics lp.StreamMetrics
+	if err := call(ctx, &metrics, "livepeer_streamMetrics", id); err != nil {
+		return err
+	}
+	if ctx.Bool(JSONOutputFlag.Name) {
+		return printJSON(struct {
+			lp.StreamInfo
+			Metrics lp.StreamMetrics
+		}{info, metrics})
+	}
+
+	w := newTable()
+	fmt.Fprintf(w, "Stream:\t%v\n", info.StreamID)
+	fmt.Fprintf(w, "Format:\t%v\n", info.Format)
+	fmt.Fprintf(w, "Source:\t%v\n", source(info))
+	fmt.Fprintf(w, "Uptime:\t%v\n", metrics.Uptime)
+	fmt.Fprintf(w, "Buffered:\t%d\n", metrics.Buffered)
+	fmt.Fprintf(w, "In:\t%d chunks, %d bytes\n", metrics.ChunksIn, metrics.BytesIn)
+	fmt.Fprintf(w, "Out:\t%d chunks, %d bytes\n", metrics.ChunksOut, metrics.BytesOut)
+	fmt.Fprintf(w, "Subscribers:\t%v\n", strings.Join(info.Subscribers, ", "))
+	for _, t := range info.Transcoded {
+		fmt.Fprintf(w, "Transcoded:\t%v (%v)\n", t.StreamID, t.Profile)
+	}
+	return w.Flush()
+}
+
+func stopStream(ctx *cli.Context) error {
+	id, err := streamIDArg(ctx)
+	if err != nil {
+		return err
+	}
+	if err := call(ctx, nil, "livepeer_stopStream", id); err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, "Stopped", id)
+	return nil
+}
+
+func playbackToken(ctx *cli.Context) error {
+	id, err := streamIDArg(ctx)
+	if err != nil {
+		return err
+	}
+	ttl := uint64(ctx.Duration(TokenTTLFlag.Name) / time.Second)
+	var token string
+	if err := call(ctx, &token, "livepeer_playbackToken", id, ttl, ctx.String(TokenViewerFlag.Name)); err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, token)
+	return nil
+}
+
+func scrubDb(ctx *cli.Context) error {
+	refetch := ctx.Bool(ScrubRefetchFlag.Name)
+	remove := refetch || ctx.Bool(ScrubDeleteFlag.Name)
+	var report storage.ScrubReport
+	if err := call(ctx, &report, "bzz_scrub", remove, refetch); err != nil {
+		return err
+	}
+	if ctx.Bool(JSONOutputFlag.Name) {
+		return printJSON(report)
+	}
+
+	w := newTable()
+	fmt.Fprintf(w, "Checked:\t%d chunks in %v\n", report.Checked, report.Duration)
+	for _, key := range report.Corrupt {
+		fmt.Fprintf(w, "Corrupt:\t%v\n", key)
+	}
+	for _, key := range report.Missing {
+		fmt.Fprintf(w, "Missing data:\t%v\n", key)
+	}
+	fmt.Fprintf(w, "Orphaned data:\t%d\n", report.Orphaned)
+	if remove {
+		fmt.Fprintf(w, "Deleted:\t%d\n", report.Deleted)
+	}
+	if refetch {
+		fmt.Fprintf(w, "Refetched:\t%d\n", len(report.Refetched))
+		for _, key := range report.Failed {
+			fmt.Fprintf(w, "Not retrieved:\t%v\n", key)
+		}
+	}
+	return w.Flush()
+}
+
+// archiveArg returns the absolute path of the archive, the node opens it
+// from its own working directory
+func archiveArg(ctx *cli.Context) (string, error) {
+	if len(ctx.Args()) != 1 {
+		return "", fmt.Errorf("Need an archive file")
+	}
+	return filepath.Abs(ctx.Args().First())
+}
+
+func exportDb(ctx *cli.Context) error {
+	path, err := archiveArg(ctx)
+	if err != nil {
+		return err
+	}
+	roots := ctx.StringSlice(ExportRootFlag.Name)
+	if roots == nil {
+		roots = []string{}
+	}
+	var count int64
+	if err := call(ctx, &count, "bzz_export", path, roots); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Exported %d chunks to %v\n", count, path)
+	return nil
+}
+
+func importDb(ctx *cli.Context) error {
+	path, err := archiveArg(ctx)
+	if err != nil {
+		return err
+	}
+	var count int64
+	if err := call(ctx, &count, "bzz_import", path); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Imported %d chunks from %v\n", count, path)
+	return nil
+}
+
+func listPeers(ctx *cli.Context) error {
+	var peers []*p2p.PeerInfo
+	if err := call(ctx, &peers, "admin_peers"); err != nil {
+		return err
+	}
+	if ctx.Bool(JSONOutputFlag.Name) {
+		return printJSON(peers)
+	}
+	w := newTable()
+	fmt.Fprintln(w, "ID\tNAME\tADDRESS")
+	for _, p := range peers {
+		fmt.Fprintf(w, "%v\t%v\t%v\n", shortID(p.ID), p.Name, p.Network.RemoteAddress)
+	}
+	return w.Flush()
+}
+
+// shortID truncates a node ID for the peers table
+func shortID(id string) string {
+	if len(id) > 16 {
+		return id[:16]
+	}
+	return id
+}
+
+func transcode(ctx *cli.Context) error {
+	id, err := streamIDArg(ctx)
+	if err != nil {
+		return err
+	}
+	profiles := strings.Join(ctx.StringSlice(TranscodeProfileFlag.Name), ",")
+	var transcodeID string
+	if err := call(ctx, &transcodeID, "livepeer_transcode", id, profiles); err != nil {
+		return err
+	}
+	if ctx.Bool(JSONOutputFlag.Name) {
+		return printJSON(map[string]string{"transcodeID": transcodeID})
+	}
+	fmt.Fprintln(stdout, "Transcode ID:", transcodeID)
+	return nil
+}
+
+// nodeStatus is printed by the status command
+type nodeStatus struct {
+	Node    *p2p.NodeInfo
+	Peers   int
+	Streams int
+	Jobs    []transcoding.JobInfo
+}
+
+func status(ctx *cli.Context) error {
+	client, err := attach(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	var (
+		st      nodeStatus
+		peers   []*p2p.PeerInfo
+		streams []lp.StreamInfo
+	)
+	if err := client.Call(&st.Node, "admin_nodeInfo"); err != nil {
+		return err
+	}
+	if err := client.Call(&peers, "admin_peers"); err != nil {
+		return err
+	}
+	if err := client.Call(&streams, "livepeer_streams"); err != nil {
+		return err
+	}
+	if err := client.Call(&st.Jobs, "livepeer_jobs"); err != nil {
+		return err
+	}
+	st.Peers, st.Streams = len(peers), len(streams)
+	if ctx.Bool(JSONOutputFlag.Name) {
+		return printJSON(st)
+	}
+
+	w := newTable()
+	fmt.Fprintf(w, "Node:\t%v\n", st.Node.Enode)
+	fmt.Fprintf(w, "Name:\t%v\n", st.Node.Name)
+	fmt.Fprintf(w, "Peers:\t%d\n", st.Peers)
+	fmt.Fprintf(w, "Streams:\t%d\n", st.Streams)
+	fmt.Fprintf(w, "Transcode jobs:\t%d\n", len(st.Jobs))
+	for _, j := range st.Jobs {
+		fmt.Fprintf(w, "\t%v %v %v\n", j.ID, j.State, j.StreamID)
+	}
+	return w.Flush()
+}
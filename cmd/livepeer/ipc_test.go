@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/rpc"
+	lp "github.com/livepeer/livepeer-swarm/livepeer"
+	"github.com/livepeer/livepeer-swarm/livepeer/transcoding"
+)
+
+// testLivepeerApi stands in for the livepeer API of a node and records the
+// calls that change it
+type testLivepeerApi struct {
+	lock  sync.Mutex
+	calls []string
+}
+
+func (self *testLivepeerApi) record(call string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.calls = append(self.calls, call)
+}
+
+func (self *testLivepeerApi) recorded() []string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return append([]string{}, self.calls...)
+}
+
+func (self *testLivepeerApi) Streams() []lp.StreamInfo {
+	return []lp.StreamInfo{{StreamID: "stream1", Format: "hls", Local: true, Subscribers: []string{"local"}}}
+}
+
+func (self *testLivepeerApi) Stream(id string) (*lp.StreamInfo, error) {
+	return &lp.StreamInfo{StreamID: "stream1", Format: "hls", Local: true}, nil
+}
+
+func (self *testLivepeerApi) StreamMetrics(id string) (*lp.StreamMetrics, error) {
+	return &lp.StreamMetrics{Uptime: "1m0s", Buffered: 3}, nil
+}
+
+func (self *testLivepeerApi) StopStream(id string) error {
+	self.record("stop " + id)
+	return nil
+}
+
+func (self *testLivepeerApi) PlaybackToken(id string, ttl uint64, viewer string) (string, error) {
+	self.record(strings.Join([]string{"token", id, strconv.FormatUint(ttl, 10), viewer}, " "))
+	return "token", nil
+}
+
+func (self *testLivepeerApi) Transcode(id string, profiles string) (string, error) {
+	self.record("transcode " + id + " " + profiles)
+	return "0x01", nil
+}
+
+func (self *testLivepeerApi) Jobs() []transcoding.JobInfo {
+	return []transcoding.JobInfo{{ID: "job1", StreamID: "stream1", State: "running"}}
+}
+
+type testAdminApi struct{}
+
+func (self *testAdminApi) Peers() []*p2p.PeerInfo {
+	short := &p2p.PeerInfo{ID: "ab12", Name: "short"}
+	long := &p2p.PeerInfo{ID: strings.Repeat("cd", 64), Name: "long"}
+	long.Network.RemoteAddress = "127.0.0.1:30399"
+	return []*p2p.PeerInfo{short, long}
+}
+
+func (self *testAdminApi) NodeInfo() *p2p.NodeInfo {
+	return &p2p.NodeInfo{Name: "testnode", Enode: "enode://test"}
+}
+
+// startTestNode serves the test APIs on the IPC endpoint in datadir
+func startTestNode(t *testing.T, datadir string, api *testLivepeerApi) func() {
+	server := rpc.NewServer()
+	if err := server.RegisterName("livepeer", api); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.RegisterName("admin", &testAdminApi{}); err != nil {
+		t.Fatal(err)
+	}
+	l, err := rpc.CreateIPCListener(filepath.Join(datadir, "bzzd.ipc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.ServeListener(l)
+	return func() {
+		l.Close()
+		server.Stop()
+	}
+}
+
+// run runs a livepeer subcommand against the node in datadir and returns
+// what it printed
+func run(datadir string, args ...string) (string, error) {
+	var out bytes.Buffer
+	stdout = &out
+	defer func() { stdout = os.Stdout }()
+	err := app.Run(append([]string{"livepeer", "--datadir", datadir}, args...))
+	return out.String(), err
+}
+
+func TestIPCArgs(t *testing.T) {
+	datadir, err := ioutil.TempDir("", "livepeer-ipc-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(datadir)
+
+	for _, args := range [][]string{
+		{"streams", "info"},
+		{"streams", "stop", "a", "b"},
+		{"streams", "token"},
+		{"transcode"},
+		{"db", "export"},
+		{"db", "import", "a", "b"},
+		// no node is running
+		{"streams", "stop", "stream1"},
+		{"status"},
+	} {
+		if _, err := run(datadir, args...); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
+
+func TestIPCOutput(t *testing.T) {
+	datadir, err := ioutil.TempDir("", "livepeer-ipc-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(datadir)
+	api := &testLivepeerApi{}
+	defer startTestNode(t, datadir, api)()
+
+	for _, test := range []struct {
+		args []string
+		exp  []string // printed lines contain these
+	}{
+		{[]string{"streams", "ls"}, []string{"STREAM", "stream1  hls     local"}},
+		{[]string{"streams", "info", "stream1"}, []string{"Stream:", "stream1", "Buffered:", "3"}},
+		{[]string{"streams", "stop", "stream1"}, []string{"Stopped stream1"}},
+		{[]string{"streams", "token", "--ttl", "1m", "--viewer", "bob", "stream1"}, []string{"token"}},
+		{[]string{"transcode", "--profile", "P720p30fps4Mbps", "--profile", "P360p30fps700Kbps", "stream1"}, []string{"Transcode ID: 0x01"}},
+		{[]string{"peers"}, []string{"ab12", "short", strings.Repeat("cd", 8) + "  long"}},
+		{[]string{"status"}, []string{"testnode", "Peers:", "2", "job1 running stream1"}},
+	} {
+		out, err := run(datadir, test.args...)
+		if err != nil {
+			t.Errorf("%v: %v", test.args, err)
+			continue
+		}
+		for _, exp := range test.exp {
+			if !strings.Contains(out, exp) {
+				t.Errorf("%v: expected %q in output:\n%v", test.args, exp, out)
+			}
+		}
+	}
+
+	exp := []string{
+		"stop stream1",
+		"token stream1 60 bob",
+		"transcode stream1 P720p30fps4Mbps,P360p30fps700Kbps",
+	}
+	if got := api.recorded(); strings.Join(got, "\n") != strings.Join(exp, "\n") {
+		t.Errorf("expected calls %v, got %v", exp, got)
+	}
+
+	out, err := run(datadir, "streams", "ls", "--json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var streams []lp.StreamInfo
+	if err := json.Unmarshal([]byte(out), &streams); err != nil {
+		t.Fatalf("expected JSON output, got %v: %v", out, err)
+	}
+	if len(streams) != 1 || streams[0].StreamID != "stream1" {
+		t.Errorf("unexpected streams %v", streams)
+	}
+}
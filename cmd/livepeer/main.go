@@ -132,6 +132,19 @@ var (
 		Usage: "The url + port to communicate to the visualization server (ex/default 'http://localhost:8585')",
 		Value: "http://localhost:8585",
 	}
+	StreamOutputFlag = cli.StringFlag{
+		Name:  "output",
+		Usage: "Save the stream to this file instead of playing it: FLV for RTMP, MPEG-TS for HLS or MP4 if the file name ends with .mp4",
+	}
+	StreamDurationFlag = cli.DurationFlag{
+		Name:  "duration",
+		Usage: "Stop saving the stream after this duration, e.g. 10m (0 = until the stream ends)",
+	}
+	StreamNodeFlag = cli.StringFlag{
+		Name:  "node",
+		Usage: "Host of the node to get the stream from",
+		Value: "localhost",
+	}
 	LivepeerNetworkIdFlag = cli.IntFlag{
 		Name:  "lpnetworkid",
 		Usage: "Network identifier (integer, default 326326=livepeer toy net)",
@@ -143,7 +156,7 @@ func init() {
 	// Override flag defaults so bzzd can run alongside geth.
 	utils.ListenPortFlag.Value = 30399
 	utils.IPCPathFlag.Value = utils.DirectoryString{Value: "bzzd.ipc"}
-	utils.IPCApiFlag.Value = "admin, bzz, chequebook, debug, rpc, web3"
+	utils.IPCApiFlag.Value = "admin, bzz, chequebook, debug, livepeer, rpc, web3"
 	utils.LPNetFlag.Value = "true"
 
 	// Set up the cli app.
@@ -157,7 +170,8 @@ func init() {
 			Name:        "stream",
 			Usage:       "Connect to a live stream by id. Pass an optional --rtmp <port> argument (1935 default)",
 			ArgsUsage:   " <streamID>",
-			Description: "This command will use ffplay to play the given stream ID from the Livepeer network",
+			Flags:       []cli.Flag{StreamOutputFlag, StreamDurationFlag, StreamNodeFlag},
+			Description: "This command will use ffplay to play the given stream ID from the Livepeer network, or save it to the --output file",
 		},
 		{
 			Action:    version,
@@ -169,6 +183,7 @@ The output of this command is supposed to be machine-readable.
 `,
 		},
 	}
+	app.Commands = append(app.Commands, ipcCommands...)
 
 	app.Flags = []cli.Flag{
 		utils.IdentityFlag,
@@ -284,6 +299,7 @@ func livepeer(ctx *cli.Context) error {
 func stream(ctx *cli.Context) error {
 	port := ctx.GlobalString(RTMPFlag.Name)
 	streamID := ctx.Args()[0]
+	host := ctx.String(StreamNodeFlag.Name)
 	var rtmpURL string
 
 	// Determine if you are streaming the HLS or RTMP version. If --hls is passed in, stream HLS
@@ -297,9 +313,13 @@ func stream(ctx *cli.Context) error {
 
 		port = strconv.Itoa(numericPort + 7000) // HLS port is 7000 + RTMP by default
 
-		rtmpURL = fmt.Sprintf("http://localhost:%v/stream/%v.m3u8", port, streamID)
+		rtmpURL = fmt.Sprintf("http://%v:%v/stream/%v.m3u8", host, port, streamID)
 	} else {
-		rtmpURL = fmt.Sprintf("rtmp://localhost:%v/stream/%v", port, streamID)
+		rtmpURL = fmt.Sprintf("rtmp://%v:%v/stream/%v", host, port, streamID)
+	}
+
+	if output := ctx.String(StreamOutputFlag.Name); output != "" {
+		return record(rtmpURL, hlsRequest, output, ctx.Duration(StreamDurationFlag.Name))
 	}
 
 	cmd := exec.Command("ffplay", rtmpURL)
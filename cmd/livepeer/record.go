@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ericxtang/m3u8"
+	"github.com/ethereum/go-ethereum/logger/glog"
+	"github.com/nareix/joy4/av"
+	"github.com/nareix/joy4/format/flv"
+	"github.com/nareix/joy4/format/mp4"
+	"github.com/nareix/joy4/format/rtmp"
+	"github.com/nareix/joy4/format/ts"
+	"golang.org/x/net/context"
+)
+
+// Saving a network stream to a file, see the --output flag of the stream
+// command
+
+var (
+	// consecutive failures to reach the node before giving up
+	recordRetries   = 5
+	recordRetryWait = time.Second * 2
+	// how often the HLS playlist is fetched for new segments
+	hlsPollInterval = time.Second
+)
+
+// errNotFound is returned by get if the node answers with 404
+var errNotFound = errors.New("not found")
+
+// record saves the stream at uri to output until the stream ends, duration
+// passed (if not 0) or the process is interrupted.  RTMP streams are saved
+// as FLV, HLS streams as MPEG-TS or as MP4 if output ends with .mp4.
+func record(uri string, hls bool, output string, duration time.Duration) error {
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if duration > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, duration)
+		defer cancelTimeout()
+	}
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt)
+	defer signal.Stop(sigc)
+	go func() {
+		select {
+		case <-sigc:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	fmt.Printf("Saving %v to %v\n", uri, output)
+	if !hls {
+		err = recordRTMP(ctx, uri, f)
+	} else if strings.ToLower(filepath.Ext(output)) == ".mp4" {
+		w := &mp4Writer{mux: mp4.NewMuxer(f)}
+		err = recordHLS(ctx, uri, w.writeSegment)
+		if w.header {
+			if terr := w.mux.WriteTrailer(); err == nil {
+				err = terr
+			}
+		}
+	} else {
+		err = recordHLS(ctx, uri, func(data []byte) error {
+			_, err := f.Write(data)
+			return err
+		})
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("Finished saving the stream")
+	return nil
+}
+
+// retry calls f until the stream ended or ctx is done.  f reports whether it
+// made progress, which resets the count of consecutive failures.
+func retry(ctx context.Context, f func() (bool, error)) error {
+	failures := 0
+	for {
+		progress, err := f()
+		if err == nil || err == io.EOF || ctx.Err() != nil {
+			return nil
+		}
+		if progress {
+			failures = 0
+		}
+		failures++
+		if failures > recordRetries {
+			return err
+		}
+		glog.Warningf("Lost the stream (%v), reconnecting in %v", err, recordRetryWait)
+		select {
+		case <-time.After(recordRetryWait):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// rtmpRecorder writes an RTMP stream to an FLV file, the packet times are
+// kept increasing across reconnects
+type rtmpRecorder struct {
+	mux    *flv.Muxer
+	header bool
+	last   time.Duration // time of the last packet written
+}
+
+func recordRTMP(ctx context.Context, uri string, w io.Writer) error {
+	rec := &rtmpRecorder{mux: flv.NewMuxer(w)}
+	err := retry(ctx, func() (bool, error) {
+		return rec.copy(ctx, uri)
+	})
+	if rec.header {
+		if terr := rec.mux.WriteTrailer(); err == nil {
+			err = terr
+		}
+	}
+	return err
+}
+
+func (self *rtmpRecorder) copy(ctx context.Context, uri string) (progress bool, err error) {
+	conn, err := rtmp.Dial(uri)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	// closing the connection interrupts a blocked read
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	streams, err := conn.Streams()
+	if err != nil {
+		return false, err
+	}
+	if !self.header {
+		if err := self.mux.WriteHeader(streams); err != nil {
+			return false, err
+		}
+		self.header = true
+	}
+
+	var start av.Packet
+	offset := self.last
+	for {
+		pkt, err := conn.ReadPacket()
+		if err != nil {
+			return progress, err
+		}
+		if !progress {
+			start = pkt
+		}
+		pkt.Time = offset + pkt.Time - start.Time
+		if err := self.mux.WritePacket(pkt); err != nil {
+			return progress, err
+		}
+		self.last = pkt.Time
+		progress = true
+	}
+}
+
+// hlsRecorder follows the media playlist of an HLS stream and writes every
+// segment once
+type hlsRecorder struct {
+	write   func(data []byte) error
+	started bool
+	seqNo   uint64 // last segment written
+}
+
+func recordHLS(ctx context.Context, uri string, write func(data []byte) error) error {
+	rec := &hlsRecorder{write: write}
+	return retry(ctx, func() (bool, error) {
+		return rec.poll(ctx, uri)
+	})
+}
+
+func (self *hlsRecorder) poll(ctx context.Context, uri string) (progress bool, err error) {
+	for {
+		data, err := get(ctx, uri)
+		if err == errNotFound && self.started {
+			// the media server does not close the playlist of a stream that
+			// ended, it drops it
+			return progress, io.EOF
+		}
+		if err != nil {
+			return progress, err
+		}
+		p, listType, err := m3u8.DecodeFrom(bytes.NewReader(data), true)
+		if err != nil {
+			return progress, err
+		}
+		if listType != m3u8.MEDIA {
+			return progress, fmt.Errorf("%v is not a media playlist", uri)
+		}
+		pl := p.(*m3u8.MediaPlaylist)
+
+		for _, seg := range pl.Segments {
+			if seg == nil || (self.started && seg.SeqId <= self.seqNo) {
+				continue
+			}
+			segURI, err := resolve(uri, seg.URI)
+			if err != nil {
+				return progress, err
+			}
+			data, err := get(ctx, segURI)
+			if err != nil {
+				return progress, err
+			}
+			if err := self.write(data); err != nil {
+				return progress, err
+			}
+			self.started, self.seqNo = true, seg.SeqId
+			progress = true
+		}
+		if pl.Closed {
+			return progress, io.EOF
+		}
+
+		select {
+		case <-time.After(hlsPollInterval):
+		case <-ctx.Done():
+			return progress, nil
+		}
+	}
+}
+
+func resolve(base, ref string) (string, error) {
+	b, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	return b.ResolveReference(r).String(), nil
+}
+
+func get(ctx context.Context, uri string) ([]byte, error) {
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %v: %v", uri, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// mp4Writer remuxes MPEG-TS segments into one MP4 file
+type mp4Writer struct {
+	mux    *mp4.Muxer
+	header bool
+}
+
+func (self *mp4Writer) writeSegment(data []byte) error {
+	dmx := ts.NewDemuxer(bytes.NewReader(data))
+	if !self.header {
+		streams, err := dmx.Streams()
+		if err != nil {
+			return err
+		}
+		if err := self.mux.WriteHeader(streams); err != nil {
+			return err
+		}
+		self.header = true
+	}
+	for {
+		pkt, err := dmx.ReadPacket()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := self.mux.WritePacket(pkt); err != nil {
+			return err
+		}
+	}
+}
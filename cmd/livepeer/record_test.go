@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestRecordRetry(t *testing.T) {
+	defer func(wait time.Duration) { recordRetryWait = wait }(recordRetryWait)
+	recordRetryWait = time.Millisecond
+	errLost := errors.New("lost")
+
+	// consecutive failures give up after recordRetries
+	calls := 0
+	err := retry(context.Background(), func() (bool, error) {
+		calls++
+		return false, errLost
+	})
+	if err != errLost || calls != recordRetries+1 {
+		t.Errorf("expected %v after %d calls, got %v after %d", errLost, recordRetries+1, err, calls)
+	}
+
+	// progress resets the count, the end of the stream is not an error
+	calls = 0
+	err = retry(context.Background(), func() (bool, error) {
+		calls++
+		if calls == 3*recordRetries {
+			return true, io.EOF
+		}
+		return calls%recordRetries == 0, errLost
+	})
+	if err != nil || calls != 3*recordRetries {
+		t.Errorf("expected the stream to end after %d calls, got %v after %d", 3*recordRetries, err, calls)
+	}
+
+	// a cancelled context stops retrying
+	ctx, cancel := context.WithCancel(context.Background())
+	calls = 0
+	err = retry(ctx, func() (bool, error) {
+		calls++
+		cancel()
+		return false, errLost
+	})
+	if err != nil || calls != 1 {
+		t.Errorf("expected to stop after the cancel, got %v after %d calls", err, calls)
+	}
+}
+
+// testPlaylist returns a media playlist of the segments first to last
+func testPlaylist(first, last int, closed bool) string {
+	pl := fmt.Sprintf("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-MEDIA-SEQUENCE:%d\n#EXT-X-TARGETDURATION:2\n", first)
+	for i := first; i <= last; i++ {
+		pl += fmt.Sprintf("#EXTINF:2.000,\nseg%d.ts\n", i)
+	}
+	if closed {
+		pl += "#EXT-X-ENDLIST\n"
+	}
+	return pl
+}
+
+func TestRecordHLS(t *testing.T) {
+	defer func(poll, wait time.Duration) { hlsPollInterval, recordRetryWait = poll, wait }(hlsPollInterval, recordRetryWait)
+	hlsPollInterval, recordRetryWait = time.Millisecond, time.Millisecond
+
+	// the playlist grows, the node fails once in between and the window
+	// moves before the stream ends
+	playlists := []string{
+		testPlaylist(0, 1, false),
+		testPlaylist(0, 1, false),
+		"",
+		testPlaylist(1, 3, false),
+		testPlaylist(2, 4, true),
+	}
+	var lock sync.Mutex
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".ts") {
+			w.Write([]byte(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/stream/"), ".ts")))
+			return
+		}
+		lock.Lock()
+		pl := playlists[requests]
+		if requests < len(playlists)-1 {
+			requests++
+		}
+		lock.Unlock()
+		if pl == "" {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(pl))
+	}))
+	defer server.Close()
+
+	var written []string
+	err := recordHLS(context.Background(), server.URL+"/stream/id.m3u8", func(data []byte) error {
+		written = append(written, string(data))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := "seg0 seg1 seg2 seg3 seg4"; strings.Join(written, " ") != exp {
+		t.Errorf("expected every segment once: %v, got %v", exp, written)
+	}
+}
+
+func TestRecordHLSEnd(t *testing.T) {
+	defer func(poll, wait time.Duration) { hlsPollInterval, recordRetryWait = poll, wait }(hlsPollInterval, recordRetryWait)
+	hlsPollInterval, recordRetryWait = time.Millisecond, time.Millisecond
+
+	// the playlist is not there before the stream starts and is gone once it
+	// ended, it is never closed
+	playlists := []string{
+		"",
+		testPlaylist(0, 1, false),
+		testPlaylist(1, 2, false),
+		"",
+	}
+	var lock sync.Mutex
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".ts") {
+			w.Write([]byte(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/stream/"), ".ts")))
+			return
+		}
+		lock.Lock()
+		pl := playlists[requests]
+		if requests < len(playlists)-1 {
+			requests++
+		}
+		lock.Unlock()
+		if pl == "" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(pl))
+	}))
+	defer server.Close()
+
+	var written []string
+	errc := make(chan error)
+	go func() {
+		errc <- recordHLS(context.Background(), server.URL+"/stream/id.m3u8", func(data []byte) error {
+			written = append(written, string(data))
+			return nil
+		})
+	}()
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("recording did not stop at the end of the stream")
+	}
+	if exp := "seg0 seg1 seg2"; strings.Join(written, " ") != exp {
+		t.Errorf("expected every segment once: %v, got %v", exp, written)
+	}
+}
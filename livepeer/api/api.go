@@ -45,8 +45,11 @@ on top of the dpa
 it is the public interface of the dpa which is included in the ethereum stack
 */
 type Api struct {
-	dpa *storage.DPA
-	dns Resolver
+	dpa      *storage.DPA
+	dns      Resolver
+	pinner   *storage.Pinner   // nil if pinning is not available
+	scrubber *storage.Scrubber // nil if scrubbing is not available
+	dbStore  *storage.DbStore  // nil for a local swarm
 }
 
 //the api constructor initialises
@@ -63,10 +66,18 @@ func (self *Api) Retrieve(key storage.Key) storage.LazySectionReader {
 	return self.dpa.Retrieve(key)
 }
 
+// Store stores content, up to EOF if the size is negative
 func (self *Api) Store(data io.Reader, size int64, wg *sync.WaitGroup) (key storage.Key, err error) {
 	return self.dpa.Store(data, size, wg, nil)
 }
 
+// StoreEncrypted stores content encrypted, the reference returned is the
+// root hash followed by the key that decrypts it.  Retrieve and Get decrypt
+// the content of such references.
+func (self *Api) StoreEncrypted(data io.Reader, size int64, wg *sync.WaitGroup) (key storage.Key, err error) {
+	return self.dpa.StoreEncrypted(data, size, wg, nil)
+}
+
 type ErrResolve error
 
 // DNS Resolver
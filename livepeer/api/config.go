@@ -23,6 +23,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -31,6 +32,8 @@ import (
 	"github.com/golang/glog"
 	"github.com/livepeer/livepeer-swarm/livepeer/network"
 	"github.com/livepeer/livepeer-swarm/livepeer/storage"
+	"github.com/livepeer/livepeer-swarm/livepeer/transcoding"
+	"github.com/livepeer/livepeer-swarm/livepeer/webhook"
 )
 
 const (
@@ -59,6 +62,21 @@ type Config struct {
 	RTMPPort   string
 	FFMpegPath string
 	VodPath    string
+	// maximum number of concurrent transcode jobs, 0 means one per CPU
+	MaxTranscodeJobs int
+	// maximum number of transcode jobs waiting for a free slot, 0 means as many as there are slots
+	MaxTranscodeQueue int
+	// transcoding profiles in addition to transcoding.DefaultProfiles
+	TranscodeProfiles []transcoding.Profile
+	// fraction of segments of transcoded streams spot-checked, 0 disables verification
+	VerifyTranscodeRate float64
+	// swap units paid for streaming and transcoding, not agreed with peers
+	// so it should match the other nodes of the network
+	Pricing *network.PricingParams
+	// URLs that stream lifecycle events are POSTed to
+	Webhooks *webhook.Params
+	// players of the media server need a playback token signed by the stream owner
+	RequirePlaybackToken bool
 }
 
 // config is agnostic to where private key is coming from
@@ -80,6 +98,8 @@ func NewConfig(path string, contract common.Address, prvKey *ecdsa.PrivateKey, n
 	self = &Config{
 		SyncParams:    network.NewSyncParams(dirpath),
 		HiveParams:    network.NewHiveParams(dirpath),
+		Pricing:       network.NewPricingParams(),
+		Webhooks:      webhook.NewParams(dirpath),
 		ChunkerParams: storage.NewChunkerParams(),
 		StoreParams:   storage.NewStoreParams(dirpath),
 		Port:          port,
@@ -127,9 +147,40 @@ func NewConfig(path string, contract common.Address, prvKey *ecdsa.PrivateKey, n
 		self.EnsRoot = ensRootAddress
 	}
 
+	if _, err = self.Profiles(); err != nil {
+		return nil, fmt.Errorf("invalid transcoding profiles: %v", err)
+	}
+	if self.VerifyTranscodeRate < 0 || self.VerifyTranscodeRate > 1 {
+		return nil, fmt.Errorf("VerifyTranscodeRate must be between 0 and 1, got %v", self.VerifyTranscodeRate)
+	}
+	if err = self.ChunkerParams.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid chunker params: %v", err)
+	}
+
 	return
 }
 
+// TranscodeJobs returns the effective transcode job limit
+func (self *Config) TranscodeJobs() int {
+	if self.MaxTranscodeJobs > 0 {
+		return self.MaxTranscodeJobs
+	}
+	return runtime.NumCPU()
+}
+
+// TranscodeQueue returns the effective limit of queued transcode jobs
+func (self *Config) TranscodeQueue() int {
+	if self.MaxTranscodeQueue > 0 {
+		return self.MaxTranscodeQueue
+	}
+	return self.TranscodeJobs()
+}
+
+// Profiles returns the validated transcoding profiles, indexed by name
+func (self *Config) Profiles() (map[string]transcoding.Profile, error) {
+	return transcoding.NewProfiles(self.TranscodeProfiles)
+}
+
 func (self *Config) Save() error {
 	data, err := json.MarshalIndent(self, "", "    ")
 	if err != nil {
@@ -34,8 +34,13 @@ var (
     "DbCapacity": 5000000,
     "CacheCapacity": 5000,
     "Radius": 0,
+    "DbCapacityBytes": 21474836480,
+    "EvictionPolicy": "access",
     "Branches": 128,
     "Hash": "SHA3",
+    "Chunker": "tree",
+    "ReadAhead": 8,
+    "Redundancy": 0,
     "CallInterval": 3000000000,
     "KadDbPath": "` + filepath.Join("TMPDIR", "bzz-peers.json") + `",
     "MaxProx": 8,
@@ -87,7 +92,24 @@ var (
     "NetworkId": 323,
     "RTMPPort": "",
     "FFMpegPath": "",
-    "VodPath": "VODPATH"
+    "VodPath": "VODPATH",
+    "MaxTranscodeJobs": 0,
+    "MaxTranscodeQueue": 0,
+    "TranscodeProfiles": null,
+    "VerifyTranscodeRate": 0,
+    "Pricing": {
+        "StreamBytesPerUnit": 4096,
+        "TranscodeUnits": 500
+    },
+    "Webhooks": {
+        "URLs": null,
+        "Secret": "",
+        "QueuePath": "` + filepath.Join("TMPDIR", "webhooks") + `",
+        "MaxAttempts": 10,
+        "RetryInterval": 1000000000,
+        "MaxRetryInterval": 600000000000
+    },
+    "RequirePlaybackToken": false
 }`
 )
 
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"errors"
+	"os"
+
+	"github.com/livepeer/livepeer-swarm/livepeer/storage"
+)
+
+var ErrNoDbStore = errors.New("no local chunk store on this node")
+
+// SetDbStore enables exporting and importing the local chunk store
+func (self *Api) SetDbStore(dbStore *storage.DbStore) {
+	self.dbStore = dbStore
+}
+
+// Export writes a chunk archive to a file of the node, with all the local
+// chunks or with the chunks under the given root hashes
+func (self *Api) Export(path string, hashes []string) (int64, error) {
+	var roots []storage.Key
+	for _, hash := range hashes {
+		key, err := self.Resolve(hash, true)
+		if err != nil {
+			return 0, err
+		}
+		roots = append(roots, key)
+	}
+	if len(roots) == 0 && self.dbStore == nil {
+		return 0, ErrNoDbStore
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	var count int64
+	if len(roots) == 0 {
+		count, err = self.dbStore.Export(f)
+	} else {
+		count, err = self.dpa.Export(f, roots...)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return count, err
+}
+
+// Import stores the chunks of an archive file of the node
+func (self *Api) Import(path string) (int64, error) {
+	if self.dbStore == nil {
+		return 0, ErrNoDbStore
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return self.dbStore.Import(f)
+}
+
+// Archive exposes exporting and importing chunk archives over RPC
+type Archive struct {
+	api *Api
+}
+
+func NewArchive(api *Api) *Archive {
+	return &Archive{api}
+}
+
+func (self *Archive) Export(path string, roots []string) (int64, error) {
+	return self.api.Export(path, roots)
+}
+
+func (self *Archive) Import(path string) (int64, error) {
+	return self.api.Import(path)
+}
@@ -45,6 +45,16 @@ func NewFileSystem(api *Api) *FileSystem {
 // using dpa store
 // TODO: localpath should point to a manifest
 func (self *FileSystem) Upload(lpath, index string) (string, error) {
+	return self.upload(lpath, index, false)
+}
+
+// UploadEncrypted uploads a local directory like Upload with the files and
+// the manifest encrypted, it returns the reference of the manifest
+func (self *FileSystem) UploadEncrypted(lpath, index string) (string, error) {
+	return self.upload(lpath, index, true)
+}
+
+func (self *FileSystem) upload(lpath, index string, encrypt bool) (string, error) {
 	var list []*manifestTrieEntry
 	localpath, err := filepath.Abs(filepath.Clean(lpath))
 	if err != nil {
@@ -116,7 +126,11 @@ func (self *FileSystem) Upload(lpath, index string) (string, error) {
 				stat, _ := f.Stat()
 				var hash storage.Key
 				wg := &sync.WaitGroup{}
-				hash, err = self.api.dpa.Store(f, stat.Size(), wg, nil)
+				if encrypt {
+					hash, err = self.api.dpa.StoreEncrypted(f, stat.Size(), wg, nil)
+				} else {
+					hash, err = self.api.dpa.Store(f, stat.Size(), wg, nil)
+				}
 				if hash != nil {
 					list[i].Hash = hash.String()
 				}
@@ -145,7 +159,8 @@ func (self *FileSystem) Upload(lpath, index string) (string, error) {
 	}
 
 	trie := &manifestTrie{
-		dpa: self.api.dpa,
+		dpa:       self.api.dpa,
+		encrypted: encrypt,
 	}
 	quitC := make(chan bool)
 	for i, entry := range list {
@@ -0,0 +1,89 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/logger"
+	"github.com/ethereum/go-ethereum/logger/glog"
+	"github.com/livepeer/livepeer-swarm/livepeer/api"
+	"github.com/livepeer/livepeer-swarm/livepeer/storage"
+)
+
+/*
+pinsHandler serves the pins of the node:
+
+	GET /pins                         lists the pins
+	GET /pins/<hash>                  the pin of a root hash
+	POST /pins/<hash>?manifest=true   pins content, with the entries if it is a manifest
+	DELETE /pins/<hash>               unpins content
+*/
+func pinsHandler(w http.ResponseWriter, r *http.Request, a *api.Api) {
+	hash := strings.Trim(strings.TrimPrefix(r.URL.Path, "/pins"), "/")
+	glog.V(logger.Debug).Infof("HTTP %s pins request '%s'", r.Method, hash)
+
+	switch {
+	case r.Method == "GET" && hash == "":
+		pins, err := a.Pins()
+		if err != nil {
+			pinError(w, err)
+			return
+		}
+		writeJSON(w, pins)
+	case r.Method == "GET":
+		pins, err := a.Pins()
+		if err != nil {
+			pinError(w, err)
+			return
+		}
+		key, err := a.Resolve(hash, true)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, pin := range pins {
+			if pin.Root.String() == key.String() {
+				writeJSON(w, pin)
+				return
+			}
+		}
+		pinError(w, storage.ErrNotPinned)
+	case r.Method == "POST" && hash != "":
+		pin, err := a.Pin(hash, r.URL.Query().Get("manifest") == "true")
+		if err != nil {
+			pinError(w, err)
+			return
+		}
+		writeJSON(w, pin)
+	case r.Method == "DELETE" && hash != "":
+		if err := a.Unpin(hash); err != nil {
+			pinError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method "+r.Method+" is not supported.", http.StatusMethodNotAllowed)
+	}
+}
+
+func pinError(w http.ResponseWriter, err error) {
+	switch err {
+	case api.ErrNoPinning:
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+	case storage.ErrNotPinned:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
@@ -0,0 +1,47 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/logger"
+	"github.com/ethereum/go-ethereum/logger/glog"
+	"github.com/livepeer/livepeer-swarm/livepeer/api"
+	"github.com/livepeer/livepeer-swarm/livepeer/storage"
+)
+
+/*
+proofHandler serves inclusion proofs of byte ranges:
+
+	GET /proof/<hash>?start=<offset>&end=<offset>   the proof of [start, end)
+*/
+func proofHandler(w http.ResponseWriter, r *http.Request, a *api.Api) {
+	hash := strings.Trim(strings.TrimPrefix(r.URL.Path, "/proof"), "/")
+	glog.V(logger.Debug).Infof("HTTP %s proof request '%s'", r.Method, hash)
+	if r.Method != "GET" {
+		http.Error(w, "Method "+r.Method+" is not supported.", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	start, err := strconv.ParseInt(query.Get("start"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid start: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	end, err := strconv.ParseInt(query.Get("end"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid end: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	proof, err := a.Proof(hash, start, end)
+	if err != nil {
+		code := http.StatusInternalServerError
+		if err == storage.ErrProofNotSupported {
+			code = http.StatusNotImplemented
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+	writeJSON(w, proof)
+}
@@ -32,6 +32,7 @@ import (
 	"github.com/ethereum/go-ethereum/logger"
 	"github.com/ethereum/go-ethereum/logger/glog"
 	"github.com/livepeer/livepeer-swarm/livepeer/api"
+	"github.com/livepeer/livepeer-swarm/livepeer/storage"
 	"github.com/rs/cors"
 )
 
@@ -73,6 +74,15 @@ func StartHttpServer(api *api.Api, server *Server) {
 	serveMux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		handler(w, r, api)
 	})
+	serveMux.HandleFunc("/pins", func(w http.ResponseWriter, r *http.Request) {
+		pinsHandler(w, r, api)
+	})
+	serveMux.HandleFunc("/pins/", func(w http.ResponseWriter, r *http.Request) {
+		pinsHandler(w, r, api)
+	})
+	serveMux.HandleFunc("/proof/", func(w http.ResponseWriter, r *http.Request) {
+		proofHandler(w, r, api)
+	})
 	var allowedOrigins []string
 	for _, domain := range strings.Split(server.CorsString, ",") {
 		allowedOrigins = append(allowedOrigins, strings.TrimSpace(domain))
@@ -135,11 +145,19 @@ func handler(w http.ResponseWriter, r *http.Request, a *api.Api) {
 
 	switch {
 	case r.Method == "POST" || r.Method == "PUT":
-		if r.Header.Get("content-length") == "" {
-			http.Error(w, "Missing Content-Length header in request.", http.StatusBadRequest)
-			return
+		// a body of unknown length, e.g. with chunked transfer encoding, is
+		// stored as it arrives
+		var body io.Reader = r.Body
+		if r.ContentLength >= 0 {
+			body = io.LimitReader(r.Body, r.ContentLength)
+		}
+		var key storage.Key
+		var err error
+		if r.URL.Query().Get("encrypt") == "true" {
+			key, err = a.StoreEncrypted(body, r.ContentLength, nil)
+		} else {
+			key, err = a.Store(body, r.ContentLength, nil)
 		}
-		key, err := a.Store(io.LimitReader(r.Body, r.ContentLength), r.ContentLength, nil)
 		if err == nil {
 			glog.V(logger.Debug).Infof("Content for %v stored", key.Log())
 		} else {
@@ -205,6 +223,9 @@ func handler(w http.ResponseWriter, r *http.Request, a *api.Api) {
 
 			// retrieving content
 			reader := a.Retrieve(key)
+			if c, ok := reader.(io.Closer); ok {
+				defer c.Close()
+			}
 			quitC := make(chan bool)
 			size, err := reader.Size(quitC)
 			if err != nil {
@@ -246,6 +267,9 @@ func handler(w http.ResponseWriter, r *http.Request, a *api.Api) {
 				http.Error(w, err.Error(), status)
 				return
 			}
+			if c, ok := reader.(io.Closer); ok {
+				defer c.Close()
+			}
 			// set mime type and status headers
 			w.Header().Set("Content-Type", mimeType)
 			if status > 0 {
@@ -0,0 +1,80 @@
+package http
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/livepeer/livepeer-swarm/livepeer/api"
+	"github.com/livepeer/livepeer-swarm/livepeer/storage"
+)
+
+func TestChunkedUpload(t *testing.T) {
+	datadir, err := ioutil.TempDir("", "bzz-http-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(datadir)
+	dpa, err := storage.NewLocalDPA(datadir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dpa.Start()
+	defer dpa.Stop()
+	a := api.NewApi(dpa, nil)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		chunked := len(r.TransferEncoding) == 1 && r.TransferEncoding[0] == "chunked"
+		if r.Method == "POST" && (r.ContentLength != -1 || !chunked) {
+			http.Error(w, "expected a chunked body of unknown length", http.StatusBadRequest)
+			return
+		}
+		handler(w, r, a)
+	}))
+	defer srv.Close()
+
+	data := make([]byte, 100000)
+	rand.Read(data)
+	// the body arrives in parts of unknown total length
+	body, w := io.Pipe()
+	go func() {
+		w.Write(data[:30000])
+		w.Write(data[30000:])
+		w.Close()
+	}()
+	req, err := http.NewRequest("POST", srv.URL+"/bzzr:/", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.ContentLength = -1
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %v: %s", resp.Status, key)
+	}
+
+	resp, err = http.Get(srv.URL + "/bzzr:/" + string(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, data) {
+		t.Errorf("expected the uploaded %d bytes back from %s, got %d bytes", len(data), key, len(content))
+	}
+}
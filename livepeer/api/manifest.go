@@ -33,9 +33,10 @@ const (
 )
 
 type manifestTrie struct {
-	dpa     *storage.DPA
-	entries [257]*manifestTrieEntry // indexed by first character of path, entries[256] is the empty path entry
-	hash    storage.Key             // if hash != nil, it is stored
+	dpa       *storage.DPA
+	entries   [257]*manifestTrieEntry // indexed by first character of path, entries[256] is the empty path entry
+	hash      storage.Key             // if hash != nil, it is stored
+	encrypted bool                    // stored encrypted, as are its subtries
 }
 
 type manifestJSON struct {
@@ -89,7 +90,8 @@ func readManifest(manifestReader storage.LazySectionReader, hash storage.Key, dp
 	glog.V(logger.Detail).Infof("Manifest %v has %d entries.", hash.Log(), len(man.Entries))
 
 	trie = &manifestTrie{
-		dpa: dpa,
+		dpa:       dpa,
+		encrypted: storage.IsEncrypted(hash),
 	}
 	for _, entry := range man.Entries {
 		trie.addEntry(entry, quitC)
@@ -130,7 +132,8 @@ func (self *manifestTrie) addEntry(entry *manifestTrieEntry, quitC chan bool) {
 	commonPrefix := entry.Path[:cpl]
 
 	subtrie := &manifestTrie{
-		dpa: self.dpa,
+		dpa:       self.dpa,
+		encrypted: self.encrypted,
 	}
 	entry.Path = entry.Path[cpl:]
 	oldentry.Path = oldentry.Path[cpl:]
@@ -220,7 +223,13 @@ func (self *manifestTrie) recalcAndStore() error {
 
 	sr := bytes.NewReader(manifest)
 	wg := &sync.WaitGroup{}
-	key, err2 := self.dpa.Store(sr, int64(len(manifest)), wg, nil)
+	var key storage.Key
+	var err2 error
+	if self.encrypted {
+		key, err2 = self.dpa.StoreEncrypted(sr, int64(len(manifest)), wg, nil)
+	} else {
+		key, err2 = self.dpa.Store(sr, int64(len(manifest)), wg, nil)
+	}
 	wg.Wait()
 	self.hash = key
 	return err2
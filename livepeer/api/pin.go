@@ -0,0 +1,106 @@
+package api
+
+import (
+	"errors"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/livepeer/livepeer-swarm/livepeer/storage"
+)
+
+var ErrNoPinning = errors.New("pinning is not available on this node")
+
+// SetPinner enables pinning, a local swarm has no pinner
+func (self *Api) SetPinner(pinner *storage.Pinner) {
+	self.pinner = pinner
+}
+
+// Pin exempts content from garbage collection.  If manifest is true the
+// root hash is a manifest and every entry is pinned with it, nested manifests
+// included.
+func (self *Api) Pin(hash string, manifest bool) (*storage.PinInfo, error) {
+	if self.pinner == nil {
+		return nil, ErrNoPinning
+	}
+	key, err := self.Resolve(hash, true)
+	if err != nil {
+		return nil, err
+	}
+	var refs []storage.Key
+	if manifest {
+		quitC := make(chan bool)
+		defer close(quitC)
+		trie, err := loadManifest(self.dpa, key, quitC)
+		if err != nil {
+			return nil, err
+		}
+		if refs, err = trie.refs(quitC); err != nil {
+			return nil, err
+		}
+	}
+	return self.pinner.Pin(key, refs...)
+}
+
+// Unpin unpins content pinned by its root hash
+func (self *Api) Unpin(hash string) error {
+	if self.pinner == nil {
+		return ErrNoPinning
+	}
+	key, err := self.Resolve(hash, true)
+	if err != nil {
+		return err
+	}
+	return self.pinner.Unpin(key)
+}
+
+// Pins lists the pinned content with its size
+func (self *Api) Pins() ([]*storage.PinInfo, error) {
+	if self.pinner == nil {
+		return nil, ErrNoPinning
+	}
+	return self.pinner.Pins(), nil
+}
+
+// refs returns the hashes of the entries of the manifest and its nested
+// manifests
+func (self *manifestTrie) refs(quitC chan bool) (refs []storage.Key, err error) {
+	for _, entry := range self.entries {
+		if entry == nil {
+			continue
+		}
+		if entry.Hash != "" {
+			refs = append(refs, storage.Key(common.Hex2Bytes(entry.Hash)))
+		}
+		if entry.ContentType == manifestType {
+			if err = self.loadSubTrie(entry, quitC); err != nil {
+				return nil, err
+			}
+			sub, err := entry.subtrie.refs(quitC)
+			if err != nil {
+				return nil, err
+			}
+			refs = append(refs, sub...)
+		}
+	}
+	return
+}
+
+// Pinning exposes pinning over RPC
+type Pinning struct {
+	api *Api
+}
+
+func NewPinning(api *Api) *Pinning {
+	return &Pinning{api}
+}
+
+func (self *Pinning) Pin(hash string, manifest bool) (*storage.PinInfo, error) {
+	return self.api.Pin(hash, manifest)
+}
+
+func (self *Pinning) Unpin(hash string) error {
+	return self.api.Unpin(hash)
+}
+
+func (self *Pinning) Pins() ([]*storage.PinInfo, error) {
+	return self.api.Pins()
+}
@@ -0,0 +1,29 @@
+package api
+
+import (
+	"github.com/livepeer/livepeer-swarm/livepeer/storage"
+)
+
+// Proof returns the inclusion proof of the bytes [start, end) of the content
+// under a root hash, the content must be hashed with BMT.  The proof is
+// checked against the root hash with storage.VerifyProof.
+func (self *Api) Proof(hash string, start, end int64) (*storage.Proof, error) {
+	key, err := self.Resolve(hash, true)
+	if err != nil {
+		return nil, err
+	}
+	return self.dpa.Proof(key, start, end)
+}
+
+// Proofs exposes range proofs over RPC
+type Proofs struct {
+	api *Api
+}
+
+func NewProofs(api *Api) *Proofs {
+	return &Proofs{api}
+}
+
+func (self *Proofs) Proof(hash string, start, end int64) (*storage.Proof, error) {
+	return self.api.Proof(hash, start, end)
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"errors"
+
+	"github.com/livepeer/livepeer-swarm/livepeer/storage"
+)
+
+var ErrNoScrubbing = errors.New("scrubbing is not available on this node")
+
+// SetScrubber enables scrubbing the chunk store, a local swarm has no
+// scrubber
+func (self *Api) SetScrubber(scrubber *storage.Scrubber) {
+	self.scrubber = scrubber
+}
+
+// Scrub checks that the stored chunks hash to their keys.  If remove is true
+// the broken entries are deleted, and if refetch is true as well the deleted
+// chunks are retrieved again from peers.
+func (self *Api) Scrub(remove, refetch bool) (*storage.ScrubReport, error) {
+	if self.scrubber == nil {
+		return nil, ErrNoScrubbing
+	}
+	return self.scrubber.Scrub(remove, refetch)
+}
+
+// Scrubbing exposes scrubbing over RPC
+type Scrubbing struct {
+	api *Api
+}
+
+func NewScrubbing(api *Api) *Scrubbing {
+	return &Scrubbing{api}
+}
+
+func (self *Scrubbing) Scrub(remove, refetch bool) (*storage.ScrubReport, error) {
+	return self.api.Scrub(remove, refetch)
+}
@@ -0,0 +1,76 @@
+package livepeer
+
+import (
+	"errors"
+	"os/exec"
+	"path"
+
+	"github.com/livepeer/livepeer-swarm/livepeer/health"
+)
+
+// Paths of the liveness and readiness reports on the media server
+const (
+	HealthzPath = "/healthz"
+	ReadyzPath  = "/readyz"
+)
+
+var (
+	errNoPeers      = errors.New("no peers")
+	errDPAStopped   = errors.New("DPA not started")
+	errNoChequebook = errors.New("no chequebook set")
+)
+
+// newHealthChecker sets up the checks of the node components.  The store,
+// the DPA and the media server listeners are liveness checks, the peers,
+// ffmpeg and the chequebook only make the node not ready.
+func (self *Swarm) newHealthChecker() *health.Checker {
+	checker := health.NewChecker()
+
+	checker.Add("store", true, func() (interface{}, error) {
+		if self.dbStore == nil {
+			return nil, health.ErrDisabled
+		}
+		entries, capacity := self.dbStore.Size()
+		size, capacityBytes := self.dbStore.Bytes()
+		return map[string]uint64{"chunks": entries, "capacity": capacity, "bytes": size, "capacityBytes": capacityBytes}, self.dbStore.CheckWritable()
+	})
+	checker.Add("dpa", true, func() (interface{}, error) {
+		if self.dpa == nil {
+			return nil, health.ErrDisabled
+		}
+		if !self.dpa.Running() {
+			return nil, errDPAStopped
+		}
+		return nil, nil
+	})
+	checker.Add("media", true, func() (interface{}, error) {
+		return self.media.CheckListeners()
+	})
+
+	checker.Add("peers", false, func() (interface{}, error) {
+		// a local swarm never connects to peers
+		if self.dpa == nil {
+			return nil, health.ErrDisabled
+		}
+		count := self.hive.PeersCount()
+		if count == 0 {
+			return count, errNoPeers
+		}
+		return count, nil
+	})
+	checker.Add("ffmpeg", false, func() (interface{}, error) {
+		return exec.LookPath(path.Join(self.config.FFMpegPath, "ffmpeg"))
+	})
+	checker.Add("chequebook", false, func() (interface{}, error) {
+		if !self.swapEnabled {
+			return nil, health.ErrDisabled
+		}
+		chbook := self.config.Swap.Chequebook()
+		if chbook == nil {
+			return nil, errNoChequebook
+		}
+		return map[string]string{"contract": chbook.Address().Hex(), "balance": chbook.Balance().String()}, nil
+	})
+
+	return checker
+}
@@ -0,0 +1,154 @@
+/*
+Package health runs the component checks behind the liveness and readiness
+endpoints of a node.
+
+Liveness checks tell whether the process works at all, an orchestrator
+restarts a node failing them.  Readiness checks additionally tell whether the
+node can serve, e.g. has peers, an orchestrator holds traffic back from a node
+failing them.  Every check is reported by name with its status, error and
+detail in the JSON body.
+*/
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Statuses of checks and reports
+const (
+	StatusOK       = "ok"
+	StatusFailing  = "failing"
+	StatusDisabled = "disabled"
+)
+
+// ErrDisabled is returned by the check of a component that is not enabled,
+// it does not fail the report
+var ErrDisabled = errors.New("disabled")
+
+var errTimeout = errors.New("check timed out")
+
+// CheckTimeout is how long a check may take before it is reported failing
+var CheckTimeout = 5 * time.Second
+
+// Check checks a component and returns details for the report, e.g. the
+// number of peers
+type Check func() (detail interface{}, err error)
+
+// Result is the outcome of a check
+type Result struct {
+	Status string      `json:"status"`
+	Error  string      `json:"error,omitempty"`
+	Detail interface{} `json:"detail,omitempty"`
+}
+
+// Report is the outcome of all checks, its status is failing if any check
+// is failing
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+type check struct {
+	name string
+	live bool
+	fn   Check
+}
+
+// Checker holds the checks of a node
+type Checker struct {
+	lock   sync.Mutex
+	checks []check
+}
+
+func NewChecker() *Checker {
+	return &Checker{}
+}
+
+// Add adds a check.  Liveness checks are run for both reports, the others
+// for the readiness report only.
+func (self *Checker) Add(name string, live bool, fn Check) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.checks = append(self.checks, check{name, live, fn})
+}
+
+// Run runs the liveness checks, or all checks if ready is true, in parallel
+func (self *Checker) Run(ready bool) *Report {
+	self.lock.Lock()
+	var checks []check
+	for _, c := range self.checks {
+		if c.live || ready {
+			checks = append(checks, c)
+		}
+	}
+	self.lock.Unlock()
+
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, fn Check) {
+			defer wg.Done()
+			results[i] = run(fn)
+		}(i, c.fn)
+	}
+	wg.Wait()
+
+	report := &Report{Status: StatusOK, Checks: make(map[string]Result)}
+	for i, c := range checks {
+		report.Checks[c.name] = results[i]
+		if results[i].Status == StatusFailing {
+			report.Status = StatusFailing
+		}
+	}
+	return report
+}
+
+// run runs a check, a check that times out keeps running in the background
+func run(fn Check) Result {
+	type outcome struct {
+		detail interface{}
+		err    error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		detail, err := fn()
+		done <- outcome{detail, err}
+	}()
+
+	var o outcome
+	select {
+	case o = <-done:
+	case <-time.After(CheckTimeout):
+		o.err = errTimeout
+	}
+	switch o.err {
+	case nil:
+		return Result{Status: StatusOK, Detail: o.detail}
+	case ErrDisabled:
+		return Result{Status: StatusDisabled, Detail: o.detail}
+	}
+	return Result{Status: StatusFailing, Error: o.err.Error(), Detail: o.detail}
+}
+
+// Handler serves the liveness report, or the readiness report if ready is
+// true, with status 200 if no check is failing and 503 otherwise
+func (self *Checker) Handler(ready bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := self.Run(ready)
+		js, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if report.Status != StatusOK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		w.Write(js)
+	})
+}
@@ -0,0 +1,70 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestChecker(t *testing.T) {
+	checker := NewChecker()
+	checker.Add("store", true, func() (interface{}, error) { return nil, nil })
+	checker.Add("chequebook", true, func() (interface{}, error) { return nil, ErrDisabled })
+	checker.Add("peers", false, func() (interface{}, error) { return 0, errors.New("no peers") })
+
+	report := checker.Run(false)
+	if report.Status != StatusOK || len(report.Checks) != 2 {
+		t.Errorf("Expecting 2 passing liveness checks, got %+v", report)
+	}
+	if report.Checks["chequebook"].Status != StatusDisabled {
+		t.Errorf("Expecting disabled chequebook, got %+v", report.Checks["chequebook"])
+	}
+
+	report = checker.Run(true)
+	if report.Status != StatusFailing || len(report.Checks) != 3 {
+		t.Errorf("Expecting 3 readiness checks failing, got %+v", report)
+	}
+	if peers := report.Checks["peers"]; peers.Status != StatusFailing || peers.Error != "no peers" || peers.Detail != 0 {
+		t.Errorf("Expecting failing peers check, got %+v", peers)
+	}
+}
+
+func TestCheckTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { CheckTimeout = timeout }(CheckTimeout)
+	CheckTimeout = 10 * time.Millisecond
+
+	checker := NewChecker()
+	block := make(chan struct{})
+	defer close(block)
+	checker.Add("media", true, func() (interface{}, error) {
+		<-block
+		return nil, nil
+	})
+	if result := checker.Run(false).Checks["media"]; result.Status != StatusFailing || result.Error != errTimeout.Error() {
+		t.Errorf("Expecting timed out check, got %+v", result)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	checker := NewChecker()
+	checker.Add("store", true, func() (interface{}, error) { return nil, nil })
+	checker.Add("peers", false, func() (interface{}, error) { return nil, errors.New("no peers") })
+
+	for ready, code := range map[bool]int{false: http.StatusOK, true: http.StatusServiceUnavailable} {
+		w := httptest.NewRecorder()
+		checker.Handler(ready).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if w.Code != code {
+			t.Errorf("Expecting status %d for ready=%v, got %d", code, ready, w.Code)
+		}
+		var report Report
+		if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+			t.Fatalf("Got error decoding report: %v", err)
+		}
+		if _, ok := report.Checks["store"]; !ok {
+			t.Errorf("Expecting store check in report, got %+v", report)
+		}
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"crypto/ecdsa"
 	"fmt"
+	"path/filepath"
 	"strconv"
 
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
@@ -27,16 +28,20 @@ import (
 	"github.com/ethereum/go-ethereum/contracts/chequebook"
 	"github.com/ethereum/go-ethereum/contracts/ens"
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/node"
 	"github.com/ethereum/go-ethereum/p2p"
 	"github.com/ethereum/go-ethereum/p2p/discover"
 	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/swarm/network/kademlia"
 	"github.com/golang/glog"
 	"github.com/livepeer/livepeer-swarm/livepeer/api"
 	httpapi "github.com/livepeer/livepeer-swarm/livepeer/api/http"
 	"github.com/livepeer/livepeer-swarm/livepeer/network"
 	"github.com/livepeer/livepeer-swarm/livepeer/storage"
 	"github.com/livepeer/livepeer-swarm/livepeer/streaming"
+	"github.com/livepeer/livepeer-swarm/livepeer/transcoding"
+	"github.com/livepeer/livepeer-swarm/livepeer/webhook"
 	"github.com/livepeer/livepeer-swarm/mediaserver"
 	streamingVizClient "github.com/livepeer/streamingviz/client"
 	"golang.org/x/net/context"
@@ -48,6 +53,7 @@ type Swarm struct {
 	api         *api.Api               // high level api layer (fs/manifest)
 	dns         api.Resolver           // DNS registrar
 	dbAccess    *network.DbAccess      // access to local chunk db iterator and storage counter
+	dbStore     *storage.DbStore       // disk store of the local chunks, nil for a local swarm
 	storage     storage.ChunkStore     // internal access to storage, common interface to cloud storage backends
 	dpa         *storage.DPA           // distributed preimage archive, the local API to the storage with document level storage/retrieval support
 	depo        network.StorageHandler // remote request handler, interface between bzz protocol and the storage
@@ -59,7 +65,13 @@ type Swarm struct {
 	swapEnabled bool
 	streamer    *streaming.Streamer
 	streamDB    *network.StreamDB
+	scheduler   *transcoding.Scheduler // runs the transcode jobs accepted from the network
+	transcoder  *network.TranscodeHandler
+	profiles    map[string]transcoding.Profile // transcoding profiles publishers can request by name
 	viz         *streamingVizClient.Client
+	media       *mediaserver.Server
+	events      *event.TypeMux      // stream events for RPC subscribers and webhooks
+	webhooks    *webhook.Dispatcher // nil if no webhook URLs are configured
 }
 
 type SwarmAPI struct {
@@ -107,7 +119,15 @@ func NewSwarm(ctx *node.ServiceContext, backend chequebook.Backend, config *api.
 	glog.Infof("Set up local storage")
 
 	self.dbAccess = network.NewDbAccess(lstore)
-	glog.Infof("Set up local db access (iterator/counter)")
+	self.dbStore = lstore.DbStore.(*storage.DbStore)
+	policy, err := storage.NewEvictionPolicy(config.StoreParams.EvictionPolicy, storage.Key(common.HexToHash(self.config.BzzKey).Bytes()))
+	if err != nil {
+		return
+	}
+	if err = self.dbStore.SetEvictionPolicy(policy); err != nil {
+		return
+	}
+	glog.Infof("Set up local db access (iterator/counter), %s eviction", policy.Name())
 
 	// set up the kademlia hive
 	self.hive = network.NewHive(
@@ -118,6 +138,18 @@ func NewSwarm(ctx *node.ServiceContext, backend chequebook.Backend, config *api.
 	)
 	glog.Infof("Set up swarm network with Kademlia hive")
 
+	// set up the transcode job scheduler and advertise transcoding capabilities to peers
+	self.scheduler = transcoding.NewScheduler(config.TranscodeJobs(), config.TranscodeQueue(), func(running, queued int) {
+		self.hive.SetTranscodeLoad(uint64(running), uint64(queued))
+	})
+	self.hive.SetCapabilities(network.NewCapabilities(config.FFMpegPath, uint64(self.scheduler.MaxJobs()), uint64(self.scheduler.MaxQueued())))
+	self.transcoder = network.NewTranscodeHandler(self.scheduler, config.FFMpegPath, filepath.Join(config.Path, "transcode"), config.VerifyTranscodeRate)
+	glog.Infof("-> transcode scheduler with %d job slots and %d queued jobs", self.scheduler.MaxJobs(), self.scheduler.MaxQueued())
+	self.profiles, err = config.Profiles()
+	if err != nil {
+		return
+	}
+
 	// setup cloud storage backend
 	self.cloud = network.NewForwarder(self.hive)
 	glog.Infof("-> set swarm forwarder as cloud storage backend")
@@ -134,10 +166,20 @@ func NewSwarm(ctx *node.ServiceContext, backend chequebook.Backend, config *api.
 	if err != nil {
 		return
 	}
+	self.events = new(event.TypeMux)
+	self.streamer.SetEventMux(self.events)
 
 	self.streamDB = network.NewStreamDB()
 
+	if config.Webhooks != nil && len(config.Webhooks.URLs) > 0 {
+		self.webhooks = webhook.NewDispatcher(config.Webhooks)
+		glog.Infof("-> webhooks to %v", config.Webhooks.URLs)
+	}
+
 	self.viz = viz
+	if self.viz == nil {
+		self.viz = streamingVizClient.NewClient("", false, "")
+	}
 
 	// set up DPA, the cloud storage local access layer
 	dpaChunkStore := storage.NewDpaChunkStore(lstore, self.storage)
@@ -156,12 +198,38 @@ func NewSwarm(ctx *node.ServiceContext, backend chequebook.Backend, config *api.
 	glog.Infof("-> Swarm Domain Name Registrar @ address %v", config.EnsRoot.Hex())
 
 	self.api = api.NewApi(self.dpa, self.dns)
+	self.api.SetPinner(storage.NewPinner(self.dpa, self.dbStore))
+	self.api.SetScrubber(storage.NewScrubber(self.dbStore, dpaChunkStore))
+	self.api.SetDbStore(self.dbStore)
 	// Manifests for Smart Hosting
 	glog.Infof("-> Web3 virtual server API")
 
+	self.newMediaServer()
+	glog.Infof("-> Media server")
+
 	return self, nil
 }
 
+// newMediaServer sets up the media server, its HTTP port is derived from the
+// RTMP port.  There are no listeners if the RTMP port is not set.
+func (self *Swarm) newMediaServer() {
+	params := &mediaserver.ServerParams{
+		FFMpegPath:   self.config.FFMpegPath,
+		VodPath:      self.config.VodPath,
+		RequireToken: self.config.RequirePlaybackToken,
+	}
+	if self.config.RTMPPort != "" {
+		rtmpPortNum, _ := strconv.Atoi(self.config.RTMPPort)
+		params.RTMPPort = self.config.RTMPPort
+		params.HTTPPort = strconv.Itoa(rtmpPortNum + 7000)
+	}
+	self.media = mediaserver.NewServer(params, self.streamer, self.cloud, self.streamDB, self.hive, self.scheduler, self.profiles, self.viz)
+	self.media.Handle(MetricsPath, self.metricsHandler())
+	checker := self.newHealthChecker()
+	self.media.Handle(HealthzPath, checker.Handler(false))
+	self.media.Handle(ReadyzPath, checker.Handler(true))
+}
+
 /*
 Start is called when the stack is started
 * starts the network kademlia hive peer management
@@ -212,16 +280,15 @@ func (self *Swarm) Start(net *p2p.Server) error {
 		go httpapi.StartHttpServer(self.api, &httpapi.Server{Addr: addr, CorsString: self.corsString})
 	}
 
-	glog.Infof("Livepeer.go: RTMPport: %v", self.config.RTMPPort)
-	if self.config.RTMPPort != "" {
-		//StartRTMPServer spins up a go routine internally.  It would be good to know the convention
-		//around this.  Go routines are spun up all over the place in this codebase, it's a little tough
-		//to understand whether you are in the main thread sometimes (or does that just not matter in Go?)
-		rtmpPort := self.config.RTMPPort
-		rtmpPortNum, _ := strconv.Atoi(rtmpPort)
-		httpPort := strconv.Itoa(rtmpPortNum + 7000)
+	if self.webhooks != nil {
+		if err := self.startWebhooks(); err != nil {
+			return fmt.Errorf("Unable to start webhooks: %v", err)
+		}
+	}
 
-		go mediaserver.StartLPMS(rtmpPort, httpPort, self.streamer, self.cloud, self.streamDB, self.viz, self.hive, self.config.FFMpegPath, self.config.VodPath)
+	glog.Infof("Livepeer.go: RTMPport: %v", self.config.RTMPPort)
+	if err := self.media.Start(); err != nil {
+		return fmt.Errorf("Unable to start media server: %v", err)
 	}
 
 	glog.Infof("Swarm http proxy started on port: %v", self.config.Port)
@@ -235,7 +302,22 @@ func (self *Swarm) Start(net *p2p.Server) error {
 
 // implements the node.Service interface
 // stops all component services.
+// The media server is stopped and the streams are ended first, while the
+// peers that need to be notified are still connected.
 func (self *Swarm) Stop() error {
+	self.stopMedia()
+	if self.scheduler != nil {
+		self.scheduler.Stop()
+	}
+	if self.streamer != nil {
+		self.stopStreams()
+	}
+	if self.events != nil {
+		self.events.Stop()
+	}
+	if self.webhooks != nil {
+		self.webhooks.Stop()
+	}
 	self.dpa.Stop()
 	self.hive.Stop()
 	if ch := self.config.Swap.Chequebook(); ch != nil {
@@ -245,9 +327,34 @@ func (self *Swarm) Stop() error {
 	return self.config.Save()
 }
 
+// stopMedia stops the media server and waits for its listeners to close
+func (self *Swarm) stopMedia() {
+	if self.media == nil {
+		return
+	}
+	if err := self.media.Stop(); err != nil {
+		glog.Warningf("Media server did not stop: %v", err)
+		return
+	}
+	glog.Infof("Media server stopped")
+}
+
+// stopStreams ends all streams: the nodes we relay streams from are asked to
+// stop sending, our subscribers receive an EOF and all workers are cancelled
+func (self *Swarm) stopStreams() {
+	for _, strm := range self.streamer.GetAllNetworkStreams() {
+		id := streaming.StreamID(strm.GetStreamID())
+		if nodeID, _ := id.SplitComponents(); nodeID != self.streamer.SelfAddress {
+			self.cloud.StopStream(id.String(), kademlia.Address{}, strm.Format)
+		}
+	}
+	self.streamer.Stop()
+	glog.Infof("Streams stopped")
+}
+
 // implements the node.Service interface
 func (self *Swarm) Protocols() []p2p.Protocol {
-	proto, err := network.Bzz(self.depo, self.backend, self.hive, self.dbAccess, self.config.Swap, self.config.SyncParams, self.config.NetworkId, self.streamer, self.streamDB, &self.cloud, self.viz)
+	proto, err := network.Bzz(self.depo, self.backend, self.hive, self.dbAccess, self.config.Swap, self.config.Pricing, self.config.SyncParams, self.config.NetworkId, self.streamer, self.streamDB, &self.cloud, self.viz, self.transcoder)
 	if err != nil {
 		return nil
 	}
@@ -271,6 +378,12 @@ func (self *Swarm) APIs() []rpc.API {
 			Service:   &Info{self.config, chequebook.ContractParams},
 			Public:    true,
 		},
+		{
+			Namespace: "bzz",
+			Version:   "0.1",
+			Service:   api.NewProofs(self.api),
+			Public:    true,
+		},
 		// admin APIs
 		{
 			Namespace: "bzz",
@@ -283,6 +396,36 @@ func (self *Swarm) APIs() []rpc.API {
 			Service:   api.NewControl(self.api, self.hive),
 			Public:    false,
 		},
+		{
+			Namespace: "bzz",
+			Version:   "0.1",
+			Service:   api.NewPinning(self.api),
+			Public:    false,
+		},
+		{
+			Namespace: "bzz",
+			Version:   "0.1",
+			Service:   api.NewScrubbing(self.api),
+			Public:    false,
+		},
+		{
+			Namespace: "bzz",
+			Version:   "0.1",
+			Service:   api.NewArchive(self.api),
+			Public:    false,
+		},
+		{
+			Namespace: "livepeer",
+			Version:   "0.1",
+			Service:   transcoding.NewApi(self.scheduler),
+			Public:    false,
+		},
+		{
+			Namespace: "livepeer",
+			Version:   "0.1",
+			Service:   NewStreamApi(self),
+			Public:    false,
+		},
 		{
 			Namespace: "chequebook",
 			Version:   chequebook.Version,
@@ -297,6 +440,12 @@ func (self *Swarm) Api() *api.Api {
 	return self.api
 }
 
+// MediaServer returns the media server, its Handler can be mounted in
+// another http.Server
+func (self *Swarm) MediaServer() *mediaserver.Server {
+	return self.media
+}
+
 // SetChequebook ensures that the local checquebook is set up on chain.
 func (self *Swarm) SetChequebook(ctx context.Context) error {
 	err := self.config.Swap.SetChequebook(ctx, self.backend, self.config.Path)
@@ -330,9 +479,27 @@ func NewLocalSwarm(datadir, port string) (self *Swarm, err error) {
 	}
 
 	self = &Swarm{
-		api:    api.NewApi(dpa, nil),
-		config: config,
+		api:        api.NewApi(dpa, nil),
+		config:     config,
+		privateKey: prvKey,
+	}
+
+	// streams are served locally only, the hive never connects to peers
+	self.hive = network.NewHive(common.HexToHash(config.BzzKey), config.HiveParams, false, false)
+	self.cloud = network.NewForwarder(self.hive)
+	self.streamer, err = streaming.NewStreamer(common.HexToHash(config.BzzKey))
+	if err != nil {
+		return
+	}
+	self.events = new(event.TypeMux)
+	self.streamer.SetEventMux(self.events)
+	self.streamDB = network.NewStreamDB()
+	self.viz = streamingVizClient.NewClient("", false, "")
+	self.profiles, err = config.Profiles()
+	if err != nil {
+		return
 	}
+	self.newMediaServer()
 
 	return
 }
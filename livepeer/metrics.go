@@ -0,0 +1,78 @@
+package livepeer
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/livepeer/livepeer-swarm/livepeer/prometheus"
+	"github.com/livepeer/livepeer-swarm/livepeer/streaming"
+	"github.com/livepeer/livepeer-swarm/livepeer/transcoding"
+	"github.com/rcrowley/go-metrics"
+)
+
+// MetricsPath is where the media server serves the Prometheus metrics
+const MetricsPath = "/metrics"
+
+// metricsHandler serves the go-ethereum metrics, which are only recorded if
+// the node runs with --metrics, and the node, network and stream metrics
+func (self *Swarm) metricsHandler() http.Handler {
+	return prometheus.Handler(prometheus.Registry("", metrics.DefaultRegistry.Each), self.collectMetrics)
+}
+
+func (self *Swarm) collectMetrics(set *prometheus.Set) {
+	set.Gauge("livepeer_peers", "Connected peers", float64(self.hive.PeersCount()))
+
+	if self.dbStore != nil {
+		entries, capacity := self.dbStore.Size()
+		set.Gauge("livepeer_storage_chunks", "Chunks in the local store", float64(entries))
+		set.Gauge("livepeer_storage_capacity_chunks", "Capacity of the local store in chunks", float64(capacity))
+		size, capacityBytes := self.dbStore.Bytes()
+		set.Gauge("livepeer_storage_bytes", "Bytes of chunk data in the local store", float64(size))
+		set.Gauge("livepeer_storage_capacity_bytes", "Capacity of the local store in bytes, 0 if unlimited", float64(capacityBytes))
+		if usage, err := self.dbStore.DiskUsage(); err == nil {
+			set.Gauge("livepeer_storage_disk_bytes", "Size of the local store database files", float64(usage))
+		}
+	}
+
+	if self.scheduler != nil {
+		states := map[string]int{transcoding.JobQueued.String(): 0, transcoding.JobRunning.String(): 0}
+		for _, job := range self.scheduler.Jobs() {
+			states[job.State]++
+		}
+		for _, state := range []transcoding.JobState{transcoding.JobQueued, transcoding.JobRunning} {
+			set.Gauge("livepeer_transcode_jobs", "Transcode jobs of the node by state", float64(states[state.String()]), "state", state.String())
+		}
+	}
+
+	if self.webhooks != nil {
+		set.Gauge("livepeer_webhooks_pending", "Webhook deliveries waiting to be sent or retried", float64(self.webhooks.Pending()))
+	}
+
+	// the streamer accessors take its lock, a stream removed in between is
+	// skipped
+	strms := self.streamer.GetAllNetworkStreams()
+	set.Gauge("livepeer_streams", "Streams originated or relayed by the node", float64(len(strms)))
+	now := time.Now()
+	for _, strm := range strms {
+		id := streaming.StreamID(strm.GetStreamID())
+		m, ok := self.streamer.Metrics(id)
+		if !ok {
+			continue
+		}
+		labels := []string{"stream", id.String(), "format", formatName(strm.Format)}
+		in := append(labels, "direction", "in")
+		out := append(labels, "direction", "out")
+
+		set.Gauge("livepeer_stream_subscribers", "Local players and peers subscribed to the stream", float64(len(self.streamer.Subscribers(id))), labels...)
+		set.Gauge("livepeer_stream_buffered", "Segments or packets in the stream buffer", float64(strm.Len()), labels...)
+		set.Counter("livepeer_stream_segments_total", "HLS segments or RTMP packets received and delivered to peers", float64(m.ChunksIn), in...)
+		set.Counter("livepeer_stream_segments_total", "", float64(m.ChunksOut), out...)
+		set.Counter("livepeer_stream_bytes_total", "Bytes received and delivered to peers", float64(m.BytesIn), in...)
+		set.Counter("livepeer_stream_bytes_total", "", float64(m.BytesOut), out...)
+		set.Gauge("livepeer_stream_uptime_seconds", "Time since the stream was added", now.Sub(m.Started).Seconds(), labels...)
+		if !m.LastIn.IsZero() {
+			set.Gauge("livepeer_stream_lag_seconds", "Time since the last segment or packet was received", now.Sub(m.LastIn).Seconds(), labels...)
+		}
+		set.Gauge("livepeer_stream_transcoded", "Transcoded streams acked for the stream", float64(len(self.streamDB.Transcoded(id))), labels...)
+	}
+}
@@ -27,6 +27,7 @@ import (
 	"github.com/ethereum/go-ethereum/swarm/network/kademlia"
 	"github.com/livepeer/livepeer-swarm/livepeer/storage"
 	"github.com/livepeer/livepeer-swarm/livepeer/streaming"
+	"github.com/livepeer/livepeer-swarm/livepeer/transcoding"
 	lpmsStream "github.com/livepeer/lpms/stream"
 )
 
@@ -184,7 +185,7 @@ func (self *forwarder) StopStream(id string, peerAddr kademlia.Address, format l
 }
 
 // Transcode request - this is to request for a node to become a transcoder.  The node should send an Ack to confirm.
-func (self *forwarder) Transcode(streamId string, transcodeId common.Hash, formats []string, bitrates []string, codecin string, codeout []string) {
+func (self *forwarder) Transcode(streamId string, transcodeId common.Hash, codecin string, profiles []transcoding.Profile) {
 	glog.Infof("Forwarding Transcode Request")
 	s := streaming.StreamID(streamId)
 	nodeID, streamID := s.SplitComponents()
@@ -193,24 +194,22 @@ func (self *forwarder) Transcode(streamId string, transcodeId common.Hash, forma
 		OriginStreamID: streamID,
 		TranscodeID:    transcodeId,
 		Id:             streaming.TranscodeRequestMsgID,
-		Formats:        formats,
-		Bitrates:       bitrates,
 		CodecIn:        codecin,
-		CodecOut:       codeout,
+		Profiles:       profiles,
 	}
 
 	glog.V(logger.Info).Infof("In forwarding func, getting peer with transcodeId: %x", transcodeId)
 	//We always try to branch out at least 1 node, so that the requested node can NEVER be the transcoding node
-	peers := self.hive.getPeers(transcodeId.Bytes(), 1)
-	if len(peers) > 0 {
-		for _, p := range peers {
-			glog.Infof("Sending transcode req to peer: %v", p.Addr())
-			p.transcode(msg)
-		}
-	} else {
+	//Candidates are the closest peers able to serve the request, least loaded first.  The next one is asked
+	//if the current one sends back an empty ack or times out.
+	peers := self.hive.getTranscoders(transcodeId.Bytes(), codecin, profiles)
+	if len(peers) == 0 {
 		glog.Errorf("Error: no peer found to forward Transcode request.")
 		return
 	}
+	if err := self.hive.requestTranscode(msg, peers); err != nil {
+		glog.Errorf("Error: cannot forward Transcode request: %v", err)
+	}
 }
 
 // once a chunk is found deliver it to its requesters unless timed out
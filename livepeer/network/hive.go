@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"math/rand"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -51,6 +52,12 @@ type Hive struct {
 	toggle       chan bool
 	more         chan bool
 
+	caps          *Capabilities // local transcoding capabilities advertised to peers
+	capsLock      sync.RWMutex
+	transcodeReqs map[common.Hash]*transcodeRequest // transcode requests awaiting an ack
+	flagged       map[kademlia.Address]time.Time    // transcoders that failed verification
+	transcodeLock sync.Mutex
+
 	// for testing only
 	swapEnabled bool
 	syncEnabled bool
@@ -87,12 +94,14 @@ func NewHiveParams(path string) *HiveParams {
 func NewHive(addr common.Hash, params *HiveParams, swapEnabled, syncEnabled bool) *Hive {
 	kad := kademlia.New(kademlia.Address(addr), params.KadParams)
 	return &Hive{
-		callInterval: params.CallInterval,
-		kad:          kad,
-		addr:         kad.Addr(),
-		path:         params.KadDbPath,
-		swapEnabled:  swapEnabled,
-		syncEnabled:  syncEnabled,
+		callInterval:  params.CallInterval,
+		kad:           kad,
+		addr:          kad.Addr(),
+		path:          params.KadDbPath,
+		swapEnabled:   swapEnabled,
+		syncEnabled:   syncEnabled,
+		transcodeReqs: make(map[common.Hash]*transcodeRequest),
+		flagged:       make(map[kademlia.Address]time.Time),
 	}
 }
 
@@ -27,6 +27,7 @@ import (
 	"github.com/ethereum/go-ethereum/swarm/network/kademlia"
 	"github.com/ethereum/go-ethereum/swarm/services/swap"
 	"github.com/livepeer/livepeer-swarm/livepeer/storage"
+	"github.com/livepeer/livepeer-swarm/livepeer/transcoding"
 	lpmsStream "github.com/livepeer/lpms/stream"
 )
 
@@ -48,6 +49,9 @@ const (
 	stopStreamRequestMsg        // 0x10
 	transcodeRequestMsg         // 0x11
 	transcodeAckMsg             // 0x12
+	// capabilities are sent after the handshake and whenever the transcoding
+	// load of the node changes. The message data is a Capabilities struct.
+	capabilitiesMsg // 0x13
 )
 
 /*
@@ -107,6 +111,8 @@ type stopStreamRequestMsgData struct {
 
 /*
  Transcode requests are sent to peers who can become trascoding nodes, and those nodes send back acks
+ The requested ladder is sent as a list of profiles, the transcoder creates one
+ new stream per profile.
 */
 type transcodeRequestMsgData struct {
 	OriginNode     common.Hash
@@ -114,10 +120,8 @@ type transcodeRequestMsgData struct {
 	TranscodeID    common.Hash
 	Id             uint64
 	from           *peer
-	Formats        []string
-	Bitrates       []string
 	CodecIn        string
-	CodecOut       []string
+	Profiles       []transcoding.Profile
 }
 
 type transcodeAckMsgData struct {
@@ -130,10 +134,8 @@ type transcodeAckMsgData struct {
 
 type transcodedStreamData struct {
 	StreamID string
-	Format   string
-	Bitrate  string
 	CodecIn  string
-	CodecOut string
+	Profile  transcoding.Profile
 }
 
 /*
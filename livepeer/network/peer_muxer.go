@@ -33,8 +33,7 @@ func (p *peerMuxer) WriteSegment(seqNo uint64, name string, duration float64, s
 		SData:      streaming.VideoChunkToByteArr(chunk),
 		Id:         streaming.DeliverStreamMsgID,
 	}
-	p.peer.stream(msg)
-	return nil
+	return p.deliver(msg)
 }
 
 func (p *peerMuxer) WriteHeader(header []av.CodecData) error {
@@ -50,8 +49,7 @@ func (p *peerMuxer) WriteHeader(header []av.CodecData) error {
 		SData:      streaming.VideoChunkToByteArr(chunk),
 		Id:         streaming.DeliverStreamMsgID,
 	}
-	p.peer.stream(msg)
-	return nil
+	return p.deliver(msg)
 }
 
 func (p *peerMuxer) WritePacket(pkt av.Packet) error {
@@ -66,8 +64,7 @@ func (p *peerMuxer) WritePacket(pkt av.Packet) error {
 		SData:      streaming.VideoChunkToByteArr(chunk),
 		Id:         streaming.DeliverStreamMsgID,
 	}
-	p.peer.stream(msg)
-	return nil
+	return p.deliver(msg)
 }
 
 func (p *peerMuxer) WriteTrailer() error {
@@ -86,3 +83,31 @@ func (p *peerMuxer) WriteTrailer() error {
 	p.peer.stream(msg)
 	return nil
 }
+
+// WriteEOF tells the peer that the HLS stream ended
+func (p *peerMuxer) WriteEOF() error {
+	chunk := streaming.VideoChunk{
+		ID: streaming.EOFStreamMsgID,
+	}
+
+	msg := &streamRequestMsgData{
+		OriginNode: p.originNode,
+		Format:     lpmsStream.HLS,
+		StreamID:   p.streamID,
+		SData:      streaming.VideoChunkToByteArr(chunk),
+		Id:         streaming.EOFStreamMsgID,
+	}
+	return p.peer.stream(msg)
+}
+
+// deliver sends stream data to the peer, who pays for it through SWAP
+func (p *peerMuxer) deliver(msg *streamRequestMsgData) error {
+	if err := p.peer.account(p.peer.pricing.StreamUnits(len(msg.SData))); err != nil {
+		return err
+	}
+	if err := p.peer.stream(msg); err != nil {
+		return err
+	}
+	p.peer.streamer.CountOut(streaming.MakeStreamID(p.originNode, p.streamID), len(msg.SData))
+	return nil
+}
@@ -0,0 +1,65 @@
+package network
+
+import (
+	"github.com/ethereum/go-ethereum/logger"
+	"github.com/ethereum/go-ethereum/logger/glog"
+)
+
+const (
+	// a unit of stream data costs the same as a retrieved chunk
+	streamBytesPerUnit = 4096
+	transcodeUnits     = 500
+)
+
+/*
+PricingParams convert video work into SWAP units so it is accounted with the
+same swap instance as chunk retrieval.  The price of a unit is set by the
+swap profile (BuyAt/SellAt).
+
+The peer doing the work adds the units to the balance, the peer it is done for
+subtracts them, and cheques are issued by the chequebook once the payment
+threshold is reached.
+
+Pricing is set per node and is not exchanged in the handshake.  Two peers with
+different params count different units for the same work, so their balances
+do not match; nodes of a network are expected to run with the same params.
+*/
+type PricingParams struct {
+	StreamBytesPerUnit uint64 // bytes of stream data delivered per unit
+	TranscodeUnits     uint64 // units per rendition accepted by a transcoder
+}
+
+func NewPricingParams() *PricingParams {
+	return &PricingParams{
+		StreamBytesPerUnit: streamBytesPerUnit,
+		TranscodeUnits:     transcodeUnits,
+	}
+}
+
+// StreamUnits returns the units paid for delivering size bytes of stream data
+func (self *PricingParams) StreamUnits(size int) int {
+	if self.StreamBytesPerUnit == 0 {
+		return 0
+	}
+	return int((uint64(size) + self.StreamBytesPerUnit - 1) / self.StreamBytesPerUnit)
+}
+
+// TranscodeJobUnits returns the units paid for n transcoded renditions
+func (self *PricingParams) TranscodeJobUnits(n int) int {
+	return n * int(self.TranscodeUnits)
+}
+
+// account adds units to the swap balance of the connection: positive if the
+// remote peer owes us for work we did, negative if we owe the remote peer.
+// The error is set if the balance is out of the agreed limits, in which case
+// the work should not be done.
+func (self *bzz) account(units int) error {
+	if self.swap == nil || units == 0 {
+		return nil
+	}
+	if err := self.swap.Add(units); err != nil {
+		glog.V(logger.Warn).Infof("cannot account %d units with %v: %v", units, self.remoteAddr, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package network
+
+import (
+	"crypto/ecdsa"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/contracts/chequebook/contract"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/p2p/discover"
+	"github.com/ethereum/go-ethereum/swarm/network/kademlia"
+	bzzswap "github.com/ethereum/go-ethereum/swarm/services/swap"
+	"github.com/livepeer/livepeer-swarm/livepeer/streaming"
+	"github.com/livepeer/livepeer-swarm/livepeer/transcoding"
+	lpmsStream "github.com/livepeer/lpms/stream"
+	"golang.org/x/net/context"
+)
+
+var (
+	swapKey0, _       = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	swapKey1, _       = crypto.HexToECDSA("8a1f9a8f95be41cd7ccb6168179afb4504aefe388d1e14474d32c45c72ce7b7a")
+	chequebookDeposit = big.NewInt(1000000000000000000)
+)
+
+// newTestSwapParams deploys a funded chequebook owned by key
+func newTestSwapParams(t *testing.T, key *ecdsa.PrivateKey, backend *backends.SimulatedBackend, dir string) *bzzswap.SwapParams {
+	opts := bind.NewKeyedTransactor(key)
+	opts.Value = chequebookDeposit
+	addr, _, _, err := contract.DeployChequebook(opts, backend)
+	if err != nil {
+		t.Fatalf("deploy chequebook: %v", err)
+	}
+	backend.Commit()
+	params := bzzswap.DefaultSwapParams(addr, key)
+	if err := params.SetChequebook(context.Background(), backend, dir); err != nil {
+		t.Fatalf("set chequebook: %v", err)
+	}
+	return params
+}
+
+func newTestSwapPeer(rw p2p.MsgReadWriter, addr byte, params *bzzswap.SwapParams) *bzz {
+	streamer, _ := streaming.NewStreamer(common.Hash{addr})
+	return &bzz{
+		hive:        NewHive(common.Hash{addr}, NewHiveParams(""), true, false),
+		rw:          rw,
+		peer:        p2p.NewPeer(discover.NodeID{addr}, "test", nil),
+		remoteAddr:  &peerAddr{Addr: kademlia.Address{addr ^ 1}},
+		swapParams:  params,
+		swapEnabled: true,
+		pricing:     NewPricingParams(),
+		streamer:    streamer,
+		streamDB:    NewStreamDB(),
+	}
+}
+
+// newTestSwapPeers returns both ends of a connection with SWAP set up and
+// their message loops running
+func newTestSwapPeers(t *testing.T) (a, b *bzz, cleanup func()) {
+	dir, err := ioutil.TempDir("", "bzz-swap-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backend := backends.NewSimulatedBackend(
+		core.GenesisAccount{Address: crypto.PubkeyToAddress(swapKey0.PublicKey), Balance: big.NewInt(0).Mul(chequebookDeposit, big.NewInt(10))},
+		core.GenesisAccount{Address: crypto.PubkeyToAddress(swapKey1.PublicKey), Balance: big.NewInt(0).Mul(chequebookDeposit, big.NewInt(10))},
+	)
+	paramsA := newTestSwapParams(t, swapKey0, backend, dir)
+	paramsB := newTestSwapParams(t, swapKey1, backend, dir)
+
+	rwA, rwB := p2p.MsgPipe()
+	a = newTestSwapPeer(rwA, 0, paramsA)
+	b = newTestSwapPeer(rwB, 1, paramsB)
+	a.swap, err = bzzswap.NewSwap(paramsA, &bzzswap.SwapProfile{Profile: paramsB.Profile, PayProfile: paramsB.PayProfile}, backend, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.swap, err = bzzswap.NewSwap(paramsB, &bzzswap.SwapProfile{Profile: paramsA.Profile, PayProfile: paramsA.PayProfile}, backend, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for a.handle() == nil {
+		}
+	}()
+	go func() {
+		for b.handle() == nil {
+		}
+	}()
+	return a, b, func() {
+		rwA.Close()
+		rwB.Close()
+		a.swap.Stop()
+		b.swap.Stop()
+		os.RemoveAll(dir)
+	}
+}
+
+// waitBalance waits until b issued a cheque and a and b agree on the
+// balance, which is returned
+func waitBalance(t *testing.T, a, b *bzz, chequebook *big.Int) int {
+	for i := 0; i < 100; i++ {
+		balance := a.swap.Balance()
+		paid := b.swapParams.Chequebook().Balance().Cmp(chequebook) < 0
+		if paid && balance == -b.swap.Balance() {
+			return balance
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("balances did not settle: %d, %d", a.swap.Balance(), b.swap.Balance())
+	return 0
+}
+
+func TestStreamUnits(t *testing.T) {
+	pricing := NewPricingParams()
+	for size, exp := range map[int]int{0: 0, 1: 1, 4096: 1, 4097: 2, 1 << 20: 256} {
+		if got := pricing.StreamUnits(size); got != exp {
+			t.Errorf("%d bytes: expected %d units, got %d", size, exp, got)
+		}
+	}
+	if got := pricing.TranscodeJobUnits(3); got != 3*transcodeUnits {
+		t.Errorf("expected %d units, got %d", 3*transcodeUnits, got)
+	}
+}
+
+func TestStreamAccounting(t *testing.T) {
+	a, b, cleanup := newTestSwapPeers(t)
+	defer cleanup()
+
+	streamID := streaming.MakeStreamID(common.Hash{0}, "stream")
+	if _, err := b.streamer.AddNewNetworkStream(streamID, lpmsStream.HLS); err != nil {
+		t.Fatal(err)
+	}
+	_, id := streamID.SplitComponents()
+	mux := &peerMuxer{peer: &peer{bzz: a}, originNode: common.Hash{0}, streamID: id}
+	chequebook := b.swapParams.Chequebook().Balance()
+
+	// a delivers 20 segments of about 10 units each, b pays whenever it owes 100 units
+	for i := 0; i < 20; i++ {
+		if err := mux.WriteSegment(uint64(i), "seg.ts", 2, make([]byte, 40000)); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	balance := waitBalance(t, a, b, chequebook)
+	if balance < 0 || balance >= int(a.swapParams.PayAt) {
+		t.Errorf("expected b to have paid all but less than %d units, balance is %d", a.swapParams.PayAt, balance)
+	}
+}
+
+func TestTranscodeAccounting(t *testing.T) {
+	a, b, cleanup := newTestSwapPeers(t)
+	defer cleanup()
+
+	scheduler := transcoding.NewScheduler(2, 0, nil)
+	defer scheduler.Stop()
+	a.transcoder = NewTranscodeHandler(scheduler, "", os.TempDir(), 0)
+	a.hive.SetCapabilities(&Capabilities{Transcoder: true, MaxJobs: 2})
+	origID := streaming.MakeStreamID(common.Hash{1}, "stream")
+	a.streamer.AddNewNetworkStream(origID, lpmsStream.HLS)
+
+	_, id := origID.SplitComponents()
+	chequebook := b.swapParams.Chequebook().Balance()
+	err := b.transcode(&transcodeRequestMsgData{
+		OriginNode:     common.Hash{1},
+		OriginStreamID: id,
+		TranscodeID:    common.Hash{2},
+		Id:             streaming.TranscodeRequestMsgID,
+		Profiles:       transcoding.DefaultProfiles[:2],
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// b owes 2 renditions, which is above the payment threshold
+	if balance := waitBalance(t, a, b, chequebook); balance != 0 {
+		t.Errorf("expected b to have paid, balance is %d", balance)
+	}
+	paid := new(big.Int).Sub(chequebook, b.swapParams.Chequebook().Balance())
+	exp := new(big.Int).Mul(big.NewInt(int64(a.pricing.TranscodeJobUnits(2))), a.swapParams.SellAt)
+	if paid.Cmp(exp) != 0 {
+		t.Errorf("expected %v wei paid, got %v", exp, paid)
+	}
+}
@@ -36,6 +36,7 @@ import (
 	"fmt"
 	"net"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/ericxtang/m3u8"
@@ -55,8 +56,9 @@ import (
 )
 
 const (
-	Version            = 0
-	ProtocolLength     = uint64(11)
+	// 1: transcode requests and acks carry codecs and profiles, capabilities carry the job queue
+	Version            = 1
+	ProtocolLength     = uint64(14)
 	ProtocolMaxMsgSize = 10 * 1024 * 1024
 	NetworkId          = 326326
 )
@@ -113,11 +115,15 @@ type bzz struct {
 
 	swap        *swap.Swap          // swap instance for the peer connection
 	swapParams  *bzzswap.SwapParams // swap settings both local and remote
+	pricing     *PricingParams      // swap units paid for streaming and transcoding
 	swapEnabled bool                // flag to enable SWAP (will be set via Caps in handshake)
 	syncEnabled bool                // flag to enable SYNC (will be set via Caps in handshake)
 	syncer      *syncer             // syncer instance for the peer connection
 	syncParams  *SyncParams         // syncer params
 	syncState   *syncState          // outgoing syncronisation state (contains reference to remote peers db counter)
+	caps        *Capabilities       // transcoding capabilities advertised by the remote peer
+	capsLock    sync.RWMutex        // caps is set by the read loop and read by the forwarder
+	transcoder  *TranscodeHandler   // handler of transcode requests, nil if the node does not transcode
 	viz         *streamingVizClient.Client
 }
 
@@ -142,7 +148,7 @@ on each peer connection
 The Run function of the Bzz protocol class creates a bzz instance
 which will represent the peer for the swarm hive and all peer-aware components
 */
-func Bzz(cloud StorageHandler, backend chequebook.Backend, hive *Hive, dbaccess *DbAccess, sp *bzzswap.SwapParams, sy *SyncParams, networkId uint64, streamer *streaming.Streamer, streamDB *StreamDB, forwarder *storage.CloudStore, viz *streamingVizClient.Client) (p2p.Protocol, error) {
+func Bzz(cloud StorageHandler, backend chequebook.Backend, hive *Hive, dbaccess *DbAccess, sp *bzzswap.SwapParams, pp *PricingParams, sy *SyncParams, networkId uint64, streamer *streaming.Streamer, streamDB *StreamDB, forwarder *storage.CloudStore, viz *streamingVizClient.Client, transcoder *TranscodeHandler) (p2p.Protocol, error) {
 
 	// a single global request db is created for all peer connections
 	// this is to persist delivery backlog and aid syncronisation
@@ -158,7 +164,7 @@ func Bzz(cloud StorageHandler, backend chequebook.Backend, hive *Hive, dbaccess
 		Version: Version,
 		Length:  ProtocolLength,
 		Run: func(p *p2p.Peer, rw p2p.MsgReadWriter) error {
-			return run(requestDb, cloud, backend, hive, dbaccess, sp, sy, networkId, p, rw, streamer, streamDB, forwarder, viz)
+			return run(requestDb, cloud, backend, hive, dbaccess, sp, pp, sy, networkId, p, rw, streamer, streamDB, forwarder, viz, transcoder)
 		},
 	}, nil
 }
@@ -175,7 +181,7 @@ the main protocol loop that
  * whenever the loop terminates, the peer will disconnect with Subprotocol error
  * whenever handlers return an error the loop terminates
 */
-func run(requestDb *storage.LDBDatabase, depo StorageHandler, backend chequebook.Backend, hive *Hive, dbaccess *DbAccess, sp *bzzswap.SwapParams, sy *SyncParams, networkId uint64, p *p2p.Peer, rw p2p.MsgReadWriter, streamer *streaming.Streamer, streamDB *StreamDB, forwarder *storage.CloudStore, viz *streamingVizClient.Client) (err error) {
+func run(requestDb *storage.LDBDatabase, depo StorageHandler, backend chequebook.Backend, hive *Hive, dbaccess *DbAccess, sp *bzzswap.SwapParams, pp *PricingParams, sy *SyncParams, networkId uint64, p *p2p.Peer, rw p2p.MsgReadWriter, streamer *streaming.Streamer, streamDB *StreamDB, forwarder *storage.CloudStore, viz *streamingVizClient.Client, transcoder *TranscodeHandler) (err error) {
 
 	self := &bzz{
 		storage:   depo,
@@ -190,6 +196,7 @@ func run(requestDb *storage.LDBDatabase, depo StorageHandler, backend chequebook
 			Errors:  errorToString,
 		},
 		swapParams:  sp,
+		pricing:     pp,
 		syncParams:  sy,
 		swapEnabled: hive.swapEnabled,
 		syncEnabled: true,
@@ -198,6 +205,7 @@ func run(requestDb *storage.LDBDatabase, depo StorageHandler, backend chequebook
 		streamDB:    streamDB,
 		forwarder:   forwarder,
 		viz:         viz,
+		transcoder:  transcoder,
 	}
 
 	// handle handshake
@@ -328,14 +336,24 @@ func (self *bzz) handle() error {
 				glog.Errorf("Received a video chunk but cannot find stream: %v", concatedStreamID)
 				return self.protoError(ErrStream, "Received a video chunk but cannot find stream: %v", concatedStreamID)
 			}
+			// stream data is paid for like retrieved chunks
+			if err := self.account(-self.pricing.StreamUnits(len(req.SData))); err != nil {
+				glog.V(logger.Warn).Infof("dropping video chunk of %v: %v", concatedStreamID, err)
+				return nil
+			}
+			self.streamer.CountIn(concatedStreamID, len(req.SData))
 			chunk := streaming.ByteArrInVideoChunk(req.SData)
 			err = insertChunkToStream(chunk, strm)
 			if err != nil {
 				glog.Errorf("Error inserting chunk into stream: %v", err)
 			}
 		} else if req.Id == streaming.EOFStreamMsgID {
+			if self.transcoder != nil {
+				self.transcoder.StreamEnded(concatedStreamID)
+			}
 			if req.Format == lpmsStream.HLS {
-				//TODO: Cannot actively end a HLS stream - gotta figure out how to do this.
+				// the stream ended upstream, pass it on to our subscribers
+				self.streamer.EndHLSStream(string(concatedStreamID))
 			} else {
 				self.streamer.EndRTMPStream(string(concatedStreamID))
 			}
@@ -386,81 +404,17 @@ func (self *bzz) handle() error {
 		}
 		req.from = &peer{bzz: self}
 
-		glog.V(logger.Error).Infof("Got Transcode Request: %v", req)
-		glog.V(logger.Error).Infof("Transcoding is unsupported until video segments are standardized")
-		// key := req.TranscodeID.Bytes()
-		// glog.V(logger.Info).Infof("Requesting a peer with transcodeID: %x", req.TranscodeID)
-
-		// // // Note this means the routing won't necessarily be routed to the absolute closes node in the network,
-		// // // since the knowledge of the local node can be constrained.  However, for now, a local optimum is enough
-		// // // to get the job done - since all we need is a single node that will do the transcoding work.
-		// peers := self.hive.getPeersCloserThanSelf(key, 1)
-
-		// if len(peers) == 1 {
-		// 	//Remember the upstream requester, forward to downstream peer
-		// 	glog.V(logger.Info).Infof("Peer we got is: %v", peers[0].Addr())
-		// 	fmt.Println("Forwarding to the closer node: ", peers[0].Addr())
-		// 	self.streamDB.AddUpstreamTranscodeRequester(streaming.MakeStreamID(req.OriginNode, req.OriginStreamID), &peer{bzz: self})
-		// 	peers[0].transcode(&req)
-		// } else if len(peers) == 0 {
-		// 	//You ARE the transcoder!
-		// 	fmt.Println("I AM the transcoder.")
-		// 	from := &peer{bzz: self}
-		// 	transcodedStrm := self.streamer.AddNewNetworkStream()
-		// 	// newHLSMux =
-		// 	self.streamer.SubscribeToHLSStream(ctx, originalStreamID, originalStreamID, newHLSMux)
-		// 	transcodedVidChan := make(chan *streaming.VideoChunk, 10) //This channel needs to be closed at some point.  When is transcoding done?
-
-		// 	originalStreamID := streaming.MakeStreamID(req.OriginNode, req.OriginStreamID)
-		// 	//Subscribe to the original video
-		// 	originalStream, err := self.streamer.GetStreamByStreamID(originalStreamID)
-		// 	if originalStream == nil {
-		// 		originalStream, err = self.streamer.SubscribeToStream(string(originalStreamID))
-		// 		if err != nil {
-		// 			glog.V(logger.Error).Infof("Error subscribing to stream %v", err)
-		// 			return self.protoError(ErrTranscode, "Error subscribing to stream %v", err)
-		// 		}
-		// 		//Send subscribe request
-		// 		(*self.forwarder).Stream(string(originalStreamID), self.remoteAddr.Addr)
-		// 	}
-
-		// 	transcodedStream, err := self.streamer.AddNewStream()
-
-		// err = lpmsIo.Transcode(originalStream.DstVideoChan, transcodedVidChan, transcodedStream.ID, req.Formats[0], req.Bitrates[0], req.CodecIn, req.CodecOut[0], originalStream.CloseChan)
-		// go lpmsIo.CopyChannelToChannel(transcodedVidChan, transcodedStream.SrcVideoChan)
-
-		//TODO: Need to spin up a Go Routine to monitor HLS playlist - if the past 10 are the same, close the transcodeStream
-
-		// 	if err != nil {
-		// 		self.streamer.DeleteStream(transcodedStream.ID)
-		// 		ack := &transcodeAckMsgData{
-		// 			OriginNode:     req.OriginNode,
-		// 			OriginStreamID: req.OriginStreamID,
-		// 		}
-		// 		glog.V(logger.Error).Infof("Got error during transcoding, sending empty ack.  %s", err)
-		// 		from.transcodeAck(ack)
-		// 		cancel()
-		// 	} else {
-		// 		transcodedID := transcodedStream.ID
-		// 		tsd := transcodedStreamData{
-		// 			StreamID: string(transcodedID),
-		// 			Format:   req.Formats[0],
-		// 			Bitrate:  req.Bitrates[0],
-		// 			CodecIn:  req.CodecIn,
-		// 			CodecOut: req.CodecOut[0],
-		// 		}
-		// 		ack := &transcodeAckMsgData{
-		// 			OriginNode:     req.OriginNode,
-		// 			OriginStreamID: req.OriginStreamID,
-		// 			NewStreamIDs:   []transcodedStreamData{tsd},
-		// 		}
-		// 		glog.V(logger.Info).Infof("Sending Ack...")
-		// 		from.transcodeAck(ack)
-
-		// 	}
-		// } else {
-		// 	return self.protoError(ErrTranscode, "Error - Got %d downstream transcoders from the swarm.", len(peers))
-		// }
+		glog.V(logger.Info).Infof("Got Transcode Request: %v", req)
+		if self.transcoder == nil {
+			// an empty ack makes the requester move on to its next candidate
+			req.from.transcodeAck(&transcodeAckMsgData{
+				OriginNode:     req.OriginNode,
+				OriginStreamID: req.OriginStreamID,
+				TranscodeID:    req.TranscodeID,
+			})
+		} else {
+			self.transcoder.HandleTranscodeRequestMsg(&req, req.from)
+		}
 
 	case transcodeAckMsg:
 		var req transcodeAckMsgData
@@ -468,28 +422,48 @@ func (self *bzz) handle() error {
 			return self.protoError(ErrDecode, "<- %v: %v", msg, err)
 		}
 		//Check local map to see if you need to pass it back to upstream requester
-		upstreamPeer := self.streamDB.UpstreamTranscodeRequesters[streaming.MakeStreamID(req.OriginNode, req.OriginStreamID)]
+		upstreamPeer := self.streamDB.UpstreamTranscodeRequester(streaming.MakeStreamID(req.OriginNode, req.OriginStreamID))
 		// for k, _ := range self.streamDB.UpstreamTranscodeRequesters {
 		// 	fmt.Println("Ack db key: ", k)
 		// }
 		// fmt.Println("Peer for Id: ", upstreamPeer, streaming.MakeStreamID(req.OriginNode, req.OriginStreamID))
 		if upstreamPeer != nil {
 			glog.V(logger.Info).Infof("Forwarding Transcode Ack to upstream peer")
+			// the relay pays the transcoder and charges the upstream requester
+			units := self.pricing.TranscodeJobUnits(len(req.NewStreamIDs))
+			self.account(-units)
+			upstreamPeer.account(units)
 			upstreamPeer.transcodeAck(&req)
 		} else {
 			glog.V(logger.Info).Infof("Got Transcode Ack: ", req)
 			if len(req.NewStreamIDs) == 0 {
-				//Transcode failed.  Need to:
-				//1. Send a EOF packet to close the RTMP stream to current requester
-				//2. Request for another transcoder (Maybe just call forwarder.Transcode() again?)
+				//Transcode failed, request another transcoder
+				err := self.hive.transcodeFailed(req.TranscodeID, &peer{bzz: self})
+				if err != nil {
+					glog.Errorf("Error: cannot find a transcoder for %x: %v", req.TranscodeID[:4], err)
+				}
 			} else {
+				self.account(-self.pricing.TranscodeJobUnits(len(req.NewStreamIDs)))
+				self.hive.transcodeAcked(req.TranscodeID)
 				for _, newID := range req.NewStreamIDs {
 					self.streamDB.AddTranscodedStream(streaming.MakeStreamID(req.OriginNode, req.OriginStreamID), newID)
 					glog.V(logger.Info).Infof("Transcoded Stream: ", newID)
 				}
+				self.streamer.PostEvent(newTranscodeAckEvent(&req, self.remoteAddr.Addr))
+				if self.transcoder != nil {
+					self.transcoder.VerifyTranscode(&req, &peer{bzz: self})
+				}
 			}
 		}
 
+	case capabilitiesMsg:
+		var req Capabilities
+		if err := msg.Decode(&req); err != nil {
+			return self.protoError(ErrDecode, "<- %v: %v", msg, err)
+		}
+		glog.V(logger.Debug).Infof("<- capabilities: %v", &req)
+		self.setRemoteCaps(&req)
+
 	case peersMsg:
 		// response to lookups and immediate response to retrieve requests
 		// dispatches new peer data to the hive that adds them to KADDB
@@ -618,6 +592,10 @@ func (self *bzz) handleStatus() (err error) {
 		return self.protoError(ErrUnwanted, "%v", err)
 	}
 
+	if caps := self.hive.Capabilities(); caps != nil {
+		self.capabilities(caps)
+	}
+
 	// hive sets syncstate so sync should start after node added
 	glog.V(logger.Info).Infof("syncronisation request sent with %v", self.syncState)
 	self.syncRequest()
@@ -750,11 +728,30 @@ func (self *bzz) transcode(req *transcodeRequestMsgData) error {
 	return self.send(transcodeRequestMsg, req)
 }
 
+// remoteCaps returns the capabilities advertised by the remote peer, nil if
+// it did not advertise any
+func (self *bzz) remoteCaps() *Capabilities {
+	self.capsLock.RLock()
+	defer self.capsLock.RUnlock()
+	return self.caps
+}
+
+func (self *bzz) setRemoteCaps(caps *Capabilities) {
+	self.capsLock.Lock()
+	defer self.capsLock.Unlock()
+	self.caps = caps
+}
+
 // send transcodeAckMsg
 func (self *bzz) transcodeAck(req *transcodeAckMsgData) error {
 	return self.send(transcodeAckMsg, req)
 }
 
+// send capabilitiesMsg
+func (self *bzz) capabilities(req *Capabilities) error {
+	return self.send(capabilitiesMsg, req)
+}
+
 func (self *bzz) syncRequest() error {
 	req := &syncRequestMsgData{}
 	if self.hive.syncEnabled {
@@ -2,11 +2,17 @@ package network
 
 import (
 	"strings"
+	"sync"
 
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/swarm/network/kademlia"
 	"github.com/livepeer/livepeer-swarm/livepeer/streaming"
 )
 
+// StreamDB is written by the protocol goroutines of the peers and read by
+// the media server and the APIs, the maps are only accessed under lock
 type StreamDB struct {
+	lock                        sync.RWMutex
 	DownstreamRequesters        map[streaming.StreamID][]*peer
 	UpstreamTranscodeRequesters map[streaming.StreamID]*peer
 	TranscodedStreams           map[streaming.StreamID][]transcodedStreamData
@@ -21,10 +27,14 @@ func NewStreamDB() *StreamDB {
 }
 
 func (self *StreamDB) AddDownstreamPeer(streamID streaming.StreamID, p *peer) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
 	self.DownstreamRequesters[streamID] = append(self.DownstreamRequesters[streamID], p)
 }
 
 func (self *StreamDB) RemoveDownstreamPeer(streamID streaming.StreamID, p *peer) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
 	peers := self.DownstreamRequesters[streamID]
 	removei := -1
 
@@ -42,9 +52,60 @@ func (self *StreamDB) RemoveDownstreamPeer(streamID streaming.StreamID, p *peer)
 }
 
 func (self *StreamDB) AddUpstreamTranscodeRequester(transcodeID streaming.StreamID, p *peer) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
 	self.UpstreamTranscodeRequesters[transcodeID] = p
 }
 
+// UpstreamTranscodeRequester returns the peer a transcode ack is relayed to,
+// nil if the request originated here
+func (self *StreamDB) UpstreamTranscodeRequester(transcodeID streaming.StreamID) *peer {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	return self.UpstreamTranscodeRequesters[transcodeID]
+}
+
 func (self *StreamDB) AddTranscodedStream(originalStreamID streaming.StreamID, transcodedStream transcodedStreamData) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
 	self.TranscodedStreams[originalStreamID] = append(self.TranscodedStreams[originalStreamID], transcodedStream)
 }
+
+// TranscodedStream is a transcoded version of a stream
+type TranscodedStream struct {
+	StreamID streaming.StreamID
+	Profile  string
+}
+
+// Transcoded returns the transcoded versions of a stream acked so far
+func (self *StreamDB) Transcoded(originalStreamID streaming.StreamID) []TranscodedStream {
+	self.lock.RLock()
+	defer self.lock.RUnlock()
+	return transcodedStreams(self.TranscodedStreams[originalStreamID])
+}
+
+func transcodedStreams(data []transcodedStreamData) []TranscodedStream {
+	strms := make([]TranscodedStream, len(data))
+	for i, d := range data {
+		strms[i] = TranscodedStream{StreamID: streaming.StreamID(d.StreamID), Profile: d.Profile.Name}
+	}
+	return strms
+}
+
+// TranscodeAckEvent is posted to the streamer when a transcoder acked a
+// transcode request of this node
+type TranscodeAckEvent struct {
+	StreamID    streaming.StreamID
+	TranscodeID common.Hash
+	Peer        kademlia.Address // the peer the ack came from
+	Streams     []TranscodedStream
+}
+
+func newTranscodeAckEvent(ack *transcodeAckMsgData, from kademlia.Address) TranscodeAckEvent {
+	return TranscodeAckEvent{
+		StreamID:    streaming.MakeStreamID(ack.OriginNode, ack.OriginStreamID),
+		TranscodeID: ack.TranscodeID,
+		Peer:        from,
+		Streams:     transcodedStreams(ack.NewStreamIDs),
+	}
+}
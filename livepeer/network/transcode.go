@@ -0,0 +1,210 @@
+package network
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/logger"
+	"github.com/ethereum/go-ethereum/logger/glog"
+	"github.com/ethereum/go-ethereum/swarm/network/kademlia"
+	"github.com/livepeer/livepeer-swarm/livepeer/streaming"
+	"github.com/livepeer/livepeer-swarm/livepeer/transcoding"
+	lpmsStream "github.com/livepeer/lpms/stream"
+)
+
+var (
+	// number of sampled segments checked per transcoded stream
+	verifySamples = 3
+	// time after which verification of a transcoded stream is given up
+	verifyTimeout = 5 * time.Minute
+)
+
+// TranscodeHandler accepts transcode requests coming via the bzz wire protocol
+// and runs them as jobs on the local scheduler.  On the requesting side it
+// spot-checks the streams returned by transcoders.
+type TranscodeHandler struct {
+	scheduler  *transcoding.Scheduler
+	verifier   *transcoding.Verifier // nil if transcoded streams are not verified
+	ffmpegPath string
+	workDir    string
+}
+
+// NewTranscodeHandler creates the handler.  verifyRate is the fraction of
+// transcoded segments checked, 0 disables verification.
+func NewTranscodeHandler(scheduler *transcoding.Scheduler, ffmpegPath, workDir string, verifyRate float64) *TranscodeHandler {
+	self := &TranscodeHandler{
+		scheduler:  scheduler,
+		ffmpegPath: ffmpegPath,
+		workDir:    workDir,
+	}
+	if verifyRate > 0 {
+		newTranscoder := func(profile transcoding.Profile) transcoding.Transcoder {
+			return transcoding.NewFFMpegTranscoder(profile, ffmpegPath, workDir)
+		}
+		self.verifier = transcoding.NewVerifier(verifyRate, transcoding.NewFFProbe(ffmpegPath, workDir), newTranscoder)
+	}
+	return self
+}
+
+// HandleTranscodeRequestMsg subscribes to the original HLS stream and queues
+// a job per requested profile, transcoding it into a new stream.  The ack
+// carries the new stream IDs, or no streams at all if the request cannot be
+// served.
+func (self *TranscodeHandler) HandleTranscodeRequestMsg(req *transcodeRequestMsgData, p *peer) error {
+	ack := &transcodeAckMsgData{
+		OriginNode:     req.OriginNode,
+		OriginStreamID: req.OriginStreamID,
+		TranscodeID:    req.TranscodeID,
+	}
+	caps := p.hive.Capabilities()
+	if caps == nil || len(req.Profiles) == 0 || !caps.CanTranscode(req.CodecIn, req.Profiles) {
+		glog.V(logger.Info).Infof("Cannot serve transcode request %x, sending empty ack", req.TranscodeID[:4])
+		return p.transcodeAck(ack)
+	}
+	for _, profile := range req.Profiles {
+		if err := profile.Validate(); err != nil {
+			glog.V(logger.Info).Infof("Invalid transcode request %x: %v", req.TranscodeID[:4], err)
+			return p.transcodeAck(ack)
+		}
+	}
+
+	origID := streaming.MakeStreamID(req.OriginNode, req.OriginStreamID)
+	if p.streamer.GetNetworkStream(origID) == nil {
+		(*p.forwarder).Stream(origID.String(), kademlia.Address{}, lpmsStream.HLS)
+	}
+	for _, profile := range req.Profiles {
+		newID, err := self.startJob(p.streamer, origID, profile)
+		if err != nil {
+			glog.Errorf("Error starting transcode job for %v: %v", profile.Name, err)
+			continue
+		}
+		ack.NewStreamIDs = append(ack.NewStreamIDs, transcodedStreamData{
+			StreamID: newID.String(),
+			CodecIn:  req.CodecIn,
+			Profile:  profile,
+		})
+	}
+	// the requester pays for every rendition in the ack
+	if err := p.account(p.pricing.TranscodeJobUnits(len(ack.NewStreamIDs))); err != nil {
+		for _, data := range ack.NewStreamIDs {
+			self.scheduler.Cancel(data.StreamID)
+		}
+		ack.NewStreamIDs = nil
+	}
+	glog.V(logger.Info).Infof("Sending Ack...")
+	return p.transcodeAck(ack)
+}
+
+// startJob creates the stream for one profile and queues the job filling it
+func (self *TranscodeHandler) startJob(streamer *streaming.Streamer, origID streaming.StreamID, profile transcoding.Profile) (streaming.StreamID, error) {
+	newID := streaming.MakeStreamID(streamer.SelfAddress, fmt.Sprintf("%x", streaming.RandomStreamID()))
+	out, err := streamer.AddNewNetworkStream(newID, lpmsStream.HLS)
+	if err != nil {
+		return "", err
+	}
+	mux := transcoding.NewSegmentMuxer()
+	if err := streamer.SubscribeToHLSStream(origID.String(), newID.String(), mux); err != nil {
+		streamer.DeleteNetworkStream(newID)
+		return "", err
+	}
+	cleanup := func() {
+		streamer.UnsubscribeToHLSStream(origID.String(), newID.String())
+		streamer.DeleteNetworkStream(newID)
+	}
+
+	t := transcoding.NewFFMpegTranscoder(profile, self.ffmpegPath, self.workDir)
+	job := &transcoding.Job{
+		ID:       newID.String(),
+		StreamID: origID.String(),
+		Run:      transcoding.TranscodeSegments(t, mux.C, out),
+		Done:     cleanup,
+	}
+	if err := self.scheduler.Submit(job); err != nil {
+		cleanup()
+		return "", err
+	}
+	return newID, nil
+}
+
+// VerifyTranscode spot-checks the streams listed in the ack in the background.
+// p is the transcoder that sent the ack, it is flagged if a check fails.
+func (self *TranscodeHandler) VerifyTranscode(ack *transcodeAckMsgData, p *peer) {
+	if self.verifier == nil {
+		return
+	}
+	origID := streaming.MakeStreamID(ack.OriginNode, ack.OriginStreamID)
+	for _, data := range ack.NewStreamIDs {
+		go self.verifyStream(p, origID, data)
+	}
+}
+
+// verifyStream subscribes to both the source and the transcoded stream and
+// compares sampled source segments with their transcoded counterparts, which
+// carry the same sequence number
+func (self *TranscodeHandler) verifyStream(p *peer, origID streaming.StreamID, data transcodedStreamData) {
+	streamer := p.streamer
+	newID := streaming.StreamID(data.StreamID)
+	subID := fmt.Sprintf("verify%x", streaming.RandomStreamID().Bytes()[:4])
+
+	src := transcoding.NewSegmentMuxer()
+	if err := streamer.SubscribeToHLSStream(origID.String(), subID, src); err != nil {
+		glog.Errorf("Cannot verify %v: %v", newID, err)
+		return
+	}
+	defer streamer.UnsubscribeToHLSStream(origID.String(), subID)
+	out := transcoding.NewSegmentMuxer()
+	(*p.forwarder).Stream(newID.String(), kademlia.Address{}, lpmsStream.HLS)
+	if err := streamer.SubscribeToHLSStream(newID.String(), subID, out); err != nil {
+		glog.Errorf("Cannot verify %v: %v", newID, err)
+		return
+	}
+	defer func() {
+		streamer.UnsubscribeToHLSStream(newID.String(), subID)
+		if !streamer.HasSubscribers(newID.String()) {
+			(*p.forwarder).StopStream(newID.String(), kademlia.Address{}, lpmsStream.HLS)
+		}
+	}()
+
+	samples := make(map[uint64][]byte)
+	timeout := time.After(verifyTimeout)
+	for checked := 0; checked < verifySamples; {
+		select {
+		case seg := <-src.C:
+			if self.verifier.Sample() {
+				samples[seg.SeqNo] = seg.Data
+			}
+		case seg := <-out.C:
+			for seqNo := range samples {
+				// the transcoded segment was dropped
+				if seqNo < seg.SeqNo {
+					delete(samples, seqNo)
+				}
+			}
+			source, ok := samples[seg.SeqNo]
+			if !ok {
+				continue
+			}
+			delete(samples, seg.SeqNo)
+			checked++
+			err := self.verifier.Verify(data.Profile, source, seg.Data)
+			if _, failed := err.(*transcoding.VerificationError); failed {
+				glog.V(logger.Warn).Infof("Transcoder %v failed check of %v segment %d: %v", p, newID, seg.SeqNo, err)
+				p.hive.flagTranscoder(p.Addr())
+				return
+			}
+			if err != nil {
+				glog.Errorf("Cannot verify %v: %v", newID, err)
+				return
+			}
+		case <-timeout:
+			glog.V(logger.Info).Infof("Verification of %v timed out", newID)
+			return
+		}
+	}
+	glog.V(logger.Info).Infof("Transcoded stream %v passed %d checks", newID, verifySamples)
+}
+
+// StreamEnded cancels the jobs transcoding the stream
+func (self *TranscodeHandler) StreamEnded(streamID streaming.StreamID) {
+	self.scheduler.CancelStream(streamID.String())
+}
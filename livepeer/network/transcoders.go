@@ -0,0 +1,308 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/logger"
+	"github.com/ethereum/go-ethereum/logger/glog"
+	"github.com/ethereum/go-ethereum/swarm/network/kademlia"
+	"github.com/livepeer/livepeer-swarm/livepeer/storage"
+	"github.com/livepeer/livepeer-swarm/livepeer/transcoding"
+)
+
+const (
+	// number of closest peers considered when choosing a transcoder
+	transcodeCandidates = 5
+)
+
+var (
+	// time to wait for a transcodeAckMsg before asking the next candidate
+	transcodeAckTimeout = 10 * time.Second
+
+	// time a transcoder that failed verification is not asked again
+	transcoderFlagTime = time.Hour
+
+	// output codecs produced by the lpms ffmpeg transcoder (-c:v libx264)
+	defaultCodecsOut = []string{"H264"}
+
+	errNoTranscoder = errors.New("no transcoder candidates left")
+)
+
+/*
+Capabilities describe the transcoding work a node is able and willing to take on.
+They are sent to peers in a capabilitiesMsg right after the handshake and again
+whenever the local load changes, so the forwarder can route transcode requests
+to nodes that can actually serve them.
+
+An empty codec or resolution list means no restriction.
+*/
+type Capabilities struct {
+	Transcoder  bool     // ffmpeg is available on the node
+	CodecsIn    []string // codecs the node can decode
+	CodecsOut   []string // codecs the node can encode
+	Resolutions []string // output resolutions the node can produce
+	MaxJobs     uint64   // maximum number of concurrent transcode jobs
+	ActiveJobs  uint64   // number of transcode jobs running
+	MaxQueued   uint64   // maximum number of transcode jobs waiting for a free slot
+	QueuedJobs  uint64   // number of transcode jobs waiting for a free slot
+}
+
+// NewCapabilities returns the capabilities of the local node, looking for the
+// ffmpeg binary the same way the lpms transcoder invokes it
+func NewCapabilities(ffmpegPath string, maxJobs, maxQueued uint64) *Capabilities {
+	_, err := exec.LookPath(path.Join(ffmpegPath, "ffmpeg"))
+	if err != nil {
+		glog.V(logger.Info).Infof("ffmpeg not found in '%v', not advertising as a transcoder: %v", ffmpegPath, err)
+	}
+	return &Capabilities{
+		Transcoder: err == nil,
+		CodecsOut:  defaultCodecsOut,
+		MaxJobs:    maxJobs,
+		MaxQueued:  maxQueued,
+	}
+}
+
+func (self *Capabilities) String() string {
+	return fmt.Sprintf("transcoder: %v, in: %v, out: %v, resolutions: %v, jobs: %d/%d, queued: %d/%d", self.Transcoder, self.CodecsIn, self.CodecsOut, self.Resolutions, self.ActiveJobs, self.MaxJobs, self.QueuedJobs, self.MaxQueued)
+}
+
+// CanTranscode returns true if the node supports the input codec and the
+// codecs and resolutions of all profiles and has a free slot or room in its
+// queue
+func (self *Capabilities) CanTranscode(codecIn string, profiles []transcoding.Profile) bool {
+	if !self.Transcoder || self.ActiveJobs >= self.MaxJobs && self.QueuedJobs >= self.MaxQueued {
+		return false
+	}
+	if codecIn != "" && !supports(self.CodecsIn, codecIn) {
+		return false
+	}
+	for _, p := range profiles {
+		if !supports(self.CodecsOut, p.Codec) || !supports(self.Resolutions, p.Resolution) {
+			return false
+		}
+	}
+	return true
+}
+
+// Load is the number of running and queued jobs per job slot
+func (self *Capabilities) Load() float64 {
+	if self.MaxJobs == 0 {
+		return 1
+	}
+	return float64(self.ActiveJobs+self.QueuedJobs) / float64(self.MaxJobs)
+}
+
+func supports(list []string, item string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, s := range list {
+		if strings.EqualFold(s, item) {
+			return true
+		}
+	}
+	return false
+}
+
+// byLoad orders transcoder candidates: peers with advertised capabilities
+// come first, least loaded first; ties keep kademlia proximity order
+type byLoad []*peer
+
+func (self byLoad) Len() int      { return len(self) }
+func (self byLoad) Swap(i, j int) { self[i], self[j] = self[j], self[i] }
+func (self byLoad) Less(i, j int) bool {
+	ci, cj := self[i].remoteCaps(), self[j].remoteCaps()
+	if ci == nil || cj == nil {
+		return ci != nil && cj == nil
+	}
+	return ci.Load() < cj.Load()
+}
+
+// selectTranscoders filters peers that are known not to be able to serve the
+// request and orders the remaining ones best first.
+// Peers that have not advertised capabilities are kept as a last resort.
+func selectTranscoders(peers []*peer, codecIn string, profiles []transcoding.Profile) (candidates []*peer) {
+	for _, p := range peers {
+		if caps := p.remoteCaps(); caps != nil && !caps.CanTranscode(codecIn, profiles) {
+			glog.V(logger.Detail).Infof("skipping transcoder candidate %v (%v)", p, caps)
+			continue
+		}
+		candidates = append(candidates, p)
+	}
+	sort.Stable(byLoad(candidates))
+	return
+}
+
+// pending transcode request waiting for an ack
+type transcodeRequest struct {
+	msg        *transcodeRequestMsgData
+	candidates []*peer // remaining candidates, best first
+	peer       *peer   // candidate currently asked
+	timer      *time.Timer
+}
+
+// SetCapabilities sets the local capabilities and advertises them to all peers
+func (self *Hive) SetCapabilities(caps *Capabilities) {
+	self.capsLock.Lock()
+	self.caps = caps
+	self.capsLock.Unlock()
+	self.advertiseCapabilities()
+}
+
+// SetTranscodeLoad updates the number of local running and queued transcode jobs
+// and advertises the new load to all peers
+func (self *Hive) SetTranscodeLoad(running, queued uint64) {
+	self.capsLock.Lock()
+	if self.caps == nil || self.caps.ActiveJobs == running && self.caps.QueuedJobs == queued {
+		self.capsLock.Unlock()
+		return
+	}
+	caps := *self.caps
+	caps.ActiveJobs = running
+	caps.QueuedJobs = queued
+	self.caps = &caps
+	self.capsLock.Unlock()
+	self.advertiseCapabilities()
+}
+
+// Capabilities returns the local capabilities, nil if none were set
+func (self *Hive) Capabilities() *Capabilities {
+	self.capsLock.RLock()
+	defer self.capsLock.RUnlock()
+	return self.caps
+}
+
+func (self *Hive) advertiseCapabilities() {
+	caps := self.Capabilities()
+	if caps == nil {
+		return
+	}
+	for _, node := range self.kad.FindClosest(kademlia.Address{}, 0) {
+		node.(*peer).capabilities(caps)
+	}
+}
+
+// getTranscoders returns the peers closest to target that can serve the
+// transcode request, best candidate first.  Flagged transcoders are skipped.
+func (self *Hive) getTranscoders(target storage.Key, codecIn string, profiles []transcoding.Profile) []*peer {
+	var peers []*peer
+	for _, p := range self.getPeers(target, transcodeCandidates) {
+		if self.isFlagged(p.Addr()) {
+			glog.V(logger.Detail).Infof("skipping flagged transcoder %v", p)
+			continue
+		}
+		peers = append(peers, p)
+	}
+	return selectTranscoders(peers, codecIn, profiles)
+}
+
+// flagTranscoder marks a transcoder that returned a bad rendition, it is
+// avoided in selections for transcoderFlagTime
+func (self *Hive) flagTranscoder(addr kademlia.Address) {
+	self.transcodeLock.Lock()
+	defer self.transcodeLock.Unlock()
+	glog.V(logger.Warn).Infof("flagging transcoder %v", addr)
+	self.flagged[addr] = time.Now()
+}
+
+func (self *Hive) isFlagged(addr kademlia.Address) bool {
+	self.transcodeLock.Lock()
+	defer self.transcodeLock.Unlock()
+	t, ok := self.flagged[addr]
+	if ok && time.Since(t) > transcoderFlagTime {
+		delete(self.flagged, addr)
+		return false
+	}
+	return ok
+}
+
+// requestTranscode sends msg to the first candidate and keeps the request
+// pending until a non-empty ack arrives
+func (self *Hive) requestTranscode(msg *transcodeRequestMsgData, candidates []*peer) error {
+	req := &transcodeRequest{
+		msg:        msg,
+		candidates: candidates,
+	}
+	self.transcodeLock.Lock()
+	self.transcodeReqs[msg.TranscodeID] = req
+	self.transcodeLock.Unlock()
+	return self.nextTranscoder(req)
+}
+
+// transcodeFailed is called when candidate p sent an empty ack or did not
+// answer in time. The request is passed on to the next candidate.
+func (self *Hive) transcodeFailed(id common.Hash, p *peer) error {
+	self.transcodeLock.Lock()
+	req := self.transcodeReqs[id]
+	if req == nil || req.peer == nil || req.peer.Addr() != p.Addr() {
+		// already acked or a late answer from an earlier candidate
+		self.transcodeLock.Unlock()
+		return nil
+	}
+	// an empty ack and the timeout of the same candidate only move on once
+	req.peer = nil
+	if req.timer != nil {
+		req.timer.Stop()
+	}
+	self.transcodeLock.Unlock()
+	glog.V(logger.Info).Infof("transcoder %v failed request %x, trying next candidate", p, id[:4])
+	return self.nextTranscoder(req)
+}
+
+// transcodeAcked removes the pending request once a transcoder accepted it
+func (self *Hive) transcodeAcked(id common.Hash) {
+	self.transcodeLock.Lock()
+	defer self.transcodeLock.Unlock()
+	if req := self.transcodeReqs[id]; req != nil {
+		if req.timer != nil {
+			req.timer.Stop()
+		}
+		delete(self.transcodeReqs, id)
+	}
+}
+
+// nextTranscoder sends the request to the remaining candidates in turn until
+// one of them can be reached. Sending blocks, so transcodeLock is only held
+// while a candidate is picked.
+func (self *Hive) nextTranscoder(req *transcodeRequest) error {
+	id := req.msg.TranscodeID
+	for {
+		self.transcodeLock.Lock()
+		if self.transcodeReqs[id] != req {
+			// acked meanwhile
+			self.transcodeLock.Unlock()
+			return nil
+		}
+		if len(req.candidates) == 0 {
+			delete(self.transcodeReqs, id)
+			self.transcodeLock.Unlock()
+			return errNoTranscoder
+		}
+		p := req.candidates[0]
+		req.candidates = req.candidates[1:]
+		req.peer = p
+		self.transcodeLock.Unlock()
+
+		glog.Infof("Sending transcode req to peer: %v", p.Addr())
+		if err := p.transcode(req.msg); err != nil {
+			continue
+		}
+		self.transcodeLock.Lock()
+		if req.peer == p {
+			req.timer = time.AfterFunc(transcodeAckTimeout, func() {
+				if err := self.transcodeFailed(id, p); err != nil {
+					glog.V(logger.Warn).Infof("cannot find a transcoder for %x: %v", id[:4], err)
+				}
+			})
+		}
+		self.transcodeLock.Unlock()
+		return nil
+	}
+}
@@ -0,0 +1,244 @@
+package network
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/p2p"
+	"github.com/ethereum/go-ethereum/swarm/network/kademlia"
+	"github.com/livepeer/livepeer-swarm/livepeer/transcoding"
+)
+
+func newTestTranscoder(b byte, caps *Capabilities) *peer {
+	return &peer{bzz: &bzz{
+		remoteAddr: &peerAddr{Addr: kademlia.Address{b}},
+		caps:       caps,
+	}}
+}
+
+func TestCanTranscode(t *testing.T) {
+	caps := &Capabilities{
+		Transcoder:  true,
+		CodecsOut:   []string{"H264"},
+		Resolutions: []string{"1280x720", "640x360"},
+		MaxJobs:     2,
+		ActiveJobs:  1,
+	}
+	ladder := []transcoding.Profile{
+		{Name: "P720p", Resolution: "1280x720", Codec: "h264"},
+		{Name: "P360p", Resolution: "640x360", Codec: "H264"},
+	}
+	if !caps.CanTranscode("AAC", ladder) {
+		t.Errorf("expected %v to accept request", caps)
+	}
+	if caps.CanTranscode("", []transcoding.Profile{{Resolution: "640x360", Codec: "H265"}}) {
+		t.Errorf("expected %v to reject unsupported output codec", caps)
+	}
+	if caps.CanTranscode("", append(ladder, transcoding.Profile{Resolution: "1920x1080", Codec: "H264"})) {
+		t.Errorf("expected %v to reject unsupported resolution", caps)
+	}
+	caps.ActiveJobs = 2
+	if caps.CanTranscode("", nil) {
+		t.Errorf("expected %v to reject request when fully loaded", caps)
+	}
+	caps.MaxQueued = 1
+	if !caps.CanTranscode("", nil) {
+		t.Errorf("expected %v to queue request when all slots are busy", caps)
+	}
+	caps.QueuedJobs = 1
+	if caps.CanTranscode("", nil) {
+		t.Errorf("expected %v to reject request when the queue is full", caps)
+	}
+	caps = &Capabilities{MaxJobs: 2}
+	if caps.CanTranscode("", nil) {
+		t.Errorf("expected %v to reject request without ffmpeg", caps)
+	}
+}
+
+func TestSelectTranscoders(t *testing.T) {
+	busy := newTestTranscoder(1, &Capabilities{Transcoder: true, MaxJobs: 4, ActiveJobs: 3})
+	unknown := newTestTranscoder(2, nil)
+	none := newTestTranscoder(3, &Capabilities{MaxJobs: 4})
+	idle := newTestTranscoder(4, &Capabilities{Transcoder: true, MaxJobs: 4})
+
+	got := selectTranscoders([]*peer{busy, unknown, none, idle}, "", nil)
+	exp := []*peer{idle, busy, unknown}
+	if len(got) != len(exp) {
+		t.Fatalf("expected %d candidates, got %d", len(exp), len(got))
+	}
+	for i := range exp {
+		if got[i] != exp[i] {
+			t.Errorf("candidate %d: expected %v, got %v", i, exp[i].Addr(), got[i].Addr())
+		}
+	}
+}
+
+func TestTranscodeFallback(t *testing.T) {
+	defer func(d time.Duration) { transcodeAckTimeout = d }(transcodeAckTimeout)
+	transcodeAckTimeout = time.Hour
+
+	hive := NewHive(common.Hash{}, NewHiveParams(""), false, false)
+	first := newTestTranscoder(1, nil)
+	second := newTestTranscoder(2, nil)
+	// both candidates have blocked writes, so sending always fails
+	first.hive, second.hive = hive, hive
+	hive.blockWrite = true
+
+	msg := &transcodeRequestMsgData{TranscodeID: common.Hash{1}}
+	err := hive.requestTranscode(msg, []*peer{first, second})
+	if err != errNoTranscoder {
+		t.Fatalf("expected errNoTranscoder, got %v", err)
+	}
+	if len(hive.transcodeReqs) != 0 {
+		t.Errorf("expected no pending requests, got %d", len(hive.transcodeReqs))
+	}
+}
+
+// connectTestTranscoder gives p a message pipe and reports every transcode
+// request p is sent on asked, it returns the remote end of the pipe
+func connectTestTranscoder(p *peer, hive *Hive, asked chan<- byte) *p2p.MsgPipeRW {
+	rw, remote := p2p.MsgPipe()
+	p.hive, p.rw, p.streamDB = hive, rw, NewStreamDB()
+	go func() {
+		for {
+			msg, err := remote.ReadMsg()
+			if err != nil {
+				return
+			}
+			msg.Discard()
+			if msg.Code == transcodeRequestMsg {
+				asked <- p.Addr()[0]
+			}
+		}
+	}()
+	return remote
+}
+
+func pendingTranscoder(hive *Hive, id common.Hash) *peer {
+	hive.transcodeLock.Lock()
+	defer hive.transcodeLock.Unlock()
+	if req := hive.transcodeReqs[id]; req != nil {
+		return req.peer
+	}
+	return nil
+}
+
+func expectAsked(t *testing.T, asked <-chan byte, exp byte) {
+	select {
+	case b := <-asked:
+		if b != exp {
+			t.Fatalf("expected transcoder %d to be asked, got %d", exp, b)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("timeout waiting for transcoder %d to be asked", exp)
+	}
+}
+
+func TestTranscodeFallbackEmptyAck(t *testing.T) {
+	defer func(d time.Duration) { transcodeAckTimeout = d }(transcodeAckTimeout)
+	transcodeAckTimeout = time.Hour
+
+	hive := NewHive(common.Hash{}, NewHiveParams(""), false, false)
+	asked := make(chan byte, 2)
+	first := newTestTranscoder(1, nil)
+	second := newTestTranscoder(2, nil)
+	remote := connectTestTranscoder(first, hive, asked)
+	defer remote.Close()
+	defer connectTestTranscoder(second, hive, asked).Close()
+
+	id := common.Hash{1}
+	if err := hive.requestTranscode(&transcodeRequestMsgData{TranscodeID: id}, []*peer{first, second}); err != nil {
+		t.Fatal(err)
+	}
+	expectAsked(t, asked, 1)
+
+	// the first candidate cannot serve the request and answers with an empty ack
+	go p2p.Send(remote, transcodeAckMsg, &transcodeAckMsgData{TranscodeID: id})
+	if err := first.handle(); err != nil {
+		t.Fatal(err)
+	}
+	expectAsked(t, asked, 2)
+	if p := pendingTranscoder(hive, id); p != second {
+		t.Errorf("expected request pending at second candidate, got %v", p)
+	}
+
+	hive.transcodeAcked(id)
+	if p := pendingTranscoder(hive, id); p != nil {
+		t.Errorf("expected no pending request after ack, got %v", p)
+	}
+}
+
+func TestTranscodeFallbackTimeout(t *testing.T) {
+	defer func(d time.Duration) { transcodeAckTimeout = d }(transcodeAckTimeout)
+	transcodeAckTimeout = 10 * time.Millisecond
+
+	hive := NewHive(common.Hash{}, NewHiveParams(""), false, false)
+	asked := make(chan byte, 2)
+	first := newTestTranscoder(1, nil)
+	second := newTestTranscoder(2, nil)
+	defer connectTestTranscoder(first, hive, asked).Close()
+	defer connectTestTranscoder(second, hive, asked).Close()
+
+	id := common.Hash{1}
+	if err := hive.requestTranscode(&transcodeRequestMsgData{TranscodeID: id}, []*peer{first, second}); err != nil {
+		t.Fatal(err)
+	}
+	// neither candidate answers, both are asked in turn and the request is dropped
+	expectAsked(t, asked, 1)
+	expectAsked(t, asked, 2)
+	time.Sleep(10 * transcodeAckTimeout)
+	if p := pendingTranscoder(hive, id); p != nil {
+		t.Errorf("expected request to be dropped after the last candidate timed out, got %v", p)
+	}
+}
+
+func TestTranscodeSendUnlocked(t *testing.T) {
+	defer func(d time.Duration) { transcodeAckTimeout = d }(transcodeAckTimeout)
+	transcodeAckTimeout = time.Hour
+
+	hive := NewHive(common.Hash{}, NewHiveParams(""), false, false)
+	first := newTestTranscoder(1, nil)
+	// nobody reads the remote end, so sending the request blocks
+	rw, remote := p2p.MsgPipe()
+	first.hive, first.rw, first.streamDB = hive, rw, NewStreamDB()
+
+	id := common.Hash{1}
+	errc := make(chan error)
+	go func() {
+		errc <- hive.requestTranscode(&transcodeRequestMsgData{TranscodeID: id}, []*peer{first})
+	}()
+	for pendingTranscoder(hive, id) != first {
+		time.Sleep(time.Millisecond)
+	}
+	flagged := make(chan bool)
+	go func() { flagged <- hive.isFlagged(first.Addr()) }()
+	select {
+	case <-flagged:
+	case <-time.After(time.Second):
+		t.Fatalf("transcodeLock held while sending the request")
+	}
+
+	remote.Close()
+	if err := <-errc; err != errNoTranscoder {
+		t.Errorf("expected errNoTranscoder, got %v", err)
+	}
+}
+
+func TestFlagTranscoder(t *testing.T) {
+	defer func(d time.Duration) { transcoderFlagTime = d }(transcoderFlagTime)
+	hive := NewHive(common.Hash{}, NewHiveParams(""), false, false)
+	addr := kademlia.Address{1}
+
+	hive.flagTranscoder(addr)
+	if !hive.isFlagged(addr) {
+		t.Errorf("expected %v to be flagged", addr)
+	}
+	if hive.isFlagged(kademlia.Address{2}) {
+		t.Errorf("expected other transcoders not to be flagged")
+	}
+	transcoderFlagTime = 0
+	if hive.isFlagged(addr) {
+		t.Errorf("expected flag to expire")
+	}
+}
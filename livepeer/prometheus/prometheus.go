@@ -0,0 +1,242 @@
+/*
+Package prometheus serves metrics in the Prometheus text exposition format.
+
+Every scrape builds a new Set from the collectors of the handler, so gauges
+like the subscribers of a stream disappear with the stream.  The metrics
+registered with go-ethereum's metrics package are exported by the collector
+returned by Registry.
+*/
+package prometheus
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Metric types
+const (
+	CounterType = "counter"
+	GaugeType   = "gauge"
+	SummaryType = "summary"
+)
+
+// quantiles exported for timers and histograms
+var quantiles = []float64{0.5, 0.75, 0.95, 0.99}
+
+type sample struct {
+	suffix string   // appended to the family name, e.g. _sum
+	labels []string // name, value pairs
+	value  float64
+}
+
+type family struct {
+	name    string
+	help    string
+	typ     string
+	samples []sample
+}
+
+// Set is the metrics of one scrape.  Samples of the same metric are written
+// together, in the order the metrics were first added.
+type Set struct {
+	families []*family
+	byName   map[string]*family
+}
+
+func NewSet() *Set {
+	return &Set{byName: make(map[string]*family)}
+}
+
+// Counter adds a sample of a counter, labels are name, value pairs
+func (self *Set) Counter(name, help string, value float64, labels ...string) {
+	self.add(name, help, CounterType, sample{labels: labels, value: value})
+}
+
+// Gauge adds a sample of a gauge, labels are name, value pairs
+func (self *Set) Gauge(name, help string, value float64, labels ...string) {
+	self.add(name, help, GaugeType, sample{labels: labels, value: value})
+}
+
+// Summary adds the quantiles, sum and count of a summary.  values holds the
+// value of every quantile in the quantiles slice.
+func (self *Set) Summary(name, help string, quantiles, values []float64, sum float64, count uint64, labels ...string) {
+	for i, q := range quantiles {
+		ql := append(append([]string{}, labels...), "quantile", formatValue(q))
+		self.add(name, help, SummaryType, sample{labels: ql, value: values[i]})
+	}
+	self.add(name, help, SummaryType, sample{suffix: "_sum", labels: labels, value: sum})
+	self.add(name, help, SummaryType, sample{suffix: "_count", labels: labels, value: float64(count)})
+}
+
+func (self *Set) add(name, help, typ string, s sample) {
+	f := self.byName[name]
+	if f == nil {
+		f = &family{name: name, help: help, typ: typ}
+		self.byName[name] = f
+		self.families = append(self.families, f)
+	}
+	f.samples = append(f.samples, s)
+}
+
+// WriteTo writes the set in the text format
+func (self *Set) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, f := range self.families {
+		if f.help != "" {
+			fmt.Fprintf(bw, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		}
+		fmt.Fprintf(bw, "# TYPE %s %s\n", f.name, f.typ)
+		for _, s := range f.samples {
+			bw.WriteString(f.name + s.suffix)
+			if len(s.labels) > 0 {
+				bw.WriteByte('{')
+				for i := 0; i+1 < len(s.labels); i += 2 {
+					if i > 0 {
+						bw.WriteByte(',')
+					}
+					fmt.Fprintf(bw, "%s=\"%s\"", s.labels[i], escapeLabel(s.labels[i+1]))
+				}
+				bw.WriteByte('}')
+			}
+			bw.WriteString(" " + formatValue(s.value) + "\n")
+		}
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (self *countingWriter) Write(p []byte) (int, error) {
+	n, err := self.w.Write(p)
+	self.n += int64(n)
+	return n, err
+}
+
+// Collector adds the metrics of a component to a scrape
+type Collector func(set *Set)
+
+// Handler serves the metrics of the collectors
+func Handler(collectors ...Collector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		set := NewSet()
+		for _, collect := range collectors {
+			collect(set)
+		}
+		w.Header().Set("Content-Type", ContentType)
+		set.WriteTo(w)
+	})
+}
+
+// the go-metrics types, matched by method set so the registry can come from
+// any copy of the package
+type (
+	timer interface {
+		Count() int64
+		Sum() int64
+		Percentiles([]float64) []float64
+		Rate1() float64
+	}
+	histogram interface {
+		Count() int64
+		Sum() int64
+		Percentiles([]float64) []float64
+	}
+	meter interface {
+		Count() int64
+		Rate1() float64
+	}
+	counter interface {
+		Count() int64
+	}
+	gauge interface {
+		Value() int64
+	}
+	gaugeFloat64 interface {
+		Value() float64
+	}
+)
+
+/*
+Registry returns a collector exporting the metrics of a go-metrics registry,
+each is the Each method of the registry, e.g. metrics.DefaultRegistry.Each,
+which holds the metrics of go-ethereum's metrics package.
+
+Names are prefixed and sanitized, livepeer/chunks/in becomes
+<prefix>livepeer_chunks_in.  Meters are exported as counters with a _total
+suffix, timers as summaries in seconds, go-metrics counters, which can be
+decremented, as gauges.  Metrics are sorted by name.
+*/
+func Registry(prefix string, each func(func(name string, metric interface{}))) Collector {
+	return func(set *Set) {
+		metrics := make(map[string]interface{})
+		var names []string
+		each(func(name string, metric interface{}) {
+			name = Name(prefix + name)
+			metrics[name] = metric
+			names = append(names, name)
+		})
+		sort.Strings(names)
+
+		for _, name := range names {
+			switch m := metrics[name].(type) {
+			case timer:
+				values := m.Percentiles(quantiles)
+				for i := range values {
+					values[i] /= 1e9
+				}
+				set.Summary(name+"_seconds", "", quantiles, values, float64(m.Sum())/1e9, uint64(m.Count()))
+			case histogram:
+				set.Summary(name, "", quantiles, m.Percentiles(quantiles), float64(m.Sum()), uint64(m.Count()))
+			case meter:
+				set.Counter(name+"_total", "", float64(m.Count()))
+			case counter:
+				set.Gauge(name, "", float64(m.Count()))
+			case gauge:
+				set.Gauge(name, "", float64(m.Value()))
+			case gaugeFloat64:
+				set.Gauge(name, "", m.Value())
+			}
+		}
+	}
+}
+
+// Name replaces the characters that are not valid in metric names with _
+func Name(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		valid := c == '_' || c == ':' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (i > 0 && c >= '0' && c <= '9')
+		if !valid {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,86 @@
+package prometheus
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	set := NewSet()
+	set.Gauge("livepeer_stream_subscribers", "Subscribers of the stream", 2, "stream", "a")
+	set.Counter("livepeer_stream_bytes_total", "", 10, "stream", "a", "direction", "in")
+	set.Gauge("livepeer_stream_subscribers", "Subscribers of the stream", 0, "stream", "b\"\n")
+	set.Summary("lag_seconds", "Lag\nin seconds", []float64{0.5}, []float64{1.5}, 3, 2)
+
+	var buf bytes.Buffer
+	n, err := set.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("Got error: %v", err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("Expecting %d bytes written, got %d", buf.Len(), n)
+	}
+	expected := `# HELP livepeer_stream_subscribers Subscribers of the stream
+# TYPE livepeer_stream_subscribers gauge
+livepeer_stream_subscribers{stream="a"} 2
+livepeer_stream_subscribers{stream="b\"\n"} 0
+# TYPE livepeer_stream_bytes_total counter
+livepeer_stream_bytes_total{stream="a",direction="in"} 10
+# HELP lag_seconds Lag\nin seconds
+# TYPE lag_seconds summary
+lag_seconds{quantile="0.5"} 1.5
+lag_seconds_sum 3
+lag_seconds_count 2
+`
+	if buf.String() != expected {
+		t.Errorf("Expecting\n%s\ngot\n%s", expected, buf.String())
+	}
+}
+
+type testMeter int64
+
+func (self testMeter) Count() int64   { return int64(self) }
+func (self testMeter) Rate1() float64 { return 0 }
+
+type testGauge int64
+
+func (self testGauge) Value() int64 { return int64(self) }
+
+func TestRegistry(t *testing.T) {
+	registry := map[string]interface{}{
+		"livepeer/chunks/in": testMeter(3),
+		"system/disk/0queue": testGauge(7),
+	}
+	each := func(f func(string, interface{})) {
+		for name, m := range registry {
+			f(name, m)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	Handler(Registry("", each)).ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("Expecting content type %v, got %v", ContentType, ct)
+	}
+	expected := `# TYPE livepeer_chunks_in_total counter
+livepeer_chunks_in_total 3
+# TYPE system_disk_0queue gauge
+system_disk_0queue 7
+`
+	if w.Body.String() != expected {
+		t.Errorf("Expecting\n%s\ngot\n%s", expected, w.Body.String())
+	}
+}
+
+func TestName(t *testing.T) {
+	for in, out := range map[string]string{
+		"livepeer/streams/req": "livepeer_streams_req",
+		"0peers":               "_peers",
+		"p2p.InboundTraffic":   "p2p_InboundTraffic",
+	} {
+		if name := Name(in); name != out {
+			t.Errorf("Expecting %v for %v, got %v", out, in, name)
+		}
+	}
+}
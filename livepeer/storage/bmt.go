@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+
+	"github.com/ethereum/go-ethereum/crypto/sha3"
+)
+
+/*
+The BMT hasher hashes the data of a chunk as a binary merkle tree of its
+segments, so that any segments of a chunk can be proven to belong to it with
+the nodes beside them instead of the whole chunk.
+
+The leaves of the tree are the Keccak256 hashes of the SegmentSize byte
+segments of the data, the last one possibly shorter.  A node is the hash of
+its two children, the odd node at the end of a level moves up unchanged.
+The key of a chunk is the hash of its 8 byte span and the root of the tree.
+
+Keys are SegmentSize long, so the children of an intermediate chunk are
+its segments.
+*/
+
+const SegmentSize = 32
+
+var errInvalidBMTProof = errors.New("invalid BMT proof")
+
+type bmtHasher struct {
+	data []byte
+}
+
+// NewBMTHasher returns the hasher of ChunkerParams.Hash "BMT", it expects
+// the span of a chunk followed by its data
+func NewBMTHasher() hash.Hash {
+	return &bmtHasher{}
+}
+
+func (self *bmtHasher) Write(p []byte) (int, error) {
+	self.data = append(self.data, p...)
+	return len(p), nil
+}
+
+func (self *bmtHasher) Sum(b []byte) []byte {
+	span, data := self.data, []byte(nil)
+	if len(span) >= 8 {
+		span, data = self.data[:8], self.data[8:]
+	}
+	return append(b, bmtKey(span, bmtRoot(data))...)
+}
+
+func (self *bmtHasher) Reset() {
+	self.data = self.data[:0]
+}
+
+func (self *bmtHasher) Size() int {
+	return SegmentSize
+}
+
+func (self *bmtHasher) BlockSize() int {
+	return 2 * SegmentSize
+}
+
+func bmtHash(data ...[]byte) []byte {
+	hasher := sha3.NewKeccak256()
+	for _, d := range data {
+		hasher.Write(d)
+	}
+	return hasher.Sum(nil)
+}
+
+func bmtKey(span, root []byte) Key {
+	return Key(bmtHash(span, root))
+}
+
+// bmtLeaves hashes the segments of data
+func bmtLeaves(data []byte) [][]byte {
+	var nodes [][]byte
+	for i := 0; i < len(data); i += SegmentSize {
+		end := i + SegmentSize
+		if end > len(data) {
+			end = len(data)
+		}
+		nodes = append(nodes, bmtHash(data[i:end]))
+	}
+	return nodes
+}
+
+// bmtParents hashes the nodes of a level in pairs
+func bmtParents(nodes [][]byte) [][]byte {
+	parents := make([][]byte, 0, (len(nodes)+1)/2)
+	for i := 0; i < len(nodes); i += 2 {
+		if i+1 == len(nodes) {
+			parents = append(parents, nodes[i])
+			break
+		}
+		parents = append(parents, bmtHash(nodes[i], nodes[i+1]))
+	}
+	return parents
+}
+
+func bmtRoot(data []byte) []byte {
+	nodes := bmtLeaves(data)
+	if len(nodes) == 0 {
+		return bmtHash()
+	}
+	for len(nodes) > 1 {
+		nodes = bmtParents(nodes)
+	}
+	return nodes[0]
+}
+
+// bmtSiblings returns the nodes needed besides segments lo to hi-1 of data
+// to compute the root, bottom up and left before right on each level
+func bmtSiblings(data []byte, lo, hi int) []Key {
+	var siblings []Key
+	nodes := bmtLeaves(data)
+	for n := len(nodes); n > 1; n = len(nodes) {
+		if lo%2 == 1 {
+			lo--
+			siblings = append(siblings, Key(nodes[lo]))
+		}
+		if hi%2 == 1 && hi < n {
+			siblings = append(siblings, Key(nodes[hi]))
+			hi++
+		}
+		nodes = bmtParents(nodes)
+		lo /= 2
+		hi = (hi + 1) / 2
+	}
+	return siblings
+}
+
+// bmtProvenRoot computes the root of the tree of n segments from the
+// segments in data, starting at segment lo, and the siblings given by
+// bmtSiblings
+func bmtProvenRoot(data []byte, lo, n int, siblings []Key) ([]byte, error) {
+	nodes := bmtLeaves(data)
+	hi := lo + len(nodes)
+	if len(nodes) == 0 || hi > n {
+		return nil, errInvalidBMTProof
+	}
+	for ; n > 1; n = (n + 1) / 2 {
+		if lo%2 == 1 {
+			if len(siblings) == 0 {
+				return nil, errInvalidBMTProof
+			}
+			nodes = append([][]byte{siblings[0]}, nodes...)
+			siblings = siblings[1:]
+			lo--
+		}
+		if hi%2 == 1 && hi < n {
+			if len(siblings) == 0 {
+				return nil, errInvalidBMTProof
+			}
+			nodes = append(nodes, siblings[0])
+			siblings = siblings[1:]
+			hi++
+		}
+		nodes = bmtParents(nodes)
+		lo /= 2
+		hi = (hi + 1) / 2
+	}
+	if len(siblings) > 0 {
+		return nil, errInvalidBMTProof
+	}
+	return nodes[0], nil
+}
+
+// isBMTChunk returns true if the key of the chunk is its BMT hash
+func isBMTChunk(chunk *Chunk) bool {
+	if len(chunk.SData) < 8 {
+		return false
+	}
+	return bytes.Equal(bmtKey(chunk.SData[:8], bmtRoot(chunk.SData[8:])), chunk.Key)
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+func TestBMTProvenRoot(t *testing.T) {
+	_, input := testDataReaderAndSlice(17 * SegmentSize)
+	for n := 1; n <= 17; n++ {
+		// the last segment is short
+		data := input[:n*SegmentSize-5]
+		root := bmtRoot(data)
+		for lo := 0; lo < n; lo++ {
+			for hi := lo + 1; hi <= n; hi++ {
+				end := hi * SegmentSize
+				if end > len(data) {
+					end = len(data)
+				}
+				siblings := bmtSiblings(data, lo, hi)
+				proven, err := bmtProvenRoot(data[lo*SegmentSize:end], lo, n, siblings)
+				if err != nil || !bytes.Equal(proven, root) {
+					t.Fatalf("%d segments, %d-%d: expecting root %x, got %x: %v", n, lo, hi, root, proven, err)
+				}
+				if len(siblings) > 0 {
+					if _, err := bmtProvenRoot(data[lo*SegmentSize:end], lo, n, siblings[1:]); err == nil {
+						t.Fatalf("%d segments, %d-%d: expecting missing siblings to fail", n, lo, hi)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestBMTHasher(t *testing.T) {
+	tester := &chunkerTester{t: t}
+	params := NewChunkerParams()
+	params.Hash = "BMT"
+	data, _ := testDataReaderAndSlice(600000)
+	chunkC := make(chan *Chunk, 1000)
+	tester.Split(NewTreeChunker(params), data, 600000, chunkC, &sync.WaitGroup{}, nil)
+	for _, chunk := range tester.chunks {
+		if !isBMTChunk(chunk) {
+			t.Fatalf("Expecting %v to be hashed with BMT", chunk.Key.Log())
+		}
+	}
+}
@@ -54,6 +54,8 @@ const (
 	defaultHash = "SHA3" // http://golang.org/pkg/hash/#Hash
 	// defaultHash           = "SHA256" // http://golang.org/pkg/hash/#Hash
 	defaultBranches int64 = 128
+	// chunks read ahead of sequential reads
+	defaultReadAhead int64 = 8
 	// hashSize     int64 = hasherfunc.New().Size() // hasher knows about its own length in bytes
 	// chunksize    int64 = branches * hashSize     // chunk is defined as this
 )
@@ -66,34 +68,90 @@ If all is well it is possible to implement this by simply composing readers so t
 The hashing itself does use extra copies and allocation though, since it does need it.
 */
 
+// Names of the chunkers, both make the same trees
+const (
+	TreeChunkerName    = "tree"
+	PyramidChunkerName = "pyramid"
+)
+
 type ChunkerParams struct {
-	Branches int64
-	Hash     string
+	Branches  int64
+	Hash      string
+	Chunker   string // TreeChunkerName or PyramidChunkerName
+	ReadAhead int64  // chunks retrieved ahead of sequential reads, 0 disables
+	// parity chunks among the children of intermediate chunks, each of them
+	// makes up for a child lost
+	Redundancy int64
+
+	hashFunc Hasher // overrides Hash for encrypted content
+}
+
+// hasher returns the hash the chunkers key chunks with
+func (self *ChunkerParams) hasher() Hasher {
+	if self.hashFunc != nil {
+		return self.hashFunc
+	}
+	return MakeHashFunc(self.Hash)
+}
+
+// Validate checks the redundancy fits in the intermediate chunks
+func (self *ChunkerParams) Validate() error {
+	if self.Redundancy < 0 {
+		return fmt.Errorf("invalid redundancy %d", self.Redundancy)
+	}
+	if max := maxParities(self.Branches); self.Redundancy > 0 && self.Redundancy > max {
+		return fmt.Errorf("redundancy %d too high for %d branches, at most %d", self.Redundancy, self.Branches, max)
+	}
+	return nil
 }
 
 func NewChunkerParams() *ChunkerParams {
 	return &ChunkerParams{
-		Branches: defaultBranches,
-		Hash:     defaultHash,
+		Branches:  defaultBranches,
+		Hash:      defaultHash,
+		Chunker:   TreeChunkerName,
+		ReadAhead: defaultReadAhead,
+	}
+}
+
+// NewChunker returns the chunker named in the params, the TreeChunker by
+// default and for redundancy
+func NewChunker(params *ChunkerParams) Chunker {
+	if params.Chunker == PyramidChunkerName && params.Redundancy <= 0 {
+		return NewPyramidChunker(params)
 	}
+	return NewTreeChunker(params)
 }
 
 type TreeChunker struct {
-	branches int64
+	branches int64 // data children of intermediate chunks
 	hashFunc Hasher
 	// calculated
 	hashSize    int64 // self.hashFunc.New().Size()
 	chunkSize   int64 // hashSize* branches
 	workerCount int
+	readAhead   int64
+	parities    int64
 }
 
 func NewTreeChunker(params *ChunkerParams) (self *TreeChunker) {
 	self = &TreeChunker{}
-	self.hashFunc = MakeHashFunc(params.Hash)
+	self.hashFunc = params.hasher()
 	self.branches = params.Branches
 	self.hashSize = int64(self.hashFunc().Size())
 	self.chunkSize = self.hashSize * self.branches
 	self.workerCount = 1
+	self.readAhead = params.ReadAhead
+	if params.Redundancy > 0 {
+		// invalid redundancy is rejected by Validate, it is clamped here
+		self.parities = params.Redundancy
+		if max := maxParities(self.branches); self.parities > max {
+			self.parities = max
+		}
+		if self.parities > 0 {
+			self.branches = redundantBranches(self.branches, self.parities)
+		}
+	}
 	return
 }
 
@@ -111,6 +169,7 @@ type hashJob struct {
 	chunk    []byte
 	size     int64
 	parentWg *sync.WaitGroup
+	sdata    *[]byte // receives the chunk for the parities of the parent
 }
 
 func (self *TreeChunker) Split(data io.Reader, size int64, chunkC chan *Chunk, swg, wwg *sync.WaitGroup) (Key, error) {
@@ -119,6 +178,21 @@ func (self *TreeChunker) Split(data io.Reader, size int64, chunkC chan *Chunk, s
 		panic("chunker must be initialised")
 	}
 
+	// the tree is split top down from the size, content of unknown length
+	// is split bottom up into the same tree
+	if size < 0 {
+		if self.parities > 0 {
+			return nil, ErrRedundancyUnknownLength
+		}
+		pyramid := &PyramidChunker{
+			hashFunc:  self.hashFunc,
+			chunkSize: self.chunkSize,
+			hashSize:  self.hashSize,
+			branches:  self.branches,
+		}
+		return pyramid.Split(data, size, chunkC, swg, wwg)
+	}
+
 	jobC := make(chan *hashJob, 2*processors)
 	wg := &sync.WaitGroup{}
 	errC := make(chan error)
@@ -143,7 +217,7 @@ func (self *TreeChunker) Split(data io.Reader, size int64, chunkC chan *Chunk, s
 	// this waitgroup member is released after the root hash is calculated
 	wg.Add(1)
 	//launch actual recursive function passing the waitgroups
-	go self.split(depth, treeSize/self.branches, key, data, size, jobC, chunkC, errC, quitC, wg, swg, wwg)
+	go self.split(depth, treeSize/self.branches, key, nil, data, size, jobC, chunkC, errC, quitC, wg, swg, wwg)
 
 	// closes internal error channel if all subprocesses in the workgroup finished
 	go func() {
@@ -167,7 +241,7 @@ func (self *TreeChunker) Split(data io.Reader, size int64, chunkC chan *Chunk, s
 	return key, nil
 }
 
-func (self *TreeChunker) split(depth int, treeSize int64, key Key, data io.Reader, size int64, jobC chan *hashJob, chunkC chan *Chunk, errC chan error, quitC chan bool, parentWg, swg, wwg *sync.WaitGroup) {
+func (self *TreeChunker) split(depth int, treeSize int64, key Key, sdata *[]byte, data io.Reader, size int64, jobC chan *hashJob, chunkC chan *Chunk, errC chan error, quitC chan bool, parentWg, swg, wwg *sync.WaitGroup) {
 
 	for depth > 0 && size < treeSize {
 		treeSize /= self.branches
@@ -188,7 +262,7 @@ func (self *TreeChunker) split(depth int, treeSize int64, key Key, data io.Reade
 			}
 		}
 		select {
-		case jobC <- &hashJob{key, chunkData, size, parentWg}:
+		case jobC <- &hashJob{key, chunkData, size, parentWg, sdata}:
 		case <-quitC:
 		}
 		return
@@ -198,6 +272,13 @@ func (self *TreeChunker) split(depth int, treeSize int64, key Key, data io.Reade
 	branchCnt := int64((size + treeSize - 1) / treeSize)
 
 	var chunk []byte = make([]byte, branchCnt*self.hashSize+8)
+	var children [][]byte
+	if self.parities > 0 {
+		// the parity keys follow the children, the chunk ends in their count
+		chunk = make([]byte, (branchCnt+self.parities)*self.hashSize+9)
+		chunk[len(chunk)-1] = byte(self.parities)
+		children = make([][]byte, branchCnt)
+	}
 	var pos, i int64
 
 	binary.LittleEndian.PutUint64(chunk[0:8], uint64(size))
@@ -214,8 +295,13 @@ func (self *TreeChunker) split(depth int, treeSize int64, key Key, data io.Reade
 		// the hash of that data
 		subTreeKey := chunk[8+i*self.hashSize : 8+(i+1)*self.hashSize]
 
+		var childData *[]byte
+		if children != nil {
+			childData = &children[i]
+		}
+
 		childrenWg.Add(1)
-		self.split(depth-1, treeSize/self.branches, subTreeKey, data, secSize, jobC, chunkC, errC, quitC, childrenWg, swg, wwg)
+		self.split(depth-1, treeSize/self.branches, subTreeKey, childData, data, secSize, jobC, chunkC, errC, quitC, childrenWg, swg, wwg)
 
 		i++
 		pos += treeSize
@@ -224,6 +310,9 @@ func (self *TreeChunker) split(depth int, treeSize int64, key Key, data io.Reade
 	// parentWg.Add(1)
 	// go func() {
 	childrenWg.Wait()
+	if children != nil {
+		self.addParities(chunk, children, chunkC, swg)
+	}
 	if len(jobC) > self.workerCount && self.workerCount < processors {
 		if wwg != nil {
 			wwg.Add(1)
@@ -232,7 +321,7 @@ func (self *TreeChunker) split(depth int, treeSize int64, key Key, data io.Reade
 		go self.hashWorker(jobC, chunkC, errC, quitC, swg, wwg)
 	}
 	select {
-	case jobC <- &hashJob{key, chunk, size, parentWg}:
+	case jobC <- &hashJob{key, chunk, size, parentWg, sdata}:
 	case <-quitC:
 	}
 }
@@ -273,6 +362,9 @@ func (self *TreeChunker) hashChunk(hasher hash.Hash, job *hashJob, chunkC chan *
 
 	// report hash of this chunk one level up (keys corresponds to the proper subslice of the parent chunk)
 	copy(job.key, h)
+	if job.sdata != nil {
+		*job.sdata = job.chunk
+	}
 	// send off new chunk to storage
 	if chunkC != nil {
 		if swg != nil {
@@ -290,22 +382,39 @@ func (self *TreeChunker) hashChunk(hasher hash.Hash, job *hashJob, chunkC chan *
 type LazyChunkReader struct {
 	key       Key         // root key
 	chunkC    chan *Chunk // chunk channel to send retrieve requests on
+	hashFunc  Hasher      // inherit from chunker
 	chunk     *Chunk      // size of the entire subtree
 	off       int64       // offset
 	chunkSize int64       // inherit from chunker
 	branches  int64       // inherit from chunker
 	hashSize  int64       // inherit from chunker
+	readAhead int64       // inherit from chunker
+	parities  int64       // read from the root chunk
+
+	lock       sync.Mutex
+	prefetched map[string]*prefetch // chunks retrieved ahead by key
+	quitAheadC chan bool            // closed to cancel the read-ahead
+	next       int64                // offset after the last read, -1 after a seek
+	ahead      int64                // offset up to which chunks are read ahead
+	closed     bool                 // no read-ahead after Close
 }
 
 // implements the Joiner interface
 func (self *TreeChunker) Join(key Key, chunkC chan *Chunk) LazySectionReader {
+	return newLazyChunkReader(key, chunkC, self.hashFunc, self.chunkSize, self.branches, self.hashSize, self.readAhead)
+}
 
+func newLazyChunkReader(key Key, chunkC chan *Chunk, hashFunc Hasher, chunkSize, branches, hashSize, readAhead int64) *LazyChunkReader {
 	return &LazyChunkReader{
-		key:       key,
-		chunkC:    chunkC,
-		chunkSize: self.chunkSize,
-		branches:  self.branches,
-		hashSize:  self.hashSize,
+		key:        key,
+		chunkC:     chunkC,
+		hashFunc:   hashFunc,
+		chunkSize:  chunkSize,
+		branches:   branches,
+		hashSize:   hashSize,
+		readAhead:  readAhead,
+		prefetched: make(map[string]*prefetch),
+		quitAheadC: make(chan bool),
 	}
 }
 
@@ -323,6 +432,7 @@ func (self *LazyChunkReader) Size(quitC chan bool) (n int64, err error) {
 			return 0, fmt.Errorf("root chunk not found for %v", self.key.Hex())
 		}
 	}
+	self.setRedundancy(chunk)
 	self.chunk = chunk
 	return chunk.Size, nil
 }
@@ -340,6 +450,13 @@ func (self *LazyChunkReader) ReadAt(b []byte, off int64) (read int, err error) {
 	if err != nil {
 		return 0, err
 	}
+	// reads past the end are short like those of io.SectionReader
+	if off >= size {
+		return 0, io.EOF
+	}
+	if off+int64(len(b)) > size {
+		b = b[:size-off]
+	}
 
 	errC := make(chan error)
 
@@ -351,6 +468,8 @@ func (self *LazyChunkReader) ReadAt(b []byte, off int64) (read int, err error) {
 	for ; treeSize < size; treeSize *= self.branches {
 		depth++
 	}
+	self.startReadAhead(off, off+int64(len(b)), size, depth, treeSize)
+
 	wg := sync.WaitGroup{}
 	wg.Add(1)
 	go self.join(b, off, off+int64(len(b)), depth, treeSize/self.branches, self.chunk, &wg, errC, quitC)
@@ -417,8 +536,11 @@ func (self *LazyChunkReader) join(b []byte, off int64, eoff int64, depth int, tr
 		wg.Add(1)
 		go func(j int64) {
 			childKey := chunk.SData[8+j*self.hashSize : 8+(j+1)*self.hashSize]
-			chunk := retrieve(childKey, self.chunkC, quitC)
-			if chunk == nil {
+			child := self.retrieve(childKey, quitC)
+			if child == nil && self.parities > 0 {
+				child = self.recoverChild(chunk, j, depth-1, treeSize, quitC)
+			}
+			if child == nil {
 				select {
 				case errC <- fmt.Errorf("chunk %v-%v not found", off, off+treeSize):
 				case <-quitC:
@@ -428,11 +550,41 @@ func (self *LazyChunkReader) join(b []byte, off int64, eoff int64, depth int, tr
 			if soff < off {
 				soff = off
 			}
-			self.join(b[soff-off:seoff-off], soff-roff, seoff-roff, depth-1, treeSize/self.branches, chunk, wg, errC, quitC)
+			self.join(b[soff-off:seoff-off], soff-roff, seoff-roff, depth-1, treeSize/self.branches, child, wg, errC, quitC)
 		}(i)
 	} //for
 }
 
+// implements the Walker interface
+// a chunk is an intermediate node if its subtree is larger than a chunk, its
+// data is then the keys of its children
+func (self *TreeChunker) Walk(key Key, chunkC chan *Chunk, quitC chan bool, f func(*Chunk) error) (int64, error) {
+	return walkTree(self.chunkSize, self.hashSize, key, chunkC, quitC, f)
+}
+
+func walkTree(chunkSize, hashSize int64, key Key, chunkC chan *Chunk, quitC chan bool, f func(*Chunk) error) (int64, error) {
+	chunk := retrieve(key, chunkC, quitC)
+	if chunk == nil {
+		select {
+		case <-quitC:
+			return 0, errors.New("aborted")
+		default:
+			return 0, fmt.Errorf("chunk %v not found", key.Log())
+		}
+	}
+	if err := f(chunk); err != nil {
+		return 0, err
+	}
+	if chunk.Size > chunkSize {
+		for i := int64(8); i+hashSize <= int64(len(chunk.SData)); i += hashSize {
+			if _, err := walkTree(chunkSize, hashSize, Key(chunk.SData[i:i+hashSize]), chunkC, quitC, f); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return chunk.Size, nil
+}
+
 // the helper method submits chunks for a key to a oueue (DPA) and
 // block until they time out or arrive
 // abort if quitC is readable
@@ -496,5 +648,6 @@ func (s *LazyChunkReader) Seek(offset int64, whence int) (int64, error) {
 		return 0, errOffset
 	}
 	s.off = offset
+	s.seeked(offset)
 	return offset, nil
 }
@@ -19,10 +19,14 @@ package storage
 import (
 	"bytes"
 	"crypto/rand"
+	"encoding/binary"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/ethereum/go-ethereum/logger"
 	"github.com/ethereum/go-ethereum/logger/glog"
@@ -115,3 +119,89 @@ func testStore(m ChunkStore, l int64, branches int64, t *testing.T) {
 	close(chunkC)
 	<-quit
 }
+
+// mapChunkStore is an in memory chunk store
+type mapChunkStore map[string]*Chunk
+
+func (self mapChunkStore) Put(chunk *Chunk) {
+	self[chunk.Key.String()] = chunk
+}
+
+func (self mapChunkStore) Get(key Key) (*Chunk, error) {
+	if chunk, ok := self[key.String()]; ok {
+		return chunk, nil
+	}
+	return nil, notFound
+}
+
+// latencyStore is an in memory chunk store that delays retrievals like a
+// remote peer
+type latencyStore struct {
+	chunks  mapChunkStore
+	latency time.Duration
+	lock    sync.Mutex
+	gets    int
+}
+
+func (self *latencyStore) Put(chunk *Chunk) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.chunks.Put(chunk)
+}
+
+func (self *latencyStore) Get(key Key) (*Chunk, error) {
+	time.Sleep(self.latency)
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.gets++
+	return self.chunks.Get(key)
+}
+
+const testChunkSize = 1000
+
+// newTestDbStore returns a store in a temporary directory with the eviction
+// policy and room for chunks test chunks, and a function removing it
+func newTestDbStore(t testing.TB, policy string, chunks uint64) (*DbStore, func()) {
+	dir, err := ioutil.TempDir("", "bzz-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewDbStore(dir, MakeHashFunc(defaultHash), defaultDbCapacity, defaultRadius)
+	if err != nil {
+		t.Fatal("can't create store:", err)
+	}
+	p, err := NewEvictionPolicy(policy, make(Key, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetEvictionPolicy(p); err != nil {
+		t.Fatal(err)
+	}
+	s.setCapacityBytes(chunks * testEntrySize)
+	return s, func() {
+		s.close()
+		os.RemoveAll(dir)
+	}
+}
+
+var testEntrySize = entrySize(make(Key, 32), make([]byte, 8+testChunkSize))
+
+// newTestChunk returns a chunk of random data
+func newTestChunk() *Chunk {
+	data := make([]byte, 8+testChunkSize)
+	binary.LittleEndian.PutUint64(data, testChunkSize)
+	rand.Read(data[8:])
+	hasher := MakeHashFunc(defaultHash)()
+	hasher.Write(data)
+	return &Chunk{Key: Key(hasher.Sum(nil)), SData: data}
+}
+
+// putTestChunks stores n test chunks and returns their keys
+func putTestChunks(s *DbStore, n int) (keys []Key) {
+	for i := 0; i < n; i++ {
+		chunk := newTestChunk()
+		s.Put(chunk)
+		keys = append(keys, chunk.Key)
+	}
+	return keys
+}
@@ -27,24 +27,32 @@ import (
 	"encoding/binary"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/logger"
 	"github.com/ethereum/go-ethereum/logger/glog"
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
 const (
-	defaultDbCapacity = 5000000
-	defaultRadius     = 0 // not yet used
+	defaultDbCapacity      = 5000000
+	defaultDbCapacityBytes = 20 << 30
+	defaultRadius          = 0 // not yet used
 
 	gcArraySize      = 10000
 	gcArrayFreeRatio = 0.1
 
 	// key prefixes for leveldb storage
-	kpIndex = 0
-	kpData  = 1
+	kpIndex  = 0
+	kpData   = 1
+	kpPinned = 7 // pin count of a chunk
+	kpPin    = 8 // pin record of a root key
+	kpLRU    = 9 // access order of the chunks, maintained by the LRU policy
+
+	kpPinKeys = 12 // chunk keys of a pin, for unpinning without the chunks
 )
 
 var (
@@ -52,12 +60,14 @@ var (
 	keyEntryCnt  = []byte{3}
 	keyDataIdx   = []byte{4}
 	keyGCPos     = []byte{5}
+	keyProbe     = []byte{6} // written and deleted by CheckWritable
+	keyDataSize  = []byte{10}
+	keyPolicy    = []byte{11} // name of the eviction policy maintaining its keys
 )
 
 type gcItem struct {
-	idx    uint64
-	value  uint64
-	idxKey []byte
+	entry *IndexEntry
+	value uint64
 }
 
 type DbStore struct {
@@ -66,11 +76,17 @@ type DbStore struct {
 	// this should be stored in db, accessed transactionally
 	entryCnt, accessCnt, dataIdx, capacity uint64
 
+	dataSize      uint64 // bytes of the chunk data and keys
+	capacityBytes uint64 // 0 if only the number of chunks is limited
+
+	policy            EvictionPolicy
 	gcPos, gcStartPos []byte
 	gcArray           []*gcItem
 
 	hashfunc Hasher
 
+	holds map[string]bool // chunks walked by the pin in progress
+
 	lock sync.Mutex
 }
 
@@ -84,8 +100,15 @@ func NewDbStore(path string, hash Hasher, capacity uint64, radius int) (s *DbSto
 		return
 	}
 
+	// a policy keeping its own keys goes on maintaining them until another
+	// policy is set
+	s.policy = accessPolicy{}
+	if name, _ := s.db.Get(keyPolicy); string(name) == LRUPolicy {
+		s.policy = lruPolicy{}
+	}
 	s.setCapacity(capacity)
 
+	s.holds = make(map[string]bool)
 	s.gcStartPos = make([]byte, 1)
 	s.gcStartPos[0] = kpIndex
 	s.gcArray = make([]*gcItem, gcArraySize)
@@ -100,7 +123,33 @@ func NewDbStore(path string, hash Hasher, capacity uint64, radius int) (s *DbSto
 	if s.gcPos == nil {
 		s.gcPos = s.gcStartPos
 	}
-	return
+	data, err = s.db.Get(keyDataSize)
+	if err == nil {
+		s.dataSize = BytesToU64(data)
+	} else {
+		s.dataSize = s.countDataSize()
+		s.db.Put(keyDataSize, U64ToBytes(s.dataSize))
+	}
+	return s, nil
+}
+
+// countDataSize adds up the sizes of the entries of a store written before
+// the data size was tracked
+func (s *DbStore) countDataSize() (size uint64) {
+	it := s.db.NewIterator()
+	defer it.Release()
+	for ok := it.Seek([]byte{kpIndex}); ok && it.Key()[0] <= kpData; ok = it.Next() {
+		size += uint64(len(it.Key()))
+		if it.Key()[0] == kpData {
+			size += uint64(len(it.Value()))
+		}
+	}
+	return size
+}
+
+// entrySize is the size of a chunk accounted against the byte capacity
+func entrySize(key Key, data []byte) uint64 {
+	return uint64(len(key) + 1 + 9 + len(data))
 }
 
 type dpaDBIndex struct {
@@ -201,7 +250,39 @@ func gcListSelect(list []*gcItem, left int, right int, n int) int {
 	}
 }
 
-func (s *DbStore) collectGarbage(ratio float32) {
+// collectGarbage deletes the chunks selected by the eviction policy, ratio
+// is the share of the candidates to delete.  It returns the number of chunks
+// deleted.
+func (s *DbStore) collectGarbage(ratio float32) int {
+	entries := s.policy.Select(s, ratio)
+	if len(entries) == 0 {
+		glog.V(logger.Warn).Infof("DbStore: all %v chunks are pinned, nothing to collect", s.entryCnt)
+		return 0
+	}
+	for _, entry := range entries {
+		data, _ := s.db.Get(getDataKey(entry.Idx))
+		s.deleteChunk(entry, data)
+	}
+	return len(entries)
+}
+
+// deleteChunk deletes the index and data of a chunk along with its keys of
+// the eviction policy and updates the counters, the lock must be held
+func (s *DbStore) deleteChunk(entry *IndexEntry, data []byte) {
+	batch := new(leveldb.Batch)
+	batch.Delete(getIndexKey(entry.Key))
+	batch.Delete(getDataKey(entry.Idx))
+	s.policy.Deleted(batch, entry)
+	s.entryCnt--
+	batch.Put(keyEntryCnt, U64ToBytes(s.entryCnt))
+	s.dataSize -= entrySize(entry.Key, data)
+	batch.Put(keyDataSize, U64ToBytes(s.dataSize))
+	s.db.Write(batch)
+}
+
+// sample returns up to gcArraySize unpinned index entries, continuing where
+// the last sample ended
+func (s *DbStore) sample() (entries []*IndexEntry) {
 	it := s.db.NewIterator()
 	it.Seek(s.gcPos)
 	if it.Valid() {
@@ -209,9 +290,9 @@ func (s *DbStore) collectGarbage(ratio float32) {
 	} else {
 		s.gcPos = nil
 	}
-	gcnt := 0
+	scanned := uint64(0)
 
-	for (gcnt < gcArraySize) && (uint64(gcnt) < s.entryCnt) {
+	for (len(entries) < gcArraySize) && (scanned < s.entryCnt) {
 
 		if (s.gcPos == nil) || (s.gcPos[0] != kpIndex) {
 			it.Seek(s.gcStartPos)
@@ -226,44 +307,54 @@ func (s *DbStore) collectGarbage(ratio float32) {
 			break
 		}
 
-		gci := new(gcItem)
-		gci.idxKey = s.gcPos
-		var index dpaDBIndex
-		decodeIndex(it.Value(), &index)
-		gci.idx = index.Idx
-		// the smaller, the more likely to be gc'd
-		gci.value = getIndexGCValue(&index)
-		s.gcArray[gcnt] = gci
-		gcnt++
+		scanned++
+		// pinned chunks are never collected
+		if !s.pinned(s.gcPos[1:]) {
+			var index dpaDBIndex
+			decodeIndex(it.Value(), &index)
+			entries = append(entries, &IndexEntry{
+				// the iterator reuses the key slice on Next
+				Key:    Key(append([]byte(nil), s.gcPos[1:]...)),
+				Idx:    index.Idx,
+				Access: index.Access,
+			})
+		}
 		it.Next()
 		if it.Valid() {
-			s.gcPos = it.Key()
+			s.gcPos = append([]byte(nil), it.Key()...)
 		} else {
 			s.gcPos = nil
 		}
 	}
 	it.Release()
+	s.db.Put(keyGCPos, s.gcPos)
+	return entries
+}
 
-	cutidx := gcListSelect(s.gcArray, 0, gcnt-1, int(float32(gcnt)*ratio))
+// selectSample returns the entries of a sample whose value is at most the
+// value at ratio of the sorted sample, the smaller the more likely to be
+// gc'd
+func (s *DbStore) selectSample(ratio float32, value func(*IndexEntry) uint64) (selected []*IndexEntry) {
+	sample := s.sample()
+	gcnt := len(sample)
+	if gcnt == 0 {
+		return nil
+	}
+	for i, entry := range sample {
+		s.gcArray[i] = &gcItem{entry: entry, value: value(entry)}
+	}
+	n := int(float32(gcnt) * ratio)
+	if n >= gcnt {
+		n = gcnt - 1
+	}
+	cutidx := gcListSelect(s.gcArray, 0, gcnt-1, n)
 	cutval := s.gcArray[cutidx].value
-
-	// fmt.Print(gcnt, " ", s.entryCnt, " ")
-
-	// actual gc
 	for i := 0; i < gcnt; i++ {
 		if s.gcArray[i].value <= cutval {
-			batch := new(leveldb.Batch)
-			batch.Delete(s.gcArray[i].idxKey)
-			batch.Delete(getDataKey(s.gcArray[i].idx))
-			s.entryCnt--
-			batch.Put(keyEntryCnt, U64ToBytes(s.entryCnt))
-			s.db.Write(batch)
+			selected = append(selected, s.gcArray[i].entry)
 		}
 	}
-
-	// fmt.Println(s.entryCnt)
-
-	s.db.Put(keyGCPos, s.gcPos)
+	return selected
 }
 
 func (s *DbStore) Counter() uint64 {
@@ -272,6 +363,69 @@ func (s *DbStore) Counter() uint64 {
 	return s.dataIdx
 }
 
+// Size returns the number of chunks in the store and its capacity
+func (s *DbStore) Size() (entries, capacity uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.entryCnt, s.capacity
+}
+
+// Bytes returns the bytes of chunk data and keys in the store and the byte
+// capacity, 0 if the bytes are not limited
+func (s *DbStore) Bytes() (size, capacity uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.dataSize, s.capacityBytes
+}
+
+// DiskUsage returns the approximate size of the database files, which are
+// compressed and include the overhead of leveldb
+func (s *DbStore) DiskUsage() (uint64, error) {
+	sizes, err := s.db.db.SizeOf([]util.Range{{}})
+	if err != nil {
+		return 0, err
+	}
+	return uint64(sizes.Sum()), nil
+}
+
+// SetEvictionPolicy sets the policy choosing the chunks to delete when the
+// store is full
+func (s *DbStore) SetEvictionPolicy(policy EvictionPolicy) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if name, _ := s.db.Get(keyPolicy); string(name) != policy.Name() {
+		if string(name) == LRUPolicy {
+			if err := deleteLRUKeys(s); err != nil {
+				return err
+			}
+		}
+		if err := policy.Init(s); err != nil {
+			return err
+		}
+		s.db.Put(keyPolicy, []byte(policy.Name()))
+	}
+	s.policy = policy
+	return nil
+}
+
+// CheckWritable writes, reads back and deletes a probe entry
+func (s *DbStore) CheckWritable() error {
+	value := U64ToBytes(uint64(time.Now().UnixNano()))
+	batch := new(leveldb.Batch)
+	batch.Put(keyProbe, value)
+	if err := s.db.Write(batch); err != nil {
+		return err
+	}
+	data, err := s.db.Get(keyProbe)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(data, value) {
+		return fmt.Errorf("read back %x, wrote %x", data, value)
+	}
+	return s.db.Delete(keyProbe)
+}
+
 func (s *DbStore) Put(chunk *Chunk) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
@@ -289,8 +443,11 @@ func (s *DbStore) Put(chunk *Chunk) {
 	data := encodeData(chunk)
 	//data := ethutil.Encode([]interface{}{entry})
 
-	if s.entryCnt >= s.capacity {
-		s.collectGarbage(gcArrayFreeRatio)
+	size := entrySize(chunk.Key, data)
+	for s.full(size) {
+		if s.collectGarbage(gcArrayFreeRatio) == 0 {
+			break
+		}
 	}
 
 	batch := new(leveldb.Batch)
@@ -302,9 +459,12 @@ func (s *DbStore) Put(chunk *Chunk) {
 
 	idata := encodeIndex(&index)
 	batch.Put(ikey, idata)
+	s.policy.Accessed(batch, &IndexEntry{Key: chunk.Key, Idx: index.Idx, Access: index.Access}, nil)
 
 	batch.Put(keyEntryCnt, U64ToBytes(s.entryCnt))
 	s.entryCnt++
+	s.dataSize += size
+	batch.Put(keyDataSize, U64ToBytes(s.dataSize))
 	batch.Put(keyDataIdx, U64ToBytes(s.dataIdx))
 	s.dataIdx++
 	batch.Put(keyAccessCnt, U64ToBytes(s.accessCnt))
@@ -329,9 +489,11 @@ func (s *DbStore) tryAccessIdx(ikey []byte, index *dpaDBIndex) bool {
 
 	batch.Put(keyAccessCnt, U64ToBytes(s.accessCnt))
 	s.accessCnt++
+	prev := &IndexEntry{Key: Key(ikey[1:]), Idx: index.Idx, Access: index.Access}
 	s.updateIndexAccess(index)
 	idata = encodeIndex(index)
 	batch.Put(ikey, idata)
+	s.policy.Accessed(batch, &IndexEntry{Key: prev.Key, Idx: index.Idx, Access: index.Access}, prev)
 
 	s.db.Write(batch)
 
@@ -355,7 +517,7 @@ func (s *DbStore) Get(key Key) (chunk *Chunk, err error) {
 		hasher.Write(data)
 		hash := hasher.Sum(nil)
 		if !bytes.Equal(hash, key) {
-			s.db.Delete(getDataKey(index.Idx))
+			s.deleteChunk(&IndexEntry{Key: key, Idx: index.Idx, Access: index.Access}, data)
 			err = fmt.Errorf("invalid chunk. hash=%x, key=%v", hash, key[:])
 			return
 		}
@@ -399,11 +561,35 @@ func (s *DbStore) setCapacity(c uint64) {
 			ratio = 1
 		}
 		for s.entryCnt > c {
-			s.collectGarbage(ratio)
+			if s.collectGarbage(ratio) == 0 {
+				break
+			}
 		}
 	}
 }
 
+// setCapacityBytes limits the bytes of chunk data and keys, 0 removes the
+// limit
+func (s *DbStore) setCapacityBytes(c uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.capacityBytes = c
+	for s.full(0) {
+		if s.collectGarbage(gcArrayFreeRatio) == 0 {
+			break
+		}
+	}
+}
+
+// full returns true if a chunk of size does not fit, the lock must be held
+func (s *DbStore) full(size uint64) bool {
+	if size > 0 && s.entryCnt >= s.capacity {
+		return true
+	}
+	return s.capacityBytes > 0 && s.dataSize+size > s.capacityBytes
+}
+
 func (s *DbStore) getEntryCnt() uint64 {
 	return s.entryCnt
 }
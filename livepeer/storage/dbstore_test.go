@@ -189,3 +189,49 @@ func TestDbStoreSyncIterator(t *testing.T) {
 		t.Fatalf("Expected %v chunk, got %v", keys[3], res[0])
 	}
 }
+
+func TestDbStoreCheckWritable(t *testing.T) {
+	m := initDbStore(t)
+	defer m.close()
+	if err := m.CheckWritable(); err != nil {
+		t.Errorf("Expecting writable store, got %v", err)
+	}
+	if _, err := m.db.Get(keyProbe); err == nil {
+		t.Errorf("Expecting probe entry to be deleted")
+	}
+}
+
+func TestDbStoreGetCorrupt(t *testing.T) {
+	s, cleanup := newTestDbStore(t, LRUPolicy, 100)
+	defer cleanup()
+	keys := putTestChunks(s, 2)
+
+	var index dpaDBIndex
+	idata, _ := s.db.Get(getIndexKey(keys[0]))
+	decodeIndex(idata, &index)
+	data, _ := s.db.Get(getDataKey(index.Idx))
+	data[8]++
+	s.db.Put(getDataKey(index.Idx), data)
+
+	if _, err := s.Get(keys[0]); err == nil {
+		t.Fatalf("Expecting an error getting a corrupt chunk")
+	}
+	if _, err := s.db.Get(getIndexKey(keys[0])); err == nil {
+		t.Errorf("Expecting the index of the corrupt chunk to be deleted")
+	}
+	if entries, _ := s.Size(); entries != 1 {
+		t.Errorf("Expecting 1 chunk, got %d", entries)
+	}
+	if size, _ := s.Bytes(); size != testEntrySize {
+		t.Errorf("Expecting %d bytes, got %d", testEntrySize, size)
+	}
+	lruKeys := 0
+	it := s.db.NewIterator()
+	for ok := it.Seek([]byte{kpLRU}); ok && it.Key()[0] == kpLRU; ok = it.Next() {
+		lruKeys++
+	}
+	it.Release()
+	if lruKeys != 1 {
+		t.Errorf("Expecting 1 LRU key, got %d", lruKeys)
+	}
+}
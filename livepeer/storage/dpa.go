@@ -17,6 +17,7 @@
 package storage
 
 import (
+	"encoding/binary"
 	"errors"
 	"io"
 	"sync"
@@ -49,6 +50,8 @@ const (
 
 var (
 	notFound = errors.New("not found")
+
+	ErrAppendNotSupported = errors.New("the chunker does not support appending")
 )
 
 type DPA struct {
@@ -56,6 +59,7 @@ type DPA struct {
 	storeC    chan *Chunk
 	retrieveC chan *Chunk
 	Chunker   Chunker
+	params    *ChunkerParams // of the Chunker, the defaults if nil
 
 	lock    sync.Mutex
 	running bool
@@ -80,10 +84,11 @@ func NewLocalDPA(datadir string) (*DPA, error) {
 }
 
 func NewDPA(store ChunkStore, params *ChunkerParams) *DPA {
-	chunker := NewTreeChunker(params)
+	chunker := NewChunker(params)
 	return &DPA{
 		Chunker:    chunker,
 		ChunkStore: store,
+		params:     params,
 	}
 }
 
@@ -91,16 +96,34 @@ func NewDPA(store ChunkStore, params *ChunkerParams) *DPA {
 // FS-aware API and httpaccess
 // Chunk retrieval blocks on netStore requests with a timeout so reader will
 // report error if retrieval of chunks within requested range time out.
+// The content of an encrypted reference is decrypted.
 func (self *DPA) Retrieve(key Key) LazySectionReader {
+	if IsEncrypted(key) {
+		return NewChunker(encryptionParams(self.chunkerParams(), nil)).Join(key, self.retrieveC)
+	}
 	return self.Chunker.Join(key, self.retrieveC)
 }
 
 // Public API. Main entry point for document storage directly. Used by the
 // FS-aware API and httpaccess
+// A negative size stores the data up to EOF.
 func (self *DPA) Store(data io.Reader, size int64, swg *sync.WaitGroup, wwg *sync.WaitGroup) (key Key, err error) {
 	return self.Chunker.Split(data, size, self.storeC, swg, wwg)
 }
 
+// Append appends data to the content under root and returns the key of the
+// grown content, the chunker must be an Appender
+func (self *DPA) Append(root Key, data io.Reader, size int64, swg *sync.WaitGroup, wwg *sync.WaitGroup) (key Key, err error) {
+	if IsEncrypted(root) {
+		return nil, ErrEncrypted
+	}
+	appender, ok := self.Chunker.(Appender)
+	if !ok {
+		return nil, ErrAppendNotSupported
+	}
+	return appender.Append(root, data, size, self.storeC, self.retrieveC, swg, wwg)
+}
+
 func (self *DPA) Start() {
 	self.lock.Lock()
 	defer self.lock.Unlock()
@@ -115,6 +138,13 @@ func (self *DPA) Start() {
 	self.retrieveLoop()
 }
 
+// Running returns true between Start and Stop
+func (self *DPA) Running() bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.running
+}
+
 func (self *DPA) Stop() {
 	self.lock.Lock()
 	defer self.lock.Unlock()
@@ -137,7 +167,8 @@ func (self *DPA) retrieveLoop() {
 func (self *DPA) retrieveWorker() {
 	for chunk := range self.retrieveC {
 		glog.V(logger.Detail).Infof("dpa: retrieve loop : chunk %v", chunk.Key.Log())
-		storedChunk, err := self.Get(chunk.Key)
+		key, chunkKey := splitReference(chunk.Key)
+		storedChunk, err := self.Get(key)
 		if err == notFound {
 			glog.V(logger.Detail).Infof("chunk %v not found", chunk.Key.Log())
 		} else if err != nil {
@@ -145,6 +176,16 @@ func (self *DPA) retrieveWorker() {
 		} else {
 			chunk.SData = storedChunk.SData
 			chunk.Size = storedChunk.Size
+			if chunkKey != nil {
+				if data := cryptChunk(chunkKey, storedChunk.SData); len(data) >= 8 {
+					chunk.SData = data
+					chunk.Size = int64(binary.LittleEndian.Uint64(data[0:8]))
+				} else {
+					glog.V(logger.Detail).Infof("chunk %v too short to decrypt", chunk.Key.Log())
+					chunk.SData = nil
+					chunk.Size = 0
+				}
+			}
 		}
 		close(chunk.C)
 
@@ -168,6 +209,15 @@ func (self *DPA) storeLoop() {
 func (self *DPA) storeWorker() {
 
 	for chunk := range self.storeC {
+		// chunks of encrypted content come keyed by their reference
+		if key, chunkKey := splitReference(chunk.Key); chunkKey != nil {
+			chunk = &Chunk{
+				Key:   key,
+				SData: cryptChunk(chunkKey, chunk.SData),
+				Size:  chunk.Size,
+				wg:    chunk.wg,
+			}
+		}
 		self.Put(chunk)
 		if chunk.wg != nil {
 			glog.V(logger.Detail).Infof("dpa: store processor %v", chunk.Key.Log())
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"hash"
+	"io"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/crypto/sha3"
+)
+
+/*
+Encrypted content.
+
+StoreEncrypted splits content with the chunker of the DPA keyed by an
+encrypting hasher.  The data of every chunk, span included, is encrypted
+with a key of its own derived from a random document key and the chunk
+data, and the chunk is stored under the hash of the ciphertext.  Chunks are
+referred to by that hash followed by their key, so intermediate chunks hold
+half as many children and nodes storing them see only ciphertext.  The
+reference of the root chunk is returned to the client, it is all that is
+needed to decrypt the content.
+
+Chunks are encrypted with AES-256 in CTR mode under a zero IV.  This is
+safe as long as a chunk key never encrypts two different plaintexts: the
+key is the Keccak256 hash of the document key and the plaintext, so the same
+key always encrypts the same data, and the document key is random for every
+StoreEncrypted call.  Document keys must not be reused or derived from the
+content, that would reveal which chunks of different documents are equal.
+
+The store loop of the DPA encrypts the chunks of such references and the
+retrieve loop decrypts them, so Retrieve reads encrypted content like any
+other.
+*/
+
+const encryptionKeySize = 32
+
+var ErrEncrypted = errors.New("not supported for encrypted content")
+
+// IsEncrypted returns true if the reference is to encrypted content, the
+// hash of the root chunk followed by its key
+func IsEncrypted(ref Key) bool {
+	return len(ref) == len(ZeroKey)+encryptionKeySize
+}
+
+// splitReference returns the key of the chunk of a reference and the key
+// that decrypts it, nil if the chunk is not encrypted
+func splitReference(ref Key) (Key, []byte) {
+	if !IsEncrypted(ref) {
+		return ref, nil
+	}
+	return ref[:len(ZeroKey)], ref[len(ZeroKey):]
+}
+
+// cryptChunk encrypts and decrypts chunk data with the key of the chunk.
+// The IV is zero, every chunk key encrypts only one plaintext.
+func cryptChunk(key, data []byte) []byte {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		// the chunk keys are Keccak256 hashes
+		panic(err)
+	}
+	out := make([]byte, len(data))
+	cipher.NewCTR(block, make([]byte, aes.BlockSize)).XORKeyStream(out, data)
+	return out
+}
+
+// encryptingHasher sums to the hash of the encrypted chunk followed by the
+// key of the chunk
+type encryptingHasher struct {
+	docKey   []byte
+	hashFunc Hasher
+	data     []byte
+}
+
+func (self *encryptingHasher) Write(p []byte) (int, error) {
+	self.data = append(self.data, p...)
+	return len(p), nil
+}
+
+func (self *encryptingHasher) Sum(b []byte) []byte {
+	keyHasher := sha3.NewKeccak256()
+	keyHasher.Write(self.docKey)
+	keyHasher.Write(self.data)
+	chunkKey := keyHasher.Sum(nil)
+
+	hasher := self.hashFunc()
+	hasher.Write(cryptChunk(chunkKey, self.data))
+	return append(hasher.Sum(b), chunkKey...)
+}
+
+func (self *encryptingHasher) Reset() {
+	self.data = self.data[:0]
+}
+
+func (self *encryptingHasher) Size() int {
+	return self.hashFunc().Size() + encryptionKeySize
+}
+
+func (self *encryptingHasher) BlockSize() int {
+	return self.hashFunc().BlockSize()
+}
+
+// plainHasher returns the hash chunks are stored under, that of the
+// ciphertext for the encrypting hasher
+func plainHasher(hashFunc Hasher) Hasher {
+	if h, ok := hashFunc().(*encryptingHasher); ok {
+		return h.hashFunc
+	}
+	return hashFunc
+}
+
+// encryptionParams returns the chunker parameters for content encrypted
+// under the document key, the chunks are as large as those of plain content.
+// Reading needs no document key.
+func encryptionParams(params *ChunkerParams, docKey []byte) *ChunkerParams {
+	hashFunc := MakeHashFunc(params.Hash)
+	hashSize := int64(hashFunc().Size())
+	encrypted := *params
+	encrypted.Branches = params.Branches * hashSize / (hashSize + encryptionKeySize)
+	encrypted.hashFunc = func() hash.Hash {
+		return &encryptingHasher{docKey: docKey, hashFunc: hashFunc}
+	}
+	return &encrypted
+}
+
+func (self *DPA) chunkerParams() *ChunkerParams {
+	if self.params == nil {
+		return NewChunkerParams()
+	}
+	return self.params
+}
+
+// StoreEncrypted stores content encrypted under a new document key and
+// returns the reference of the root chunk.  A negative size stores the data
+// up to EOF.
+func (self *DPA) StoreEncrypted(data io.Reader, size int64, swg *sync.WaitGroup, wwg *sync.WaitGroup) (Key, error) {
+	docKey := make([]byte, encryptionKeySize)
+	if _, err := rand.Read(docKey); err != nil {
+		return nil, err
+	}
+	return NewChunker(encryptionParams(self.chunkerParams(), docKey)).Split(data, size, self.storeC, swg, wwg)
+}
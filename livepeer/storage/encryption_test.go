@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"sync"
+	"testing"
+)
+
+func TestStoreEncrypted(t *testing.T) {
+	store := &latencyStore{chunks: make(mapChunkStore)}
+	params := NewChunkerParams()
+	dpa := NewDPA(store, params)
+	dpa.Start()
+	defer dpa.Stop()
+
+	// 147 leaves under 3 intermediate chunks of 64 children
+	size := 600000
+	var inputs [][]byte
+	for _, n := range []int64{int64(size), -1} {
+		data, input := testDataReaderAndSlice(size)
+		wg := &sync.WaitGroup{}
+		ref, err := dpa.StoreEncrypted(data, n, wg, nil)
+		if err != nil {
+			t.Fatalf("StoreEncrypted error: %v", err)
+		}
+		wg.Wait()
+		inputs = append(inputs, input)
+		if !IsEncrypted(ref) {
+			t.Fatalf("Expecting an encrypted reference, got %v", ref)
+		}
+
+		output, err := ioutil.ReadAll(dpa.Retrieve(ref))
+		if err != nil || !bytes.Equal(output, input) {
+			t.Fatalf("input and output mismatch: %v", err)
+		}
+		// the root hash alone does not decrypt
+		if _, err := ioutil.ReadAll(dpa.Retrieve(ref[:32])); err == nil {
+			t.Errorf("Expecting the root hash alone not to retrieve the content")
+		}
+	}
+
+	hasher := MakeHashFunc(params.Hash)
+	for _, chunk := range store.chunks {
+		h := hasher()
+		h.Write(chunk.SData)
+		if !bytes.Equal(h.Sum(nil), chunk.Key) {
+			t.Fatalf("Expecting %v to be stored under the hash of its data", chunk.Key.Log())
+		}
+		for _, input := range inputs {
+			if bytes.Contains(input, chunk.SData[8:40]) {
+				t.Fatalf("Expecting %v to be encrypted", chunk.Key.Log())
+			}
+		}
+	}
+
+	// a new document key for every document
+	ref1, _ := dpa.StoreEncrypted(bytes.NewReader([]byte("recording")), 9, nil, nil)
+	ref2, _ := dpa.StoreEncrypted(bytes.NewReader([]byte("recording")), 9, nil, nil)
+	if bytes.Equal(ref1, ref2) {
+		t.Errorf("Expecting the same content to be encrypted differently")
+	}
+	if _, err := dpa.Export(ioutil.Discard, ref1); err != ErrEncrypted {
+		t.Errorf("Expecting %v exporting encrypted content, got %v", ErrEncrypted, err)
+	}
+}
+
+func TestShortEncryptedChunk(t *testing.T) {
+	store := &latencyStore{chunks: make(mapChunkStore)}
+	dpa := NewDPA(store, NewChunkerParams())
+	dpa.Start()
+	defer dpa.Stop()
+
+	key := make(Key, 32)
+	key[0] = 1
+	store.Put(&Chunk{Key: key, SData: []byte{1, 2, 3}})
+	ref := append(key, make([]byte, encryptionKeySize)...)
+	if _, err := ioutil.ReadAll(dpa.Retrieve(ref)); err == nil {
+		t.Errorf("Expecting a chunk too short to decrypt not to be found")
+	}
+}
+
+func TestKeyJSON(t *testing.T) {
+	for _, size := range []int{32, 64} {
+		key := make(Key, size)
+		key[size-1] = 1
+		js, _ := json.Marshal(key)
+		var out Key
+		if err := json.Unmarshal(js, &out); err != nil || !bytes.Equal(out, key) {
+			t.Errorf("%d bytes: expecting %v, got %v (%v)", size, key, out, err)
+		}
+	}
+	for _, js := range []string{`""`, `"0102"`, `"` + string(bytes.Repeat([]byte("ab"), 48)) + `"`, `12`} {
+		var out Key
+		if err := json.Unmarshal([]byte(js), &out); err == nil {
+			t.Errorf("%s: expecting an error, got %v", js, out)
+		}
+	}
+}
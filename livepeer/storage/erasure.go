@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"errors"
+)
+
+/*
+Reed-Solomon erasure code over GF(2^8).
+
+The code is systematic: the data shards are kept as they are and parity
+shards are added, each a combination of the data shards by a row of a
+Cauchy matrix.  Every square submatrix of the identity stacked on a Cauchy
+matrix is invertible, so any data shards of a group can be reconstructed
+from as many shards of the group, data or parity.  Groups are at most 256
+shards.
+*/
+
+var errTooFewShards = errors.New("too few shards to reconstruct")
+
+// multiplication table and inverses with the polynomial x^8+x^4+x^3+x^2+1
+var (
+	gfMulTable [256][256]byte
+	gfInvTable [256]byte
+)
+
+func init() {
+	var exp [510]byte
+	var log [256]int
+	x := 1
+	for i := 0; i < 255; i++ {
+		exp[i] = byte(x)
+		exp[i+255] = byte(x)
+		log[x] = i
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	for a := 1; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			gfMulTable[a][b] = exp[log[a]+log[b]]
+		}
+		gfInvTable[a] = exp[255-log[a]]
+	}
+}
+
+type erasureCode struct {
+	data   int
+	parity int
+}
+
+func newErasureCode(data, parity int) *erasureCode {
+	return &erasureCode{data: data, parity: parity}
+}
+
+// row returns the coefficients of shard i of a group in the data shards
+func (self *erasureCode) row(i int) []byte {
+	row := make([]byte, self.data)
+	if i < self.data {
+		row[i] = 1
+		return row
+	}
+	// the parity and data shards are distinct points of the Cauchy matrix
+	for j := range row {
+		row[j] = gfInvTable[byte(i)^byte(j)]
+	}
+	return row
+}
+
+// mulAdd adds c times in to out
+func mulAdd(out, in []byte, c byte) {
+	if c == 0 {
+		return
+	}
+	table := &gfMulTable[c]
+	for i, b := range in {
+		out[i] ^= table[b]
+	}
+}
+
+// encode returns the parity shards of data shards of equal length
+func (self *erasureCode) encode(shards [][]byte) [][]byte {
+	parities := make([][]byte, self.parity)
+	for i := range parities {
+		parity := make([]byte, len(shards[0]))
+		for j, c := range self.row(self.data + i) {
+			mulAdd(parity, shards[j], c)
+		}
+		parities[i] = parity
+	}
+	return parities
+}
+
+// reconstruct fills in the missing data shards of a group, shards holds the
+// data shards followed by the parity shards with nil for the missing ones
+func (self *erasureCode) reconstruct(shards [][]byte) error {
+	var present []int
+	for i, shard := range shards {
+		if shard != nil {
+			present = append(present, i)
+			if len(present) == self.data {
+				break
+			}
+		}
+	}
+	if len(present) < self.data {
+		return errTooFewShards
+	}
+	matrix := make([][]byte, self.data)
+	for i, j := range present {
+		matrix[i] = self.row(j)
+	}
+	inverse, err := gfInvert(matrix)
+	if err != nil {
+		return err
+	}
+	size := len(shards[present[0]])
+	for i := 0; i < self.data; i++ {
+		if shards[i] != nil {
+			continue
+		}
+		shard := make([]byte, size)
+		for k, j := range present {
+			mulAdd(shard, shards[j], inverse[i][k])
+		}
+		shards[i] = shard
+	}
+	return nil
+}
+
+// gfInvert inverts a square matrix by Gauss-Jordan elimination
+func gfInvert(matrix [][]byte) ([][]byte, error) {
+	n := len(matrix)
+	work := make([][]byte, n)
+	for i := range work {
+		work[i] = make([]byte, 2*n)
+		copy(work[i], matrix[i])
+		work[i][n+i] = 1
+	}
+	for col := 0; col < n; col++ {
+		pivot := col
+		for pivot < n && work[pivot][col] == 0 {
+			pivot++
+		}
+		if pivot == n {
+			return nil, errors.New("singular matrix")
+		}
+		work[col], work[pivot] = work[pivot], work[col]
+		inv := gfInvTable[work[col][col]]
+		for k := range work[col] {
+			work[col][k] = gfMulTable[inv][work[col][k]]
+		}
+		for r := 0; r < n; r++ {
+			if r != col {
+				mulAdd(work[r], work[col], work[r][col])
+			}
+		}
+	}
+	inverse := make([][]byte, n)
+	for i := range inverse {
+		inverse[i] = work[i][n:]
+	}
+	return inverse, nil
+}
@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestErasureCode(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	code := newErasureCode(10, 4)
+	shards := make([][]byte, 10)
+	for i := range shards {
+		shards[i] = make([]byte, 100)
+		r.Read(shards[i])
+	}
+	all := append(append([][]byte{}, shards...), code.encode(shards)...)
+
+	for n := 0; n < 100; n++ {
+		lost := append([][]byte{}, all...)
+		for _, i := range r.Perm(len(all))[:4] {
+			lost[i] = nil
+		}
+		if err := code.reconstruct(lost); err != nil {
+			t.Fatalf("reconstruct error: %v", err)
+		}
+		for i, shard := range shards {
+			if !bytes.Equal(lost[i], shard) {
+				t.Fatalf("shard %d mismatch", i)
+			}
+		}
+	}
+
+	for _, i := range r.Perm(len(all))[:5] {
+		all[i] = nil
+	}
+	if err := code.reconstruct(all); err != errTooFewShards {
+		t.Fatalf("Expecting %v, got %v", errTooFewShards, err)
+	}
+}
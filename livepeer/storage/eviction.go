@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/logger"
+	"github.com/ethereum/go-ethereum/logger/glog"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// Names of the eviction policies
+const (
+	AccessPolicy    = "access"
+	LRUPolicy       = "lru"
+	ProximityPolicy = "proximity"
+)
+
+const defaultEvictionPolicy = AccessPolicy
+
+// IndexEntry is the index of a stored chunk as seen by an eviction policy
+type IndexEntry struct {
+	Key    Key
+	Idx    uint64 // position of the data
+	Access uint64 // value of the access counter at the last access
+}
+
+/*
+EvictionPolicy chooses the chunks the DbStore deletes when it is full.  The
+DbStore calls it with its lock held, a policy keeping its own keys writes
+them to the batch of the store update.  Pinned chunks must never be
+selected.
+*/
+type EvictionPolicy interface {
+	Name() string
+	// Init builds the keys of the policy for the chunks in the store, it is
+	// called when the store was last maintained by another policy
+	Init(s *DbStore) error
+	// Accessed is called when a chunk is stored or read, prev is the entry
+	// before the access, nil for a new chunk
+	Accessed(batch *leveldb.Batch, entry, prev *IndexEntry)
+	// Deleted is called when a chunk is deleted
+	Deleted(batch *leveldb.Batch, entry *IndexEntry)
+	// Select returns the entries to delete, ratio is the share of the
+	// candidates considered
+	Select(s *DbStore, ratio float32) []*IndexEntry
+}
+
+// NewEvictionPolicy returns the policy by name, base is the overlay address
+// of the node used by the proximity policy
+func NewEvictionPolicy(name string, base Key) (EvictionPolicy, error) {
+	switch name {
+	case "", AccessPolicy:
+		return accessPolicy{}, nil
+	case LRUPolicy:
+		return lruPolicy{}, nil
+	case ProximityPolicy:
+		if len(base) == 0 {
+			return nil, fmt.Errorf("eviction policy %s needs the base address", name)
+		}
+		return &proximityPolicy{base: base}, nil
+	}
+	return nil, fmt.Errorf("unknown eviction policy '%s'", name)
+}
+
+// accessPolicy deletes the least recently accessed chunks of a sample of the
+// index, the sample window rotates over the index
+type accessPolicy struct{}
+
+func (accessPolicy) Name() string                                      { return AccessPolicy }
+func (accessPolicy) Init(*DbStore) error                               { return nil }
+func (accessPolicy) Accessed(*leveldb.Batch, *IndexEntry, *IndexEntry) {}
+func (accessPolicy) Deleted(*leveldb.Batch, *IndexEntry)               {}
+
+func (accessPolicy) Select(s *DbStore, ratio float32) []*IndexEntry {
+	return s.selectSample(ratio, func(entry *IndexEntry) uint64 {
+		return entry.Access
+	})
+}
+
+// proximityPolicy samples like the access policy but deletes the chunks
+// farthest from the base address first, the nodes closer to them are
+// responsible for storing them.  Chunks at the same proximity are deleted by
+// access.
+type proximityPolicy struct {
+	base Key
+}
+
+func (self *proximityPolicy) Name() string                                      { return ProximityPolicy }
+func (self *proximityPolicy) Init(*DbStore) error                               { return nil }
+func (self *proximityPolicy) Accessed(*leveldb.Batch, *IndexEntry, *IndexEntry) {}
+func (self *proximityPolicy) Deleted(*leveldb.Batch, *IndexEntry)               {}
+
+func (self *proximityPolicy) Select(s *DbStore, ratio float32) []*IndexEntry {
+	return s.selectSample(ratio, func(entry *IndexEntry) uint64 {
+		access := entry.Access
+		if access >= 1<<56 {
+			access = 1<<56 - 1
+		}
+		return uint64(proximity(self.base, entry.Key))<<56 | access
+	})
+}
+
+// proximity returns the number of leading bits two keys share
+func proximity(one, other Key) int {
+	for i := 0; i < len(one) && i < len(other); i++ {
+		if x := one[i] ^ other[i]; x != 0 {
+			for j := 0; j < 8; j++ {
+				if x&(0x80>>uint(j)) != 0 {
+					return i*8 + j
+				}
+			}
+		}
+	}
+	return 8 * len(one)
+}
+
+// lruPolicy keeps the chunks ordered by access, it deletes exactly the least
+// recently used chunks at the cost of a key per chunk
+type lruPolicy struct{}
+
+func getLRUKey(access uint64, key Key) []byte {
+	lkey := make([]byte, 9+len(key))
+	lkey[0] = kpLRU
+	binary.BigEndian.PutUint64(lkey[1:9], access)
+	copy(lkey[9:], key)
+	return lkey
+}
+
+func (lruPolicy) Name() string { return LRUPolicy }
+
+func (lruPolicy) Init(s *DbStore) error {
+	if err := deleteLRUKeys(s); err != nil {
+		return err
+	}
+	it := s.db.NewIterator()
+	defer it.Release()
+	batch := new(leveldb.Batch)
+	for ok := it.Seek([]byte{kpIndex}); ok && it.Key()[0] == kpIndex; ok = it.Next() {
+		var index dpaDBIndex
+		decodeIndex(it.Value(), &index)
+		batch.Put(getLRUKey(index.Access, Key(it.Key()[1:])), nil)
+	}
+	glog.V(logger.Info).Infof("DbStore: LRU order of %v chunks built", batch.Len())
+	return s.db.Write(batch)
+}
+
+// deleteLRUKeys deletes the LRU order, it is stale once another policy
+// maintains the store
+func deleteLRUKeys(s *DbStore) error {
+	it := s.db.NewIterator()
+	defer it.Release()
+	batch := new(leveldb.Batch)
+	for ok := it.Seek([]byte{kpLRU}); ok && it.Key()[0] == kpLRU; ok = it.Next() {
+		batch.Delete(append([]byte(nil), it.Key()...))
+	}
+	if batch.Len() == 0 {
+		return nil
+	}
+	glog.V(logger.Info).Infof("DbStore: LRU order of %v chunks deleted", batch.Len())
+	return s.db.Write(batch)
+}
+
+func (lruPolicy) Accessed(batch *leveldb.Batch, entry, prev *IndexEntry) {
+	if prev != nil {
+		batch.Delete(getLRUKey(prev.Access, prev.Key))
+	}
+	batch.Put(getLRUKey(entry.Access, entry.Key), nil)
+}
+
+func (lruPolicy) Deleted(batch *leveldb.Batch, entry *IndexEntry) {
+	batch.Delete(getLRUKey(entry.Access, entry.Key))
+}
+
+func (lruPolicy) Select(s *DbStore, ratio float32) (entries []*IndexEntry) {
+	// as many as the access policy would delete from its sample
+	n := int(float32(gcArraySize) * ratio)
+	if s.entryCnt < gcArraySize {
+		n = int(float32(s.entryCnt) * ratio)
+	}
+	if n == 0 {
+		n = 1
+	}
+	it := s.db.NewIterator()
+	defer it.Release()
+	stale := new(leveldb.Batch)
+	for ok := it.Seek([]byte{kpLRU}); ok && it.Key()[0] == kpLRU && len(entries) < n; ok = it.Next() {
+		lkey := it.Key()
+		key := Key(append([]byte(nil), lkey[9:]...))
+		if s.pinned(key) {
+			continue
+		}
+		data, err := s.db.Get(getIndexKey(key))
+		if err != nil {
+			// the chunk was deleted without the policy
+			stale.Delete(append([]byte(nil), lkey...))
+			continue
+		}
+		var index dpaDBIndex
+		decodeIndex(data, &index)
+		if index.Access != binary.BigEndian.Uint64(lkey[1:9]) {
+			stale.Delete(append([]byte(nil), lkey...))
+			continue
+		}
+		entries = append(entries, &IndexEntry{Key: key, Idx: index.Idx, Access: index.Access})
+	}
+	if stale.Len() > 0 {
+		s.db.Write(stale)
+	}
+	return entries
+}
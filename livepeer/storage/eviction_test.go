@@ -0,0 +1,171 @@
+package storage
+
+import (
+	"testing"
+)
+
+func stored(s *DbStore, keys []Key) (in, out []Key) {
+	for _, key := range keys {
+		if _, err := s.db.Get(getIndexKey(key)); err == nil {
+			in = append(in, key)
+		} else {
+			out = append(out, key)
+		}
+	}
+	return
+}
+
+// testEvictionAccessed stores chunks beyond the capacity after reading
+// some, the chunks read must survive
+func testEvictionAccessed(t *testing.T, policy string) {
+	s, cleanup := newTestDbStore(t, policy, 100)
+	defer cleanup()
+
+	keys := putTestChunks(s, 100)
+	for _, key := range keys[:10] {
+		if _, err := s.Get(key); err != nil {
+			t.Fatalf("Get error: %v", err)
+		}
+	}
+	keys = append(keys, putTestChunks(s, 50)...)
+
+	if size, capacity := s.Bytes(); size > capacity {
+		t.Errorf("Expecting at most %d bytes, got %d", capacity, size)
+	}
+	if entries, _ := s.Size(); entries > 100 {
+		t.Errorf("Expecting at most 100 chunks, got %d", entries)
+	}
+	if _, out := stored(s, keys[:10]); len(out) > 0 {
+		t.Errorf("Expecting accessed chunks to survive, %d collected", len(out))
+	}
+	if _, out := stored(s, keys[100:]); len(out) > 0 {
+		t.Errorf("Expecting the latest chunks to survive, %d collected", len(out))
+	}
+}
+
+func TestEvictionAccess(t *testing.T) {
+	testEvictionAccessed(t, AccessPolicy)
+}
+
+func TestEvictionLRU(t *testing.T) {
+	testEvictionAccessed(t, LRUPolicy)
+
+	s, cleanup := newTestDbStore(t, LRUPolicy, 100)
+	defer cleanup()
+	keys := putTestChunks(s, 100)
+	s.Get(keys[0])
+	putTestChunks(s, 5)
+	// a tenth of the store goes, exactly the least recently used
+	if in, out := stored(s, keys); len(out) != 10 || in[0].String() != keys[0].String() || out[0].String() != keys[1].String() || out[9].String() != keys[10].String() {
+		t.Errorf("Expecting chunks 1 to 10 to be collected, got %v", out)
+	}
+}
+
+func TestEvictionLRUInit(t *testing.T) {
+	s, cleanup := newTestDbStore(t, AccessPolicy, 100)
+	defer cleanup()
+	keys := putTestChunks(s, 100)
+
+	if err := s.SetEvictionPolicy(lruPolicy{}); err != nil {
+		t.Fatal(err)
+	}
+	putTestChunks(s, 5)
+	if _, out := stored(s, keys); len(out) != 10 || out[0].String() != keys[0].String() || out[9].String() != keys[9].String() {
+		t.Errorf("Expecting the oldest 10 chunks to be collected, got %v", out)
+	}
+}
+
+func TestEvictionLRUSwitch(t *testing.T) {
+	s, cleanup := newTestDbStore(t, LRUPolicy, 100)
+	defer cleanup()
+	putTestChunks(s, 10)
+
+	lruKeys := func() (n int) {
+		it := s.db.NewIterator()
+		defer it.Release()
+		for ok := it.Seek([]byte{kpLRU}); ok && it.Key()[0] == kpLRU; ok = it.Next() {
+			n++
+		}
+		return n
+	}
+	if n := lruKeys(); n != 10 {
+		t.Fatalf("Expecting 10 LRU keys, got %d", n)
+	}
+	if err := s.SetEvictionPolicy(accessPolicy{}); err != nil {
+		t.Fatal(err)
+	}
+	if n := lruKeys(); n != 0 {
+		t.Errorf("Expecting the LRU keys to be deleted, got %d", n)
+	}
+}
+
+func TestEvictionProximity(t *testing.T) {
+	s, cleanup := newTestDbStore(t, ProximityPolicy, 100)
+	defer cleanup()
+
+	// the base is 0, near chunks share the first bit with it
+	var near, far []*Chunk
+	for len(near) < 50 || len(far) < 100 {
+		chunk := newTestChunk()
+		if chunk.Key[0]&0x80 == 0 && len(near) < 50 {
+			near = append(near, chunk)
+		} else if chunk.Key[0]&0x80 != 0 && len(far) < 100 {
+			far = append(far, chunk)
+		}
+	}
+	var keys []Key
+	for _, chunk := range append(near, far...) {
+		s.Put(chunk)
+		keys = append(keys, chunk.Key)
+	}
+	// the access policy would collect the near chunks stored first
+	if _, out := stored(s, keys[:50]); len(out) > 0 {
+		t.Errorf("Expecting near chunks to survive, %d collected", len(out))
+	}
+	if in, _ := stored(s, keys[50:]); len(in) > 50 {
+		t.Errorf("Expecting at most 50 far chunks, got %d", len(in))
+	}
+}
+
+func TestDbStoreDataSize(t *testing.T) {
+	s, cleanup := newTestDbStore(t, AccessPolicy, 100)
+	defer cleanup()
+
+	putTestChunks(s, 150)
+	size, _ := s.Bytes()
+	if entries, _ := s.Size(); size != entries*testEntrySize {
+		t.Errorf("Expecting %d bytes, got %d", entries*testEntrySize, size)
+	}
+	if counted := s.countDataSize(); counted != size {
+		t.Errorf("Expecting tracked size %d to match the counted %d", size, counted)
+	}
+	if _, err := s.DiskUsage(); err != nil {
+		t.Errorf("DiskUsage error: %v", err)
+	}
+}
+
+func benchmarkEviction(b *testing.B, policy string) {
+	s, cleanup := newTestDbStore(b, policy, 1000)
+	defer cleanup()
+	putTestChunks(s, 1000)
+	chunks := make([]*Chunk, b.N)
+	for i := range chunks {
+		chunks[i] = newTestChunk()
+	}
+	b.ResetTimer()
+	for _, chunk := range chunks {
+		s.Put(chunk)
+	}
+}
+
+func BenchmarkEvictionAccess(b *testing.B) {
+	benchmarkEviction(b, AccessPolicy)
+}
+
+func BenchmarkEvictionLRU(b *testing.B) {
+	benchmarkEviction(b, LRUPolicy)
+}
+
+func BenchmarkEvictionProximity(b *testing.B) {
+	benchmarkEviction(b, ProximityPolicy)
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"archive/tar"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/ethereum/go-ethereum/logger"
+	"github.com/ethereum/go-ethereum/logger/glog"
+)
+
+/*
+Chunk archives are tar archives with an entry per chunk, named by the hex
+key of the chunk and holding its data.  They are written by Export and read
+by Import on another node.
+*/
+
+// maxArchivedChunkSize bounds the entries read from an archive, well above
+// the size of the chunks of any chunker parameters
+const maxArchivedChunkSize = 1 << 20
+
+func writeArchivedChunk(tw *tar.Writer, key Key, data []byte) error {
+	hdr := &tar.Header{
+		Name: hex.EncodeToString(key),
+		Mode: 0644,
+		Size: int64(len(data)),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// Export writes all the chunks of the store to an archive, it returns the
+// number of chunks written
+func (s *DbStore) Export(out io.Writer) (int64, error) {
+	tw := tar.NewWriter(out)
+	it := s.db.NewIterator()
+	defer it.Release()
+
+	var count int64
+	for ok := it.Seek([]byte{kpIndex}); ok && it.Key()[0] == kpIndex; ok = it.Next() {
+		key := Key(it.Key()[1:])
+		var index dpaDBIndex
+		decodeIndex(it.Value(), &index)
+		data, err := s.db.Get(getDataKey(index.Idx))
+		if err != nil {
+			glog.V(logger.Warn).Infof("DbStore export: no data for %v", key.Log())
+			continue
+		}
+		if err := writeArchivedChunk(tw, key, data); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, tw.Close()
+}
+
+// Export writes the chunks of the trees under the roots to an archive,
+// chunks missing locally are retrieved on the way.  It returns the number of
+// chunks written.
+func (self *DPA) Export(out io.Writer, roots ...Key) (int64, error) {
+	tw := tar.NewWriter(out)
+	quitC := make(chan bool)
+	defer close(quitC)
+
+	for _, root := range roots {
+		if IsEncrypted(root) {
+			return 0, ErrEncrypted
+		}
+	}
+	written := make(map[string]bool)
+	for _, root := range roots {
+		_, err := self.Chunker.Walk(root, self.retrieveC, quitC, func(chunk *Chunk) error {
+			if written[string(chunk.Key)] {
+				return nil
+			}
+			written[string(chunk.Key)] = true
+			return writeArchivedChunk(tw, chunk.Key, chunk.SData)
+		})
+		if err != nil {
+			return int64(len(written)), err
+		}
+	}
+	return int64(len(written)), tw.Close()
+}
+
+// Import stores the chunks of an archive.  The data of every chunk must hash
+// to its key, the import stops at the first chunk that does not.  It returns
+// the number of chunks stored.
+func (s *DbStore) Import(in io.Reader) (int64, error) {
+	tr := tar.NewReader(in)
+	var count int64
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		key, err := hex.DecodeString(hdr.Name)
+		if err != nil || len(key) == 0 {
+			return count, fmt.Errorf("invalid chunk archive entry '%s'", hdr.Name)
+		}
+		if hdr.Size > maxArchivedChunkSize {
+			return count, fmt.Errorf("chunk %x too large: %d bytes", key, hdr.Size)
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return count, err
+		}
+		if !s.valid(Key(key), data) {
+			return count, fmt.Errorf("invalid chunk %x: the data does not hash to the key", key)
+		}
+		s.Put(&Chunk{Key: Key(key), SData: data})
+		count++
+	}
+}
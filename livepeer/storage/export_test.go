@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/hex"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestExportImport(t *testing.T) {
+	src, cleanup := newTestDbStore(t, AccessPolicy, 100)
+	defer cleanup()
+	keys := putTestChunks(src, 20)
+
+	var archive bytes.Buffer
+	count, err := src.Export(&archive)
+	if err != nil || count != 20 {
+		t.Fatalf("Expecting 20 chunks exported, got %d: %v", count, err)
+	}
+
+	dst, cleanup := newTestDbStore(t, AccessPolicy, 100)
+	defer cleanup()
+	count, err = dst.Import(bytes.NewReader(archive.Bytes()))
+	if err != nil || count != 20 {
+		t.Fatalf("Expecting 20 chunks imported, got %d: %v", count, err)
+	}
+	for _, key := range keys {
+		if _, err := dst.Get(key); err != nil {
+			t.Errorf("Expecting %v to be imported: %v", key.Log(), err)
+		}
+	}
+
+	// an entry that does not hash to its name stops the import
+	var bad bytes.Buffer
+	tw := tar.NewWriter(&bad)
+	writeArchivedChunk(tw, keys[0], []byte("garbage"))
+	tw.Close()
+	if _, err := dst.Import(&bad); err == nil {
+		t.Errorf("Expecting an invalid chunk to be rejected")
+	}
+}
+
+func TestDPAExport(t *testing.T) {
+	dbStore := initDbStore(t)
+	defer dbStore.close()
+	dpa := &DPA{
+		Chunker:    NewTreeChunker(NewChunkerParams()),
+		ChunkStore: &LocalStore{NewMemStore(dbStore, defaultCacheCapacity), dbStore},
+	}
+	dpa.Start()
+	defer dpa.Stop()
+	defer os.RemoveAll("/tmp/bzz")
+
+	wg := &sync.WaitGroup{}
+	root, err := dpa.Store(testDataReader(600000), 600000, wg, nil)
+	if err != nil {
+		t.Fatalf("Store error: %v", err)
+	}
+	wg.Wait()
+	dpa.Store(testDataReader(1000), 1000, wg, nil)
+	wg.Wait()
+
+	var archive bytes.Buffer
+	count, err := dpa.Export(&archive, root, root)
+	// 147 leaves, 2 intermediate chunks and the root
+	if err != nil || count != 150 {
+		t.Fatalf("Expecting the 150 chunks of the tree, got %d: %v", count, err)
+	}
+	tr := tar.NewReader(&archive)
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != hex.EncodeToString(root) {
+		t.Errorf("Expecting the root first, got %v: %v", hdr, err)
+	}
+}
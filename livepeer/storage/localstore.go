@@ -33,6 +33,7 @@ func NewLocalStore(hash Hasher, params *StoreParams) (*LocalStore, error) {
 	if err != nil {
 		return nil, err
 	}
+	dbStore.setCapacityBytes(params.DbCapacityBytes)
 	return &LocalStore{
 		memStore: NewMemStore(dbStore, params.CacheCapacity),
 		DbStore:  dbStore,
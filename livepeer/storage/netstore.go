@@ -25,6 +25,7 @@ import (
 	"github.com/ethereum/go-ethereum/logger"
 	"github.com/ethereum/go-ethereum/logger/glog"
 	"github.com/ethereum/go-ethereum/swarm/network/kademlia"
+	"github.com/livepeer/livepeer-swarm/livepeer/transcoding"
 	lpmsStream "github.com/livepeer/lpms/stream"
 )
 
@@ -55,7 +56,7 @@ type CloudStore interface {
 	Retrieve(*Chunk)
 	Stream(string, kademlia.Address, lpmsStream.VideoFormat)
 	StopStream(string, kademlia.Address, lpmsStream.VideoFormat)
-	Transcode(string, common.Hash, []string, []string, string, []string)
+	Transcode(string, common.Hash, string, []transcoding.Profile)
 	// TranscodeAck()
 }
 
@@ -64,14 +65,20 @@ type StoreParams struct {
 	DbCapacity    uint64
 	CacheCapacity uint
 	Radius        int
+	// bytes of chunk data the store keeps, 0 to only limit the chunks
+	DbCapacityBytes uint64
+	// name of the eviction policy: access, lru or proximity
+	EvictionPolicy string
 }
 
 func NewStoreParams(path string) (self *StoreParams) {
 	return &StoreParams{
-		ChunkDbPath:   filepath.Join(path, "chunks"),
-		DbCapacity:    defaultDbCapacity,
-		CacheCapacity: defaultCacheCapacity,
-		Radius:        defaultRadius,
+		ChunkDbPath:     filepath.Join(path, "chunks"),
+		DbCapacity:      defaultDbCapacity,
+		CacheCapacity:   defaultCacheCapacity,
+		Radius:          defaultRadius,
+		DbCapacityBytes: defaultDbCapacityBytes,
+		EvictionPolicy:  defaultEvictionPolicy,
 	}
 }
 
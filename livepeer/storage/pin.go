@@ -0,0 +1,307 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/logger"
+	"github.com/ethereum/go-ethereum/logger/glog"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+var ErrNotPinned = errors.New("not pinned")
+
+/*
+PinInfo is the pin record of a root key.  The chunks of the tree under the
+root and under every ref are exempt from garbage collection until the root is
+unpinned.  Refs are further roots pinned together with the root, e.g. the
+entries of a manifest.
+*/
+type PinInfo struct {
+	Root   Key
+	Refs   []Key  `json:",omitempty"`
+	Size   int64  // content bytes of the root and refs
+	Chunks uint64 // chunks of the trees, a chunk shared by trees is counted for each
+	Pinned time.Time
+}
+
+// Pinner pins content by walking its chunk trees through the DPA, chunks
+// missing locally are retrieved on the way.  The pins are kept in the
+// DbStore.
+type Pinner struct {
+	dpa  *DPA
+	db   *DbStore
+	lock sync.Mutex // serialises pinning and unpinning
+}
+
+func NewPinner(dpa *DPA, db *DbStore) *Pinner {
+	return &Pinner{dpa: dpa, db: db}
+}
+
+// Pin pins a root key and refs.  Pinning a pinned root again adds the refs
+// that are not pinned with it yet, the record is returned unchanged if there
+// are none.
+func (self *Pinner) Pin(root Key, refs ...Key) (*PinInfo, error) {
+	for _, key := range append([]Key{root}, refs...) {
+		if IsEncrypted(key) {
+			return nil, ErrEncrypted
+		}
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	info, err := self.db.PinInfo(root)
+	var roots []Key
+	switch err {
+	case nil:
+		refs = newRefs(info, refs)
+		if len(refs) == 0 {
+			return info, nil
+		}
+		roots = refs
+	case ErrNotPinned:
+		info = &PinInfo{Root: root, Pinned: time.Now()}
+		refs = newRefs(info, refs)
+		roots = append([]Key{root}, refs...)
+	default:
+		return nil, err
+	}
+
+	// the walked chunks are held until the pin is stored, so garbage
+	// collection cannot remove them in between
+	var keys []Key
+	size, err := self.walk(roots, func(chunk *Chunk) error {
+		keys = append(keys, chunk.Key)
+		self.db.hold(chunk)
+		return nil
+	})
+	if err != nil {
+		self.db.release(keys)
+		return nil, err
+	}
+	info.Refs = append(info.Refs, refs...)
+	info.Size += size
+	info.Chunks += uint64(len(keys))
+	if err := self.db.pin(info, keys); err != nil {
+		self.db.release(keys)
+		return nil, err
+	}
+	glog.V(logger.Info).Infof("Pinned %v: %d bytes, %d chunks", root.Log(), info.Size, info.Chunks)
+	return info, nil
+}
+
+// newRefs returns the refs that are not pinned with the root of info yet,
+// without duplicates
+func newRefs(info *PinInfo, refs []Key) (added []Key) {
+	seen := map[string]bool{string(info.Root): true}
+	for _, ref := range info.Refs {
+		seen[string(ref)] = true
+	}
+	for _, ref := range refs {
+		if !seen[string(ref)] {
+			seen[string(ref)] = true
+			added = append(added, ref)
+		}
+	}
+	return added
+}
+
+// Unpin removes the pin of a root key, its chunks can be collected unless
+// they are pinned by other roots.  The chunk keys are stored with the pin, so
+// chunks that are missing do not prevent unpinning.
+func (self *Pinner) Unpin(root Key) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if err := self.db.unpin(root); err != nil {
+		return err
+	}
+	glog.V(logger.Info).Infof("Unpinned %v", root.Log())
+	return nil
+}
+
+// Pins lists the pin records
+func (self *Pinner) Pins() []*PinInfo {
+	return self.db.Pins()
+}
+
+// walk walks the trees of the roots and returns the sum of their sizes
+func (self *Pinner) walk(roots []Key, f func(*Chunk) error) (size int64, err error) {
+	quitC := make(chan bool)
+	defer close(quitC)
+	for _, key := range roots {
+		n, err := self.dpa.Chunker.Walk(key, self.dpa.retrieveC, quitC, f)
+		if err != nil {
+			return 0, err
+		}
+		size += n
+	}
+	return size, nil
+}
+
+func getPinnedKey(key Key) []byte {
+	return append([]byte{kpPinned}, key...)
+}
+
+func getPinKey(key Key) []byte {
+	return append([]byte{kpPin}, key...)
+}
+
+func getPinKeysKey(key Key) []byte {
+	return append([]byte{kpPinKeys}, key...)
+}
+
+// pinKeys reads the chunk keys of a pin, stored back to back.  They have the
+// length of the root key, being hashes of the same chunker.  The lock must be
+// held.
+func (s *DbStore) pinKeys(root Key) (keys []Key) {
+	data, _ := s.db.Get(getPinKeysKey(root))
+	for i := 0; i+len(root) <= len(data); i += len(root) {
+		keys = append(keys, Key(data[i:i+len(root)]))
+	}
+	return keys
+}
+
+// pinned returns true if a chunk is pinned or held by the pin in progress,
+// the lock must be held
+func (s *DbStore) pinned(hash []byte) bool {
+	if s.holds[string(hash)] {
+		return true
+	}
+	_, err := s.db.Get(getPinnedKey(hash))
+	return err == nil
+}
+
+// hold exempts a chunk walked by a pin from garbage collection until the pin
+// is stored or released.  A chunk collected since it was retrieved is stored
+// again.
+func (s *DbStore) hold(chunk *Chunk) {
+	s.lock.Lock()
+	s.holds[string(chunk.Key)] = true
+	_, err := s.db.Get(getIndexKey(chunk.Key))
+	s.lock.Unlock()
+	if err != nil {
+		s.Put(&Chunk{Key: chunk.Key, SData: chunk.SData, Size: chunk.Size})
+	}
+}
+
+// release ends the holds of a pin that failed
+func (s *DbStore) release(keys []Key) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, key := range keys {
+		delete(s.holds, string(key))
+	}
+}
+
+// pin stores the pin record, adds keys to the chunk keys of the pin and
+// increments their pin counts
+func (s *DbStore) pin(info *PinInfo, keys []Key) error {
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	counts := s.pinCounts(keys)
+	for _, key := range keys {
+		counts[string(getPinnedKey(key))]++
+	}
+	batch := new(leveldb.Batch)
+	for pkey, count := range counts {
+		batch.Put([]byte(pkey), U64ToBytes(count))
+	}
+	stored, _ := s.db.Get(getPinKeysKey(info.Root))
+	all := append([]byte{}, stored...)
+	for _, key := range keys {
+		all = append(all, key...)
+	}
+	batch.Put(getPinKeysKey(info.Root), all)
+	batch.Put(getPinKey(info.Root), data)
+	if err := s.db.Write(batch); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		delete(s.holds, string(key))
+	}
+	return nil
+}
+
+// unpin deletes the pin record and its chunk keys and decrements the pin
+// counts of the chunks, ErrNotPinned if there is no pin
+func (s *DbStore) unpin(root Key) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, err := s.db.Get(getPinKey(root)); err != nil {
+		return ErrNotPinned
+	}
+	keys := s.pinKeys(root)
+	counts := s.pinCounts(keys)
+	for _, key := range keys {
+		if pkey := string(getPinnedKey(key)); counts[pkey] > 0 {
+			counts[pkey]--
+		}
+	}
+	batch := new(leveldb.Batch)
+	for pkey, count := range counts {
+		if count == 0 {
+			batch.Delete([]byte(pkey))
+		} else {
+			batch.Put([]byte(pkey), U64ToBytes(count))
+		}
+	}
+	batch.Delete(getPinKeysKey(root))
+	batch.Delete(getPinKey(root))
+	return s.db.Write(batch)
+}
+
+// pinCounts reads the pin counts of the chunks, the lock must be held
+func (s *DbStore) pinCounts(keys []Key) map[string]uint64 {
+	counts := make(map[string]uint64)
+	for _, key := range keys {
+		pkey := getPinnedKey(key)
+		if _, ok := counts[string(pkey)]; !ok {
+			data, _ := s.db.Get(pkey)
+			counts[string(pkey)] = BytesToU64(data)
+		}
+	}
+	return counts
+}
+
+// PinInfo returns the pin record of a root key, ErrNotPinned if there is
+// none
+func (s *DbStore) PinInfo(root Key) (*PinInfo, error) {
+	data, err := s.db.Get(getPinKey(root))
+	if err != nil {
+		return nil, ErrNotPinned
+	}
+	var info PinInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Pins returns the pin records, oldest first
+func (s *DbStore) Pins() []*PinInfo {
+	it := s.db.NewIterator()
+	defer it.Release()
+	pins := []*PinInfo{}
+	for ok := it.Seek([]byte{kpPin}); ok && it.Key()[0] == kpPin; ok = it.Next() {
+		var info PinInfo
+		if err := json.Unmarshal(it.Value(), &info); err != nil {
+			glog.V(logger.Warn).Infof("DbStore: unreadable pin record %x: %v", it.Key(), err)
+			continue
+		}
+		pins = append(pins, &info)
+	}
+	sort.Sort(pinsByTime(pins))
+	return pins
+}
+
+type pinsByTime []*PinInfo
+
+func (s pinsByTime) Len() int           { return len(s) }
+func (s pinsByTime) Less(i, j int) bool { return s[i].Pinned.Before(s[j].Pinned) }
+func (s pinsByTime) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"os"
+	"sync"
+	"testing"
+)
+
+// walkKeys returns the keys of the chunks in the trees of the roots
+func walkKeys(t *testing.T, dpa *DPA, roots ...Key) (keys []Key) {
+	quitC := make(chan bool)
+	defer close(quitC)
+	for _, root := range roots {
+		_, err := dpa.Chunker.Walk(root, dpa.retrieveC, quitC, func(chunk *Chunk) error {
+			keys = append(keys, chunk.Key)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Walk error: %v", err)
+		}
+	}
+	return keys
+}
+
+func TestPinner(t *testing.T) {
+	dbStore := initDbStore(t)
+	defer dbStore.close()
+	dpa := &DPA{
+		Chunker:    NewTreeChunker(NewChunkerParams()),
+		ChunkStore: &LocalStore{NewMemStore(dbStore, defaultCacheCapacity), dbStore},
+	}
+	dpa.Start()
+	defer dpa.Stop()
+	defer os.RemoveAll("/tmp/bzz")
+
+	store := func(size int) Key {
+		wg := &sync.WaitGroup{}
+		key, err := dpa.Store(testDataReader(size), int64(size), wg, nil)
+		if err != nil {
+			t.Fatalf("Store error: %v", err)
+		}
+		wg.Wait()
+		return key
+	}
+	// more leaves than branches, so the tree has intermediate chunks
+	pinnedKey := store(600000)
+	manifestKey := store(1000)
+	otherKey := store(600000)
+
+	pinner := NewPinner(dpa, dbStore)
+	info, err := pinner.Pin(manifestKey, pinnedKey)
+	if err != nil {
+		t.Fatalf("Pin error: %v", err)
+	}
+	// 147 leaves and 2 intermediate chunks under the root, 1 manifest chunk
+	if info.Size != 601000 || info.Chunks != 151 {
+		t.Errorf("Expecting 601000 bytes in 151 chunks, got %d bytes in %d chunks", info.Size, info.Chunks)
+	}
+	if again, err := pinner.Pin(manifestKey); err != nil || !again.Pinned.Equal(info.Pinned) {
+		t.Errorf("Expecting pinning again to return the record, got %v, %v", again, err)
+	}
+	if pins := pinner.Pins(); len(pins) != 1 || pins[0].Root.String() != manifestKey.String() || len(pins[0].Refs) != 1 {
+		t.Errorf("Expecting the pin of %v, got %v", manifestKey, pins)
+	}
+
+	walked := walkKeys(t, dpa, manifestKey, pinnedKey)
+	if uint64(len(walked)) != info.Chunks {
+		t.Errorf("Expecting %d chunks in the pinned trees, walked %d", info.Chunks, len(walked))
+	}
+	dbStore.setCapacity(info.Chunks)
+	for _, key := range walked {
+		if _, err := dbStore.Get(key); err != nil {
+			t.Errorf("Expecting pinned %v to survive garbage collection: %v", key.Log(), err)
+		}
+	}
+	if _, err := dbStore.Get(otherKey); err == nil {
+		t.Errorf("Expecting %v to be collected", otherKey.Log())
+	}
+
+	if err := pinner.Unpin(manifestKey); err != nil {
+		t.Fatalf("Unpin error: %v", err)
+	}
+	if err := pinner.Unpin(manifestKey); err != ErrNotPinned {
+		t.Errorf("Expecting ErrNotPinned, got %v", err)
+	}
+	if len(pinner.Pins()) != 0 {
+		t.Errorf("Expecting no pins, got %v", pinner.Pins())
+	}
+	if dbStore.pinned(pinnedKey) {
+		t.Errorf("Expecting %v to be unpinned", pinnedKey.Log())
+	}
+}
+
+func TestPinAddRefs(t *testing.T) {
+	dbStore := initDbStore(t)
+	defer dbStore.close()
+	dpa := &DPA{
+		Chunker:    NewTreeChunker(NewChunkerParams()),
+		ChunkStore: &LocalStore{NewMemStore(dbStore, defaultCacheCapacity), dbStore},
+	}
+	dpa.Start()
+	defer dpa.Stop()
+
+	store := func(size int) Key {
+		wg := &sync.WaitGroup{}
+		key, err := dpa.Store(testDataReader(size), int64(size), wg, nil)
+		if err != nil {
+			t.Fatalf("Store error: %v", err)
+		}
+		wg.Wait()
+		return key
+	}
+	manifestKey := store(1000)
+	firstKey := store(5000)
+	secondKey := store(600000)
+
+	pinner := NewPinner(dpa, dbStore)
+	if _, err := pinner.Pin(manifestKey, firstKey); err != nil {
+		t.Fatalf("Pin error: %v", err)
+	}
+	// pinning again with a further ref adds it to the pin
+	info, err := pinner.Pin(manifestKey, firstKey, secondKey)
+	if err != nil {
+		t.Fatalf("Pin error: %v", err)
+	}
+	// 1 manifest chunk, 2 leaves and a root, 147 leaves, 2 intermediate
+	// chunks and a root
+	if len(info.Refs) != 2 || info.Size != 606000 || info.Chunks != 154 {
+		t.Errorf("Expecting 2 refs, 606000 bytes in 154 chunks, got %d refs, %d bytes in %d chunks", len(info.Refs), info.Size, info.Chunks)
+	}
+	if pins := pinner.Pins(); len(pins) != 1 || len(pins[0].Refs) != 2 {
+		t.Errorf("Expecting one pin with 2 refs, got %v", pins)
+	}
+	walked := walkKeys(t, dpa, manifestKey, firstKey, secondKey)
+	for _, key := range walked {
+		if !dbStore.pinned(key) {
+			t.Errorf("Expecting %v to be pinned", key.Log())
+		}
+	}
+
+	// the chunk keys are stored with the pin, missing chunks do not matter
+	dbStore.db.Delete(getIndexKey(secondKey))
+	if err := pinner.Unpin(manifestKey); err != nil {
+		t.Fatalf("Unpin error: %v", err)
+	}
+	for _, key := range walked {
+		if dbStore.pinned(key) {
+			t.Errorf("Expecting %v to be unpinned", key.Log())
+		}
+	}
+}
+
+func TestPinDuringGC(t *testing.T) {
+	dbStore := initDbStore(t)
+	defer dbStore.close()
+	dpa := &DPA{
+		Chunker:    NewTreeChunker(NewChunkerParams()),
+		ChunkStore: &LocalStore{NewMemStore(dbStore, defaultCacheCapacity), dbStore},
+	}
+	dpa.Start()
+	defer dpa.Stop()
+
+	wg := &sync.WaitGroup{}
+	key, err := dpa.Store(testDataReader(600000), 600000, wg, nil)
+	if err != nil {
+		t.Fatalf("Store error: %v", err)
+	}
+	wg.Wait()
+
+	// collect everything collectable while the pin walks the tree
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-quit:
+				return
+			default:
+			}
+			dbStore.setCapacity(1)
+			dbStore.setCapacity(defaultDbCapacity)
+		}
+	}()
+	info, err := NewPinner(dpa, dbStore).Pin(key)
+	close(quit)
+	<-done
+	if err != nil {
+		t.Fatalf("Pin error: %v", err)
+	}
+
+	dbStore.setCapacity(1)
+	walked := walkKeys(t, dpa, key)
+	if uint64(len(walked)) != info.Chunks {
+		t.Errorf("Expecting %d chunks in the pinned tree, walked %d", info.Chunks, len(walked))
+	}
+	for _, key := range walked {
+		if _, err := dbStore.Get(key); err != nil {
+			t.Errorf("Expecting pinned %v to survive garbage collection: %v", key.Log(), err)
+		}
+	}
+}
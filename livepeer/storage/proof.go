@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+/*
+Range proofs of BMT hashed content.
+
+A proof of a byte range under a root hash holds, for every chunk on the
+paths from the root to the range, the segments of the chunk under the range
+and the BMT nodes beside them.  For an intermediate chunk the segments are
+the keys of its children, each of which has a proof in turn; for a leaf
+they are the data.  VerifyProof checks the proof against the root hash
+alone, so a light client can verify a partial download without the rest of
+the content.  Content stored with redundancy cannot be proven.
+*/
+
+var ErrProofNotSupported = errors.New("the content is not hashed with BMT or has parities")
+
+// Proof proves the segments of a chunk under a byte range
+type Proof struct {
+	Key      Key      // the chunk
+	Span     int64    // size of the content under the chunk
+	Length   int64    // size of the chunk data
+	Offset   int64    // offset of Data in the chunk data, at a segment
+	Data     []byte   // the segments under the range
+	Siblings []Key    // BMT nodes beside the segments, bottom up
+	Children []*Proof `json:",omitempty"` // proofs of the children under the range
+}
+
+// Proof returns the proof of the content in [off, eoff) under the root,
+// the content must be hashed with BMT
+func (self *DPA) Proof(root Key, off, eoff int64) (*Proof, error) {
+	if IsEncrypted(root) {
+		return nil, ErrEncrypted
+	}
+	quitC := make(chan bool)
+	defer close(quitC)
+	chunk, err := self.proofChunk(root, quitC)
+	if err != nil {
+		return nil, err
+	}
+	if off < 0 || off >= eoff || eoff > chunk.Size {
+		return nil, fmt.Errorf("invalid range %d-%d of %d bytes", off, eoff, chunk.Size)
+	}
+	return self.prove(chunk, off, eoff, quitC)
+}
+
+// proofChunk retrieves a chunk and checks it is hashed with BMT
+func (self *DPA) proofChunk(key Key, quitC chan bool) (*Chunk, error) {
+	chunk := retrieve(key, self.retrieveC, quitC)
+	if chunk == nil || len(chunk.SData) < 8 {
+		return nil, fmt.Errorf("chunk %v not found", key.Log())
+	}
+	if !isBMTChunk(chunk) {
+		return nil, ErrProofNotSupported
+	}
+	chunk.Size = int64(binary.LittleEndian.Uint64(chunk.SData[:8]))
+	return chunk, nil
+}
+
+func (self *DPA) prove(chunk *Chunk, off, eoff int64, quitC chan bool) (*Proof, error) {
+	data := chunk.SData[8:]
+	size := int64(len(data))
+	proof := &Proof{
+		Key:    chunk.Key,
+		Span:   chunk.Size,
+		Length: size,
+	}
+	// leaf chunk
+	if chunk.Size <= size {
+		lo := off / SegmentSize
+		hi := (eoff + SegmentSize - 1) / SegmentSize
+		end := hi * SegmentSize
+		if end > size {
+			end = size
+		}
+		proof.Offset = lo * SegmentSize
+		proof.Data = data[proof.Offset:end]
+		proof.Siblings = bmtSiblings(data, int(lo), int(hi))
+		return proof, nil
+	}
+
+	// the keys of parities are followed by their count
+	if size%SegmentSize != 0 {
+		return nil, ErrProofNotSupported
+	}
+	// all children but the last span as much as the first
+	children := size / SegmentSize
+	treeSize := chunk.Size
+	if children > 1 {
+		first, err := self.proofChunk(Key(data[:SegmentSize]), quitC)
+		if err != nil {
+			return nil, err
+		}
+		treeSize = first.Size
+	}
+	lo := off / treeSize
+	hi := (eoff + treeSize - 1) / treeSize
+	if hi > children {
+		hi = children
+	}
+	proof.Offset = lo * SegmentSize
+	proof.Data = data[proof.Offset : hi*SegmentSize]
+	proof.Siblings = bmtSiblings(data, int(lo), int(hi))
+	for i := lo; i < hi; i++ {
+		child, err := self.proofChunk(Key(data[i*SegmentSize:(i+1)*SegmentSize]), quitC)
+		if err != nil {
+			return nil, err
+		}
+		soff, seoff := off-i*treeSize, eoff-i*treeSize
+		if soff < 0 {
+			soff = 0
+		}
+		if seoff > child.Size {
+			seoff = child.Size
+		}
+		childProof, err := self.prove(child, soff, seoff, quitC)
+		if err != nil {
+			return nil, err
+		}
+		proof.Children = append(proof.Children, childProof)
+	}
+	return proof, nil
+}
+
+// VerifyProof checks a proof against the root hash of the content and
+// returns the proven data of [off, eoff)
+func VerifyProof(root Key, proof *Proof, off, eoff int64) ([]byte, error) {
+	if proof == nil || !bytes.Equal(proof.Key, root) {
+		return nil, fmt.Errorf("the proof is not for %v", root.Log())
+	}
+	if off < 0 || off >= eoff || eoff > proof.Span {
+		return nil, fmt.Errorf("invalid range %d-%d of %d bytes", off, eoff, proof.Span)
+	}
+	return verifyProof(proof, off, eoff)
+}
+
+func verifyProof(proof *Proof, off, eoff int64) ([]byte, error) {
+	if len(proof.Data) == 0 {
+		return nil, fmt.Errorf("invalid proof for chunk %v: no segments", proof.Key.Log())
+	}
+	end := proof.Offset + int64(len(proof.Data))
+	if proof.Offset < 0 || proof.Offset%SegmentSize != 0 || end > proof.Length || end%SegmentSize != 0 && end != proof.Length {
+		return nil, fmt.Errorf("invalid proof for chunk %v: segments %d-%d of %d bytes", proof.Key.Log(), proof.Offset, end, proof.Length)
+	}
+	segments := (proof.Length + SegmentSize - 1) / SegmentSize
+	root, err := bmtProvenRoot(proof.Data, int(proof.Offset/SegmentSize), int(segments), proof.Siblings)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proof for chunk %v: %v", proof.Key.Log(), err)
+	}
+	span := make([]byte, 8)
+	binary.LittleEndian.PutUint64(span, uint64(proof.Span))
+	if !bytes.Equal(bmtKey(span, root), proof.Key) {
+		return nil, fmt.Errorf("invalid proof for chunk %v: the segments do not hash to the key", proof.Key.Log())
+	}
+
+	// leaf chunk
+	if proof.Span <= proof.Length {
+		if off < proof.Offset || eoff > end {
+			return nil, fmt.Errorf("the proof for chunk %v does not cover %d-%d", proof.Key.Log(), off, eoff)
+		}
+		return proof.Data[off-proof.Offset : eoff-proof.Offset], nil
+	}
+
+	if proof.Length%SegmentSize != 0 || len(proof.Children) == 0 || int64(len(proof.Children)) != int64(len(proof.Data))/SegmentSize {
+		return nil, fmt.Errorf("invalid proof for chunk %v: %d children proven", proof.Key.Log(), len(proof.Children))
+	}
+	for i, child := range proof.Children {
+		if child == nil {
+			return nil, fmt.Errorf("invalid proof for chunk %v: child %d is missing", proof.Key.Log(), i)
+		}
+	}
+	// all children but the last span as much as the first
+	first, children := proof.Offset/SegmentSize, proof.Length/SegmentSize
+	start := proof.Span - proof.Children[0].Span
+	if first < children-1 {
+		start = first * proof.Children[0].Span
+	}
+	if start > off {
+		return nil, fmt.Errorf("the proof for chunk %v does not cover %d-%d", proof.Key.Log(), off, eoff)
+	}
+	var data []byte
+	pos := start
+	for i, child := range proof.Children {
+		if !bytes.Equal(child.Key, proof.Data[i*SegmentSize:(i+1)*SegmentSize]) {
+			return nil, fmt.Errorf("invalid proof for chunk %v: child %d is not %v", proof.Key.Log(), i, child.Key.Log())
+		}
+		soff, seoff := off-pos, eoff-pos
+		if soff < 0 {
+			soff = 0
+		}
+		if seoff > child.Span {
+			seoff = child.Span
+		}
+		if child.Span <= 0 || soff >= seoff {
+			return nil, fmt.Errorf("invalid proof for chunk %v: child %v is out of range", proof.Key.Log(), child.Key.Log())
+		}
+		childData, err := verifyProof(child, soff, seoff)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, childData...)
+		pos += child.Span
+	}
+	if pos < eoff {
+		return nil, fmt.Errorf("the proof for chunk %v does not cover %d-%d", proof.Key.Log(), off, eoff)
+	}
+	return data, nil
+}
@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"testing"
+)
+
+func newProofTestDPA(t *testing.T, hash string, size int) (*DPA, Key, []byte) {
+	params := NewChunkerParams()
+	params.Hash = hash
+	dpa := &DPA{
+		Chunker:    NewTreeChunker(params),
+		ChunkStore: &latencyStore{chunks: make(mapChunkStore)},
+	}
+	dpa.Start()
+	data, input := testDataReaderAndSlice(size)
+	wg := &sync.WaitGroup{}
+	key, err := dpa.Store(data, int64(size), wg, nil)
+	if err != nil {
+		t.Fatalf("Store error: %v", err)
+	}
+	wg.Wait()
+	return dpa, key, input
+}
+
+func TestProof(t *testing.T) {
+	// 147 leaves under 2 intermediate chunks
+	size := 600000
+	dpa, root, input := newProofTestDPA(t, "BMT", size)
+	defer dpa.Stop()
+
+	for _, r := range [][2]int64{{0, 1}, {100, 5000}, {4000, 300000}, {524287, 524289}, {599999, 600000}, {0, 600000}} {
+		off, eoff := r[0], r[1]
+		proof, err := dpa.Proof(root, off, eoff)
+		if err != nil {
+			t.Fatalf("%d-%d: Proof error: %v", off, eoff, err)
+		}
+		// as received by a client
+		js, _ := json.Marshal(proof)
+		proof = &Proof{}
+		if err := json.Unmarshal(js, proof); err != nil {
+			t.Fatalf("%d-%d: unmarshal error: %v", off, eoff, err)
+		}
+		data, err := VerifyProof(root, proof, off, eoff)
+		if err != nil {
+			t.Fatalf("%d-%d: VerifyProof error: %v", off, eoff, err)
+		}
+		if !bytes.Equal(data, input[off:eoff]) {
+			t.Fatalf("%d-%d: proven data and input mismatch", off, eoff)
+		}
+	}
+
+	proof, _ := dpa.Proof(root, 5000, 9000)
+	if _, err := VerifyProof(root, proof, 1000, 9000); err == nil {
+		t.Errorf("Expecting a range outside the proof to fail")
+	}
+	leaf := proof.Children[0].Children[0]
+	leaf.Data[0]++
+	if _, err := VerifyProof(root, proof, 5000, 9000); err == nil {
+		t.Errorf("Expecting tampered data to fail")
+	}
+	leaf.Data[0]--
+	if _, err := VerifyProof(leaf.Key, proof, 5000, 9000); err == nil {
+		t.Errorf("Expecting the proof to fail for another root")
+	}
+
+	if _, err := dpa.Proof(root, 1000, int64(size)+1); err == nil {
+		t.Errorf("Expecting a range past the end to fail")
+	}
+}
+
+func TestProofMalformed(t *testing.T) {
+	dpa, root, _ := newProofTestDPA(t, "BMT", 600000)
+	defer dpa.Stop()
+	proof, err := dpa.Proof(root, 5000, 9000)
+	if err != nil {
+		t.Fatalf("Proof error: %v", err)
+	}
+	js, _ := json.Marshal(proof)
+
+	for name, tamper := range map[string]func(p *Proof){
+		"nil child":      func(p *Proof) { p.Children[0] = nil },
+		"nil grandchild": func(p *Proof) { p.Children[0].Children[0] = nil },
+		"no children":    func(p *Proof) { p.Children = nil },
+		"no data":        func(p *Proof) { p.Data = nil },
+		"no leaf data":   func(p *Proof) { p.Children[0].Children[0].Data = []byte{} },
+	} {
+		p := &Proof{}
+		json.Unmarshal(js, p)
+		tamper(p)
+		if _, err := VerifyProof(root, p, 5000, 9000); err == nil {
+			t.Errorf("%v: expecting the proof to fail", name)
+		}
+	}
+}
+
+func TestProofNotSupported(t *testing.T) {
+	dpa, root, _ := newProofTestDPA(t, "SHA3", 10000)
+	defer dpa.Stop()
+	if _, err := dpa.Proof(root, 0, 100); err != ErrProofNotSupported {
+		t.Errorf("Expecting %v, got %v", ErrProofNotSupported, err)
+	}
+
+	// the keys of parities are not proven
+	params := NewChunkerParams()
+	params.Hash = "BMT"
+	params.Branches = 16
+	params.Redundancy = 3
+	dpa = NewDPA(&latencyStore{chunks: make(mapChunkStore)}, params)
+	dpa.Start()
+	defer dpa.Stop()
+	data, _ := testDataReaderAndSlice(10000)
+	wg := &sync.WaitGroup{}
+	root, err := dpa.Store(data, 10000, wg, nil)
+	if err != nil {
+		t.Fatalf("Store error: %v", err)
+	}
+	wg.Wait()
+	if _, err := dpa.Proof(root, 0, 100); err != ErrProofNotSupported {
+		t.Errorf("Expecting %v with redundancy, got %v", ErrProofNotSupported, err)
+	}
+}
@@ -17,185 +17,308 @@
 package storage
 
 import (
+	"bytes"
 	"encoding/binary"
-	"fmt"
+	"errors"
 	"io"
-	"math"
-	"strings"
 	"sync"
-
-	"github.com/ethereum/go-ethereum/common"
 )
 
 const (
 	processors = 8
 )
 
-type Tree struct {
-	Chunks int64
-	Levels []map[int64]*Node
-	Lock   sync.RWMutex
-}
-
-type Node struct {
-	Pending  int64
-	Size     uint64
-	Children []common.Hash
-	Last     bool
-}
-
-func (self *Node) String() string {
-	var children []string
-	for _, node := range self.Children {
-		children = append(children, node.Hex())
-	}
-	return fmt.Sprintf("pending: %v, size: %v, last :%v, children: %v", self.Pending, self.Size, self.Last, strings.Join(children, ", "))
-}
-
-type Task struct {
-	Index int64 // Index of the chunk being processed
-	Size  uint64
-	Data  []byte // Binary blob of the chunk
-	Last  bool
-}
+/*
+PyramidChunker builds the same trees as the TreeChunker, bottom up.  The data
+is read once in chunk sized sections, the leaves are hashed in parallel and
+an intermediate chunk is made as soon as its children are complete, so only
+the keys along the right edge of the tree are held.
 
+Because the right edge is all it needs, it can also append data to an
+existing tree: the keys of the edge are loaded back from the chunks and the
+last leaf, if not full, is split again with the appended data.
+*/
 type PyramidChunker struct {
-	hashFunc    Hasher
-	chunkSize   int64
-	hashSize    int64
-	branches    int64
-	workerCount int
+	hashFunc  Hasher
+	chunkSize int64
+	hashSize  int64
+	branches  int64
+	readAhead int64
 }
 
 func NewPyramidChunker(params *ChunkerParams) (self *PyramidChunker) {
 	self = &PyramidChunker{}
-	self.hashFunc = MakeHashFunc(params.Hash)
+	self.hashFunc = params.hasher()
 	self.branches = params.Branches
 	self.hashSize = int64(self.hashFunc().Size())
 	self.chunkSize = self.hashSize * self.branches
-	self.workerCount = 1
+	self.readAhead = params.ReadAhead
 	return
 }
 
+// pyramid is the state of a split
+type pyramid struct {
+	*PyramidChunker
+	levels [][]Key // keys of the complete subtrees by depth, not yet under a parent
+	tail   *Chunk  // the last leaf if it is not full
+	base   int64   // bytes under the complete subtrees loaded from an existing tree
+	chunkC chan *Chunk
+	swg    *sync.WaitGroup
+}
+
+type leafJob struct {
+	chunk *Chunk
+	done  chan bool
+}
+
+// implements the Splitter interface
+// a negative size splits the data up to EOF
 func (self *PyramidChunker) Split(data io.Reader, size int64, chunkC chan *Chunk, swg, wwg *sync.WaitGroup) (Key, error) {
+	p := &pyramid{PyramidChunker: self, chunkC: chunkC, swg: swg}
+	return p.split(data, size)
+}
+
+// implements the Appender interface
+// the data is appended to the content under root, up to EOF if size is
+// negative.  The key of the grown content is returned.
+func (self *PyramidChunker) Append(root Key, data io.Reader, size int64, chunkC, retrieveC chan *Chunk, swg, wwg *sync.WaitGroup) (Key, error) {
+	quitC := make(chan bool)
+	defer close(quitC)
+
+	p := &pyramid{PyramidChunker: self, chunkC: chunkC, swg: swg}
+	chunk := retrieve(root, retrieveC, quitC)
+	if chunk == nil || len(chunk.SData) < 8 {
+		return nil, errors.New("root chunk not found")
+	}
+	rootSize := int64(binary.LittleEndian.Uint64(chunk.SData[0:8]))
+	if rootSize > self.chunkSize && (int64(len(chunk.SData))-8)%self.hashSize != 0 {
+		// the tree has parity chunks
+		return nil, ErrAppendNotSupported
+	}
+	if err := p.load(self.depth(rootSize), chunk, rootSize, retrieveC, quitC); err != nil {
+		return nil, err
+	}
+	if p.tail != nil {
+		// the partial leaf is split again with the appended data
+		tail := p.tail.SData[8:]
+		p.base -= int64(len(tail))
+		p.tail = nil
+		data = io.MultiReader(bytes.NewReader(tail), data)
+		if size >= 0 {
+			size += int64(len(tail))
+		}
+	}
+	return p.split(data, size)
+}
 
-	chunks := (size + self.chunkSize - 1) / self.chunkSize
-	depth := int(math.Ceil(math.Log(float64(chunks))/math.Log(float64(self.branches)))) + 1
+// implements the Joiner interface, the trees are the same as those of the
+// TreeChunker
+func (self *PyramidChunker) Join(key Key, chunkC chan *Chunk) LazySectionReader {
+	return newLazyChunkReader(key, chunkC, self.hashFunc, self.chunkSize, self.branches, self.hashSize, self.readAhead)
+}
 
-	results := Tree{
-		Chunks: chunks,
-		Levels: make([]map[int64]*Node, depth),
+// implements the Walker interface
+func (self *PyramidChunker) Walk(key Key, chunkC chan *Chunk, quitC chan bool, f func(*Chunk) error) (int64, error) {
+	return walkTree(self.chunkSize, self.hashSize, key, chunkC, quitC, f)
+}
+
+// depth returns the depth of the root of a tree over size bytes, the lowest
+// depth such that chunkSize*branches^depth >= size
+func (self *PyramidChunker) depth(size int64) (depth int) {
+	for treeSize := self.chunkSize; treeSize < size; treeSize *= self.branches {
+		depth++
 	}
+	return
+}
+
+// span returns the size of a complete subtree of depth
+func (self *PyramidChunker) span(depth int) int64 {
+	span := self.chunkSize
 	for i := 0; i < depth; i++ {
-		results.Levels[i] = make(map[int64]*Node)
+		span *= self.branches
 	}
-	// Create a pool of workers to crunch through the file
-	tasks := make(chan *Task, 2*processors)
-	pend := new(sync.WaitGroup)
-	abortC := make(chan bool)
+	return span
+}
+
+// childDepth returns the depth of the last child of an intermediate chunk at
+// depth, a child smaller than the span of its children has fewer levels
+func (self *PyramidChunker) childDepth(depth int, size int64) int {
+	depth--
+	for childSpan := self.span(depth - 1); depth > 0 && size < childSpan; childSpan /= self.branches {
+		depth--
+	}
+	return depth
+}
+
+func (self *pyramid) split(data io.Reader, size int64) (Key, error) {
+	jobC := make(chan *leafJob, 2*processors)
+	workers := &sync.WaitGroup{}
 	for i := 0; i < processors; i++ {
-		pend.Add(1)
-		go self.processor(pend, swg, tasks, chunkC, &results)
-	}
-	// Feed the chunks into the task pool
-	read := 0
-	for index := 0; ; index++ {
-		buffer := make([]byte, self.chunkSize+8)
-		n, err := data.Read(buffer[8:])
-		read += n
-		last := int64(read) == size || err == io.ErrUnexpectedEOF || err == io.EOF
-		if err != nil && !last {
-			close(abortC)
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			hasher := self.hashFunc()
+			for job := range jobC {
+				hasher.Reset()
+				hasher.Write(job.chunk.SData)
+				job.chunk.Key = hasher.Sum(nil)
+				close(job.done)
+			}
+		}()
+	}
+
+	// the leaves are hashed in parallel but added in order
+	var queue []*leafJob
+	var read int64
+	var err error
+	for {
+		n := self.chunkSize
+		if size >= 0 && size-read < n {
+			n = size - read
+		}
+		sdata := make([]byte, n+8)
+		m, rerr := io.ReadFull(data, sdata[8:])
+		if size < 0 && (rerr == io.EOF || rerr == io.ErrUnexpectedEOF) {
+			// the end of content of unknown length
+			n, rerr = int64(m), nil
+			sdata = sdata[:8+m]
+		}
+		if rerr != nil {
+			err = rerr
 			break
 		}
-		binary.LittleEndian.PutUint64(buffer[:8], uint64(n))
-		pend.Add(1)
-		select {
-		case tasks <- &Task{Index: int64(index), Size: uint64(n), Data: buffer[:n+8], Last: last}:
-		case <-abortC:
-			return nil, err
+		if n == 0 && (read > 0 || self.base > 0) {
+			break
 		}
-		if last {
+		binary.LittleEndian.PutUint64(sdata[0:8], uint64(n))
+		read += n
+		job := &leafJob{&Chunk{SData: sdata, Size: n}, make(chan bool)}
+		jobC <- job
+		queue = append(queue, job)
+		if len(queue) > 2*processors {
+			<-queue[0].done
+			self.addLeaf(queue[0].chunk)
+			queue = queue[1:]
+		}
+		// a leaf that is not full is the last, empty content has an empty leaf
+		if n < self.chunkSize || read == size {
 			break
 		}
 	}
-	// Wait for the workers and return
-	close(tasks)
-	pend.Wait()
+	close(jobC)
+	workers.Wait()
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range queue {
+		self.addLeaf(job.chunk)
+	}
 
-	key := results.Levels[0][0].Children[0][:]
+	key := self.build(self.depth(self.base+read), self.base+read)
+	if self.swg != nil {
+		self.swg.Wait()
+	}
 	return key, nil
 }
 
-func (self *PyramidChunker) processor(pend, swg *sync.WaitGroup, tasks chan *Task, chunkC chan *Chunk, results *Tree) {
-	defer pend.Done()
+func (self *pyramid) addLeaf(chunk *Chunk) {
+	self.store(chunk)
+	if chunk.Size < self.chunkSize {
+		self.tail = chunk
+		return
+	}
+	self.push(0, chunk.Key)
+}
+
+// push adds the key of a complete subtree, making their parent once there
+// are branches of them
+func (self *pyramid) push(depth int, key Key) {
+	for len(self.levels) <= depth {
+		self.levels = append(self.levels, nil)
+	}
+	self.levels[depth] = append(self.levels[depth], key)
+	if int64(len(self.levels[depth])) == self.branches {
+		parent := self.node(self.levels[depth], self.span(depth+1))
+		self.levels[depth] = nil
+		self.push(depth+1, parent)
+	}
+}
+
+// build makes the chunks of the right edge of the tree once all the data is
+// read, the way the TreeChunker splits the last section of each level
+func (self *pyramid) build(depth int, size int64) Key {
+	if size == self.span(depth) && len(self.levels) > depth && len(self.levels[depth]) > 0 {
+		key := self.levels[depth][0]
+		self.levels[depth] = self.levels[depth][1:]
+		return key
+	}
+	if depth == 0 {
+		return self.tail.Key
+	}
+	var children []Key
+	if len(self.levels) >= depth {
+		children = self.levels[depth-1]
+		self.levels[depth-1] = nil
+	}
+	if rest := size - int64(len(children))*self.span(depth-1); rest > 0 {
+		children = append(children, self.build(self.childDepth(depth, rest), rest))
+	}
+	return self.node(children, size)
+}
 
-	// Start processing leaf chunks ad infinitum
+// node makes and stores an intermediate chunk
+func (self *pyramid) node(children []Key, size int64) Key {
+	sdata := make([]byte, 8+int64(len(children))*self.hashSize)
+	binary.LittleEndian.PutUint64(sdata[0:8], uint64(size))
+	for i, key := range children {
+		copy(sdata[8+int64(i)*self.hashSize:], key)
+	}
 	hasher := self.hashFunc()
-	for task := range tasks {
-		depth, pow := len(results.Levels)-1, self.branches
-		size := task.Size
-		data := task.Data
-		var node *Node
-		for depth >= 0 {
-			// New chunk received, reset the hasher and start processing
-			hasher.Reset()
-			if node == nil { // Leaf node, hash the data chunk
-				hasher.Write(task.Data)
-			} else { // Internal node, hash the children
-				size = node.Size
-				data = make([]byte, hasher.Size()*len(node.Children)+8)
-				binary.LittleEndian.PutUint64(data[:8], size)
-
-				hasher.Write(data[:8])
-				for i, hash := range node.Children {
-					copy(data[i*hasher.Size()+8:], hash[:])
-					hasher.Write(hash[:])
-				}
-			}
-			hash := hasher.Sum(nil)
-			last := task.Last || (node != nil) && node.Last
-			// Insert the subresult into the memoization tree
-			results.Lock.Lock()
-			if node = results.Levels[depth][task.Index/pow]; node == nil {
-				// Figure out the pending tasks
-				pending := self.branches
-				if task.Index/pow == results.Chunks/pow {
-					pending = (results.Chunks + pow/self.branches - 1) / (pow / self.branches) % self.branches
-				}
-				node = &Node{pending, 0, make([]common.Hash, pending), last}
-				results.Levels[depth][task.Index/pow] = node
-			}
-			node.Pending--
-			i := task.Index / (pow / self.branches) % self.branches
-			if last {
-				node.Last = true
-			}
-			copy(node.Children[i][:], hash)
-			node.Size += size
-			left := node.Pending
-			if chunkC != nil {
-				if swg != nil {
-					swg.Add(1)
-				}
-				select {
-				case chunkC <- &Chunk{Key: hash, SData: data, wg: swg}:
-					// case <- self.quitC
-				}
-			}
-			if depth+1 < len(results.Levels) {
-				delete(results.Levels[depth+1], task.Index/(pow/self.branches))
-			}
+	hasher.Write(sdata)
+	chunk := &Chunk{Key: hasher.Sum(nil), SData: sdata, Size: size}
+	self.store(chunk)
+	return chunk.Key
+}
 
-			results.Lock.Unlock()
-			// If there's more work to be done, leave for others
-			if left > 0 {
-				break
-			}
-			// We're the last ones in this batch, merge the children together
-			depth--
-			pow *= self.branches
+func (self *pyramid) store(chunk *Chunk) {
+	if self.chunkC == nil {
+		return
+	}
+	chunk.wg = self.swg
+	if self.swg != nil {
+		self.swg.Add(1)
+	}
+	self.chunkC <- chunk
+}
+
+// load restores the state of the split of an existing tree from the chunks
+// of its right edge
+func (self *pyramid) load(depth int, chunk *Chunk, size int64, retrieveC chan *Chunk, quitC chan bool) error {
+	if size == self.span(depth) || depth == 0 {
+		self.base += size
+		if size == self.span(depth) {
+			self.push(depth, chunk.Key)
+		} else {
+			self.tail = chunk
 		}
-		pend.Done()
+		return nil
+	}
+	children := (int64(len(chunk.SData)) - 8) / self.hashSize
+	childSpan := self.span(depth - 1)
+	for i := int64(0); i < children-1; i++ {
+		self.push(depth-1, Key(chunk.SData[8+i*self.hashSize:8+(i+1)*self.hashSize]))
+		self.base += childSpan
+	}
+	last := Key(chunk.SData[8+(children-1)*self.hashSize : 8+children*self.hashSize])
+	rest := size - (children-1)*childSpan
+	if rest == childSpan {
+		self.push(depth-1, last)
+		self.base += childSpan
+		return nil
+	}
+	lastChunk := retrieve(last, retrieveC, quitC)
+	if lastChunk == nil || len(lastChunk.SData) < 8 {
+		return errors.New("chunk " + last.Log() + " not found")
 	}
+	return self.load(self.childDepth(depth, rest), lastChunk, rest, retrieveC, quitC)
 }
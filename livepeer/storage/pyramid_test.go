@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+)
+
+func splitBoth(t *testing.T, params *ChunkerParams, input []byte) (tree, pyramid Key) {
+	tester := &chunkerTester{t: t}
+	size := int64(len(input))
+	tree = tester.Split(NewTreeChunker(params), bytes.NewReader(input), size, nil, nil, nil)
+	pyramid = tester.Split(NewPyramidChunker(params), bytes.NewReader(input), size, nil, nil, nil)
+	return
+}
+
+// the trees differ where the last section of a level is smaller than a
+// subtree or exactly one, small branches get there with little data
+func TestChunkerCompatibility(t *testing.T) {
+	params := &ChunkerParams{Branches: 4, Hash: defaultHash}
+	_, input := testDataReaderAndSlice(128*4*4*4 + 300)
+	for n := 0; n <= len(input); n++ {
+		tree, pyramid := splitBoth(t, params, input[:n])
+		if !bytes.Equal(tree, pyramid) {
+			t.Fatalf("size %d: tree chunker root %v, pyramid chunker root %v", n, tree, pyramid)
+		}
+	}
+
+	params = NewChunkerParams()
+	_, input = testDataReaderAndSlice(4096*128*2 + 4096 + 1)
+	for _, n := range []int{4096 * 128, 4096*128 + 1, 4096 * 129, 4096*129 + 1, 4096*128*2 + 4096 + 1} {
+		tree, pyramid := splitBoth(t, params, input[:n])
+		if !bytes.Equal(tree, pyramid) {
+			t.Fatalf("size %d: tree chunker root %v, pyramid chunker root %v", n, tree, pyramid)
+		}
+	}
+}
+
+func TestPyramidJoin(t *testing.T) {
+	tester := &chunkerTester{t: t}
+	pyramid := NewPyramidChunker(NewChunkerParams())
+	for _, n := range []int{0, 1, 4095, 4096, 4097, 123456, 2345678} {
+		data, input := testDataReaderAndSlice(n)
+		chunkC := make(chan *Chunk, 1000)
+		key := tester.Split(pyramid, data, int64(n), chunkC, &sync.WaitGroup{}, nil)
+
+		chunkC = make(chan *Chunk, 1000)
+		quitC := make(chan bool)
+		reader := tester.Join(pyramid, key, 0, chunkC, quitC)
+		output := make([]byte, n)
+		if r, err := reader.Read(output); n > 0 && (r != n || err != io.EOF) {
+			t.Fatalf("read error  read: %v  n = %v  err = %v", r, n, err)
+		}
+		if !bytes.Equal(output, input) {
+			t.Fatalf("size %d: input and output mismatch", n)
+		}
+		close(chunkC)
+		<-quitC
+	}
+}
+
+func TestPyramidAppend(t *testing.T) {
+	params := &ChunkerParams{Branches: 4, Hash: defaultHash}
+	pyramid := NewPyramidChunker(params)
+	_, input := testDataReaderAndSlice(128*4*4*4 + 300)
+
+	for i, sizes := range [][2]int{{0, 10}, {10, 0}, {100, 28}, {128, 1}, {129, 2000}, {512, 512}, {1000, 3000}, {2048, 1}, {2049, 128}, {4096, 396}} {
+		a, b := sizes[0], sizes[1]
+		tester := &chunkerTester{t: t}
+		chunkC := make(chan *Chunk, 1000)
+		root := tester.Split(pyramid, bytes.NewReader(input[:a]), int64(a), chunkC, &sync.WaitGroup{}, nil)
+		chunks := tester.chunks
+
+		// serves the retrieve requests from the chunks of the first split
+		retrieveC := make(chan *Chunk)
+		go func() {
+			for chunk := range retrieveC {
+				if stored, ok := chunks[chunk.Key.String()]; ok {
+					chunk.SData = stored.SData
+					chunk.Size = stored.Size
+				}
+				close(chunk.C)
+			}
+		}()
+		storeC := make(chan *Chunk, 1000)
+		go func() {
+			for chunk := range storeC {
+				chunk.wg.Done()
+			}
+		}()
+		size := int64(b)
+		if i%2 == 1 {
+			// appending up to EOF
+			size = -1
+		}
+		appended, err := pyramid.Append(root, bytes.NewReader(input[a:a+b]), size, storeC, retrieveC, &sync.WaitGroup{}, nil)
+		close(retrieveC)
+		close(storeC)
+		if err != nil {
+			t.Fatalf("%d+%d: Append error: %v", a, b, err)
+		}
+		tree, _ := splitBoth(t, params, input[:a+b])
+		if !bytes.Equal(appended, tree) {
+			t.Errorf("%d+%d: expecting root %v, got %v", a, b, tree, appended)
+		}
+	}
+}
+
+// oneByteReader returns the data a byte at a time like a slow stream
+type oneByteReader struct {
+	r io.Reader
+}
+
+func (self oneByteReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return self.r.Read(p[:1])
+}
+
+func TestSplitUnknownLength(t *testing.T) {
+	params := &ChunkerParams{Branches: 4, Hash: defaultHash}
+	_, input := testDataReaderAndSlice(128*4*4 + 300)
+	tester := &chunkerTester{t: t}
+	for _, n := range []int{0, 1, 127, 128, 129, 512, 513, 640, 2048, len(input)} {
+		tree, _ := splitBoth(t, params, input[:n])
+		for _, chunker := range []Splitter{NewTreeChunker(params), NewPyramidChunker(params)} {
+			key := tester.Split(chunker, oneByteReader{bytes.NewReader(input[:n])}, -1, nil, nil, nil)
+			if !bytes.Equal(key, tree) {
+				t.Errorf("size %d: expecting root %v, got %v", n, tree, key)
+			}
+		}
+	}
+}
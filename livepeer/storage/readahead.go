@@ -0,0 +1,195 @@
+package storage
+
+/*
+Read-ahead for the LazyChunkReader.
+
+Media players read a document front to back in small reads, each of which
+waits for the retrieval of the chunks under it.  When the reads of a reader
+follow each other, the chunks of the next ReadAhead chunk sizes of the
+document are requested through the retrieve loop in parallel, and the reads
+use the retrievals in flight instead of requesting the chunks again.
+A Seek or a read elsewhere cancels the read-ahead, it starts over once the
+reads are sequential again.  Close stops it for good, readers that are not
+read to the end should be closed.
+*/
+
+// prefetch is a chunk retrieval started by the read-ahead
+type prefetch struct {
+	chunk *Chunk
+	end   int64 // offset of the end of the subtree of the chunk
+	quitC chan bool
+}
+
+// startReadAhead is called by ReadAt before reading [off, eoff), it extends
+// the read-ahead past eoff if the read follows the previous one and cancels
+// it otherwise
+func (self *LazyChunkReader) startReadAhead(off, eoff, size int64, depth int, treeSize int64) {
+	if self.readAhead <= 0 {
+		return
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.closed {
+		return
+	}
+
+	sequential := off == self.next
+	self.next = eoff
+	if !sequential {
+		self.cancelReadAhead()
+		return
+	}
+	// the chunks of the data read are not needed any more
+	for key, p := range self.prefetched {
+		if p.end <= off {
+			delete(self.prefetched, key)
+		}
+	}
+	start := eoff
+	if self.ahead > start {
+		start = self.ahead
+	}
+	end := eoff + self.readAhead*self.chunkSize
+	if end > size {
+		end = size
+	}
+	if start >= end {
+		return
+	}
+	self.ahead = end
+	go self.prefetchTree(start, end, depth, treeSize/self.branches, self.chunk, 0, self.quitAheadC)
+}
+
+// seeked is called by Seek, the read-ahead is cancelled unless the reader
+// stays where it is
+func (self *LazyChunkReader) seeked(offset int64) {
+	if self.readAhead <= 0 {
+		return
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if offset != self.next {
+		self.cancelReadAhead()
+		self.next = -1
+	}
+}
+
+// Close stops the read-ahead, the retrievals it requested and not yet
+// submitted are abandoned.  The reader can still be read, without
+// read-ahead.
+func (self *LazyChunkReader) Close() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.closed = true
+	self.cancelReadAhead()
+	return nil
+}
+
+// cancelReadAhead stops the retrievals not yet requested and forgets the
+// chunks read ahead, it is called with the lock held
+func (self *LazyChunkReader) cancelReadAhead() {
+	if self.ahead == 0 {
+		return
+	}
+	close(self.quitAheadC)
+	self.quitAheadC = make(chan bool)
+	self.prefetched = make(map[string]*prefetch)
+	self.ahead = 0
+}
+
+// prefetchTree requests the chunks of the subtrees of chunk under
+// [off, eoff) without waiting for the leaves.  Offsets are relative to the
+// chunk, base is the offset of the chunk in the document.
+func (self *LazyChunkReader) prefetchTree(off, eoff int64, depth int, treeSize int64, chunk *Chunk, base int64, quitC chan bool) {
+	for chunk.Size < treeSize && depth > 0 {
+		treeSize /= self.branches
+		depth--
+	}
+	if depth == 0 {
+		return
+	}
+	start := off / treeSize
+	end := (eoff + treeSize - 1) / treeSize
+	if children := (int64(len(chunk.SData)) - 8) / self.hashSize; end > children {
+		end = children
+	}
+	for i := start; i < end; i++ {
+		childKey := chunk.SData[8+i*self.hashSize : 8+(i+1)*self.hashSize]
+		child := self.prefetchChunk(childKey, base+(i+1)*treeSize, quitC)
+		if child == nil {
+			return
+		}
+		if depth == 1 {
+			continue
+		}
+		soff, seoff := i*treeSize, (i+1)*treeSize
+		if soff < off {
+			soff = off
+		}
+		if seoff > eoff {
+			seoff = eoff
+		}
+		go func(child *Chunk, soff, seoff, roff int64) {
+			select {
+			case <-child.C:
+			case <-quitC:
+				return
+			}
+			if len(child.SData) == 0 {
+				return
+			}
+			self.prefetchTree(soff-roff, seoff-roff, depth-1, treeSize/self.branches, child, base+roff, quitC)
+		}(child, soff, seoff, i*treeSize)
+	}
+}
+
+// prefetchChunk requests the retrieval of a chunk unless it is already
+// requested, it returns nil if the read-ahead is cancelled
+func (self *LazyChunkReader) prefetchChunk(key Key, end int64, quitC chan bool) *Chunk {
+	self.lock.Lock()
+	if quitC != self.quitAheadC {
+		self.lock.Unlock()
+		return nil
+	}
+	if p, ok := self.prefetched[string(key)]; ok {
+		self.lock.Unlock()
+		return p.chunk
+	}
+	chunk := &Chunk{
+		Key: key,
+		C:   make(chan bool),
+	}
+	self.prefetched[string(key)] = &prefetch{chunk: chunk, end: end, quitC: quitC}
+	self.lock.Unlock()
+
+	go func() {
+		select {
+		case self.chunkC <- chunk:
+		case <-quitC:
+		}
+	}()
+	return chunk
+}
+
+// retrieve returns a chunk for a read, through the read-ahead if it is
+// requested already
+func (self *LazyChunkReader) retrieve(key Key, quitC chan bool) *Chunk {
+	self.lock.Lock()
+	p, ok := self.prefetched[string(key)]
+	self.lock.Unlock()
+	if !ok {
+		return retrieve(key, self.chunkC, quitC)
+	}
+	select {
+	case <-p.chunk.C:
+	case <-p.quitC:
+		// cancelled, possibly before the request was submitted
+		return retrieve(key, self.chunkC, quitC)
+	case <-quitC:
+		return nil
+	}
+	if len(p.chunk.SData) == 0 {
+		return nil
+	}
+	return p.chunk
+}
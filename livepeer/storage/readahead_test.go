@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newLatencyDPA(t testing.TB, readAhead int64, latency time.Duration, size int) (*DPA, *latencyStore, Key, []byte) {
+	params := NewChunkerParams()
+	params.ReadAhead = readAhead
+	store := &latencyStore{chunks: make(mapChunkStore), latency: latency}
+	dpa := &DPA{
+		Chunker:    NewTreeChunker(params),
+		ChunkStore: store,
+	}
+	dpa.Start()
+	data, input := testDataReaderAndSlice(size)
+	wg := &sync.WaitGroup{}
+	key, err := dpa.Store(data, int64(size), wg, nil)
+	if err != nil {
+		t.Fatalf("Store error: %v", err)
+	}
+	wg.Wait()
+	store.lock.Lock()
+	store.gets = 0
+	store.lock.Unlock()
+	return dpa, store, key, input
+}
+
+// readInto reads the reader to the end in reads of n bytes
+func readInto(t testing.TB, reader io.Reader, n int) []byte {
+	var output []byte
+	b := make([]byte, n)
+	for {
+		r, err := reader.Read(b)
+		output = append(output, b[:r]...)
+		if err == io.EOF {
+			return output
+		}
+		if err != nil {
+			t.Fatalf("Read error: %v", err)
+		}
+	}
+}
+
+func TestReadAhead(t *testing.T) {
+	// 74 leaves under the root
+	size := 300000
+	dpa, store, key, input := newLatencyDPA(t, 8, 0, size)
+	defer dpa.Stop()
+
+	reader := dpa.Retrieve(key).(*LazyChunkReader)
+	if output := readInto(t, reader, 1000); !bytes.Equal(output, input) {
+		t.Fatalf("input and output mismatch")
+	}
+	// without the read-ahead every read retrieves its leaves again
+	store.lock.Lock()
+	gets := store.gets
+	store.lock.Unlock()
+	if gets > 2*75 {
+		t.Errorf("Expecting the chunks to be retrieved about once, got %d retrievals", gets)
+	}
+
+	reader.Seek(int64(size/2), 0)
+	reader.lock.Lock()
+	if len(reader.prefetched) != 0 || reader.ahead != 0 {
+		t.Errorf("Expecting the read-ahead to be cancelled by Seek")
+	}
+	reader.lock.Unlock()
+	if output := readInto(t, reader, 1000); !bytes.Equal(output, input[size/2:]) {
+		t.Fatalf("input and output mismatch after Seek")
+	}
+
+	// reads elsewhere are served without the read-ahead
+	b := make([]byte, 5000)
+	for _, off := range []int{200000, 1000, 150000} {
+		if _, err := reader.ReadAt(b, int64(off)); err != nil || !bytes.Equal(b, input[off:off+len(b)]) {
+			t.Fatalf("ReadAt %d: input and output mismatch: %v", off, err)
+		}
+	}
+}
+
+func TestReadAheadClose(t *testing.T) {
+	size := 300000
+	dpa, _, key, input := newLatencyDPA(t, 8, time.Millisecond, size)
+	defer dpa.Stop()
+
+	reader := dpa.Retrieve(key).(*LazyChunkReader)
+	b := make([]byte, 1000)
+	for i := 0; i < 3; i++ {
+		if _, err := reader.Read(b); err != nil {
+			t.Fatalf("Read error: %v", err)
+		}
+	}
+	reader.lock.Lock()
+	started := reader.ahead > 0
+	reader.lock.Unlock()
+	if !started {
+		t.Fatalf("Expecting sequential reads to read ahead")
+	}
+
+	reader.Close()
+	if output := readInto(t, reader, 1000); !bytes.Equal(output, input[3000:]) {
+		t.Fatalf("input and output mismatch after Close")
+	}
+	reader.lock.Lock()
+	defer reader.lock.Unlock()
+	if len(reader.prefetched) != 0 || reader.ahead != 0 {
+		t.Errorf("Expecting no read-ahead after Close, %d chunks up to %d", len(reader.prefetched), reader.ahead)
+	}
+}
+
+func benchmarkReadAhead(readAhead int64, b *testing.B) {
+	dpa, _, key, _ := newLatencyDPA(b, readAhead, time.Millisecond, 1000000)
+	defer dpa.Stop()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// a player reading through a segment
+		readInto(b, dpa.Retrieve(key), 16384)
+	}
+}
+
+func BenchmarkReadAhead_0(b *testing.B)  { benchmarkReadAhead(0, b) }
+func BenchmarkReadAhead_8(b *testing.B)  { benchmarkReadAhead(8, b) }
+func BenchmarkReadAhead_32(b *testing.B) { benchmarkReadAhead(32, b) }
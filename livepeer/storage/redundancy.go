@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/logger"
+	"github.com/ethereum/go-ethereum/logger/glog"
+)
+
+/*
+Redundancy of the tree chunker.
+
+With a Redundancy of r the keys of the children of every intermediate chunk
+are followed by the keys of r parity chunks and the chunk ends in a byte
+holding r.  The parity chunks are the Reed-Solomon parities of the data of
+the children, each padded to the chunk size.  When children cannot be
+retrieved the joiner reconstructs them from as many of their siblings and
+parities as there are children, so up to r children of every chunk may be
+lost.  The parities take the place of r+1 children, trees with redundancy
+branch less, and a group of children and parities is at most 256 chunks.
+Rebuilt chunks are checked against their keys.
+
+Parity chunks are stored like leaf chunks of the chunk size.  The tree is
+split top down to know the children of a chunk before its parities, so
+content of unknown length cannot be stored with redundancy.
+*/
+
+var ErrRedundancyUnknownLength = errors.New("content of unknown length cannot be stored with redundancy")
+
+// maxShards is the largest group of children and parities the erasure code
+// handles
+const maxShards = 256
+
+// maxParities returns the highest redundancy of trees of the branches, a
+// group has at least as many children as parities
+func maxParities(branches int64) int64 {
+	max := branches/2 - 1
+	if max > maxShards/2 {
+		max = maxShards / 2
+	}
+	return max
+}
+
+// redundantBranches returns the data children of intermediate chunks with
+// parities, the parity keys and their count take the place of children and
+// a group of children and parities is at most maxShards
+func redundantBranches(branches, parities int64) int64 {
+	branches -= parities + 1
+	if branches > maxShards-parities {
+		branches = maxShards - parities
+	}
+	return branches
+}
+
+// padShard copies the data of a chunk into a shard of the chunk size
+func padShard(data []byte, chunkSize int64) []byte {
+	shard := make([]byte, chunkSize)
+	copy(shard, data)
+	return shard
+}
+
+// addParities stores the parity chunks of the children of an intermediate
+// chunk and puts their keys after those of the children
+func (self *TreeChunker) addParities(chunk []byte, children [][]byte, chunkC chan *Chunk, swg *sync.WaitGroup) {
+	shards := make([][]byte, len(children))
+	for i, child := range children {
+		if child == nil {
+			// the split is aborted
+			return
+		}
+		shards[i] = padShard(child[8:], self.chunkSize)
+	}
+	hasher := self.hashFunc()
+	branchCnt := int64(len(children))
+	for i, parity := range newErasureCode(len(shards), int(self.parities)).encode(shards) {
+		sdata := make([]byte, 8+len(parity))
+		binary.LittleEndian.PutUint64(sdata[0:8], uint64(len(parity)))
+		copy(sdata[8:], parity)
+		hasher.Reset()
+		hasher.Write(sdata)
+		key := hasher.Sum(nil)
+		copy(chunk[8+(branchCnt+int64(i))*self.hashSize:], key)
+		if chunkC != nil {
+			if swg != nil {
+				swg.Add(1)
+			}
+			chunkC <- &Chunk{
+				Key:   key,
+				SData: sdata,
+				Size:  int64(len(parity)),
+				wg:    swg,
+			}
+		}
+	}
+}
+
+// setRedundancy reads the parities of the tree from its root chunk, the
+// branches of the reader follow from them
+func (self *LazyChunkReader) setRedundancy(root *Chunk) {
+	self.parities = 0
+	if root.Size > self.chunkSize && (int64(len(root.SData))-8)%self.hashSize == 1 {
+		self.parities = int64(root.SData[len(root.SData)-1])
+	}
+	self.branches = self.chunkSize / self.hashSize
+	if self.parities > 0 {
+		self.branches = redundantBranches(self.branches, self.parities)
+	}
+}
+
+// recoverChild reconstructs child i of an intermediate chunk from the other
+// children and the parities.  depth and treeSize are those the joiner reads
+// the child with, nil is returned if too many chunks are missing.
+func (self *LazyChunkReader) recoverChild(parent *Chunk, i int64, depth int, treeSize int64, quitC chan bool) *Chunk {
+	if (int64(len(parent.SData))-8)%self.hashSize != 1 {
+		return nil
+	}
+	keys := (int64(len(parent.SData)) - 8) / self.hashSize
+	parities := int64(parent.SData[len(parent.SData)-1])
+	branchCnt := keys - parities
+	if i >= branchCnt || branchCnt <= 0 {
+		return nil
+	}
+
+	type shard struct {
+		j    int64
+		data []byte
+	}
+	// buffered so that retrievals still running when enough shards are in
+	// do not block
+	shardC := make(chan shard, keys)
+	for j := int64(0); j < keys; j++ {
+		if j == i {
+			continue
+		}
+		go func(j int64) {
+			var data []byte
+			if chunk := self.retrieve(parent.SData[8+j*self.hashSize:8+(j+1)*self.hashSize], quitC); chunk != nil {
+				data = padShard(chunk.SData[8:], self.chunkSize)
+			}
+			shardC <- shard{j, data}
+		}(j)
+	}
+	shards := make([][]byte, keys)
+	var found int64
+	for k := int64(1); k < keys && found < branchCnt; k++ {
+		s := <-shardC
+		if s.data != nil {
+			shards[s.j] = s.data
+			found++
+		}
+	}
+	if found < branchCnt {
+		return nil
+	}
+	if err := newErasureCode(int(branchCnt), int(parities)).reconstruct(shards); err != nil {
+		return nil
+	}
+
+	// all children but the last span the tree size
+	span := treeSize
+	if i == branchCnt-1 {
+		span = parent.Size - (branchCnt-1)*treeSize
+	}
+	// the level of the child as split and joined
+	length := span
+	childTreeSize := treeSize / self.branches
+	for span < childTreeSize && depth > 0 {
+		childTreeSize /= self.branches
+		depth--
+	}
+	if depth > 0 {
+		length = ((span+childTreeSize-1)/childTreeSize+parities)*self.hashSize + 1
+	}
+	if span <= 0 || length > self.chunkSize {
+		return nil
+	}
+	sdata := make([]byte, 8+length)
+	binary.LittleEndian.PutUint64(sdata[0:8], uint64(span))
+	copy(sdata[8:], shards[i][:length])
+	ref := Key(parent.SData[8+i*self.hashSize : 8+(i+1)*self.hashSize])
+	// encrypted chunks are stored under the hash of the ciphertext
+	key, chunkKey := splitReference(ref)
+	data := sdata
+	if chunkKey != nil {
+		data = cryptChunk(chunkKey, sdata)
+	}
+	hasher := plainHasher(self.hashFunc)()
+	hasher.Write(data)
+	if !bytes.Equal(hasher.Sum(nil), key) {
+		glog.V(logger.Detail).Infof("LazyChunkReader: chunk %v recovered from parities does not match its key", ref.Log())
+		return nil
+	}
+	glog.V(logger.Detail).Infof("LazyChunkReader: chunk %v recovered from parities", ref.Log())
+	return &Chunk{
+		Key:   ref,
+		SData: sdata,
+		Size:  span,
+	}
+}
@@ -0,0 +1,240 @@
+package storage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+// parityGroups returns the keys of the children and parities of every
+// intermediate chunk of a tree of 16 branches
+func parityGroups(t *testing.T, dpa *DPA, key Key) [][]Key {
+	var groups [][]Key
+	quitC := make(chan bool)
+	defer close(quitC)
+	_, err := dpa.Chunker.(*TreeChunker).Walk(key, dpa.retrieveC, quitC, func(chunk *Chunk) error {
+		if chunk.Size <= 16*32 {
+			return nil
+		}
+		var group []Key
+		for i := 8; i+32 <= len(chunk.SData); i += 32 {
+			group = append(group, Key(chunk.SData[i:i+32]))
+		}
+		groups = append(groups, group)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk error: %v", err)
+	}
+	return groups
+}
+
+// pendingStore answers retrievals of the chunks it lacks with requests that
+// are never delivered, like a net store searching peers
+type pendingStore struct {
+	*latencyStore
+}
+
+func (self pendingStore) Get(key Key) (*Chunk, error) {
+	if chunk, err := self.latencyStore.Get(key); err == nil {
+		return chunk, nil
+	}
+	return &Chunk{Key: key, Req: newRequestStatus(key)}, nil
+}
+
+func TestRedundancy(t *testing.T) {
+	params := NewChunkerParams()
+	params.Branches = 16
+	params.Redundancy = 3
+	store := &latencyStore{chunks: make(mapChunkStore)}
+	dpa := NewDPA(store, params)
+	dpa.Start()
+	defer dpa.Stop()
+	data, input := testDataReaderAndSlice(200000)
+	wg := &sync.WaitGroup{}
+	key, err := dpa.Store(data, 200000, wg, nil)
+	if err != nil {
+		t.Fatalf("Store error: %v", err)
+	}
+	wg.Wait()
+
+	// 12 children and 3 parities under each of 3 levels of intermediate chunks
+	groups := parityGroups(t, dpa, key)
+	if len(groups) < 3 {
+		t.Fatalf("Expecting intermediate chunks, got %d", len(groups))
+	}
+
+	r := rand.New(rand.NewSource(1))
+	var deleted int
+	for _, group := range groups {
+		if len(group) < 4 {
+			t.Fatalf("Expecting 3 parities in every group, got %d keys", len(group))
+		}
+		// any 3 chunks of a group, children or parities
+		for _, i := range r.Perm(len(group))[:r.Intn(4)] {
+			delete(store.chunks, group[i].String())
+			deleted++
+		}
+	}
+	if deleted == 0 {
+		t.Fatalf("no chunks deleted")
+	}
+
+	output, err := ioutil.ReadAll(dpa.Retrieve(key))
+	if err != nil {
+		t.Fatalf("Read error after deleting %d chunks: %v", deleted, err)
+	}
+	if !bytes.Equal(output, input) {
+		t.Fatalf("input and output mismatch after deleting %d chunks", deleted)
+	}
+
+	// one chunk too many in a group
+	group := groups[len(groups)-1]
+	for _, key := range group[:4] {
+		delete(store.chunks, key.String())
+	}
+	if _, err := ioutil.ReadAll(dpa.Retrieve(key)); err == nil {
+		t.Fatalf("Expecting an error with 4 chunks of a group deleted")
+	}
+}
+
+func TestRedundancyCorrupt(t *testing.T) {
+	params := NewChunkerParams()
+	params.Branches = 16
+	params.Redundancy = 3
+	store := &latencyStore{chunks: make(mapChunkStore)}
+	dpa := NewDPA(store, params)
+	dpa.Start()
+	defer dpa.Stop()
+	data, _ := testDataReaderAndSlice(200000)
+	wg := &sync.WaitGroup{}
+	key, err := dpa.Store(data, 200000, wg, nil)
+	if err != nil {
+		t.Fatalf("Store error: %v", err)
+	}
+	wg.Wait()
+
+	// the last leaves are rebuilt from all the other chunks of their group,
+	// one parity of which is corrupt
+	groups := parityGroups(t, dpa, key)
+	group := groups[len(groups)-1]
+	for _, key := range group[:3] {
+		delete(store.chunks, key.String())
+	}
+	parity := store.chunks[group[len(group)-1].String()]
+	corrupt := append([]byte{}, parity.SData...)
+	corrupt[8]++
+	store.chunks[parity.Key.String()] = &Chunk{Key: parity.Key, SData: corrupt, Size: parity.Size}
+
+	if _, err := ioutil.ReadAll(dpa.Retrieve(key)); err == nil {
+		t.Fatalf("Expecting an error rebuilding chunks from a corrupt parity")
+	}
+}
+
+func TestRedundancyTimeout(t *testing.T) {
+	params := NewChunkerParams()
+	params.Branches = 16
+	params.Redundancy = 3
+	store := &latencyStore{chunks: make(mapChunkStore)}
+	dpa := NewDPA(store, params)
+	dpa.Start()
+	defer dpa.Stop()
+	data, input := testDataReaderAndSlice(50000)
+	wg := &sync.WaitGroup{}
+	key, err := dpa.Store(data, 50000, wg, nil)
+	if err != nil {
+		t.Fatalf("Store error: %v", err)
+	}
+	wg.Wait()
+
+	for _, group := range parityGroups(t, dpa, key) {
+		delete(store.chunks, group[0].String())
+	}
+	// the missing chunks are searched for until the timeout, then rebuilt
+	defer func(timeout time.Duration) { searchTimeout = timeout }(searchTimeout)
+	searchTimeout = 100 * time.Millisecond
+	store.latency = 10 * time.Millisecond
+	// no retrievals are left running to read the timeout when it is restored
+	readerParams := *params
+	readerParams.ReadAhead = 0
+	reader := NewDPA(NewDpaChunkStore(store, pendingStore{store}), &readerParams)
+	reader.Start()
+	defer reader.Stop()
+
+	start := time.Now()
+	output, err := ioutil.ReadAll(reader.Retrieve(key))
+	if err != nil || !bytes.Equal(output, input) {
+		t.Fatalf("input and output mismatch: %v", err)
+	}
+	if time.Since(start) < searchTimeout {
+		t.Errorf("Expecting the missing chunks to be searched for %v first", searchTimeout)
+	}
+}
+
+func TestRedundancyNone(t *testing.T) {
+	params := NewChunkerParams()
+	params.Branches = 16
+	store := &latencyStore{chunks: make(mapChunkStore)}
+	dpa := NewDPA(store, params)
+	dpa.Start()
+	defer dpa.Stop()
+	data, input := testDataReaderAndSlice(50000)
+	wg := &sync.WaitGroup{}
+	key, err := dpa.Store(data, 50000, wg, nil)
+	if err != nil {
+		t.Fatalf("Store error: %v", err)
+	}
+	wg.Wait()
+
+	// the reader of a DPA with redundancy reads content stored without it
+	params = NewChunkerParams()
+	params.Branches = 16
+	params.Redundancy = 3
+	reader := NewDPA(store, params)
+	reader.Start()
+	defer reader.Stop()
+	output, err := ioutil.ReadAll(reader.Retrieve(key))
+	if err != nil || !bytes.Equal(output, input) {
+		t.Fatalf("input and output mismatch: %v", err)
+	}
+
+	delete(store.chunks, parityGroups(t, dpa, key)[0][0].String())
+	if _, err := ioutil.ReadAll(dpa.Retrieve(key)); err == nil {
+		t.Fatalf("Expecting an error with a chunk deleted")
+	}
+
+	if _, err := reader.Store(bytes.NewReader(input), -1, nil, nil); err != ErrRedundancyUnknownLength {
+		t.Fatalf("Expecting %v, got %v", ErrRedundancyUnknownLength, err)
+	}
+}
+
+func TestRedundancyParams(t *testing.T) {
+	for _, test := range []struct {
+		branches, redundancy int64
+		valid                bool
+		children             int64 // of the tree chunker
+	}{
+		{16, 0, true, 16},
+		{16, 7, true, 8},
+		{16, 8, false, 8},
+		{16, -1, false, 16},
+		{2, 1, false, 2},
+		{1024, 128, true, 128},
+		{1024, 129, false, 128},
+		{1024, 100, true, 156},
+	} {
+		params := NewChunkerParams()
+		params.Branches = test.branches
+		params.Redundancy = test.redundancy
+		if err := params.Validate(); (err == nil) != test.valid {
+			t.Errorf("%d branches, redundancy %d: expecting valid %v, got %v", test.branches, test.redundancy, test.valid, err)
+		}
+		chunker := NewTreeChunker(params)
+		if chunker.branches != test.children || chunker.branches+chunker.parities > maxShards {
+			t.Errorf("%d branches, redundancy %d: expecting %d children, got %d and %d parities", test.branches, test.redundancy, test.children, chunker.branches, chunker.parities)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/logger"
+	"github.com/ethereum/go-ethereum/logger/glog"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+var ErrScrubRunning = errors.New("scrub already running")
+
+// ScrubReport is the result of a scrub of the DbStore
+type ScrubReport struct {
+	Checked   uint64 // index entries checked
+	Corrupt   []Key  // data that does not hash to its key
+	Missing   []Key  // index entries without data
+	Orphaned  uint64 // data entries without index entry
+	Deleted   uint64 // corrupt, missing and orphaned entries deleted
+	Refetched []Key  // deleted chunks stored again from peers
+	Failed    []Key  // deleted chunks the peers did not deliver
+	Duration  time.Duration
+}
+
+// Scrubber checks that the chunks of the DbStore hash to their keys.  The
+// chunks found corrupt are deleted and retrieved again from the network on
+// request.
+type Scrubber struct {
+	db      *DbStore
+	store   ChunkStore // retrieves the deleted chunks, nil for a local swarm
+	lock    sync.Mutex
+	running bool
+}
+
+func NewScrubber(db *DbStore, store ChunkStore) *Scrubber {
+	return &Scrubber{db: db, store: store}
+}
+
+// Scrub checks every entry of the store.  If remove is true the corrupt,
+// missing and orphaned entries are deleted, and if refetch is true as well
+// the deleted chunks are retrieved again and stored.
+func (self *Scrubber) Scrub(remove, refetch bool) (*ScrubReport, error) {
+	self.lock.Lock()
+	if self.running {
+		self.lock.Unlock()
+		return nil, ErrScrubRunning
+	}
+	self.running = true
+	self.lock.Unlock()
+	defer func() {
+		self.lock.Lock()
+		self.running = false
+		self.lock.Unlock()
+	}()
+
+	start := time.Now()
+	report, orphans := self.db.scrub()
+	glog.V(logger.Info).Infof("DbStore scrub: %d chunks checked, %d corrupt, %d missing, %d orphaned", report.Checked, len(report.Corrupt), len(report.Missing), report.Orphaned)
+	if remove {
+		bad := append(append([]Key(nil), report.Corrupt...), report.Missing...)
+		deleted, err := self.db.deleteBroken(bad, orphans)
+		if err != nil {
+			return nil, err
+		}
+		report.Deleted = deleted
+		if refetch && self.store != nil {
+			report.Refetched, report.Failed = self.refetch(bad)
+		}
+	}
+	report.Duration = time.Since(start)
+	return report, nil
+}
+
+// refetch retrieves chunks through the network and stores them if they
+// hash to their keys
+func (self *Scrubber) refetch(keys []Key) (fetched, failed []Key) {
+	for _, key := range keys {
+		chunk, err := self.store.Get(key)
+		if err != nil || chunk.SData == nil || !self.db.valid(key, chunk.SData) {
+			glog.V(logger.Warn).Infof("DbStore scrub: %v could not be retrieved: %v", key.Log(), err)
+			failed = append(failed, key)
+			continue
+		}
+		self.db.Put(&Chunk{Key: key, SData: chunk.SData, Size: chunk.Size})
+		fetched = append(fetched, key)
+	}
+	return
+}
+
+// valid returns true if data hashes to key
+func (s *DbStore) valid(key Key, data []byte) bool {
+	hasher := s.hashfunc()
+	hasher.Write(data)
+	return bytes.Equal(hasher.Sum(nil), key)
+}
+
+// scrub checks the entries of a snapshot of the store without holding the
+// lock, it returns the data keys of the orphaned entries
+func (s *DbStore) scrub() (report *ScrubReport, orphans [][]byte) {
+	report = &ScrubReport{}
+	it := s.db.NewIterator()
+	defer it.Release()
+
+	indexed := make(map[uint64]bool)
+	for ok := it.Seek([]byte{kpIndex}); ok && it.Key()[0] == kpIndex; ok = it.Next() {
+		key := Key(append([]byte(nil), it.Key()[1:]...))
+		var index dpaDBIndex
+		decodeIndex(it.Value(), &index)
+		indexed[index.Idx] = true
+		report.Checked++
+
+		data, err := s.db.Get(getDataKey(index.Idx))
+		if err != nil {
+			report.Missing = append(report.Missing, key)
+			continue
+		}
+		if !s.valid(key, data) {
+			glog.V(logger.Warn).Infof("DbStore scrub: %v is corrupt", key.Log())
+			report.Corrupt = append(report.Corrupt, key)
+		}
+	}
+	for ok := it.Seek([]byte{kpData}); ok && it.Key()[0] == kpData; ok = it.Next() {
+		if !indexed[binary.BigEndian.Uint64(it.Key()[1:9])] {
+			orphans = append(orphans, append([]byte(nil), it.Key()...))
+		}
+	}
+	report.Orphaned = uint64(len(orphans))
+	return report, orphans
+}
+
+// deleteBroken deletes the index and data of chunks and orphaned data
+// entries.  A chunk stored again since the scrub is left alone.
+func (s *DbStore) deleteBroken(keys []Key, orphans [][]byte) (deleted uint64, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	batch := new(leveldb.Batch)
+	for _, key := range keys {
+		idata, err := s.db.Get(getIndexKey(key))
+		if err != nil {
+			continue
+		}
+		var index dpaDBIndex
+		decodeIndex(idata, &index)
+		if data, err := s.db.Get(getDataKey(index.Idx)); err == nil && s.valid(key, data) {
+			continue
+		}
+		batch.Delete(getIndexKey(key))
+		batch.Delete(getDataKey(index.Idx))
+		s.policy.Deleted(batch, &IndexEntry{Key: key, Idx: index.Idx, Access: index.Access})
+		s.entryCnt--
+		deleted++
+	}
+	// data indexes are never reused, the orphans stay orphans
+	for _, dkey := range orphans {
+		batch.Delete(dkey)
+		deleted++
+	}
+	batch.Put(keyEntryCnt, U64ToBytes(s.entryCnt))
+	if err = s.db.Write(batch); err != nil {
+		return 0, err
+	}
+	s.dataSize = s.countDataSize()
+	s.db.Put(keyDataSize, U64ToBytes(s.dataSize))
+	return deleted, nil
+}
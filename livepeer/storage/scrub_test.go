@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"testing"
+)
+
+func TestScrub(t *testing.T) {
+	s, cleanup := newTestDbStore(t, AccessPolicy, 100)
+	defer cleanup()
+	net := make(mapChunkStore)
+	for i := 0; i < 10; i++ {
+		chunk := newTestChunk()
+		s.Put(chunk)
+		net.Put(chunk)
+	}
+	var keys []Key
+	for _, chunk := range net {
+		keys = append(keys, chunk.Key)
+	}
+	corrupt, missing, lost := keys[0], keys[1], keys[2]
+	delete(net, lost.String())
+
+	index := func(key Key) dpaDBIndex {
+		var index dpaDBIndex
+		idata, _ := s.db.Get(getIndexKey(key))
+		decodeIndex(idata, &index)
+		return index
+	}
+	s.db.Put(getDataKey(index(corrupt).Idx), []byte("garbage"))
+	s.db.Delete(getDataKey(index(missing).Idx))
+	s.db.Put(getDataKey(index(lost).Idx), []byte("garbage"))
+	s.db.Put(getDataKey(1000), []byte("orphan"))
+
+	scrubber := NewScrubber(s, net)
+	report, err := scrubber.Scrub(false, false)
+	if err != nil {
+		t.Fatalf("Scrub error: %v", err)
+	}
+	if report.Checked != 10 || len(report.Corrupt) != 2 || len(report.Missing) != 1 || report.Orphaned != 1 || report.Deleted != 0 {
+		t.Fatalf("Expecting 10 checked, 2 corrupt, 1 missing and 1 orphaned, got %+v", report)
+	}
+
+	report, err = scrubber.Scrub(true, true)
+	if err != nil {
+		t.Fatalf("Scrub error: %v", err)
+	}
+	if report.Deleted != 4 || len(report.Refetched) != 2 || len(report.Failed) != 1 || report.Failed[0].String() != lost.String() {
+		t.Errorf("Expecting 4 deleted, 2 refetched and %v failed, got %+v", lost.Log(), report)
+	}
+	for _, key := range []Key{corrupt, missing} {
+		if _, err := s.Get(key); err != nil {
+			t.Errorf("Expecting %v to be repaired: %v", key.Log(), err)
+		}
+	}
+	if entries, _ := s.Size(); entries != 9 {
+		t.Errorf("Expecting 9 chunks, got %d", entries)
+	}
+	if size, _ := s.Bytes(); size != 9*testEntrySize {
+		t.Errorf("Expecting %d bytes, got %d", 9*testEntrySize, size)
+	}
+
+	report, _ = scrubber.Scrub(false, false)
+	if len(report.Corrupt)+len(report.Missing) != 0 || report.Orphaned != 0 {
+		t.Errorf("Expecting a clean store, got %+v", report)
+	}
+}
@@ -83,6 +83,8 @@ func MakeHashFunc(hash string) Hasher {
 		return crypto.SHA256.New
 	case "SHA3":
 		return sha3.NewKeccak256
+	case "BMT":
+		return NewBMTHasher
 	}
 	return nil
 }
@@ -108,9 +110,15 @@ func (key Key) MarshalJSON() (out []byte, err error) {
 
 func (key *Key) UnmarshalJSON(value []byte) error {
 	s := string(value)
-	*key = make([]byte, 32)
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return fmt.Errorf("invalid key %s", s)
+	}
 	h := common.Hex2Bytes(s[1 : len(s)-1])
-	copy(*key, h)
+	// a hash or a reference to encrypted content, the hash followed by its key
+	if len(h) != len(ZeroKey) && len(h) != len(ZeroKey)+encryptionKeySize {
+		return fmt.Errorf("invalid key %s: %d bytes", s, len(h))
+	}
+	*key = h
 	return nil
 }
 
@@ -188,6 +196,7 @@ type Splitter interface {
 	   wg is a Waitgroup (can be nil) that can be used to block until the local storage finishes
 	   The caller gets returned an error channel, if an error is encountered during splitting, it is fed to errC error channel.
 	   A closed error signals process completion at which point the key can be considered final if there were no errors.
+	   If the size is negative the data is split up to EOF, the tree is the same as if the size was given.
 	*/
 	Split(io.Reader, int64, chan *Chunk, *sync.WaitGroup, *sync.WaitGroup) (Key, error)
 }
@@ -208,9 +217,26 @@ type Joiner interface {
 	Join(key Key, chunkC chan *Chunk) LazySectionReader
 }
 
+type Walker interface {
+	/*
+	   Walk calls f with every chunk of the tree under a root key, parents
+	   before their children, and returns the size of the content.  Chunks are
+	   retrieved through chunkC like when joining, walking stops at the first
+	   missing chunk or error returned by f.
+	*/
+	Walk(key Key, chunkC chan *Chunk, quitC chan bool, f func(*Chunk) error) (int64, error)
+}
+
+// Appender grows content without splitting it again, only the chunks along
+// the right edge of the tree are retrieved on chunkC
+type Appender interface {
+	Append(root Key, data io.Reader, size int64, storeC, chunkC chan *Chunk, swg, wwg *sync.WaitGroup) (Key, error)
+}
+
 type Chunker interface {
 	Joiner
 	Splitter
+	Walker
 	// returns the key length
 	// KeySize() int64
 }
@@ -0,0 +1,236 @@
+package livepeer
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/swarm/network/kademlia"
+	"github.com/golang/glog"
+	"github.com/livepeer/livepeer-swarm/livepeer/network"
+	"github.com/livepeer/livepeer-swarm/livepeer/streaming"
+	"github.com/livepeer/livepeer-swarm/livepeer/transcoding"
+	lpmsStream "github.com/livepeer/lpms/stream"
+	"golang.org/x/net/context"
+)
+
+var ErrStreamNotFound = errors.New("stream not found")
+
+// Types of the notifications sent to StreamApi.Events subscribers
+const (
+	StreamStarted    = "streamStarted"
+	StreamEnded      = "streamEnded"
+	SubscriberJoined = "subscriberJoined"
+	SubscriberLeft   = "subscriberLeft"
+	TranscodeAck     = "transcodeAck"
+)
+
+// StreamInfo describes a stream of the node
+type StreamInfo struct {
+	StreamID    streaming.StreamID
+	Format      string // hls or rtmp
+	Origin      common.Hash
+	Local       bool // originates at this node
+	Subscribers []string
+	Transcoded  []network.TranscodedStream
+}
+
+// StreamMetrics are the metrics of a stream
+type StreamMetrics struct {
+	streaming.StreamMetrics
+	Uptime      string
+	Buffered    int64 // segments or packets in the stream buffer
+	Subscribers int
+	Transcoded  int
+}
+
+// StreamEvent is the notification sent to Events subscribers, Type is one of
+// the constants above
+type StreamEvent struct {
+	Type         string
+	StreamID     streaming.StreamID
+	Format       string                     `json:",omitempty"`
+	SubscriberID string                     `json:",omitempty"`
+	TranscodeID  *common.Hash               `json:",omitempty"`
+	Transcoded   []network.TranscodedStream `json:",omitempty"`
+}
+
+// StreamApi exposes the streams of the node over RPC
+type StreamApi struct {
+	swarm *Swarm
+}
+
+func NewStreamApi(swarm *Swarm) *StreamApi {
+	return &StreamApi{swarm}
+}
+
+// CreateStream creates an RTMP stream originating at this node, the stream is
+// published to the media server's RTMP endpoint under the returned ID
+func (self *StreamApi) CreateStream() (streaming.StreamID, error) {
+	strmID := self.swarm.NewStreamID()
+	if _, err := self.swarm.streamer.AddNewNetworkStream(strmID, lpmsStream.RTMP); err != nil {
+		return "", err
+	}
+	glog.Infof("Created Stream: %v", strmID)
+	return strmID, nil
+}
+
+// Streams lists the streams originated or relayed by this node
+func (self *StreamApi) Streams() []StreamInfo {
+	strms := self.swarm.streamer.GetAllNetworkStreams()
+	infos := make([]StreamInfo, 0, len(strms))
+	for _, strm := range strms {
+		infos = append(infos, self.info(strm))
+	}
+	return infos
+}
+
+// Stream describes a stream
+func (self *StreamApi) Stream(id streaming.StreamID) (*StreamInfo, error) {
+	strm := self.swarm.streamer.GetNetworkStream(id)
+	if strm == nil {
+		return nil, ErrStreamNotFound
+	}
+	info := self.info(strm)
+	return &info, nil
+}
+
+func (self *StreamApi) info(strm *lpmsStream.VideoStream) StreamInfo {
+	id := streaming.StreamID(strm.GetStreamID())
+	origin, _ := id.SplitComponents()
+	return StreamInfo{
+		StreamID:    id,
+		Format:      formatName(strm.Format),
+		Origin:      origin,
+		Local:       origin == self.swarm.streamer.SelfAddress,
+		Subscribers: self.swarm.streamer.Subscribers(id),
+		Transcoded:  self.swarm.streamDB.Transcoded(id),
+	}
+}
+
+// StopStream ends a stream: subscribers receive the end of the stream, the
+// transcode jobs of the stream are cancelled and a relayed stream is no
+// longer requested from upstream
+func (self *StreamApi) StopStream(id streaming.StreamID) error {
+	strm := self.swarm.streamer.GetNetworkStream(id)
+	if strm == nil {
+		return ErrStreamNotFound
+	}
+	if origin, _ := id.SplitComponents(); origin != self.swarm.streamer.SelfAddress {
+		self.swarm.cloud.StopStream(id.String(), kademlia.Address{}, strm.Format)
+	}
+	self.swarm.streamer.EndStream(id)
+	if self.swarm.scheduler != nil {
+		self.swarm.scheduler.CancelStream(id.String())
+	}
+	return nil
+}
+
+// Transcode asks the network to transcode an HLS stream into the comma
+// separated profiles, the transcoded streams are listed by Stream once a
+// transcoder acked
+func (self *StreamApi) Transcode(id streaming.StreamID, profiles string) (common.Hash, error) {
+	if self.swarm.streamer.GetNetworkStream(id) == nil {
+		return common.Hash{}, ErrStreamNotFound
+	}
+	ladder, err := transcoding.ParseLadder(profiles, self.swarm.profiles)
+	if err == nil && len(ladder) == 0 {
+		err = errors.New("no profiles requested")
+	}
+	if err != nil {
+		return common.Hash{}, err
+	}
+	transcodeID := streaming.RandomStreamID()
+	go self.swarm.cloud.Transcode(id.String(), transcodeID, "", ladder)
+	glog.Infof("Requested transcoding of %v into %v (%x)", id, profiles, transcodeID[:4])
+	return transcodeID, nil
+}
+
+// PlaybackToken signs a token that authorizes playing a stream of this node
+// for ttl seconds, optionally bound to a viewer ID.  Players pass it in the
+// token query parameter of the playlist or RTMP URL.
+func (self *StreamApi) PlaybackToken(id streaming.StreamID, ttl uint64, viewer string) (string, error) {
+	return self.swarm.PlaybackToken(id, time.Duration(ttl)*time.Second, viewer)
+}
+
+// StreamMetrics returns the traffic counters and the state of a stream
+func (self *StreamApi) StreamMetrics(id streaming.StreamID) (*StreamMetrics, error) {
+	strm := self.swarm.streamer.GetNetworkStream(id)
+	m, ok := self.swarm.streamer.Metrics(id)
+	if strm == nil || !ok {
+		return nil, ErrStreamNotFound
+	}
+	return &StreamMetrics{
+		StreamMetrics: m,
+		Uptime:        time.Since(m.Started).String(),
+		Buffered:      strm.Len(),
+		Subscribers:   len(self.swarm.streamer.Subscribers(id)),
+		Transcoded:    len(self.swarm.streamDB.Transcoded(id)),
+	}, nil
+}
+
+// Events notifies the subscriber when streams start or end, subscribers join
+// or leave and transcoders ack transcode requests of this node
+func (self *StreamApi) Events(ctx context.Context) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+	rpcSub := notifier.CreateSubscription()
+
+	go func() {
+		sub := self.swarm.events.Subscribe(
+			streaming.StreamStartedEvent{},
+			streaming.StreamEndedEvent{},
+			streaming.SubscriberJoinedEvent{},
+			streaming.SubscriberLeftEvent{},
+			network.TranscodeAckEvent{},
+		)
+		defer sub.Unsubscribe()
+
+		for {
+			select {
+			case ev, ok := <-sub.Chan():
+				if !ok {
+					return
+				}
+				if se := newStreamEvent(ev.Data); se != nil {
+					notifier.Notify(rpcSub.ID, se)
+				}
+			case <-rpcSub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+
+	return rpcSub, nil
+}
+
+// newStreamEvent returns the notification of an event, nil if the event is
+// not one of the subscribed types
+func newStreamEvent(data interface{}) *StreamEvent {
+	switch ev := data.(type) {
+	case streaming.StreamStartedEvent:
+		return &StreamEvent{Type: StreamStarted, StreamID: ev.StreamID, Format: formatName(ev.Format)}
+	case streaming.StreamEndedEvent:
+		return &StreamEvent{Type: StreamEnded, StreamID: ev.StreamID}
+	case streaming.SubscriberJoinedEvent:
+		return &StreamEvent{Type: SubscriberJoined, StreamID: ev.StreamID, SubscriberID: ev.SubscriberID}
+	case streaming.SubscriberLeftEvent:
+		return &StreamEvent{Type: SubscriberLeft, StreamID: ev.StreamID, SubscriberID: ev.SubscriberID}
+	case network.TranscodeAckEvent:
+		return &StreamEvent{Type: TranscodeAck, StreamID: ev.StreamID, TranscodeID: &ev.TranscodeID, Transcoded: ev.Streams}
+	}
+	glog.Errorf("Skipping unexpected stream event %T", data)
+	return nil
+}
+
+func formatName(format lpmsStream.VideoFormat) string {
+	if format == lpmsStream.HLS {
+		return "hls"
+	}
+	return "rtmp"
+}
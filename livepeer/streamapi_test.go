@@ -0,0 +1,42 @@
+package livepeer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nareix/joy4/av"
+)
+
+func TestStopStreamTrailer(t *testing.T) {
+	swarm, cleanup := newTestSwarm(t)
+	defer cleanup()
+	api := NewStreamApi(swarm)
+
+	id := swarm.NewStreamID()
+	src := chanDemuxer{C: make(chan av.Packet)}
+	errc := make(chan error)
+	go func() { errc <- swarm.PublishRTMP(context.Background(), id, src) }()
+	defer func() {
+		close(src.C)
+		<-errc
+	}()
+	src.C <- av.Packet{Data: []byte{0, 1}}
+	mux := newTrailerMuxer()
+	if err := swarm.streamer.SubscribeToRTMPStream(id.String(), "test", mux); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := api.StopStream(id); err != nil {
+		t.Fatalf("StopStream: %v", err)
+	}
+	expectTrailer(t, mux)
+	if err := api.StopStream(id); err != ErrStreamNotFound {
+		t.Errorf("expected %v stopping the stream again, got %v", ErrStreamNotFound, err)
+	}
+}
+
+func TestUnexpectedStreamEvent(t *testing.T) {
+	if ev := newStreamEvent(struct{}{}); ev != nil {
+		t.Errorf("expected no notification for an unexpected event, got %v", ev)
+	}
+}
@@ -0,0 +1,128 @@
+package streaming
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	lpmsStream "github.com/livepeer/lpms/stream"
+	"github.com/nareix/joy4/av"
+)
+
+var (
+	ErrNotOrigin     = errors.New("stream does not originate at this node")
+	ErrStreamExists  = errors.New("stream already exists")
+	ErrBroadcastDone = errors.New("broadcast finished")
+)
+
+// DefaultSegmentDuration is the duration in seconds reported for segments
+// published with Broadcast
+var DefaultSegmentDuration = 2.0
+
+// HLSBroadcaster publishes segments into a new HLS stream originating at this
+// node, from where they are served to local players and subscribed peers.
+// It implements Broadcaster and HLSMuxer, so the segments of another stream
+// can be piped into it.
+type HLSBroadcaster struct {
+	SegmentDuration float64 // duration reported for segments published with Broadcast
+
+	streamer *Streamer
+	strm     *lpmsStream.VideoStream
+	finished bool
+}
+
+var _ Broadcaster = (*HLSBroadcaster)(nil)
+
+// NewHLSBroadcaster creates the HLS stream strmID, its node ID component
+// needs to be the streamer's address
+func NewHLSBroadcaster(streamer *Streamer, strmID StreamID) (*HLSBroadcaster, error) {
+	if err := checkNewStream(streamer, strmID); err != nil {
+		return nil, err
+	}
+	strm, err := streamer.AddNewNetworkStream(strmID, lpmsStream.HLS)
+	if err != nil {
+		return nil, err
+	}
+	streamer.post(BroadcastStartedEvent{StreamID: strmID})
+	return &HLSBroadcaster{
+		SegmentDuration: DefaultSegmentDuration,
+		streamer:        streamer,
+		strm:            strm,
+	}, nil
+}
+
+func checkNewStream(streamer *Streamer, strmID StreamID) error {
+	if nodeID, id := strmID.SplitComponents(); id == "" || nodeID != streamer.SelfAddress {
+		return ErrNotOrigin
+	}
+	if streamer.GetNetworkStream(strmID) != nil {
+		return ErrStreamExists
+	}
+	return nil
+}
+
+// StreamID returns the ID of the published stream
+func (self *HLSBroadcaster) StreamID() StreamID {
+	return StreamID(self.strm.GetStreamID())
+}
+
+// Broadcast publishes a segment, it is named after the stream and seqNo
+func (self *HLSBroadcaster) Broadcast(seqNo uint64, data []byte) error {
+	return self.WriteSegment(seqNo, fmt.Sprintf("%v_%d.ts", self.strm.GetStreamID(), seqNo), self.SegmentDuration, data)
+}
+
+// WriteSegment publishes a segment with the given name and duration
+func (self *HLSBroadcaster) WriteSegment(seqNo uint64, name string, duration float64, data []byte) error {
+	if self.finished {
+		return ErrBroadcastDone
+	}
+	if err := self.strm.WriteHLSSegmentToStream(lpmsStream.HLSSegment{SeqNo: seqNo, Name: name, Duration: duration, Data: data}); err != nil {
+		return err
+	}
+	self.streamer.CountIn(self.StreamID(), len(data))
+	return nil
+}
+
+// Finish ends the stream, subscribers receive an EOF
+func (self *HLSBroadcaster) Finish() error {
+	if self.finished {
+		return ErrBroadcastDone
+	}
+	self.finished = true
+	self.streamer.EndHLSStream(self.strm.GetStreamID())
+	self.streamer.post(BroadcastEndedEvent{StreamID: self.StreamID()})
+	return nil
+}
+
+type nopDemuxCloser struct {
+	av.Demuxer
+}
+
+func (nopDemuxCloser) Close() error { return nil }
+
+// PublishRTMP creates the RTMP stream strmID and writes the header and
+// packets of src into it until src returns io.EOF or ctx is cancelled.
+// Subscribers receive the trailer in either case.  The node ID component of
+// strmID needs to be the streamer's address.
+func (self *Streamer) PublishRTMP(ctx context.Context, strmID StreamID, src av.Demuxer) error {
+	if err := checkNewStream(self, strmID); err != nil {
+		return err
+	}
+	strm, err := self.AddNewNetworkStream(strmID, lpmsStream.RTMP)
+	if err != nil {
+		return err
+	}
+	self.post(BroadcastStartedEvent{StreamID: strmID})
+	defer self.post(BroadcastEndedEvent{StreamID: strmID})
+	err = strm.WriteRTMPToStream(ctx, nopDemuxCloser{src})
+	if err == io.EOF {
+		// the trailer was written by the stream
+		return nil
+	}
+	strm.WriteRTMPTrailer()
+	if err == context.Canceled {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,72 @@
+package streaming
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	lpmsStream "github.com/livepeer/lpms/stream"
+)
+
+func TestHLSBroadcastSubscribe(t *testing.T) {
+	addr := RandomStreamID()
+	streamer, _ := NewStreamer(addr)
+	id := MakeStreamID(addr, fmt.Sprintf("%x", RandomStreamID()))
+
+	if _, err := NewHLSBroadcaster(streamer, MakeStreamID(RandomStreamID(), fmt.Sprintf("%x", RandomStreamID()))); err != ErrNotOrigin {
+		t.Errorf("Expecting ErrNotOrigin, got %v", err)
+	}
+	b, err := NewHLSBroadcaster(streamer, id)
+	if err != nil {
+		t.Fatalf("Got error creating broadcaster: %v", err)
+	}
+	if _, err := NewHLSBroadcaster(streamer, id); err != ErrStreamExists {
+		t.Errorf("Expecting ErrStreamExists, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	segs := make(chan uint64, 10)
+	sub := NewNetworkSubscriber(streamer, nil, id, lpmsStream.HLS)
+	if err := sub.Subscribe(ctx, func(seqNo uint64, data []byte) { segs <- seqNo }); err != nil {
+		t.Fatalf("Got error subscribing: %v", err)
+	}
+	if err := sub.Subscribe(ctx, func(seqNo uint64, data []byte) {}); err != ErrSubscribed {
+		t.Errorf("Expecting ErrSubscribed, got %v", err)
+	}
+
+	for i := uint64(1); i <= 3; i++ {
+		if err := b.Broadcast(i, []byte("data")); err != nil {
+			t.Errorf("Got error broadcasting: %v", err)
+		}
+	}
+	for i := uint64(1); i <= 3; i++ {
+		select {
+		case seqNo := <-segs:
+			if seqNo != i {
+				t.Errorf("Expecting segment %v, got %v", i, seqNo)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("Timed out waiting for segment %v", i)
+		}
+	}
+
+	cancel()
+	for i := 0; i < 100 && streamer.HasSubscribers(id.String()); i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if streamer.HasSubscribers(id.String()) {
+		t.Errorf("Expecting subscription to end with the context")
+	}
+
+	if err := b.Finish(); err != nil {
+		t.Errorf("Got error finishing broadcast: %v", err)
+	}
+	if streamer.GetNetworkStream(id) != nil {
+		t.Errorf("Expecting stream to be removed")
+	}
+	if err := b.Broadcast(4, []byte("data")); err != ErrBroadcastDone {
+		t.Errorf("Expecting ErrBroadcastDone, got %v", err)
+	}
+}
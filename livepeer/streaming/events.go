@@ -0,0 +1,89 @@
+package streaming
+
+import (
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/logger/glog"
+	lpmsStream "github.com/livepeer/lpms/stream"
+)
+
+// StreamStartedEvent is posted when a stream is added to the streamer, by a
+// local publisher or because a subscriber requested it from the network
+type StreamStartedEvent struct {
+	StreamID StreamID
+	Format   lpmsStream.VideoFormat
+}
+
+// StreamEndedEvent is posted when a stream is removed from the streamer
+type StreamEndedEvent struct {
+	StreamID StreamID
+}
+
+// SubscriberJoinedEvent is posted when a local player or a peer subscribes to
+// a stream
+type SubscriberJoinedEvent struct {
+	StreamID     StreamID
+	SubscriberID string
+}
+
+// SubscriberLeftEvent is posted when a subscription ends
+type SubscriberLeftEvent struct {
+	StreamID     StreamID
+	SubscriberID string
+}
+
+// BroadcastStartedEvent is posted when a publisher starts broadcasting a
+// stream into this node.  The HLS stream segmented from an RTMP broadcast is
+// set if there is one.
+type BroadcastStartedEvent struct {
+	StreamID    StreamID
+	HLSStreamID StreamID
+}
+
+// BroadcastEndedEvent is posted when the publisher of a stream is done
+type BroadcastEndedEvent struct {
+	StreamID StreamID
+}
+
+// EventQueueSize is the number of stream events buffered for the event mux.
+// TypeMux.Post blocks until all subscribers received an event, so events are
+// delivered in the background and dropped while the queue is full.
+var EventQueueSize = 256
+
+// SetEventMux sets the mux the stream events are posted to
+func (self *Streamer) SetEventMux(mux *event.TypeMux) {
+	self.eventsLock.Lock()
+	defer self.eventsLock.Unlock()
+	if self.events != nil {
+		close(self.events)
+	}
+	self.events = make(chan interface{}, EventQueueSize)
+	go deliverEvents(mux, self.events)
+}
+
+// PostEvent posts an event of another component about the streams of this
+// streamer, e.g. transcode acks received by the network
+func (self *Streamer) PostEvent(ev interface{}) {
+	self.post(ev)
+}
+
+// post queues an event for the mux, it never blocks
+func (self *Streamer) post(ev interface{}) {
+	self.eventsLock.Lock()
+	defer self.eventsLock.Unlock()
+	if self.events == nil {
+		return
+	}
+	select {
+	case self.events <- ev:
+	default:
+		glog.Warningf("Stream event queue full, dropping %T", ev)
+	}
+}
+
+func deliverEvents(mux *event.TypeMux, events chan interface{}) {
+	for ev := range events {
+		if err := mux.Post(ev); err == event.ErrMuxClosed {
+			return
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package streaming
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/event"
+	lpmsStream "github.com/livepeer/lpms/stream"
+)
+
+func TestStreamEvents(t *testing.T) {
+	addr := RandomStreamID()
+	streamer, _ := NewStreamer(addr)
+	mux := new(event.TypeMux)
+	defer mux.Stop()
+	streamer.SetEventMux(mux)
+
+	sub := mux.Subscribe(StreamStartedEvent{}, StreamEndedEvent{}, SubscriberJoinedEvent{}, SubscriberLeftEvent{})
+	events := make(chan interface{}, 10)
+	go func() {
+		for ev := range sub.Chan() {
+			events <- ev.Data
+		}
+	}()
+
+	id := MakeStreamID(addr, fmt.Sprintf("%x", RandomStreamID()))
+	b, err := NewHLSBroadcaster(streamer, id)
+	if err != nil {
+		t.Fatalf("Got error creating broadcaster: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s := NewNetworkSubscriber(streamer, nil, id, lpmsStream.HLS)
+	if err := s.Subscribe(ctx, func(seqNo uint64, data []byte) {}); err != nil {
+		t.Fatalf("Got error subscribing: %v", err)
+	}
+	s.Unsubscribe()
+	cancel()
+	b.Finish()
+
+	expected := []string{"StreamStartedEvent", "SubscriberJoinedEvent", "SubscriberLeftEvent", "StreamEndedEvent"}
+	for _, name := range expected {
+		select {
+		case ev := <-events:
+			if got := fmt.Sprintf("%T", ev); got != "streaming."+name {
+				t.Fatalf("Expecting %v, got %v", name, got)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("Timed out waiting for %v", name)
+		}
+	}
+	if m, ok := streamer.Metrics(id); ok {
+		t.Errorf("Expecting metrics to be removed with the stream, got %v", m)
+	}
+}
+
+func TestStreamMetrics(t *testing.T) {
+	addr := RandomStreamID()
+	streamer, _ := NewStreamer(addr)
+	id := MakeStreamID(addr, fmt.Sprintf("%x", RandomStreamID()))
+	b, err := NewHLSBroadcaster(streamer, id)
+	if err != nil {
+		t.Fatalf("Got error creating broadcaster: %v", err)
+	}
+	for i := uint64(1); i <= 3; i++ {
+		b.Broadcast(i, []byte("data"))
+	}
+	streamer.CountOut(id, 10)
+
+	m, ok := streamer.Metrics(id)
+	if !ok {
+		t.Fatalf("Expecting metrics for %v", id)
+	}
+	if m.ChunksIn != 3 || m.BytesIn != 12 || m.ChunksOut != 1 || m.BytesOut != 10 {
+		t.Errorf("Unexpected metrics %+v", m)
+	}
+}
+
+func TestStreamEventsDoNotBlock(t *testing.T) {
+	addr := RandomStreamID()
+	streamer, _ := NewStreamer(addr)
+	mux := new(event.TypeMux)
+	defer mux.Stop()
+	streamer.SetEventMux(mux)
+	// nobody reads from the subscription, so the first event blocks the mux
+	sub := mux.Subscribe(StreamStartedEvent{}, StreamEndedEvent{})
+	defer sub.Unsubscribe()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < EventQueueSize*2; i++ {
+			id := MakeStreamID(addr, fmt.Sprintf("%064x", i))
+			streamer.AddNewNetworkStream(id, lpmsStream.HLS)
+			streamer.DeleteNetworkStream(id)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatalf("Adding streams blocked on the event mux")
+	}
+	// the first events are still delivered once the subscriber reads
+	select {
+	case ev := <-sub.Chan():
+		if _, ok := ev.Data.(StreamStartedEvent); !ok {
+			t.Errorf("Expecting StreamStartedEvent, got %T", ev.Data)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatalf("Timed out waiting for the first event")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/gob"
 	"fmt"
 	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -56,15 +57,38 @@ type HlsSegment struct {
 	Name string
 }
 
+// HLSEOFWriter is implemented by HLS muxers that pass the end of a stream on,
+// e.g. to a subscribed peer
+type HLSEOFWriter interface {
+	WriteEOF() error
+}
+
 // The streamer brookers the video streams
 type Streamer struct {
 	// Streams        map[StreamID]*Stream
+	lock           sync.Mutex // guards networkStreams, subscribers, cancellation and muxers
 	networkStreams map[StreamID]*lpmsStream.VideoStream
 	subscribers    map[StreamID]*lpmsStream.StreamSubscriber
 	cancellation   map[StreamID]context.CancelFunc
+	muxers         map[StreamID]map[string]interface{} // av.Muxer or HLSMuxer by subscription ID
+	eventsLock     sync.Mutex
+	events         chan interface{} // events waiting for the event mux, nil if nobody listens
+	metricsLock    sync.Mutex
+	metrics        map[StreamID]*StreamMetrics
 	SelfAddress    common.Hash
 }
 
+// StreamMetrics counts the traffic of a stream since it was added to the
+// streamer
+type StreamMetrics struct {
+	Started   time.Time
+	ChunksIn  uint64 // segments or packets received from upstream or published locally
+	BytesIn   uint64
+	ChunksOut uint64 // segments or packets delivered to peers
+	BytesOut  uint64
+	LastIn    time.Time // zero until the first segment or packet is received
+}
+
 func NewStreamer(selfAddress common.Hash) (*Streamer, error) {
 	glog.Infof("Setting up new streamer with self address: %x", selfAddress[:])
 	s := &Streamer{
@@ -72,6 +96,8 @@ func NewStreamer(selfAddress common.Hash) (*Streamer, error) {
 		networkStreams: make(map[StreamID]*lpmsStream.VideoStream),
 		subscribers:    make(map[StreamID]*lpmsStream.StreamSubscriber),
 		cancellation:   make(map[StreamID]context.CancelFunc),
+		muxers:         make(map[StreamID]map[string]interface{}),
+		metrics:        make(map[StreamID]*StreamMetrics),
 		SelfAddress:    selfAddress,
 	}
 	return s, nil
@@ -79,7 +105,13 @@ func NewStreamer(selfAddress common.Hash) (*Streamer, error) {
 
 func (self *Streamer) GetRTMPBuffer(id string) (buf av.Demuxer) {
 	// q := self.rtmpBuffers[StreamID(id)]
-	mux := self.subscribers[StreamID(id)].GetRTMPBuffer(id)
+	self.lock.Lock()
+	sub := self.subscribers[StreamID(id)]
+	self.lock.Unlock()
+	if sub == nil {
+		return nil
+	}
+	mux := sub.GetRTMPBuffer(id)
 	q, ok := mux.(*pubsub.Queue)
 	if !ok {
 		return nil
@@ -93,11 +125,13 @@ func (self *Streamer) GetRTMPBuffer(id string) (buf av.Demuxer) {
 //Subscribes to a RTMP stream.  This function should be called in combination with forwarder.stream(), or another mechanism that will
 //populate the VideoStream associated with the id.
 func (self *Streamer) SubscribeToRTMPStream(strmID string, subID string, mux av.Muxer) (err error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
 	strm := self.networkStreams[StreamID(strmID)]
 	if strm == nil {
 		//Create VideoStream
 		strm = lpmsStream.NewVideoStream(strmID, lpmsStream.RTMP)
-		self.networkStreams[StreamID(strmID)] = strm
+		self.addStream(StreamID(strmID), strm)
 	}
 
 	sub := self.subscribers[StreamID(strmID)]
@@ -111,19 +145,22 @@ func (self *Streamer) SubscribeToRTMPStream(strmID string, subID string, mux av.
 	}
 
 	go sub.SubscribeRTMP(subID, mux)
+	self.addMuxer(StreamID(strmID), subID, mux)
 
 	return nil
 }
 
 func (self *Streamer) EndRTMPStream(strmID string) {
-	strm := self.networkStreams[StreamID(strmID)]
+	strm := self.GetNetworkStream(StreamID(strmID))
 	if strm != nil {
 		strm.WriteRTMPTrailer()
 	}
 }
 
 func (self *Streamer) GetHLSMuxer(strmID string, subID string) (mux lpmsStream.HLSMuxer) {
+	self.lock.Lock()
 	sub := self.subscribers[StreamID(strmID)]
+	self.lock.Unlock()
 	if sub != nil {
 		return sub.GetHLSMuxer(subID)
 	}
@@ -131,10 +168,12 @@ func (self *Streamer) GetHLSMuxer(strmID string, subID string) (mux lpmsStream.H
 }
 
 func (self *Streamer) SubscribeToHLSStream(strmID string, subID string, mux lpmsStream.HLSMuxer) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
 	strm := self.networkStreams[StreamID(strmID)]
 	if strm == nil {
 		strm = lpmsStream.NewVideoStream(strmID, lpmsStream.HLS)
-		self.networkStreams[StreamID(strmID)] = strm
+		self.addStream(StreamID(strmID), strm)
 	}
 
 	sub := self.subscribers[StreamID(strmID)]
@@ -146,13 +185,64 @@ func (self *Streamer) SubscribeToHLSStream(strmID string, subID string, mux lpms
 		self.cancellation[StreamID(strmID)] = cancel
 	}
 
-	return sub.SubscribeHLS(subID, mux)
+	if err := sub.SubscribeHLS(subID, mux); err != nil {
+		return err
+	}
+	self.addMuxer(StreamID(strmID), subID, mux)
+	return nil
+}
+
+// addMuxer, removeMuxer, addStream, removeStream and unsubscribeAll are
+// called with the lock held
+
+func (self *Streamer) addMuxer(strmID StreamID, subID string, mux interface{}) {
+	if self.muxers[strmID] == nil {
+		self.muxers[strmID] = make(map[string]interface{})
+	}
+	self.muxers[strmID][subID] = mux
+	self.post(SubscriberJoinedEvent{StreamID: strmID, SubscriberID: subID})
+}
+
+func (self *Streamer) removeMuxer(strmID StreamID, subID string) {
+	if _, ok := self.muxers[strmID][subID]; !ok {
+		return
+	}
+	delete(self.muxers[strmID], subID)
+	self.post(SubscriberLeftEvent{StreamID: strmID, SubscriberID: subID})
+}
+
+// addStream adds or replaces a stream, the metrics of a replaced stream are
+// kept
+func (self *Streamer) addStream(strmID StreamID, strm *lpmsStream.VideoStream) {
+	_, replaced := self.networkStreams[strmID]
+	self.networkStreams[strmID] = strm
+	if replaced {
+		return
+	}
+	self.metricsLock.Lock()
+	self.metrics[strmID] = &StreamMetrics{Started: time.Now()}
+	self.metricsLock.Unlock()
+	self.post(StreamStartedEvent{StreamID: strmID, Format: strm.Format})
+}
+
+func (self *Streamer) removeStream(strmID StreamID) {
+	if _, ok := self.networkStreams[strmID]; !ok {
+		return
+	}
+	delete(self.networkStreams, strmID)
+	self.metricsLock.Lock()
+	delete(self.metrics, strmID)
+	self.metricsLock.Unlock()
+	self.post(StreamEndedEvent{StreamID: strmID})
 }
 
 func (self *Streamer) UnsubscribeToHLSStream(strmID string, subID string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
 	sub := self.subscribers[StreamID(strmID)]
 	if sub != nil {
 		sub.UnsubscribeHLS(subID)
+		self.removeMuxer(StreamID(strmID), subID)
 	} else {
 		return
 	}
@@ -163,15 +253,18 @@ func (self *Streamer) UnsubscribeToHLSStream(strmID string, subID string) {
 		sid := StreamID(strmID)
 		nID, _ := sid.SplitComponents()
 		if self.SelfAddress != nID { //Only delete the networkStream if you are a relay node
-			delete(self.networkStreams, StreamID(strmID))
+			self.removeStream(StreamID(strmID))
 		}
 	}
 }
 
 func (self *Streamer) UnsubscribeToRTMPStream(strmID string, subID string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
 	sub := self.subscribers[StreamID(strmID)]
 	if sub != nil {
 		sub.UnsubscribeRTMP(subID)
+		self.removeMuxer(StreamID(strmID), subID)
 	} else {
 		return
 	}
@@ -179,22 +272,103 @@ func (self *Streamer) UnsubscribeToRTMPStream(strmID string, subID string) {
 	if !sub.HasSubscribers() {
 		self.cancellation[StreamID(strmID)]() //Call cancel on hls worker
 		delete(self.subscribers, StreamID(strmID))
-		delete(self.networkStreams, StreamID(strmID))
+		self.removeStream(StreamID(strmID))
 	}
 }
 
 func (self *Streamer) UnsubscribeAll(strmID string) {
-	sub := self.subscribers[StreamID(strmID)]
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.unsubscribeAll(StreamID(strmID))
+}
+
+func (self *Streamer) unsubscribeAll(strmID StreamID) {
+	sub := self.subscribers[strmID]
 	if sub != nil {
 		sub.UnsubscribeAll()
-		self.cancellation[StreamID(strmID)]()
-		delete(self.subscribers, StreamID(strmID))
-		delete(self.networkStreams, StreamID(strmID))
+		self.cancellation[strmID]()
+		delete(self.cancellation, strmID)
+		delete(self.subscribers, strmID)
+		self.removeStream(strmID)
+		for subID := range self.muxers[strmID] {
+			self.removeMuxer(strmID, subID)
+		}
+		delete(self.muxers, strmID)
+	}
+}
+
+// EndHLSStream passes the end of an HLS stream on to the subscribers that
+// support it and removes the stream
+func (self *Streamer) EndHLSStream(strmID string) {
+	for subID, mux := range self.streamMuxers(StreamID(strmID)) {
+		if w, ok := mux.(HLSEOFWriter); ok {
+			if err := w.WriteEOF(); err != nil {
+				glog.Errorf("Error sending EOF of %v to %v: %v", strmID, subID, err)
+			}
+		}
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.unsubscribeAll(StreamID(strmID))
+	self.removeStream(StreamID(strmID))
+}
+
+// EndStream ends a stream so its subscribers can finish what they recorded:
+// RTMP subscribers get the trailer, HLS subscribers an EOF.  The stream and
+// its subscriptions are removed.
+func (self *Streamer) EndStream(strmID StreamID) {
+	strm := self.GetNetworkStream(strmID)
+	if strm != nil && strm.Format == lpmsStream.HLS {
+		self.EndHLSStream(strmID.String())
+		return
+	}
+	for _, mux := range self.streamMuxers(strmID) {
+		if m, ok := mux.(av.Muxer); ok {
+			m.WriteTrailer()
+		}
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.unsubscribeAll(strmID)
+	self.removeStream(strmID)
+}
+
+// streamMuxers returns a copy of the muxers subscribed to a stream, so the end
+// of the stream can be written to them without holding the lock
+func (self *Streamer) streamMuxers(strmID StreamID) map[string]interface{} {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	muxers := make(map[string]interface{}, len(self.muxers[strmID]))
+	for subID, mux := range self.muxers[strmID] {
+		muxers[subID] = mux
+	}
+	return muxers
+}
+
+// Stop ends all streams when the node shuts down: RTMP subscribers get the
+// trailer, HLS subscribers an EOF, and all subscriber workers are cancelled
+func (self *Streamer) Stop() {
+	self.lock.Lock()
+	ids := make([]StreamID, 0, len(self.networkStreams))
+	for id := range self.networkStreams {
+		ids = append(ids, id)
+	}
+	self.lock.Unlock()
+	for _, id := range ids {
+		self.EndStream(id)
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for id, cancel := range self.cancellation {
+		cancel()
+		delete(self.cancellation, id)
 	}
 }
 
 func (self *Streamer) HasSubscribers(strmID string) bool {
+	self.lock.Lock()
 	sub := self.subscribers[StreamID(strmID)]
+	self.lock.Unlock()
 	if sub != nil {
 		return sub.HasSubscribers()
 	}
@@ -202,10 +376,14 @@ func (self *Streamer) HasSubscribers(strmID string) bool {
 }
 
 func (self *Streamer) GetNetworkStream(id StreamID) *lpmsStream.VideoStream {
+	self.lock.Lock()
+	defer self.lock.Unlock()
 	return self.networkStreams[id]
 }
 
 func (self *Streamer) GetAllNetworkStreams() []*lpmsStream.VideoStream {
+	self.lock.Lock()
+	defer self.lock.Unlock()
 	streams := make([]*lpmsStream.VideoStream, 0, len(self.networkStreams))
 	for _, s := range self.networkStreams {
 		streams = append(streams, s)
@@ -215,17 +393,67 @@ func (self *Streamer) GetAllNetworkStreams() []*lpmsStream.VideoStream {
 
 func (self *Streamer) AddNewNetworkStream(strmID StreamID, format lpmsStream.VideoFormat) (strm *lpmsStream.VideoStream, err error) {
 	strm = lpmsStream.NewVideoStream(strmID.String(), format)
-	self.networkStreams[strmID] = strm
+	self.lock.Lock()
+	self.addStream(strmID, strm)
+	self.lock.Unlock()
 
 	// glog.V(logger.Info).Infof("Adding new video stream with ID: %v", streamID)
 	return strm, nil
 }
 
 func (self *Streamer) DeleteNetworkStream(streamID StreamID) {
-	delete(self.networkStreams, streamID)
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.removeStream(streamID)
+}
+
+// Subscribers returns the IDs of the local and remote subscribers of a stream
+func (self *Streamer) Subscribers(strmID StreamID) []string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	subIDs := make([]string, 0, len(self.muxers[strmID]))
+	for subID := range self.muxers[strmID] {
+		subIDs = append(subIDs, subID)
+	}
+	return subIDs
+}
+
+// Metrics returns the traffic counters of a stream, false if there is no such
+// stream
+func (self *Streamer) Metrics(strmID StreamID) (StreamMetrics, bool) {
+	self.metricsLock.Lock()
+	defer self.metricsLock.Unlock()
+	m := self.metrics[strmID]
+	if m == nil {
+		return StreamMetrics{}, false
+	}
+	return *m, true
+}
+
+// CountIn accounts a segment or packet received for a stream
+func (self *Streamer) CountIn(strmID StreamID, size int) {
+	self.metricsLock.Lock()
+	defer self.metricsLock.Unlock()
+	if m := self.metrics[strmID]; m != nil {
+		m.ChunksIn++
+		m.BytesIn += uint64(size)
+		m.LastIn = time.Now()
+	}
+}
+
+// CountOut accounts a segment or packet delivered to a peer
+func (self *Streamer) CountOut(strmID StreamID, size int) {
+	self.metricsLock.Lock()
+	defer self.metricsLock.Unlock()
+	if m := self.metrics[strmID]; m != nil {
+		m.ChunksOut++
+		m.BytesOut += uint64(size)
+	}
 }
 
 func (self *Streamer) CurrentStatus() string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
 	var networkStreams []string
 	for k := range self.networkStreams {
 		networkStreams = append(networkStreams, k.String())
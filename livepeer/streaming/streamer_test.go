@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"sync"
 	"testing"
 	"time"
 
@@ -222,3 +223,76 @@ func TestUnsubscribeToHLS(t *testing.T) {
 		t.Errorf("Expecting 0 subscribers, got %v", subLen)
 	}
 }
+
+type eofMuxer struct {
+	eof bool
+}
+
+func (m *eofMuxer) WriteSegment(seqNo uint64, name string, duration float64, s []byte) error {
+	return nil
+}
+
+func (m *eofMuxer) WriteEOF() error {
+	m.eof = true
+	return nil
+}
+
+func TestStopStreamer(t *testing.T) {
+	addr := RandomStreamID()
+	streamer, _ := NewStreamer(addr)
+	hlsID := MakeStreamID(addr, RandomStreamID().Str())
+	rtmpID := MakeStreamID(addr, RandomStreamID().Str())
+
+	hlsMux := &eofMuxer{}
+	if err := streamer.SubscribeToHLSStream(hlsID.String(), "peer", hlsMux); err != nil {
+		t.Errorf("Got error %v subscribing to stream", err)
+	}
+	rtmpMux := &TestQueue{c: &Counter{}}
+	if err := streamer.SubscribeToRTMPStream(rtmpID.String(), "peer", rtmpMux); err != nil {
+		t.Errorf("Got error %v subscribing to stream", err)
+	}
+
+	streamer.Stop()
+
+	if !hlsMux.eof {
+		t.Errorf("Expecting HLS subscriber to get EOF")
+	}
+	if !rtmpMux.wroteTrailer {
+		t.Errorf("Expecting RTMP subscriber to get the trailer")
+	}
+	if len(streamer.networkStreams) != 0 || len(streamer.subscribers) != 0 || len(streamer.cancellation) != 0 {
+		t.Errorf("Expecting no streams left, got %v", streamer.CurrentStatus())
+	}
+}
+
+// TestConcurrentStreams adds, plays and ends streams from several goroutines,
+// run it with -race
+func TestConcurrentStreams(t *testing.T) {
+	addr := RandomStreamID()
+	streamer, _ := NewStreamer(addr)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			id := MakeStreamID(addr, fmt.Sprintf("%064x", i))
+			streamer.AddNewNetworkStream(id, lpmsStream.HLS)
+			if err := streamer.SubscribeToHLSStream(id.String(), "peer", &eofMuxer{}); err != nil {
+				t.Errorf("Got error %v subscribing to stream", err)
+			}
+			streamer.Subscribers(id)
+			streamer.UnsubscribeToHLSStream(id.String(), "peer")
+			streamer.EndStream(id)
+		}(i)
+		go func() {
+			defer wg.Done()
+			streamer.GetAllNetworkStreams()
+			streamer.CurrentStatus()
+		}()
+	}
+	wg.Wait()
+	if n := len(streamer.GetAllNetworkStreams()); n != 0 {
+		t.Errorf("Expecting no streams left, got %v", n)
+	}
+}
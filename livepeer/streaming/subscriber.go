@@ -0,0 +1,137 @@
+package streaming
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/swarm/network/kademlia"
+	"github.com/livepeer/livepeer-swarm/livepeer/storage"
+	lpmsStream "github.com/livepeer/lpms/stream"
+	"github.com/nareix/joy4/av"
+)
+
+var ErrSubscribed = errors.New("already subscribed")
+
+// segmentFunc adapts the callback of Subscriber.Subscribe to an HLSMuxer
+type segmentFunc func(seqNo uint64, data []byte)
+
+func (self segmentFunc) WriteSegment(seqNo uint64, name string, duration float64, data []byte) error {
+	self(seqNo, data)
+	return nil
+}
+
+/*
+NetworkSubscriber subscribes to a stream of the streamer.  A stream that is
+neither originated nor relayed by this node is requested from the network
+through the forwarder, and the request is stopped once the last local
+subscriber left.
+
+It implements Subscriber for HLS streams; a subscriber is used for one
+subscription at a time.
+*/
+type NetworkSubscriber struct {
+	streamer  *Streamer
+	forwarder storage.CloudStore // nil to only subscribe to local streams
+	strmID    StreamID
+	format    lpmsStream.VideoFormat
+
+	lock   sync.Mutex
+	subID  string
+	cancel context.CancelFunc
+}
+
+var _ Subscriber = (*NetworkSubscriber)(nil)
+
+func NewNetworkSubscriber(streamer *Streamer, forwarder storage.CloudStore, strmID StreamID, format lpmsStream.VideoFormat) *NetworkSubscriber {
+	return &NetworkSubscriber{
+		streamer:  streamer,
+		forwarder: forwarder,
+		strmID:    strmID,
+		format:    format,
+	}
+}
+
+// Subscribe calls f with every segment of the HLS stream until ctx is
+// cancelled or Unsubscribe is called
+func (self *NetworkSubscriber) Subscribe(ctx context.Context, f func(seqNo uint64, data []byte)) error {
+	return self.SubscribeHLS(ctx, segmentFunc(f))
+}
+
+// SubscribeHLS writes the segments of the HLS stream to mux until ctx is
+// cancelled or Unsubscribe is called
+func (self *NetworkSubscriber) SubscribeHLS(ctx context.Context, mux lpmsStream.HLSMuxer) error {
+	if self.format != lpmsStream.HLS {
+		return lpmsStream.ErrWrongFormat
+	}
+	return self.subscribe(ctx, func(subID string) error {
+		return self.streamer.SubscribeToHLSStream(self.strmID.String(), subID, mux)
+	})
+}
+
+// SubscribeRTMP writes the header and packets of the RTMP stream to mux until
+// ctx is cancelled or Unsubscribe is called
+func (self *NetworkSubscriber) SubscribeRTMP(ctx context.Context, mux av.Muxer) error {
+	if self.format != lpmsStream.RTMP {
+		return lpmsStream.ErrWrongFormat
+	}
+	return self.subscribe(ctx, func(subID string) error {
+		return self.streamer.SubscribeToRTMPStream(self.strmID.String(), subID, mux)
+	})
+}
+
+func (self *NetworkSubscriber) subscribe(ctx context.Context, subscribe func(subID string) error) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.cancel != nil {
+		return ErrSubscribed
+	}
+
+	if self.streamer.GetNetworkStream(self.strmID) == nil && !self.isOrigin() && self.forwarder != nil {
+		self.forwarder.Stream(self.strmID.String(), kademlia.Address{}, self.format)
+	}
+	subID := fmt.Sprintf("sdk%x", RandomStreamID().Bytes()[:8])
+	if err := subscribe(subID); err != nil {
+		self.release()
+		return err
+	}
+
+	self.subID = subID
+	ctx, self.cancel = context.WithCancel(ctx)
+	go func() {
+		<-ctx.Done()
+		self.Unsubscribe()
+	}()
+	return nil
+}
+
+// Unsubscribe ends the subscription
+func (self *NetworkSubscriber) Unsubscribe() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.cancel == nil {
+		return nil
+	}
+	self.cancel()
+	self.cancel = nil
+	if self.format == lpmsStream.HLS {
+		self.streamer.UnsubscribeToHLSStream(self.strmID.String(), self.subID)
+	} else {
+		self.streamer.UnsubscribeToRTMPStream(self.strmID.String(), self.subID)
+	}
+	self.release()
+	return nil
+}
+
+func (self *NetworkSubscriber) isOrigin() bool {
+	nodeID, _ := self.strmID.SplitComponents()
+	return nodeID == self.streamer.SelfAddress
+}
+
+// release stops the network request if no local subscribers are left
+func (self *NetworkSubscriber) release() {
+	if !self.isOrigin() && self.forwarder != nil && !self.streamer.HasSubscribers(self.strmID.String()) {
+		self.forwarder.StopStream(self.strmID.String(), kademlia.Address{}, self.format)
+	}
+}
@@ -0,0 +1,108 @@
+package streaming
+
+import (
+	"crypto/ecdsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var (
+	ErrNoToken       = errors.New("playback token required")
+	ErrInvalidToken  = errors.New("invalid playback token")
+	ErrTokenExpired  = errors.New("playback token expired")
+	ErrTokenStream   = errors.New("playback token is for another stream")
+	ErrTokenViewer   = errors.New("playback token is for another viewer")
+	ErrTokenNotOwner = errors.New("playback token is not signed by the stream owner")
+)
+
+// TokenParam is the query parameter of playlist, segment and RTMP URLs that
+// carries the playback token, ViewerParam the optional viewer ID
+const (
+	TokenParam  = "token"
+	ViewerParam = "viewer"
+)
+
+/*
+PlaybackToken authorizes playing a stream until it expires.  It is signed by
+the node the stream originates from, so any node relaying the stream can
+check it without contacting the owner: the bzz address of the signing key is
+the node ID component of the stream ID.
+
+A token with a viewer ID is only valid for requests carrying the same
+viewer query parameter.
+
+The string form is the base64url encoded JSON token and the base64url
+encoded signature of its hash, separated by a dot.
+*/
+type PlaybackToken struct {
+	StreamID StreamID
+	Expiry   int64  // unix time
+	Viewer   string `json:",omitempty"`
+}
+
+func NewPlaybackToken(strmID StreamID, ttl time.Duration, viewer string) *PlaybackToken {
+	return &PlaybackToken{
+		StreamID: strmID,
+		Expiry:   time.Now().Add(ttl).Unix(),
+		Viewer:   viewer,
+	}
+}
+
+// Sign returns the string form of the token signed with the private key of
+// the stream owner
+func (self *PlaybackToken) Sign(prvKey *ecdsa.PrivateKey) (string, error) {
+	data, err := json.Marshal(self)
+	if err != nil {
+		return "", err
+	}
+	sig, err := crypto.Sign(crypto.Keccak256(data), prvKey)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data) + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// ParsePlaybackToken decodes a token and checks that it is signed by the
+// owner of its stream, it does not check the expiry
+func ParsePlaybackToken(s string) (*PlaybackToken, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) != 2 {
+		return nil, ErrInvalidToken
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var token PlaybackToken
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, ErrInvalidToken
+	}
+	pub, err := crypto.Ecrecover(crypto.Keccak256(data), sig)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	if owner, _ := token.StreamID.SplitComponents(); crypto.Sha3Hash(pub) != owner {
+		return nil, ErrTokenNotOwner
+	}
+	return &token, nil
+}
+
+// Check checks that the token is valid now for the viewer
+func (self *PlaybackToken) Check(viewer string) error {
+	if time.Now().Unix() > self.Expiry {
+		return ErrTokenExpired
+	}
+	if self.Viewer != "" && self.Viewer != viewer {
+		return ErrTokenViewer
+	}
+	return nil
+}
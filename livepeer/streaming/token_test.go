@@ -0,0 +1,47 @@
+package streaming
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestPlaybackToken(t *testing.T) {
+	prvKey, _ := crypto.GenerateKey()
+	owner := crypto.Sha3Hash(crypto.FromECDSAPub(&prvKey.PublicKey))
+	id := MakeStreamID(owner, fmt.Sprintf("%x", RandomStreamID()))
+
+	s, err := NewPlaybackToken(id, time.Minute, "alice").Sign(prvKey)
+	if err != nil {
+		t.Fatalf("Got error signing token: %v", err)
+	}
+	token, err := ParsePlaybackToken(s)
+	if err != nil {
+		t.Fatalf("Got error parsing token: %v", err)
+	}
+	if token.StreamID != id {
+		t.Errorf("Expecting token for %v, got %v", id, token.StreamID)
+	}
+	if err := token.Check("alice"); err != nil {
+		t.Errorf("Expecting valid token, got %v", err)
+	}
+	if err := token.Check("bob"); err != ErrTokenViewer {
+		t.Errorf("Expecting ErrTokenViewer, got %v", err)
+	}
+
+	expired, _ := NewPlaybackToken(id, -time.Minute, "").Sign(prvKey)
+	if token, err := ParsePlaybackToken(expired); err != nil || token.Check("") != ErrTokenExpired {
+		t.Errorf("Expecting expired token, got %v", err)
+	}
+
+	otherKey, _ := crypto.GenerateKey()
+	forged, _ := NewPlaybackToken(id, time.Minute, "").Sign(otherKey)
+	if _, err := ParsePlaybackToken(forged); err != ErrTokenNotOwner {
+		t.Errorf("Expecting ErrTokenNotOwner, got %v", err)
+	}
+	if _, err := ParsePlaybackToken(s[:len(s)-4]); err == nil {
+		t.Errorf("Expecting error for a truncated token")
+	}
+}
@@ -0,0 +1,73 @@
+package livepeer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/livepeer/livepeer-swarm/livepeer/streaming"
+	lpmsStream "github.com/livepeer/lpms/stream"
+	"github.com/nareix/joy4/av"
+)
+
+// In-process publishing and subscribing, without going through the RTMP
+// endpoint of the media server.
+
+// NewStreamID returns a new random ID for a stream originating at this node
+func (self *Swarm) NewStreamID() streaming.StreamID {
+	return streaming.MakeStreamID(self.streamer.SelfAddress, fmt.Sprintf("%x", streaming.RandomStreamID()))
+}
+
+// NewBroadcaster creates the HLS stream strmID, its segments are published
+// with Broadcast or WriteSegment until Finish is called
+func (self *Swarm) NewBroadcaster(strmID streaming.StreamID) (*streaming.HLSBroadcaster, error) {
+	b, err := streaming.NewHLSBroadcaster(self.streamer, strmID)
+	if err != nil {
+		return nil, err
+	}
+	self.viz.LogBroadcast(strmID.String())
+	return b, nil
+}
+
+// PublishRTMP creates the RTMP stream strmID and publishes src until it
+// returns io.EOF or ctx is cancelled, then the subscribers get the trailer and
+// the stream is removed
+func (self *Swarm) PublishRTMP(ctx context.Context, strmID streaming.StreamID, src av.Demuxer) error {
+	self.viz.LogBroadcast(strmID.String())
+	err := self.streamer.PublishRTMP(ctx, strmID, src)
+	if err == streaming.ErrNotOrigin || err == streaming.ErrStreamExists {
+		return err
+	}
+	self.streamer.EndStream(strmID)
+	return err
+}
+
+// PlaybackToken returns a signed token that authorizes playing the stream
+// strmID, and the streams transcoded from it, until ttl passed.  The token
+// is bound to the viewer if it is not empty.  Only tokens of streams
+// originating at this node can be signed.
+func (self *Swarm) PlaybackToken(strmID streaming.StreamID, ttl time.Duration, viewer string) (string, error) {
+	if nodeID, _ := strmID.SplitComponents(); nodeID != self.streamer.SelfAddress {
+		return "", streaming.ErrNotOrigin
+	}
+	return streaming.NewPlaybackToken(strmID, ttl, viewer).Sign(self.privateKey)
+}
+
+// NewSubscriber returns a subscriber to the HLS stream strmID, streams of
+// other nodes are requested from the network
+func (self *Swarm) NewSubscriber(strmID streaming.StreamID) *streaming.NetworkSubscriber {
+	return streaming.NewNetworkSubscriber(self.streamer, self.cloud, strmID, lpmsStream.HLS)
+}
+
+// SubscribeHLS writes the segments of the stream to mux until ctx is
+// cancelled
+func (self *Swarm) SubscribeHLS(ctx context.Context, strmID streaming.StreamID, mux lpmsStream.HLSMuxer) error {
+	self.viz.LogConsume(strmID.String())
+	return self.NewSubscriber(strmID).SubscribeHLS(ctx, mux)
+}
+
+// SubscribeRTMP writes the RTMP stream to mux until ctx is cancelled
+func (self *Swarm) SubscribeRTMP(ctx context.Context, strmID streaming.StreamID, mux av.Muxer) error {
+	self.viz.LogConsume(strmID.String())
+	return streaming.NewNetworkSubscriber(self.streamer, self.cloud, strmID, lpmsStream.RTMP).SubscribeRTMP(ctx, mux)
+}
@@ -0,0 +1,92 @@
+package livepeer
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nareix/joy4/av"
+)
+
+func newTestSwarm(t *testing.T) (*Swarm, func()) {
+	dir, err := ioutil.TempDir("", "livepeer-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	swarm, err := NewLocalSwarm(dir, "")
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return swarm, func() { os.RemoveAll(dir) }
+}
+
+// chanDemuxer publishes the packets sent on C until C is closed
+type chanDemuxer struct {
+	C chan av.Packet
+}
+
+func (self chanDemuxer) Streams() ([]av.CodecData, error) { return nil, nil }
+
+func (self chanDemuxer) ReadPacket() (av.Packet, error) {
+	pkt, ok := <-self.C
+	if !ok {
+		return av.Packet{}, io.EOF
+	}
+	return pkt, nil
+}
+
+// trailerMuxer closes done when it gets the trailer
+type trailerMuxer struct {
+	done chan bool
+	once sync.Once
+}
+
+func newTrailerMuxer() *trailerMuxer {
+	return &trailerMuxer{done: make(chan bool)}
+}
+
+func (self *trailerMuxer) WriteHeader([]av.CodecData) error { return nil }
+func (self *trailerMuxer) WritePacket(av.Packet) error      { return nil }
+func (self *trailerMuxer) WriteTrailer() error {
+	self.once.Do(func() { close(self.done) })
+	return nil
+}
+
+func expectTrailer(t *testing.T, mux *trailerMuxer) {
+	select {
+	case <-mux.done:
+	case <-time.After(time.Second):
+		t.Fatalf("subscriber did not get the trailer")
+	}
+}
+
+func TestPublishRTMPTrailer(t *testing.T) {
+	swarm, cleanup := newTestSwarm(t)
+	defer cleanup()
+
+	id := swarm.NewStreamID()
+	src := chanDemuxer{C: make(chan av.Packet)}
+	errc := make(chan error)
+	go func() { errc <- swarm.PublishRTMP(context.Background(), id, src) }()
+	// the stream exists once the first packet is read
+	src.C <- av.Packet{Data: []byte{0, 1}}
+	mux := newTrailerMuxer()
+	if err := swarm.streamer.SubscribeToRTMPStream(id.String(), "test", mux); err != nil {
+		t.Fatal(err)
+	}
+	src.C <- av.Packet{Data: []byte{2, 3}}
+	close(src.C)
+
+	if err := <-errc; err != nil {
+		t.Fatalf("PublishRTMP: %v", err)
+	}
+	expectTrailer(t, mux)
+	if swarm.streamer.GetNetworkStream(id) != nil {
+		t.Errorf("expected the stream to be removed")
+	}
+}
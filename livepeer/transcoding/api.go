@@ -0,0 +1,20 @@
+package transcoding
+
+// Api exposes the scheduler over RPC
+type Api struct {
+	scheduler *Scheduler
+}
+
+func NewApi(scheduler *Scheduler) *Api {
+	return &Api{scheduler}
+}
+
+// Jobs lists running jobs followed by queued jobs in the order they will run
+func (self *Api) Jobs() []JobInfo {
+	return self.scheduler.Jobs()
+}
+
+// CancelJob cancels a running or queued job, returns false if there is no such job
+func (self *Api) CancelJob(id string) bool {
+	return self.scheduler.Cancel(id)
+}
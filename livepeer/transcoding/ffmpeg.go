@@ -0,0 +1,72 @@
+package transcoding
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+
+	"github.com/ethereum/go-ethereum/logger"
+	"github.com/ethereum/go-ethereum/logger/glog"
+)
+
+// FFMpegTranscoder transcodes segments into a profile by calling the ffmpeg
+// binary, one process per segment
+type FFMpegTranscoder struct {
+	profile    Profile
+	ffmpegPath string
+	workDir    string
+}
+
+// NewFFMpegTranscoder returns a segment transcoder calling the ffmpeg binary in ffmpegPath
+func NewFFMpegTranscoder(profile Profile, ffmpegPath, workDir string) *FFMpegTranscoder {
+	return &FFMpegTranscoder{
+		profile:    profile,
+		ffmpegPath: ffmpegPath,
+		workDir:    workDir,
+	}
+}
+
+// Transcode runs ffmpeg on the segment, the process is killed when ctx is cancelled
+func (self *FFMpegTranscoder) Transcode(ctx context.Context, data []byte) ([]byte, error) {
+	if err := os.MkdirAll(self.workDir, 0700); err != nil {
+		return nil, err
+	}
+	name := make([]byte, 10)
+	rand.Read(name)
+	in := path.Join(self.workDir, fmt.Sprintf("%x.ts", name))
+	out := path.Join(self.workDir, fmt.Sprintf("out%x.ts", name))
+	defer os.Remove(in)
+	defer os.Remove(out)
+
+	if err := ioutil.WriteFile(in, data, 0644); err != nil {
+		return nil, err
+	}
+	args := append(append([]string{"-i", in}, self.args()...), out)
+	glog.V(logger.Detail).Infof("Running ffmpeg %v", args)
+	cmd := exec.CommandContext(ctx, path.Join(self.ffmpegPath, "ffmpeg"), args...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("ffmpeg failed: %v: %s", err, output)
+	}
+	return ioutil.ReadFile(out)
+}
+
+// args returns the ffmpeg output options for the profile
+func (self *FFMpegTranscoder) args() []string {
+	p := self.profile
+	args := []string{
+		"-c:v", encoders[p.Codec],
+		"-s", p.Resolution,
+		"-r", fmt.Sprintf("%d", p.Framerate),
+		"-minrate", p.Bitrate,
+		"-maxrate", p.Bitrate,
+		"-bufsize", p.Bitrate,
+	}
+	if p.GOP > 0 {
+		args = append(args, "-g", fmt.Sprintf("%d", p.GOP), "-keyint_min", fmt.Sprintf("%d", p.GOP))
+	}
+	return append(args, "-mpegts_copyts", "1", "-threads", "1")
+}
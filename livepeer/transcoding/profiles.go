@@ -0,0 +1,128 @@
+package transcoding
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ProfilesParam is the name of the HTTP and RTMP query parameter holding a
+// comma separated list of profile names
+const ProfilesParam = "profiles"
+
+var (
+	profileNameRe = regexp.MustCompile(`^[[:alnum:]_]+$`)
+	resolutionRe  = regexp.MustCompile(`^[1-9]\d*x[1-9]\d*$`)
+	bitrateRe     = regexp.MustCompile(`^[1-9]\d*[kKmM]?$`)
+
+	// ffmpeg encoders for the supported output codecs
+	encoders = map[string]string{
+		"H264": "libx264",
+		"H265": "libx265",
+	}
+)
+
+// Profile describes one rendition of a transcoded stream.  A list of
+// profiles (a bitrate ladder) is sent to the transcoder with the request.
+type Profile struct {
+	Name       string
+	Resolution string // WIDTHxHEIGHT, e.g. 1280x720
+	Framerate  uint
+	Bitrate    string // ffmpeg bitrate, e.g. 4000k
+	Codec      string // output video codec, e.g. H264
+	GOP        uint   // keyframe interval in frames, 0 leaves it to the encoder
+}
+
+// DefaultProfiles are always available, profiles in the config with the same
+// name override them
+var DefaultProfiles = []Profile{
+	{Name: "P720p30fps4Mbps", Resolution: "1280x720", Framerate: 30, Bitrate: "4000k", Codec: "H264", GOP: 60},
+	{Name: "P720p30fps2Mbps", Resolution: "1280x720", Framerate: 30, Bitrate: "2000k", Codec: "H264", GOP: 60},
+	{Name: "P480p30fps1Mbps", Resolution: "854x480", Framerate: 30, Bitrate: "1000k", Codec: "H264", GOP: 60},
+	{Name: "P360p30fps700Kbps", Resolution: "640x360", Framerate: 30, Bitrate: "700k", Codec: "H264", GOP: 60},
+	{Name: "P240p30fps400Kbps", Resolution: "426x240", Framerate: 30, Bitrate: "400k", Codec: "H264", GOP: 60},
+}
+
+func (self Profile) String() string {
+	return fmt.Sprintf("%v (%v %dfps %v %v gop %d)", self.Name, self.Resolution, self.Framerate, self.Bitrate, self.Codec, self.GOP)
+}
+
+// Validate checks the profile settings before they are handed to ffmpeg
+func (self Profile) Validate() error {
+	if !profileNameRe.MatchString(self.Name) {
+		return fmt.Errorf("invalid profile name '%v'", self.Name)
+	}
+	if !resolutionRe.MatchString(self.Resolution) {
+		return fmt.Errorf("profile %v: invalid resolution '%v', expected WIDTHxHEIGHT", self.Name, self.Resolution)
+	}
+	if self.Framerate == 0 || self.Framerate > 120 {
+		return fmt.Errorf("profile %v: invalid framerate %d", self.Name, self.Framerate)
+	}
+	if !bitrateRe.MatchString(self.Bitrate) {
+		return fmt.Errorf("profile %v: invalid bitrate '%v'", self.Name, self.Bitrate)
+	}
+	if _, ok := encoders[self.Codec]; !ok {
+		return fmt.Errorf("profile %v: unsupported codec '%v'", self.Name, self.Codec)
+	}
+	return nil
+}
+
+// NewProfiles validates the given profiles and returns them together with
+// the defaults, indexed by name
+func NewProfiles(profiles []Profile) (map[string]Profile, error) {
+	result := make(map[string]Profile)
+	for _, p := range DefaultProfiles {
+		result[p.Name] = p
+	}
+	seen := make(map[string]bool)
+	for _, p := range profiles {
+		if err := p.Validate(); err != nil {
+			return nil, err
+		}
+		if seen[p.Name] {
+			return nil, fmt.Errorf("duplicate profile %v", p.Name)
+		}
+		seen[p.Name] = true
+		result[p.Name] = p
+	}
+	return result, nil
+}
+
+// ParseLadder looks up the comma separated profile names in profiles.
+// An empty string gives an empty ladder.
+func ParseLadder(names string, profiles map[string]Profile) (ladder []Profile, err error) {
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		p, ok := profiles[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown profile %v", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate profile %v", name)
+		}
+		seen[name] = true
+		ladder = append(ladder, p)
+	}
+	return
+}
+
+// SortedProfiles lists profiles by name
+func SortedProfiles(profiles map[string]Profile) []Profile {
+	list := make([]Profile, 0, len(profiles))
+	for _, p := range profiles {
+		list = append(list, p)
+	}
+	sort.Sort(byName(list))
+	return list
+}
+
+type byName []Profile
+
+func (self byName) Len() int           { return len(self) }
+func (self byName) Less(i, j int) bool { return self[i].Name < self[j].Name }
+func (self byName) Swap(i, j int)      { self[i], self[j] = self[j], self[i] }
@@ -0,0 +1,89 @@
+package transcoding
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProfileValidate(t *testing.T) {
+	for _, p := range DefaultProfiles {
+		if err := p.Validate(); err != nil {
+			t.Errorf("expected default profile %v to be valid, got %v", p.Name, err)
+		}
+	}
+
+	valid := Profile{Name: "P1080p", Resolution: "1920x1080", Framerate: 60, Bitrate: "6M", Codec: "H265"}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("expected %v to be valid, got %v", valid, err)
+	}
+	invalid := []Profile{
+		{Name: "bad,name", Resolution: "1920x1080", Framerate: 60, Bitrate: "6M", Codec: "H264"},
+		{Name: "P1080p", Resolution: "1920:1080", Framerate: 60, Bitrate: "6M", Codec: "H264"},
+		{Name: "P1080p", Resolution: "1920x1080", Framerate: 0, Bitrate: "6M", Codec: "H264"},
+		{Name: "P1080p", Resolution: "1920x1080", Framerate: 60, Bitrate: "6 Mbps", Codec: "H264"},
+		{Name: "P1080p", Resolution: "1920x1080", Framerate: 60, Bitrate: "6M", Codec: "VP8"},
+	}
+	for _, p := range invalid {
+		if err := p.Validate(); err == nil {
+			t.Errorf("expected %v to be invalid", p)
+		}
+	}
+}
+
+func TestNewProfiles(t *testing.T) {
+	custom := Profile{Name: "P720p30fps4Mbps", Resolution: "1280x720", Framerate: 25, Bitrate: "3500k", Codec: "H264"}
+	profiles, err := NewProfiles([]Profile{custom})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(profiles) != len(DefaultProfiles) {
+		t.Errorf("expected %d profiles, got %d", len(DefaultProfiles), len(profiles))
+	}
+	if profiles[custom.Name] != custom {
+		t.Errorf("expected config profile to override default, got %v", profiles[custom.Name])
+	}
+
+	if _, err := NewProfiles([]Profile{custom, custom}); err == nil {
+		t.Errorf("expected error for duplicate profile")
+	}
+	custom.Bitrate = ""
+	if _, err := NewProfiles([]Profile{custom}); err == nil {
+		t.Errorf("expected error for invalid profile")
+	}
+}
+
+func TestParseLadder(t *testing.T) {
+	profiles, _ := NewProfiles(nil)
+	ladder, err := ParseLadder("P720p30fps4Mbps, P360p30fps700Kbps", profiles)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(ladder) != 2 || ladder[0].Name != "P720p30fps4Mbps" || ladder[1].Name != "P360p30fps700Kbps" {
+		t.Errorf("unexpected ladder %v", ladder)
+	}
+
+	ladder, err = ParseLadder("", profiles)
+	if err != nil || len(ladder) != 0 {
+		t.Errorf("expected empty ladder, got %v, %v", ladder, err)
+	}
+	if _, err = ParseLadder("P720p30fps4Mbps,P4K", profiles); err == nil || !strings.Contains(err.Error(), "P4K") {
+		t.Errorf("expected unknown profile error, got %v", err)
+	}
+	if _, err = ParseLadder("P720p30fps4Mbps,P720p30fps4Mbps", profiles); err == nil {
+		t.Errorf("expected duplicate profile error")
+	}
+}
+
+func TestFFMpegArgs(t *testing.T) {
+	p := Profile{Name: "P720p", Resolution: "1280x720", Framerate: 30, Bitrate: "4000k", Codec: "H264", GOP: 60}
+	args := strings.Join(NewFFMpegTranscoder(p, "", "").args(), " ")
+	for _, exp := range []string{"-c:v libx264", "-s 1280x720", "-r 30", "-maxrate 4000k", "-g 60"} {
+		if !strings.Contains(args, exp) {
+			t.Errorf("expected '%v' in ffmpeg args '%v'", exp, args)
+		}
+	}
+	p.GOP = 0
+	if args = strings.Join(NewFFMpegTranscoder(p, "", "").args(), " "); strings.Contains(args, "-g ") {
+		t.Errorf("expected no keyframe interval in '%v'", args)
+	}
+}
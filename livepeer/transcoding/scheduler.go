@@ -0,0 +1,316 @@
+//Package transcoding schedules the transcode jobs a node accepts from the network.
+
+package transcoding
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/logger"
+	"github.com/ethereum/go-ethereum/logger/glog"
+)
+
+var ErrDuplicateJob = errors.New("DuplicateJob")
+var ErrSchedulerStopped = errors.New("SchedulerStopped")
+var ErrQueueFull = errors.New("QueueFull")
+
+type JobState int
+
+const (
+	JobQueued JobState = iota
+	JobRunning
+)
+
+func (self JobState) String() string {
+	if self == JobRunning {
+		return "running"
+	}
+	return "queued"
+}
+
+// Job is a unit of transcoding work.  Run is called once a slot is free and
+// must return when ctx is cancelled.
+type Job struct {
+	ID       string
+	StreamID string // the source stream, the job is cancelled when it ends
+	Priority int    // jobs with a higher priority run first, FIFO otherwise
+	Run      func(ctx context.Context) error
+	Done     func() // optional, called after Run returned or when the job is cancelled before running
+
+	state     JobState
+	seq       uint64
+	index     int // index in the queue heap
+	submitted time.Time
+	started   time.Time
+	cancel    context.CancelFunc
+}
+
+// JobInfo is the serialisable view of a job
+type JobInfo struct {
+	ID        string
+	StreamID  string
+	Priority  int
+	State     string
+	Submitted time.Time
+	Started   time.Time
+}
+
+// Scheduler runs at most maxJobs transcode jobs at a time, up to maxQueued
+// more wait in a priority queue.
+type Scheduler struct {
+	maxJobs   int
+	maxQueued int
+	onLoad    func(running, queued int)
+
+	lock    sync.Mutex
+	jobs    map[string]*Job
+	queue   jobQueue
+	running int
+	seq     uint64
+	stopped bool
+}
+
+// NewScheduler creates a scheduler running up to maxJobs jobs concurrently
+// and queueing up to maxQueued jobs waiting for a slot.
+// onLoad (if not nil) is called whenever the number of running or queued jobs changes.
+func NewScheduler(maxJobs, maxQueued int, onLoad func(running, queued int)) *Scheduler {
+	if maxJobs < 1 {
+		maxJobs = 1
+	}
+	if maxQueued < 0 {
+		maxQueued = 0
+	}
+	return &Scheduler{
+		maxJobs:   maxJobs,
+		maxQueued: maxQueued,
+		onLoad:    onLoad,
+		jobs:      make(map[string]*Job),
+	}
+}
+
+func (self *Scheduler) MaxJobs() int {
+	return self.maxJobs
+}
+
+func (self *Scheduler) MaxQueued() int {
+	return self.maxQueued
+}
+
+// Submit queues the job and starts it right away if a slot is free.  It
+// returns ErrQueueFull if all slots are busy and the queue is full.
+func (self *Scheduler) Submit(job *Job) error {
+	self.lock.Lock()
+	if self.stopped {
+		self.lock.Unlock()
+		return ErrSchedulerStopped
+	}
+	if _, ok := self.jobs[job.ID]; ok {
+		self.lock.Unlock()
+		return ErrDuplicateJob
+	}
+	if self.running >= self.maxJobs && self.queue.Len() >= self.maxQueued {
+		self.lock.Unlock()
+		return ErrQueueFull
+	}
+	self.seq++
+	job.seq = self.seq
+	job.state = JobQueued
+	job.submitted = time.Now()
+	self.jobs[job.ID] = job
+	heap.Push(&self.queue, job)
+	glog.V(logger.Info).Infof("Transcode job %v queued (stream %v, priority %d)", job.ID, job.StreamID, job.Priority)
+	self.schedule()
+	self.lock.Unlock()
+	self.load()
+	return nil
+}
+
+// Cancel stops a running job or removes it from the queue
+func (self *Scheduler) Cancel(id string) bool {
+	self.lock.Lock()
+	job := self.jobs[id]
+	var dequeued []*Job
+	if job != nil {
+		dequeued = self.cancel(job, dequeued)
+	}
+	self.lock.Unlock()
+	if job != nil {
+		done(dequeued)
+		self.load()
+	}
+	return job != nil
+}
+
+// CancelStream cancels all jobs transcoding the given source stream.  It is
+// called when the source stream ends.
+func (self *Scheduler) CancelStream(streamID string) (n int) {
+	self.lock.Lock()
+	var dequeued []*Job
+	for _, job := range self.jobs {
+		if job.StreamID == streamID {
+			dequeued = self.cancel(job, dequeued)
+			n++
+		}
+	}
+	self.lock.Unlock()
+	done(dequeued)
+	if n > 0 {
+		glog.V(logger.Info).Infof("Cancelled %d transcode jobs for stream %v", n, streamID)
+		self.load()
+	}
+	return
+}
+
+// Jobs lists running jobs followed by queued jobs in the order they will run
+func (self *Scheduler) Jobs() []JobInfo {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	infos := make([]JobInfo, 0, len(self.jobs))
+	for _, job := range self.jobs {
+		if job.state == JobRunning {
+			infos = append(infos, job.info())
+		}
+	}
+	queued := make(byOrder, len(self.queue))
+	copy(queued, self.queue)
+	sort.Sort(queued)
+	for _, job := range queued {
+		infos = append(infos, job.info())
+	}
+	return infos
+}
+
+// Stop cancels all jobs, no new jobs are accepted afterwards
+func (self *Scheduler) Stop() {
+	self.lock.Lock()
+	self.stopped = true
+	var dequeued []*Job
+	for _, job := range self.jobs {
+		dequeued = self.cancel(job, dequeued)
+	}
+	self.lock.Unlock()
+	done(dequeued)
+	self.load()
+}
+
+// Load returns the number of running and queued jobs
+func (self *Scheduler) Load() (running, queued int) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.running, self.queue.Len()
+}
+
+// needs lock
+// queued jobs are removed and appended to dequeued, their Done must be
+// called once the lock is released
+func (self *Scheduler) cancel(job *Job, dequeued []*Job) []*Job {
+	delete(self.jobs, job.ID)
+	if job.state == JobRunning {
+		job.cancel()
+		return dequeued
+	}
+	heap.Remove(&self.queue, job.index)
+	return append(dequeued, job)
+}
+
+func done(jobs []*Job) {
+	for _, job := range jobs {
+		if job.Done != nil {
+			job.Done()
+		}
+	}
+}
+
+// needs lock
+func (self *Scheduler) schedule() {
+	for self.running < self.maxJobs && self.queue.Len() > 0 {
+		job := heap.Pop(&self.queue).(*Job)
+		ctx, cancel := context.WithCancel(context.Background())
+		job.cancel = cancel
+		job.state = JobRunning
+		job.started = time.Now()
+		self.running++
+		go self.run(ctx, job)
+	}
+}
+
+func (self *Scheduler) run(ctx context.Context, job *Job) {
+	glog.V(logger.Info).Infof("Transcode job %v started", job.ID)
+	err := job.Run(ctx)
+	if err != nil && err != context.Canceled {
+		glog.Errorf("Transcode job %v failed: %v", job.ID, err)
+	} else {
+		glog.V(logger.Info).Infof("Transcode job %v finished", job.ID)
+	}
+	job.cancel()
+	done([]*Job{job})
+
+	self.lock.Lock()
+	self.running--
+	if self.jobs[job.ID] == job {
+		delete(self.jobs, job.ID)
+	}
+	if !self.stopped {
+		self.schedule()
+	}
+	self.lock.Unlock()
+	self.load()
+}
+
+func (self *Scheduler) load() {
+	if self.onLoad != nil {
+		self.onLoad(self.Load())
+	}
+}
+
+func (self *Job) info() JobInfo {
+	return JobInfo{
+		ID:        self.ID,
+		StreamID:  self.StreamID,
+		Priority:  self.Priority,
+		State:     self.state.String(),
+		Submitted: self.submitted,
+		Started:   self.started,
+	}
+}
+
+// runsBefore orders jobs by priority, then by submission
+func runsBefore(one, other *Job) bool {
+	if one.Priority != other.Priority {
+		return one.Priority > other.Priority
+	}
+	return one.seq < other.seq
+}
+
+type byOrder []*Job
+
+func (self byOrder) Len() int           { return len(self) }
+func (self byOrder) Less(i, j int) bool { return runsBefore(self[i], self[j]) }
+func (self byOrder) Swap(i, j int)      { self[i], self[j] = self[j], self[i] }
+
+// jobQueue implements heap.Interface
+type jobQueue []*Job
+
+func (self jobQueue) Len() int           { return len(self) }
+func (self jobQueue) Less(i, j int) bool { return runsBefore(self[i], self[j]) }
+func (self jobQueue) Swap(i, j int) {
+	self[i], self[j] = self[j], self[i]
+	self[i].index = i
+	self[j].index = j
+}
+func (self *jobQueue) Push(x interface{}) {
+	job := x.(*Job)
+	job.index = len(*self)
+	*self = append(*self, job)
+}
+func (self *jobQueue) Pop() interface{} {
+	old := *self
+	n := len(old)
+	job := old[n-1]
+	*self = old[:n-1]
+	return job
+}
@@ -0,0 +1,297 @@
+package transcoding
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	lpmsStream "github.com/livepeer/lpms/stream"
+)
+
+// testJob blocks until released or cancelled
+type testJob struct {
+	started  chan string
+	done     chan string
+	release  chan bool
+	lock     sync.Mutex
+	running  int
+	maxSeen  int
+	canceled int
+}
+
+func newTestJobs() *testJob {
+	return &testJob{
+		started: make(chan string, 100),
+		done:    make(chan string, 100),
+		release: make(chan bool),
+	}
+}
+
+func (self *testJob) job(id, streamID string, priority int) *Job {
+	return &Job{
+		ID:       id,
+		StreamID: streamID,
+		Priority: priority,
+		Run: func(ctx context.Context) error {
+			self.lock.Lock()
+			self.running++
+			if self.running > self.maxSeen {
+				self.maxSeen = self.running
+			}
+			self.lock.Unlock()
+			defer func() {
+				self.lock.Lock()
+				self.running--
+				self.lock.Unlock()
+			}()
+			self.started <- id
+			select {
+			case <-self.release:
+				return nil
+			case <-ctx.Done():
+				self.lock.Lock()
+				self.canceled++
+				self.lock.Unlock()
+				return ctx.Err()
+			}
+		},
+		Done: func() { self.done <- id },
+	}
+}
+
+func (self *testJob) waitStarted(t *testing.T) string {
+	select {
+	case id := <-self.started:
+		return id
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for a job to start")
+	}
+	return ""
+}
+
+func waitLoad(t *testing.T, s *Scheduler, running, queued int) {
+	for i := 0; i < 100; i++ {
+		r, q := s.Load()
+		if r == running && q == queued {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	r, q := s.Load()
+	t.Fatalf("expected %d running and %d queued jobs, got %d and %d", running, queued, r, q)
+}
+
+func TestSchedulerMaxJobs(t *testing.T) {
+	var loads []int
+	var loadLock sync.Mutex
+	s := NewScheduler(2, 3, func(running, queued int) {
+		loadLock.Lock()
+		loads = append(loads, running+queued)
+		loadLock.Unlock()
+	})
+	jobs := newTestJobs()
+	for i := 0; i < 5; i++ {
+		if err := s.Submit(jobs.job(fmt.Sprintf("job%d", i), "stream", 0)); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	jobs.waitStarted(t)
+	jobs.waitStarted(t)
+	waitLoad(t, s, 2, 3)
+	if err := s.Submit(jobs.job("job5", "stream", 0)); err != ErrQueueFull {
+		t.Errorf("expected ErrQueueFull, got %v", err)
+	}
+
+	infos := s.Jobs()
+	if len(infos) != 5 {
+		t.Fatalf("expected 5 jobs, got %d", len(infos))
+	}
+	for i, info := range infos {
+		exp := "queued"
+		if i < 2 {
+			exp = "running"
+		}
+		if info.State != exp {
+			t.Errorf("job %d: expected state %v, got %v", i, exp, info.State)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		jobs.release <- true
+		jobs.waitStarted(t)
+	}
+	jobs.release <- true
+	jobs.release <- true
+	waitLoad(t, s, 0, 0)
+
+	jobs.lock.Lock()
+	if jobs.maxSeen != 2 {
+		t.Errorf("expected at most 2 concurrent jobs, got %d", jobs.maxSeen)
+	}
+	jobs.lock.Unlock()
+	loadLock.Lock()
+	if len(loads) == 0 || loads[len(loads)-1] != 0 {
+		t.Errorf("expected load reports ending in 0, got %v", loads)
+	}
+	loadLock.Unlock()
+	if err := s.Submit(jobs.job("job0", "stream", 0)); err != nil {
+		t.Errorf("expected finished job id to be reusable, got %v", err)
+	}
+	s.Stop()
+}
+
+func TestSchedulerPriority(t *testing.T) {
+	s := NewScheduler(1, 4, nil)
+	defer s.Stop()
+	jobs := newTestJobs()
+	s.Submit(jobs.job("blocker", "stream", 0))
+	jobs.waitStarted(t)
+
+	s.Submit(jobs.job("low1", "stream", 0))
+	s.Submit(jobs.job("high1", "stream", 5))
+	s.Submit(jobs.job("low2", "stream", 0))
+	s.Submit(jobs.job("high2", "stream", 5))
+	if err := s.Submit(jobs.job("low2", "stream", 0)); err != ErrDuplicateJob {
+		t.Errorf("expected ErrDuplicateJob, got %v", err)
+	}
+
+	exp := []string{"high1", "high2", "low1", "low2"}
+	for i, info := range s.Jobs()[1:] {
+		if info.ID != exp[i] {
+			t.Errorf("queue position %d: expected %v, got %v", i, exp[i], info.ID)
+		}
+	}
+	for _, id := range exp {
+		jobs.release <- true
+		if got := jobs.waitStarted(t); got != id {
+			t.Errorf("expected %v to start, got %v", id, got)
+		}
+	}
+	jobs.release <- true
+	waitLoad(t, s, 0, 0)
+}
+
+func TestSchedulerCancelStream(t *testing.T) {
+	s := NewScheduler(1, 2, nil)
+	defer s.Stop()
+	jobs := newTestJobs()
+	s.Submit(jobs.job("a1", "A", 0))
+	jobs.waitStarted(t)
+	s.Submit(jobs.job("a2", "A", 0))
+	s.Submit(jobs.job("b1", "B", 0))
+
+	if n := s.CancelStream("A"); n != 2 {
+		t.Errorf("expected 2 jobs cancelled, got %d", n)
+	}
+	if got := jobs.waitStarted(t); got != "b1" {
+		t.Errorf("expected b1 to start, got %v", got)
+	}
+	waitLoad(t, s, 1, 0)
+	jobs.lock.Lock()
+	if jobs.canceled != 1 {
+		t.Errorf("expected 1 running job to see cancellation, got %d", jobs.canceled)
+	}
+	jobs.lock.Unlock()
+	if s.Cancel("a2") {
+		t.Errorf("expected a2 to be gone")
+	}
+	if !s.Cancel("b1") {
+		t.Errorf("expected b1 to be cancelled")
+	}
+	waitLoad(t, s, 0, 0)
+
+	// cleanup runs for running as well as queued jobs
+	done := make(map[string]bool)
+	for len(done) < 3 {
+		select {
+		case id := <-jobs.done:
+			done[id] = true
+		case <-time.After(time.Second):
+			t.Fatalf("expected a1, a2 and b1 to be done, got %v", done)
+		}
+	}
+
+	s.Stop()
+	if err := s.Submit(jobs.job("c1", "C", 0)); err != ErrSchedulerStopped {
+		t.Errorf("expected ErrSchedulerStopped, got %v", err)
+	}
+}
+
+type fakeTranscoder struct {
+	fail  bool
+	block bool // until ctx is cancelled
+}
+
+func (self *fakeTranscoder) Transcode(ctx context.Context, data []byte) ([]byte, error) {
+	if self.block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if self.fail {
+		return nil, errors.New("fake error")
+	}
+	return bytes.ToUpper(data), nil
+}
+
+type testWriter struct {
+	segs chan lpmsStream.HLSSegment
+}
+
+func (self *testWriter) WriteHLSSegmentToStream(seg lpmsStream.HLSSegment) error {
+	self.segs <- seg
+	return nil
+}
+
+func TestTranscodeSegments(t *testing.T) {
+	mux := NewSegmentMuxer()
+	out := &testWriter{segs: make(chan lpmsStream.HLSSegment, 10)}
+	s := NewScheduler(1, 0, nil)
+	defer s.Stop()
+	s.Submit(&Job{ID: "job", StreamID: "stream", Run: TranscodeSegments(&fakeTranscoder{}, mux.C, out)})
+
+	mux.WriteSegment(1, "seg_1.ts", 2, []byte("data1"))
+	mux.WriteSegment(2, "seg_2.ts", 2, []byte("data2"))
+	for i, exp := range []string{"DATA1", "DATA2"} {
+		select {
+		case seg := <-out.segs:
+			if string(seg.Data) != exp || seg.SeqNo != uint64(i+1) {
+				t.Errorf("expected segment %d with %v, got %d with %s", i+1, exp, seg.SeqNo, seg.Data)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for segment %d", i+1)
+		}
+	}
+
+	s.CancelStream("stream")
+	waitLoad(t, s, 0, 0)
+}
+
+func TestTranscodeSegmentsCancel(t *testing.T) {
+	mux := NewSegmentMuxer()
+	out := &testWriter{segs: make(chan lpmsStream.HLSSegment, 10)}
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() {
+		errc <- TranscodeSegments(&fakeTranscoder{block: true}, mux.C, out)(ctx)
+	}()
+
+	// the job returns while a segment is being transcoded
+	mux.WriteSegment(1, "seg_1.ts", 2, []byte("data1"))
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-errc:
+		if err != context.Canceled {
+			t.Errorf("expected %v, got %v", context.Canceled, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("job did not return after cancel")
+	}
+	if len(out.segs) != 0 {
+		t.Errorf("expected no transcoded segments, got %d", len(out.segs))
+	}
+}
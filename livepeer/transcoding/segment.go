@@ -0,0 +1,69 @@
+package transcoding
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum/logger"
+	"github.com/ethereum/go-ethereum/logger/glog"
+	lpmsStream "github.com/livepeer/lpms/stream"
+)
+
+// SegmentBufferSize is the number of source segments buffered for a job
+// before new segments are dropped
+var SegmentBufferSize = 10
+
+// Transcoder transcodes a single video segment, it gives up when ctx is cancelled
+type Transcoder interface {
+	Transcode(ctx context.Context, data []byte) ([]byte, error)
+}
+
+// SegmentWriter receives transcoded segments, e.g. a lpms VideoStream
+type SegmentWriter interface {
+	WriteHLSSegmentToStream(seg lpmsStream.HLSSegment) error
+}
+
+// SegmentMuxer is a HLSMuxer that feeds the segments of the source stream
+// into a transcode job.  Subscribe it to the source stream with the streamer.
+type SegmentMuxer struct {
+	C chan *lpmsStream.HLSSegment
+}
+
+func NewSegmentMuxer() *SegmentMuxer {
+	return &SegmentMuxer{C: make(chan *lpmsStream.HLSSegment, SegmentBufferSize)}
+}
+
+func (self *SegmentMuxer) WriteSegment(seqNo uint64, name string, duration float64, s []byte) error {
+	seg := &lpmsStream.HLSSegment{SeqNo: seqNo, Name: name, Duration: duration, Data: s}
+	select {
+	case self.C <- seg:
+	default:
+		glog.V(logger.Warn).Infof("Transcode job falling behind, dropping segment %v", name)
+	}
+	return nil
+}
+
+// TranscodeSegments returns the Run function of a job that transcodes the
+// segments arriving on in one at a time and writes the results to out
+func TranscodeSegments(t Transcoder, in <-chan *lpmsStream.HLSSegment, out SegmentWriter) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		for {
+			select {
+			case seg := <-in:
+				data, err := t.Transcode(ctx, seg.Data)
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				if err != nil {
+					glog.Errorf("Error transcoding segment %v: %v", seg.Name, err)
+					continue
+				}
+				err = out.WriteHLSSegmentToStream(lpmsStream.HLSSegment{SeqNo: seg.SeqNo, Name: seg.Name, Duration: seg.Duration, Data: data})
+				if err != nil {
+					return err
+				}
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package transcoding
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"math"
+	mrand "math/rand"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+)
+
+var (
+	// maximum relative difference between the durations of a transcoded
+	// segment and its local rendition
+	DurationTolerance = 0.1
+	// maximum number of differing bits between the perceptual signatures
+	// of a transcoded segment and its local rendition
+	SignatureTolerance = 10
+)
+
+// SegmentInfo holds the properties of a segment compared during verification
+type SegmentInfo struct {
+	Duration  float64
+	Width     int
+	Height    int
+	Signature uint64 // difference hash of the first frame
+}
+
+// Prober extracts the SegmentInfo of a segment
+type Prober interface {
+	Probe(data []byte) (*SegmentInfo, error)
+}
+
+// VerificationError is returned if a transcoded segment does not match the
+// local rendition, as opposed to errors preventing the check
+type VerificationError struct {
+	Reason string
+}
+
+func (self *VerificationError) Error() string {
+	return "transcode verification failed: " + self.Reason
+}
+
+// Verifier spot-checks transcoded segments by transcoding the source segment
+// locally and comparing the results
+type Verifier struct {
+	rate          float64
+	prober        Prober
+	newTranscoder func(Profile) Transcoder
+}
+
+// NewVerifier returns a verifier checking the given fraction of segments.
+// newTranscoder creates the transcoder for the local rendition.
+func NewVerifier(rate float64, prober Prober, newTranscoder func(Profile) Transcoder) *Verifier {
+	return &Verifier{
+		rate:          rate,
+		prober:        prober,
+		newTranscoder: newTranscoder,
+	}
+}
+
+// Sample returns true if the next segment should be checked
+func (self *Verifier) Sample() bool {
+	return mrand.Float64() < self.rate
+}
+
+// Verify checks that transcoded is a rendition of source in the given profile.
+// A *VerificationError is returned if it is not.
+func (self *Verifier) Verify(profile Profile, source, transcoded []byte) error {
+	local, err := self.newTranscoder(profile).Transcode(context.Background(), source)
+	if err != nil {
+		return fmt.Errorf("local transcode failed: %v", err)
+	}
+	want, err := self.prober.Probe(local)
+	if err != nil {
+		return fmt.Errorf("cannot probe local rendition: %v", err)
+	}
+	got, err := self.prober.Probe(transcoded)
+	if err != nil {
+		return &VerificationError{fmt.Sprintf("cannot probe segment: %v", err)}
+	}
+	if got.Width != want.Width || got.Height != want.Height {
+		return &VerificationError{fmt.Sprintf("resolution %dx%d, expected %dx%d", got.Width, got.Height, want.Width, want.Height)}
+	}
+	if math.Abs(got.Duration-want.Duration) > DurationTolerance*want.Duration {
+		return &VerificationError{fmt.Sprintf("duration %.3fs, expected %.3fs", got.Duration, want.Duration)}
+	}
+	if d := hammingDistance(got.Signature, want.Signature); d > SignatureTolerance {
+		return &VerificationError{fmt.Sprintf("signature differs in %d bits", d)}
+	}
+	return nil
+}
+
+func hammingDistance(a, b uint64) (n int) {
+	for x := a ^ b; x != 0; x &= x - 1 {
+		n++
+	}
+	return
+}
+
+// dHash computes the difference hash of a 9x8 grayscale image: a bit is set
+// if a pixel is brighter than its right neighbour
+func dHash(pixels []byte) (hash uint64) {
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if pixels[y*9+x] > pixels[y*9+x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return
+}
+
+// FFProbe probes segments with the ffprobe and ffmpeg binaries
+type FFProbe struct {
+	ffmpegPath string
+	workDir    string
+}
+
+func NewFFProbe(ffmpegPath, workDir string) *FFProbe {
+	return &FFProbe{
+		ffmpegPath: ffmpegPath,
+		workDir:    workDir,
+	}
+}
+
+func (self *FFProbe) Probe(data []byte) (*SegmentInfo, error) {
+	if err := os.MkdirAll(self.workDir, 0700); err != nil {
+		return nil, err
+	}
+	name := make([]byte, 10)
+	rand.Read(name)
+	file := path.Join(self.workDir, fmt.Sprintf("probe%x.ts", name))
+	defer os.Remove(file)
+	if err := ioutil.WriteFile(file, data, 0644); err != nil {
+		return nil, err
+	}
+
+	out, err := exec.Command(path.Join(self.ffmpegPath, "ffprobe"), "-v", "error", "-select_streams", "v:0",
+		"-show_entries", "stream=width,height:format=duration", "-of", "default=noprint_wrappers=1", file).Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe failed: %v", err)
+	}
+	info := &SegmentInfo{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "width":
+			info.Width, _ = strconv.Atoi(kv[1])
+		case "height":
+			info.Height, _ = strconv.Atoi(kv[1])
+		case "duration":
+			info.Duration, _ = strconv.ParseFloat(kv[1], 64)
+		}
+	}
+	if info.Width == 0 || info.Height == 0 {
+		return nil, fmt.Errorf("no video stream found")
+	}
+
+	// the first frame scaled down to 9x8 grayscale pixels
+	pixels, err := exec.Command(path.Join(self.ffmpegPath, "ffmpeg"), "-v", "error", "-i", file,
+		"-vf", "scale=9:8,format=gray", "-frames:v", "1", "-f", "rawvideo", "-").Output()
+	if err != nil {
+		return nil, fmt.Errorf("cannot extract frame: %v", err)
+	}
+	if len(pixels) < 72 {
+		return nil, fmt.Errorf("short frame: %d bytes", len(pixels))
+	}
+	info.Signature = dHash(pixels)
+	return info, nil
+}
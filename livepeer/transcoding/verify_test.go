@@ -0,0 +1,78 @@
+package transcoding
+
+import (
+	"errors"
+	"testing"
+)
+
+// fakeProber returns the info registered for the segment data
+type fakeProber map[string]*SegmentInfo
+
+func (self fakeProber) Probe(data []byte) (*SegmentInfo, error) {
+	info, ok := self[string(data)]
+	if !ok {
+		return nil, errors.New("not a video")
+	}
+	return info, nil
+}
+
+func newTestVerifier(prober Prober) *Verifier {
+	return NewVerifier(1, prober, func(Profile) Transcoder { return &fakeTranscoder{} })
+}
+
+func TestVerify(t *testing.T) {
+	prober := fakeProber{
+		// local rendition of source "seg" by the fake transcoder
+		"SEG":     {Duration: 2, Width: 1280, Height: 720, Signature: 0xff00ff00ff00ff00},
+		"good":    {Duration: 2.05, Width: 1280, Height: 720, Signature: 0xff00ff00ff00ff0f},
+		"lowres":  {Duration: 2, Width: 640, Height: 360, Signature: 0xff00ff00ff00ff00},
+		"short":   {Duration: 1, Width: 1280, Height: 720, Signature: 0xff00ff00ff00ff00},
+		"garbage": {Duration: 2, Width: 1280, Height: 720, Signature: 0x00ff00ff00ff00ff},
+	}
+	v := newTestVerifier(prober)
+	profile := DefaultProfiles[0]
+
+	if err := v.Verify(profile, []byte("seg"), []byte("good")); err != nil {
+		t.Errorf("expected segment to pass, got %v", err)
+	}
+	for _, seg := range []string{"lowres", "short", "garbage", "undecodable"} {
+		err := v.Verify(profile, []byte("seg"), []byte(seg))
+		if _, ok := err.(*VerificationError); !ok {
+			t.Errorf("%v: expected VerificationError, got %v", seg, err)
+		}
+	}
+
+	// errors on the local side do not fail the transcoder
+	v = NewVerifier(1, prober, func(Profile) Transcoder { return &fakeTranscoder{fail: true} })
+	err := v.Verify(profile, []byte("seg"), []byte("good"))
+	if _, ok := err.(*VerificationError); ok || err == nil {
+		t.Errorf("expected local error, got %v", err)
+	}
+}
+
+func TestSample(t *testing.T) {
+	if !newTestVerifier(nil).Sample() {
+		t.Errorf("expected rate 1 to sample every segment")
+	}
+	if NewVerifier(0, nil, nil).Sample() {
+		t.Errorf("expected rate 0 to sample no segment")
+	}
+}
+
+func TestDHash(t *testing.T) {
+	pixels := make([]byte, 72)
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 9; x++ {
+			// brightness decreasing to the right in even rows
+			if y%2 == 0 {
+				pixels[y*9+x] = byte(255 - x)
+			}
+		}
+	}
+	if h := dHash(pixels); h != 0xff00ff00ff00ff00 {
+		t.Errorf("expected hash ff00ff00ff00ff00, got %x", h)
+	}
+	if d := hammingDistance(0xff00ff00ff00ff00, 0xff00ff00ff00ff0f); d != 4 {
+		t.Errorf("expected distance 4, got %d", d)
+	}
+}
@@ -0,0 +1,390 @@
+/*
+Package webhook POSTs stream lifecycle events to the URLs configured by the
+node operator.
+
+Every event is queued once per URL in a leveldb database, so undelivered
+events survive a restart.  The URLs are delivered to concurrently, each in
+queue order.  Failed deliveries are retried with exponential backoff until they
+succeed or run out of attempts, later events to the same URL wait for them.  If a secret is set the
+body is signed with HMAC-SHA256, the hex encoded signature is sent in the
+X-Livepeer-Signature header.
+*/
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/logger"
+	"github.com/ethereum/go-ethereum/logger/glog"
+	"github.com/livepeer/livepeer-swarm/livepeer/storage"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+const (
+	SignatureHeader = "X-Livepeer-Signature"
+	EventHeader     = "X-Livepeer-Event"
+)
+
+// Event types
+const (
+	BroadcastStarted  = "broadcastStarted"
+	BroadcastEnded    = "broadcastEnded"
+	SubscriberJoined  = "subscriberJoined"
+	TranscodeComplete = "transcodeComplete"
+)
+
+var ErrStopped = errors.New("webhook dispatcher stopped")
+
+const (
+	maxAttempts      = 10
+	retryInterval    = time.Second
+	maxRetryInterval = 10 * time.Minute
+	requestTimeout   = 10 * time.Second
+)
+
+// Params are the webhook settings of the node
+type Params struct {
+	URLs             []string      // events are POSTed to every URL, no webhooks if empty
+	Secret           string        // HMAC key of the signature header, bodies are not signed if empty
+	QueuePath        string        // leveldb database of the undelivered events
+	MaxAttempts      int           // deliveries of an event to a URL before it is dropped, 0 retries forever
+	RetryInterval    time.Duration // wait after the first failed delivery, doubled after every further failure
+	MaxRetryInterval time.Duration
+}
+
+func NewParams(path string) *Params {
+	return &Params{
+		QueuePath:        filepath.Join(path, "webhooks"),
+		MaxAttempts:      maxAttempts,
+		RetryInterval:    retryInterval,
+		MaxRetryInterval: maxRetryInterval,
+	}
+}
+
+// Event is the JSON body POSTed to the webhook URLs
+type Event struct {
+	ID       string      `json:"id"` // random, the same for all URLs and retries
+	Type     string      `json:"type"`
+	Time     time.Time   `json:"time"`
+	Node     string      `json:"node"` // bzz address of the node
+	StreamID string      `json:"streamID"`
+	Data     interface{} `json:"data,omitempty"` // depends on the type
+}
+
+// delivery is the queued delivery of an event to a URL
+type delivery struct {
+	URL      string
+	Type     string
+	Body     []byte
+	Attempts int
+	Next     time.Time
+}
+
+// Dispatcher queues events and delivers them in the background
+type Dispatcher struct {
+	params *Params
+	client *http.Client
+	db     *storage.LDBDatabase
+
+	lock    sync.Mutex
+	running bool
+	pending map[uint64]*delivery // by queue key
+	seq     uint64               // last queue key
+	busy    map[string]bool      // URLs being delivered to
+	workers sync.WaitGroup
+	wake    chan struct{}
+	quit    chan struct{}
+	done    chan struct{}
+}
+
+func NewDispatcher(params *Params) *Dispatcher {
+	return &Dispatcher{
+		params:  params,
+		client:  &http.Client{Timeout: requestTimeout},
+		pending: make(map[uint64]*delivery),
+		busy:    make(map[string]bool),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// Start opens the queue and starts delivering the events left from the last
+// run
+func (self *Dispatcher) Start() error {
+	db, err := storage.NewLDBDatabase(self.params.QueuePath)
+	if err != nil {
+		return err
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.db = db
+	it := db.NewIterator()
+	for it.Next() {
+		var d delivery
+		if err := json.Unmarshal(it.Value(), &d); err != nil {
+			glog.V(logger.Warn).Infof("dropping unreadable webhook delivery: %v", err)
+			db.Delete(it.Key())
+			continue
+		}
+		key := binary.BigEndian.Uint64(it.Key())
+		self.pending[key] = &d
+		if key > self.seq {
+			self.seq = key
+		}
+	}
+	it.Release()
+	if len(self.pending) > 0 {
+		glog.V(logger.Info).Infof("%d webhook deliveries queued", len(self.pending))
+	}
+
+	self.running = true
+	self.quit = make(chan struct{})
+	self.done = make(chan struct{})
+	go self.loop()
+	return nil
+}
+
+// Stop waits for the running deliveries and closes the queue, undelivered events
+// are delivered after the next Start
+func (self *Dispatcher) Stop() {
+	self.lock.Lock()
+	if !self.running {
+		self.lock.Unlock()
+		return
+	}
+	self.running = false
+	self.lock.Unlock()
+
+	close(self.quit)
+	<-self.done
+	self.db.Close()
+}
+
+// Post queues an event for delivery to every URL, ID and Time are set if
+// they are empty
+func (self *Dispatcher) Post(ev *Event) error {
+	if ev.ID == "" {
+		var id [16]byte
+		rand.Read(id[:])
+		ev.ID = hex.EncodeToString(id[:])
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if !self.running {
+		return ErrStopped
+	}
+	for _, url := range self.params.URLs {
+		self.seq++
+		d := &delivery{URL: url, Type: ev.Type, Body: body, Next: ev.Time}
+		if err := self.save(self.seq, d); err != nil {
+			return err
+		}
+		self.pending[self.seq] = d
+	}
+	self.wakeUp()
+	return nil
+}
+
+func (self *Dispatcher) wakeUp() {
+	select {
+	case self.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Pending returns the number of queued deliveries
+func (self *Dispatcher) Pending() int {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return len(self.pending)
+}
+
+func (self *Dispatcher) loop() {
+	defer close(self.done)
+	defer self.workers.Wait()
+	for {
+		var timer <-chan time.Time
+		if next, ok := self.deliverDue(); ok {
+			timer = time.After(next.Sub(time.Now()))
+		}
+		select {
+		case <-self.wake:
+		case <-timer:
+		case <-self.quit:
+			return
+		}
+	}
+}
+
+// deliverDue starts delivering the events that are due to the URLs that are
+// not being delivered to, it returns when the next delivery is due
+func (self *Dispatcher) deliverDue() (next time.Time, ok bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	now := time.Now()
+	due := make(map[string][]uint64)
+	waiting := make(map[string]bool)
+	for _, key := range self.keys() {
+		d := self.pending[key]
+		if self.busy[d.URL] || waiting[d.URL] {
+			continue
+		}
+		if d.Next.After(now) {
+			// later events to the URL wait for this one
+			waiting[d.URL] = true
+			if !ok || d.Next.Before(next) {
+				next, ok = d.Next, true
+			}
+			continue
+		}
+		due[d.URL] = append(due[d.URL], key)
+	}
+	for url, keys := range due {
+		self.busy[url] = true
+		self.workers.Add(1)
+		go func(url string, keys []uint64) {
+			defer self.workers.Done()
+			self.deliverURL(keys)
+			self.lock.Lock()
+			delete(self.busy, url)
+			self.lock.Unlock()
+			self.wakeUp()
+		}(url, keys)
+	}
+	return
+}
+
+// deliverURL delivers the events to one URL in queue order, it stops at the
+// first delivery to retry
+func (self *Dispatcher) deliverURL(keys []uint64) {
+	for _, key := range keys {
+		select {
+		case <-self.quit:
+			return
+		default:
+		}
+		self.lock.Lock()
+		d := *self.pending[key]
+		self.lock.Unlock()
+
+		err := self.deliver(&d)
+
+		self.lock.Lock()
+		d.Attempts++
+		retry := false
+		if err == nil {
+			self.remove(key)
+		} else if self.params.MaxAttempts > 0 && d.Attempts >= self.params.MaxAttempts {
+			glog.V(logger.Warn).Infof("dropping %v webhook to %v after %d attempts: %v", d.Type, d.URL, d.Attempts, err)
+			self.remove(key)
+		} else {
+			d.Next = time.Now().Add(self.backoff(d.Attempts))
+			glog.V(logger.Debug).Infof("%v webhook to %v failed, retrying at %v: %v", d.Type, d.URL, d.Next, err)
+			self.pending[key] = &d
+			if err := self.save(key, &d); err != nil {
+				glog.V(logger.Warn).Infof("cannot save %v webhook to %v: %v", d.Type, d.URL, err)
+			}
+			retry = true
+		}
+		self.lock.Unlock()
+		if retry {
+			return
+		}
+	}
+}
+
+// keys returns the keys of the pending deliveries in queue order, needs lock
+func (self *Dispatcher) keys() (keys []uint64) {
+	for key := range self.pending {
+		keys = append(keys, key)
+	}
+	sort.Sort(keySlice(keys))
+	return keys
+}
+
+type keySlice []uint64
+
+func (s keySlice) Len() int           { return len(s) }
+func (s keySlice) Less(i, j int) bool { return s[i] < s[j] }
+func (s keySlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func (self *Dispatcher) backoff(attempts int) time.Duration {
+	wait := self.params.RetryInterval
+	for i := 1; i < attempts && wait < self.params.MaxRetryInterval; i++ {
+		wait *= 2
+	}
+	if self.params.MaxRetryInterval > 0 && wait > self.params.MaxRetryInterval {
+		wait = self.params.MaxRetryInterval
+	}
+	return wait
+}
+
+func (self *Dispatcher) deliver(d *delivery) error {
+	req, err := http.NewRequest("POST", d.URL, bytes.NewReader(d.Body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, d.Type)
+	if self.params.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(self.params.Secret, d.Body))
+	}
+	resp, err := self.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%v", resp.Status)
+	}
+	return nil
+}
+
+func (self *Dispatcher) save(key uint64, d *delivery) error {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	batch := new(leveldb.Batch)
+	batch.Put(queueKey(key), data)
+	return self.db.Write(batch)
+}
+
+func (self *Dispatcher) remove(key uint64) {
+	delete(self.pending, key)
+	self.db.Delete(queueKey(key))
+}
+
+func queueKey(key uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, key)
+	return k
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of body, receivers compare it to
+// the X-Livepeer-Signature header
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
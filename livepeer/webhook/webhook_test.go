@@ -0,0 +1,188 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type receiver struct {
+	lock   sync.Mutex
+	fail   int // requests to fail before accepting
+	events []*Event
+	sigs   []string
+	got    chan struct{}
+}
+
+func newReceiver(fail int) *receiver {
+	return &receiver{fail: fail, got: make(chan struct{}, 10)}
+}
+
+func (self *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.fail > 0 {
+		self.fail--
+		http.Error(w, "try again", http.StatusServiceUnavailable)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	var ev Event
+	json.Unmarshal(body, &ev)
+	if r.Header.Get(SignatureHeader) != Sign("secret", body) {
+		self.sigs = append(self.sigs, r.Header.Get(SignatureHeader))
+	}
+	self.events = append(self.events, &ev)
+	self.got <- struct{}{}
+}
+
+func (self *receiver) wait(t *testing.T, n int) {
+	for i := 0; i < n; i++ {
+		select {
+		case <-self.got:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for event %d", i+1)
+		}
+	}
+}
+
+func newTestParams(t *testing.T, url string) *Params {
+	dir, err := ioutil.TempDir("", "webhook-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := NewParams(dir)
+	params.URLs = []string{url}
+	params.Secret = "secret"
+	params.RetryInterval = 10 * time.Millisecond
+	return params
+}
+
+func TestDeliverWithRetries(t *testing.T) {
+	rec := newReceiver(2)
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+	params := newTestParams(t, srv.URL)
+	defer os.RemoveAll(filepath.Dir(params.QueuePath))
+
+	d := NewDispatcher(params)
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop()
+	d.Post(&Event{Type: BroadcastStarted, StreamID: "a"})
+	d.Post(&Event{Type: BroadcastEnded, StreamID: "a"})
+	rec.wait(t, 2)
+
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	if len(rec.sigs) > 0 {
+		t.Errorf("Expecting valid signatures, got %v", rec.sigs)
+	}
+	if rec.events[0].Type != BroadcastStarted || rec.events[1].Type != BroadcastEnded {
+		t.Errorf("Expecting the events in order, got %v and %v", rec.events[0].Type, rec.events[1].Type)
+	}
+	if rec.events[0].ID == "" || rec.events[0].ID == rec.events[1].ID {
+		t.Errorf("Expecting distinct event IDs, got %v and %v", rec.events[0].ID, rec.events[1].ID)
+	}
+}
+
+func TestDropAfterMaxAttempts(t *testing.T) {
+	rec := newReceiver(1000)
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+	params := newTestParams(t, srv.URL)
+	defer os.RemoveAll(filepath.Dir(params.QueuePath))
+	params.MaxAttempts = 3
+
+	d := NewDispatcher(params)
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop()
+	d.Post(&Event{Type: SubscriberJoined, StreamID: "a"})
+	for i := 0; i < 100 && d.Pending() > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if d.Pending() != 0 {
+		t.Errorf("Expecting the event to be dropped")
+	}
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	if rec.fail != 1000-3 {
+		t.Errorf("Expecting 3 attempts, got %v", 1000-rec.fail)
+	}
+}
+
+func TestQueuePersists(t *testing.T) {
+	rec := newReceiver(1000)
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+	params := newTestParams(t, srv.URL)
+	defer os.RemoveAll(filepath.Dir(params.QueuePath))
+
+	d := NewDispatcher(params)
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	d.Post(&Event{Type: TranscodeComplete, StreamID: "a"})
+	time.Sleep(50 * time.Millisecond)
+	d.Stop()
+	if err := d.Post(&Event{Type: TranscodeComplete, StreamID: "b"}); err != ErrStopped {
+		t.Errorf("Expecting ErrStopped, got %v", err)
+	}
+
+	rec.lock.Lock()
+	rec.fail = 0
+	rec.lock.Unlock()
+	d = NewDispatcher(params)
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop()
+	rec.wait(t, 1)
+
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	if len(rec.events) != 1 || rec.events[0].StreamID != "a" {
+		t.Errorf("Expecting the queued event after the restart, got %v", rec.events)
+	}
+}
+
+func TestDeliverConcurrently(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	rec := newReceiver(0)
+	srv := httptest.NewServer(rec)
+	defer srv.Close()
+	params := newTestParams(t, slow.URL)
+	defer os.RemoveAll(filepath.Dir(params.QueuePath))
+	params.URLs = append(params.URLs, srv.URL)
+
+	d := NewDispatcher(params)
+	if err := d.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop()
+	// Stop waits for the hanging delivery
+	defer close(release)
+	d.Post(&Event{Type: BroadcastStarted, StreamID: "a"})
+	d.Post(&Event{Type: BroadcastEnded, StreamID: "a"})
+	// the events reach the second URL while the first one hangs
+	rec.wait(t, 2)
+
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+	if rec.events[0].Type != BroadcastStarted || rec.events[1].Type != BroadcastEnded {
+		t.Errorf("Expecting the events in order, got %v and %v", rec.events[0].Type, rec.events[1].Type)
+	}
+}
@@ -0,0 +1,70 @@
+package livepeer
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/golang/glog"
+	"github.com/livepeer/livepeer-swarm/livepeer/network"
+	"github.com/livepeer/livepeer-swarm/livepeer/streaming"
+	"github.com/livepeer/livepeer-swarm/livepeer/webhook"
+)
+
+// startWebhooks starts delivering the stream events to the configured
+// webhook URLs, until the event mux is stopped
+func (self *Swarm) startWebhooks() error {
+	if err := self.webhooks.Start(); err != nil {
+		return err
+	}
+	sub := self.events.Subscribe(
+		streaming.BroadcastStartedEvent{},
+		streaming.BroadcastEndedEvent{},
+		streaming.SubscriberJoinedEvent{},
+		network.TranscodeAckEvent{},
+	)
+	go self.forwardWebhooks(sub)
+	return nil
+}
+
+func (self *Swarm) forwardWebhooks(sub event.Subscription) {
+	for ev := range sub.Chan() {
+		hook, err := newWebhookEvent(ev.Data)
+		if err != nil {
+			glog.Errorf("Not posting webhook: %v", err)
+			continue
+		}
+		hook.Node = self.config.BzzKey
+		if err := self.webhooks.Post(hook); err != nil {
+			glog.Errorf("Error queueing %v webhook: %v", hook.Type, err)
+		}
+	}
+}
+
+func newWebhookEvent(data interface{}) (*webhook.Event, error) {
+	switch ev := data.(type) {
+	case streaming.BroadcastStartedEvent:
+		hook := &webhook.Event{Type: webhook.BroadcastStarted, StreamID: ev.StreamID.String()}
+		if ev.HLSStreamID != "" {
+			hook.Data = map[string]string{"hlsStreamID": ev.HLSStreamID.String()}
+		}
+		return hook, nil
+	case streaming.BroadcastEndedEvent:
+		return &webhook.Event{Type: webhook.BroadcastEnded, StreamID: ev.StreamID.String()}, nil
+	case streaming.SubscriberJoinedEvent:
+		return &webhook.Event{
+			Type:     webhook.SubscriberJoined,
+			StreamID: ev.StreamID.String(),
+			Data:     map[string]string{"subscriberID": ev.SubscriberID},
+		}, nil
+	case network.TranscodeAckEvent:
+		return &webhook.Event{
+			Type:     webhook.TranscodeComplete,
+			StreamID: ev.StreamID.String(),
+			Data: map[string]interface{}{
+				"transcodeID": ev.TranscodeID.Hex(),
+				"streams":     ev.Streams,
+			},
+		}, nil
+	}
+	return nil, fmt.Errorf("unexpected webhook event %T", data)
+}
@@ -0,0 +1,96 @@
+package mediaserver
+
+import (
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/logger/glog"
+)
+
+// HTTPError is returned by the playback callbacks to answer a request with
+// Code instead of 500, e.g. 403 for an unauthorized player.  ErrNotFound is
+// answered with 404.
+type HTTPError struct {
+	Code int
+	Err  error
+}
+
+func (e *HTTPError) Error() string {
+	return e.Err.Error()
+}
+
+func httpError(w http.ResponseWriter, err error, msg string) {
+	if herr, ok := err.(*HTTPError); ok {
+		http.Error(w, herr.Error(), herr.Code)
+		return
+	}
+	if err == ErrNotFound {
+		// the stream ended or never started, players stop asking
+		http.Error(w, msg, http.StatusNotFound)
+		return
+	}
+	http.Error(w, msg, http.StatusInternalServerError)
+}
+
+// handleLive serves the playlists and segments of live HLS streams under
+// /stream/.  It replaces the lpms vidplayer HLS handler, which registers itself
+// on http.DefaultServeMux, so that it can go on the server's own mux.
+func (self *Server) handleLive(w http.ResponseWriter, r *http.Request) {
+	glog.Infof("Got HTTP request @ %v", r.URL.Path)
+	w.Header().Set("Content-Type", "application/x-mpegURL")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("Cache-Control", "max-age=5")
+
+	switch {
+	case strings.HasSuffix(r.URL.Path, ".m3u8"):
+		pl, err := self.getMediaPlaylist(r.URL)
+		if err != nil {
+			httpError(w, err, "Error getting HLS playlist")
+			return
+		}
+		if _, err := w.Write(pl.Encode().Bytes()); err != nil {
+			glog.Errorf("Error writing playlist to ResponseWriter: %v", err)
+		}
+
+	case strings.HasSuffix(r.URL.Path, ".ts"):
+		seg, err := self.getSegment(r.URL)
+		if err != nil {
+			glog.Errorf("Error getting segment %v: %v", r.URL, err)
+			httpError(w, err, "Error getting HLS segment")
+			return
+		}
+		w.Header().Set("Content-Type", mime.TypeByExtension(path.Ext(r.URL.Path)))
+		if _, err := w.Write(seg); err != nil {
+			glog.Errorf("Error writting HLS segment %v: %v", r.URL, err)
+		}
+
+	default:
+		http.Error(w, "Only HLS requests are served over HTTP (m3u8, ts).", http.StatusInternalServerError)
+	}
+}
+
+// handleVOD serves the playlists and segments in the VOD directory under /vod/
+func (self *Server) handleVOD(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasSuffix(r.URL.Path, ".m3u8") && !strings.HasSuffix(r.URL.Path, ".ts") {
+		http.NotFound(w, r)
+		return
+	}
+	name := filepath.Join(self.params.VodPath, filepath.FromSlash(path.Clean("/"+strings.TrimPrefix(r.URL.Path, "/vod/"))))
+	dat, err := ioutil.ReadFile(name)
+	if err != nil {
+		glog.Errorf("Cannot find file: %v", name)
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", mime.TypeByExtension(path.Ext(r.URL.Path)))
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if strings.HasSuffix(r.URL.Path, ".m3u8") {
+		w.Header().Set("Cache-Control", "max-age=5")
+	}
+	w.Write(dat)
+}
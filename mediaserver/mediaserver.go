@@ -7,9 +7,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ericxtang/m3u8"
@@ -19,144 +21,335 @@ import (
 	"github.com/livepeer/livepeer-swarm/livepeer/network"
 	"github.com/livepeer/livepeer-swarm/livepeer/storage"
 	"github.com/livepeer/livepeer-swarm/livepeer/streaming"
+	"github.com/livepeer/livepeer-swarm/livepeer/transcoding"
 
 	"net/url"
 
-	lpmscore "github.com/livepeer/lpms/core"
 	lpmsStream "github.com/livepeer/lpms/stream"
+	"github.com/livepeer/lpms/vidlistener"
+	"github.com/livepeer/lpms/vidplayer"
 	streamingVizClient "github.com/livepeer/streamingviz/client"
 	"github.com/nareix/joy4/av/pubsub"
+	joy4rtmp "github.com/nareix/joy4/format/rtmp"
 )
 
 var ErrNotFound = errors.New("NotFound")
 var ErrStreamPublish = errors.New("StreamPublishError")
 var ErrHLSPlay = errors.New("ErrHLSPlay")
+var ErrStopping = errors.New("MediaServerStopping")
+var ErrStarted = errors.New("MediaServerAlreadyStarted")
+var ErrStopTimeout = errors.New("MediaServerStopTimeout")
+var ErrNotStarted = errors.New("MediaServerNotStarted")
 var HLSWaitTime = time.Second * 10
 var HLSBufferCap = uint(43200) //12 hrs assuming 1s segment
 var HLSBufferWindow = uint(5)
 var HLSUnsubscribeWaitLimit = time.Second * 20
+var StopTimeout = time.Second * 10 //time the listeners get to close on Stop
+
+// ServerParams are the listener settings of a media server
+type ServerParams struct {
+	RTMPPort   string // no RTMP listener is started if empty
+	HTTPPort   string // no HTTP listener is started if empty, Handler can be mounted elsewhere
+	FFMpegPath string
+	VodPath    string
+	// players need a playback token signed by the stream owner, see streaming.PlaybackToken
+	RequireToken bool
+}
 
-func startHlsUnsubscribeWorker(hlsSubTimer map[streaming.StreamID]time.Time, streamer *streaming.Streamer, forwarder storage.CloudStore, limit time.Duration) {
-	for {
-		time.Sleep(time.Second * 5)
-		for sid, t := range hlsSubTimer {
-			if time.Since(t) > limit {
-				streamer.UnsubscribeToHLSStream(sid.String(), "local")
-				forwarder.StopStream(sid.String(), kademlia.Address(ethCommon.HexToHash("")), lpmsStream.HLS) //This could fail if it's a local stream, but it's ok.
-				delete(hlsSubTimer, sid)
-			}
-		}
+/*
+Server is the media server of a node: the RTMP endpoint to publish and play
+streams and the HTTP endpoints for HLS playback, stream management and
+transcoding.
+
+The HTTP endpoints are registered on the server's own mux, so several servers
+can run in one process and the endpoints can be mounted in another http.Server
+through Handler.
+*/
+type Server struct {
+	params    *ServerParams
+	streamer  *streaming.Streamer
+	forwarder storage.CloudStore
+	streamdb  *network.StreamDB
+	hive      *network.Hive
+	scheduler *transcoding.Scheduler         // nil if the node does not transcode
+	profiles  map[string]transcoding.Profile // transcoding profiles publishers can request by name
+	viz       *streamingVizClient.Client
+
+	rtmpHandlers *joy4rtmp.Server // holds the lpms publish and play handlers, never listens itself
+	mux          *http.ServeMux
+	rtmp         *rtmpListener // nil if there is no RTMP port
+	httpServer   *http.Server  // nil if there is no HTTP port
+
+	hlsLock     sync.Mutex
+	hlsSubTimer map[streaming.StreamID]time.Time // last playlist request of the locally played HLS streams
+
+	publishLock sync.Mutex
+	publishes   map[streaming.StreamID]streaming.StreamID // HLS stream of each RTMP stream published here
+
+	lock    sync.Mutex
+	started bool
+	ctx     context.Context // cancelled by Stop
+	cancel  context.CancelFunc
+	done    chan struct{} // closed once the listeners returned
+}
+
+// NewServer creates a media server and registers its handlers.
+// scheduler and viz may be nil, profiles defaults to transcoding.DefaultProfiles.
+func NewServer(params *ServerParams, streamer *streaming.Streamer, forwarder storage.CloudStore, streamdb *network.StreamDB, hive *network.Hive,
+	scheduler *transcoding.Scheduler, profiles map[string]transcoding.Profile, viz *streamingVizClient.Client) *Server {
+
+	if profiles == nil {
+		profiles, _ = transcoding.NewProfiles(nil)
+	}
+	if viz == nil {
+		viz = streamingVizClient.NewClient("", false, "")
+	}
+	self := &Server{
+		params:       params,
+		streamer:     streamer,
+		forwarder:    forwarder,
+		streamdb:     streamdb,
+		hive:         hive,
+		scheduler:    scheduler,
+		profiles:     profiles,
+		viz:          viz,
+		rtmpHandlers: &joy4rtmp.Server{},
+		mux:          http.NewServeMux(),
+		hlsSubTimer:  make(map[streaming.StreamID]time.Time),
+		publishes:    make(map[streaming.StreamID]streaming.StreamID),
+		done:         make(chan struct{}),
 	}
+	self.ctx, self.cancel = context.WithCancel(context.Background())
+	self.setupHandlers()
+	return self
 }
 
-func StartLPMS(rtmpPort string, httpPort string, streamer *streaming.Streamer, forwarder storage.CloudStore, streamdb *network.StreamDB,
-	viz *streamingVizClient.Client, hive *network.Hive, ffmpegPath string, vodPath string) {
+// Handler returns the handler serving the HTTP endpoints
+func (self *Server) Handler() http.Handler {
+	return self.mux
+}
 
-	hlsSubTimer := make(map[streaming.StreamID]time.Time)
-	go startHlsUnsubscribeWorker(hlsSubTimer, streamer, forwarder, HLSUnsubscribeWaitLimit)
+// Handle registers another handler on the HTTP port of the server
+func (self *Server) Handle(pattern string, handler http.Handler) {
+	self.mux.Handle(pattern, handler)
+}
 
-	server := lpmscore.New(rtmpPort, httpPort, ffmpegPath, vodPath)
+// Start binds the listeners and starts serving and the background workers,
+// it does not block.  It needs to be called even if the Handler is mounted
+// elsewhere.
+func (self *Server) Start() (err error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.started {
+		return ErrStarted
+	}
+	if self.ctx.Err() != nil {
+		return ErrStopping
+	}
+	var httpListener net.Listener
+	if self.params.RTMPPort != "" {
+		if self.rtmp, err = listenRTMP(":"+self.params.RTMPPort, self.rtmpHandlers); err != nil {
+			return err
+		}
+	}
+	if self.params.HTTPPort != "" {
+		if httpListener, err = net.Listen("tcp", ":"+self.params.HTTPPort); err != nil {
+			if self.rtmp != nil {
+				self.rtmp.Close()
+			}
+			return err
+		}
+		glog.Infof("Starting HTTP Server at %v", httpListener.Addr())
+		self.httpServer = &http.Server{Handler: self.mux}
+	}
+	self.started = true
+	go self.hlsUnsubscribeLoop(HLSUnsubscribeWaitLimit)
+
+	var wg sync.WaitGroup
+	if self.rtmp != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			self.serve("RTMP", self.rtmp.serve())
+		}()
+	}
+	if self.httpServer != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			self.serve("HTTP", self.httpServer.Serve(httpListener))
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(self.done)
+	}()
+	return nil
+}
 
-	server.HandleHLSPlay(
-		//getMasterPlaylist
-		func(url *url.URL) (*m3u8.MasterPlaylist, error) {
-			return nil, nil
-		},
-		//getMediaPlaylist
-		func(url *url.URL) (*m3u8.MediaPlaylist, error) {
-			strmID := parseStreamID(url.Path)
+// serve logs why a listener returned unless the server is stopping
+func (self *Server) serve(name string, err error) {
+	if self.ctx.Err() == nil {
+		glog.Errorf("%v server stopped: %v", name, err)
+	}
+}
 
-			//Validate the stream ID format
-			sid := streaming.StreamID(strmID)
-			nodeID, streamID := sid.SplitComponents()
+// CheckListeners dials the RTMP and HTTP ports of a started server.  It
+// returns the checked addresses, none if the ports are not set.
+func (self *Server) CheckListeners() (map[string]string, error) {
+	self.lock.Lock()
+	started := self.started
+	self.lock.Unlock()
+	if !started || self.ctx.Err() != nil {
+		return nil, ErrNotStarted
+	}
+	addrs := make(map[string]string)
+	for name, port := range map[string]string{"rtmp": self.params.RTMPPort, "http": self.params.HTTPPort} {
+		if port == "" {
+			continue
+		}
+		addrs[name] = "127.0.0.1:" + port
+		conn, err := net.DialTimeout("tcp", addrs[name], time.Second)
+		if err != nil {
+			return addrs, fmt.Errorf("%v listener: %v", name, err)
+		}
+		conn.Close()
+	}
+	return addrs, nil
+}
 
-			if strmID == "" || streamID == "" {
-				glog.Errorf("Cannot find stream for %v", url.Path)
-				return nil, errors.New("Stream Not Found")
-			}
+// Stop refuses new streams and players and closes the listeners and the RTMP
+// connections.  It waits at most StopTimeout for HTTP requests to finish and
+// for the publishes to end, which passes the end of their streams on to the
+// subscribers so recordings are finalised.
+func (self *Server) Stop() error {
+	self.lock.Lock()
+	started := self.started
+	self.lock.Unlock()
+	self.cancel()
+	if !started {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), StopTimeout)
+	defer cancel()
+	if self.rtmp != nil {
+		self.rtmp.Close()
+	}
+	if self.httpServer != nil {
+		if err := self.httpServer.Shutdown(ctx); err != nil {
+			self.httpServer.Close()
+		}
+	}
+	select {
+	case <-self.done:
+	case <-ctx.Done():
+		return ErrStopTimeout
+	}
+	return self.waitPublishes(ctx)
+}
 
-			strm := streamer.GetNetworkStream(streaming.StreamID(strmID))
-			if strm == nil {
-				if streamer.SelfAddress != nodeID {
-					glog.Infof("Cannot find HLS stream:%v locally, forwarding request to the newtork", strmID)
-					forwarder.Stream(strmID, kademlia.Address(ethCommon.HexToHash("")), lpmsStream.HLS)
-				} else {
-					glog.Infof("Cannot find HLS stream:%v, returning 404", strmID)
-					return nil, ErrNotFound
-				}
-			} else {
-				// glog.Infof("Found HLS stream:%v locally", strmID)
-			}
+// waitPublishes waits until the publishes ended or ctx is done
+func (self *Server) waitPublishes(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		self.publishLock.Lock()
+		n := len(self.publishes)
+		self.publishLock.Unlock()
+		if n == 0 {
+			return nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			glog.Errorf("%d publishes did not end on Stop", n)
+			return ErrStopTimeout
+		}
+	}
+}
 
-			subID := "local"
-			hlsBuffer := streamer.GetHLSMuxer(strmID, subID)
-			if hlsBuffer == nil {
-				glog.Infof("Creating new HLS buffer")
-				hlsBuffer = lpmsStream.NewHLSBuffer(HLSBufferWindow, HLSBufferCap)
-				err := streamer.SubscribeToHLSStream(strmID, subID, hlsBuffer)
-				if err != nil {
-					glog.Errorf("Error subscribing to hls stream:%v", url.Path)
-					return nil, err
-				}
-			} else {
-				// glog.Infof("Found HLS buffer for %v", reqPath)
-			}
-			// glog.Infof("Buffer subscribed to local stream:%v ", strmID)
-
-			startTime := time.Now()
-			for {
-				// _, err := hlsBuffer.(*lpmsStream.HLSBuffer).GeneratePlaylist(0)
-				_, err := hlsBuffer.(*lpmsStream.HLSBuffer).LatestPlaylist()
-				if err == nil {
-					hlsSubTimer[streaming.StreamID(strmID)] = time.Now()
-					return hlsBuffer.(*lpmsStream.HLSBuffer).LatestPlaylist()
-				} else {
-					glog.Errorf("Error generating pl: %v", err)
-				}
-				time.Sleep(time.Second * 2) //Sleep for 2 seconds so the segments start to get to the buffer
-				if time.Since(startTime) > HLSWaitTime {
-					return nil, ErrNotFound
-				}
-			}
-		},
-		//getSegment
-		func(url *url.URL) ([]byte, error) {
-			strmID := parseStreamID(url.Path)
-			buftmp := streamer.GetHLSMuxer(strmID, "local")
-			if buftmp == nil {
-				return nil, ErrNotFound
-			}
+// endPublish ends the RTMP stream of a publish and the HLS stream it was
+// segmented into, so the subscribers get the trailer or an EOF
+func (self *Server) endPublish(rtmpStrmID streaming.StreamID) {
+	self.publishLock.Lock()
+	hlsStrmID, ok := self.publishes[rtmpStrmID]
+	delete(self.publishes, rtmpStrmID)
+	self.publishLock.Unlock()
+
+	self.streamer.EndStream(rtmpStrmID)
+	if self.scheduler != nil {
+		self.scheduler.CancelStream(rtmpStrmID.String())
+	}
+	if ok {
+		self.streamer.EndStream(hlsStrmID)
+		if self.scheduler != nil {
+			self.scheduler.CancelStream(hlsStrmID.String())
+		}
+	}
+	self.streamer.PostEvent(streaming.BroadcastEndedEvent{StreamID: rtmpStrmID})
+}
 
-			buf, ok := buftmp.(*lpmsStream.HLSBuffer)
-			if !ok {
-				return nil, ErrHLSPlay
+// hlsUnsubscribeLoop drops the local subscriptions of HLS streams that were
+// not played for limit
+func (self *Server) hlsUnsubscribeLoop(limit time.Duration) {
+	ticker := time.NewTicker(time.Second * 5)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-self.ctx.Done():
+			return
+		}
+		self.hlsLock.Lock()
+		for sid, t := range self.hlsSubTimer {
+			if time.Since(t) > limit {
+				self.streamer.UnsubscribeToHLSStream(sid.String(), "local")
+				self.forwarder.StopStream(sid.String(), kademlia.Address(ethCommon.HexToHash("")), lpmsStream.HLS) //This could fail if it's a local stream, but it's ok.
+				delete(self.hlsSubTimer, sid)
 			}
-			sn := parseSegName(url.Path)
-			return buf.WaitAndPopSegment(context.Background(), sn)
-		})
+		}
+		self.hlsLock.Unlock()
+	}
+}
+
+// setupHandlers registers the LPMS callbacks and the HTTP endpoints
+func (self *Server) setupHandlers() {
+	self.mux.HandleFunc("/stream/", self.handleLive)
+	self.mux.HandleFunc("/vod/", self.handleVOD)
 
-	server.HandleRTMPPublish(
+	listener := &vidlistener.VidListener{RtmpServer: self.rtmpHandlers, FfmpegPath: self.params.FFMpegPath}
+	listener.HandleRTMPPublish(
 		//makeStreamID
 		func(url *url.URL) (strmID string) {
 			rtmpStrmID := streaming.StreamID(parseStreamID(url.Path))
 			if rtmpStrmID == "" {
-				rtmpStrmID = streaming.MakeStreamID(streamer.SelfAddress, fmt.Sprintf("%x", streaming.RandomStreamID()))
+				rtmpStrmID = streaming.MakeStreamID(self.streamer.SelfAddress, fmt.Sprintf("%x", streaming.RandomStreamID()))
 			}
 			return rtmpStrmID.String()
 		},
 		//gotStream
 		func(url *url.URL, rtmpStrm *lpmsStream.VideoStream) (err error) {
+			// publishes that connected before Stop closed the listener are refused here
+			if self.ctx.Err() != nil {
+				return ErrStopping
+			}
 			rtmpStrmID := streaming.StreamID(rtmpStrm.GetStreamID())
 			nodeID, _ := rtmpStrmID.SplitComponents()
-			if nodeID != streamer.SelfAddress {
+			if nodeID != self.streamer.SelfAddress {
 				glog.Errorf("Invalid rtmp strmID - nodeID component needs to be self.")
 				return ErrStreamPublish
 			}
 
-			rtmpStream := streamer.GetNetworkStream(rtmpStrmID)
+			ladder, err := transcoding.ParseLadder(url.Query().Get(transcoding.ProfilesParam), self.profiles)
+			if err != nil {
+				glog.Errorf("Invalid transcoding profiles: %v", err)
+				return ErrStreamPublish
+			}
+
+			rtmpStream := self.streamer.GetNetworkStream(rtmpStrmID)
 			if rtmpStream == nil {
 				var rtmpErr error
-				rtmpStream, rtmpErr = streamer.AddNewNetworkStream(rtmpStrmID, lpmsStream.RTMP)
+				rtmpStream, rtmpErr = self.streamer.AddNewNetworkStream(rtmpStrmID, lpmsStream.RTMP)
 				if rtmpErr != nil {
 					glog.Errorf("Error when creating RTMP stream: %v", rtmpErr)
 					return ErrStreamPublish
@@ -165,78 +358,49 @@ func StartLPMS(rtmpPort string, httpPort string, streamer *streaming.Streamer, f
 
 			hlsStrmID := streaming.StreamID(url.Query().Get("hlsStrmID"))
 			if hlsStrmID == "" {
-				hlsStrmID = streaming.MakeStreamID(streamer.SelfAddress, fmt.Sprintf("%x", streaming.RandomStreamID()))
+				hlsStrmID = streaming.MakeStreamID(self.streamer.SelfAddress, fmt.Sprintf("%x", streaming.RandomStreamID()))
 			}
 			nodeID, _ = hlsStrmID.SplitComponents()
-			if nodeID != streamer.SelfAddress {
+			if nodeID != self.streamer.SelfAddress {
 				glog.Errorf("Invalid hlsStrmID - nodeID component needs to be self.")
 				return ErrStreamPublish
 			}
-			hlsStream, err := streamer.AddNewNetworkStream(hlsStrmID, lpmsStream.HLS)
+			hlsStream, err := self.streamer.AddNewNetworkStream(hlsStrmID, lpmsStream.HLS)
 			if err != nil {
 				glog.Errorf("Error when creating HLS stream: %v", err)
 				return ErrStreamPublish
 			}
 
+			self.publishLock.Lock()
+			self.publishes[rtmpStrmID] = hlsStrmID
+			self.publishLock.Unlock()
+
 			glog.Infof("RTMP streamID is %v", rtmpStream.GetStreamID())
 			glog.Infof("HLS streamID is %v", hlsStream.GetStreamID())
 
-			viz.LogBroadcast(rtmpStream.GetStreamID())
-			viz.LogBroadcast(hlsStream.GetStreamID())
+			self.viz.LogBroadcast(rtmpStream.GetStreamID())
+			self.viz.LogBroadcast(hlsStream.GetStreamID())
+			self.streamer.PostEvent(streaming.BroadcastStartedEvent{StreamID: rtmpStrmID, HLSStreamID: hlsStrmID})
+
+			if len(ladder) > 0 {
+				transcodeID := requestTranscode(self.forwarder, hlsStream.GetStreamID(), ladder)
+				glog.Infof("Requested transcoding of %v into %v (%x)", hlsStream.GetStreamID(), url.Query().Get(transcoding.ProfilesParam), transcodeID[:4])
+			}
 			return nil
 		},
 		//endStream
 		func(url *url.URL, rtmpStrm *lpmsStream.VideoStream) error {
-			glog.Infof("Finish Stream - canceling stream (need to implement handler for Done())")
-			streamer.DeleteNetworkStream(streaming.StreamID(rtmpStrm.GetStreamID()))
-			streamer.UnsubscribeAll(rtmpStrm.GetStreamID())
-
-			// streamer.DeleteNetworkStream(streaming.StreamID(hlsStrmID))
-			// streamer.UnsubscribeAll(hlsStrmID)
+			glog.Infof("Finish Stream - ending %v", rtmpStrm.GetStreamID())
+			self.endPublish(streaming.StreamID(rtmpStrm.GetStreamID()))
 			return nil
 		})
 
-	server.HandleRTMPPlay(
-		//getStream
-		func(url *url.URL) (lpmsStream.Stream, error) {
-			glog.Infof("Got req: ", url.Path)
-
-			var strmID string
-			regex, _ := regexp.Compile("\\/stream\\/([[:alpha:]]|\\d)*")
-			match := regex.FindString(url.Path)
-			if match != "" {
-				strmID = strings.Replace(match, "/stream/", "", -1)
-			}
-
-			if strmID == "" {
-				glog.Errorf("Cannot find stream for %v", url.Path)
-				return nil, errors.New("Stream Not Found")
-			}
-
-			// glog.Infof("Got RTMP streamID as %v", strmID)
-			viz.LogConsume(strmID)
-
-			strm := streamer.GetNetworkStream(streaming.StreamID(strmID))
-			if strm == nil {
-				//Send subscribe request
-				glog.Infof("No local RTMP stream found - forwarding request to the network")
-				forwarder.Stream(strmID, kademlia.Address(ethCommon.HexToHash("")), lpmsStream.RTMP)
-			}
-			q := pubsub.NewQueue()
-			subID := streaming.RandomStreamID().Str()
-
-			err := streamer.SubscribeToRTMPStream(strmID, subID, q)
-			if err != nil {
-				glog.Errorf("Error subscribing to stream %v", err)
-				return nil, err
-			}
-
-			return strm, nil
-		})
+	player := &vidplayer.VidPlayer{RtmpServer: self.rtmpHandlers, VodPath: self.params.VodPath}
+	player.HandleRTMPPlay(self.getRTMPStream)
 
-	http.HandleFunc("/createStream", func(w http.ResponseWriter, r *http.Request) {
-		strmID := streaming.MakeStreamID(streamer.SelfAddress, fmt.Sprintf("%x", streaming.RandomStreamID()))
-		newRTMPStream, _ := streamer.AddNewNetworkStream(strmID, lpmsStream.RTMP)
+	self.mux.HandleFunc("/createStream", func(w http.ResponseWriter, r *http.Request) {
+		strmID := streaming.MakeStreamID(self.streamer.SelfAddress, fmt.Sprintf("%x", streaming.RandomStreamID()))
+		newRTMPStream, _ := self.streamer.AddNewNetworkStream(strmID, lpmsStream.RTMP)
 		res := map[string]string{"streamID": newRTMPStream.GetStreamID()}
 
 		js, err := json.Marshal(res)
@@ -250,15 +414,15 @@ func StartLPMS(rtmpPort string, httpPort string, streamer *streaming.Streamer, f
 		w.Write(js)
 	})
 
-	http.HandleFunc("/localStreams", func(w http.ResponseWriter, r *http.Request) {
-		streams := streamer.GetAllNetworkStreams()
+	self.mux.HandleFunc("/localStreams", func(w http.ResponseWriter, r *http.Request) {
+		streams := self.streamer.GetAllNetworkStreams()
 		ret := make([]map[string]string, 0, len(streams))
-		for _, s := range streamer.GetAllNetworkStreams() {
+		for _, s := range self.streamer.GetAllNetworkStreams() {
 			sid := streaming.StreamID(s.GetStreamID())
 			nodeID, _ := sid.SplitComponents()
 			var source string
 
-			if nodeID == streamer.SelfAddress {
+			if nodeID == self.streamer.SelfAddress {
 				source = "local"
 			} else {
 				source = fmt.Sprintf("%v", nodeID)
@@ -281,8 +445,8 @@ func StartLPMS(rtmpPort string, httpPort string, streamer *streaming.Streamer, f
 		w.Write(js)
 	})
 
-	http.HandleFunc("/peersCount", func(w http.ResponseWriter, r *http.Request) {
-		c := hive.PeersCount()
+	self.mux.HandleFunc("/peersCount", func(w http.ResponseWriter, r *http.Request) {
+		c := self.hive.PeersCount()
 		ret := make(map[string]int)
 		ret["count"] = c
 
@@ -296,18 +460,279 @@ func StartLPMS(rtmpPort string, httpPort string, streamer *streaming.Streamer, f
 		w.Write(js)
 	})
 
-	http.HandleFunc("/streamerStatus", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte(streamer.CurrentStatus()))
+	self.mux.HandleFunc("/transcodeJobs", func(w http.ResponseWriter, r *http.Request) {
+		jobs := []transcoding.JobInfo{}
+		if self.scheduler != nil {
+			jobs = self.scheduler.Jobs()
+		}
+		js, err := json.Marshal(jobs)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(js)
+	})
+
+	self.mux.HandleFunc("/transcodeProfiles", func(w http.ResponseWriter, r *http.Request) {
+		js, err := json.Marshal(transcoding.SortedProfiles(self.profiles))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(js)
+	})
+
+	//transcode?streamID=...&profiles=P720p30fps4Mbps,P360p30fps700Kbps requests a ladder for an HLS stream
+	self.mux.HandleFunc("/transcode", func(w http.ResponseWriter, r *http.Request) {
+		strmID := r.URL.Query().Get("streamID")
+		if self.streamer.GetNetworkStream(streaming.StreamID(strmID)) == nil {
+			http.Error(w, "stream not found", http.StatusNotFound)
+			return
+		}
+		ladder, err := transcoding.ParseLadder(r.URL.Query().Get(transcoding.ProfilesParam), self.profiles)
+		if err == nil && len(ladder) == 0 {
+			err = errors.New("no profiles requested")
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		transcodeID := requestTranscode(self.forwarder, strmID, ladder)
+		js, err := json.Marshal(map[string]string{"transcodeID": transcodeID.Hex()})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(js)
+	})
+
+	self.mux.HandleFunc("/transcodedStreams", func(w http.ResponseWriter, r *http.Request) {
+		strmID := streaming.StreamID(r.URL.Query().Get("streamID"))
+		js, err := json.Marshal(self.streamdb.Transcoded(strmID))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(js)
+	})
+
+	self.mux.HandleFunc("/streamerStatus", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(self.streamer.CurrentStatus()))
 	})
 
 	fs := http.FileServer(http.Dir("static"))
 	fmt.Println("Serving static files from: ", fs)
-	http.Handle("/static/", http.StripPrefix("/static/", fs))
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	self.mux.Handle("/static/", http.StripPrefix("/static/", fs))
+	self.mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/static/broadcast.html", 301)
 	})
 
-	server.Start(context.Background())
+}
+
+// getRTMPStream returns the RTMP stream played at url, streams of other nodes
+// are requested from the network
+func (self *Server) getRTMPStream(url *url.URL) (lpmsStream.Stream, error) {
+	glog.Infof("Got req: ", url.Path)
+	if self.ctx.Err() != nil {
+		return nil, ErrStopping
+	}
+
+	var strmID string
+	regex, _ := regexp.Compile("\\/stream\\/([[:alpha:]]|\\d)*")
+	match := regex.FindString(url.Path)
+	if match != "" {
+		strmID = strings.Replace(match, "/stream/", "", -1)
+	}
+
+	if strmID == "" {
+		glog.Errorf("Cannot find stream for %v", url.Path)
+		return nil, errors.New("Stream Not Found")
+	}
+	if err := self.authorize(url, streaming.StreamID(strmID)); err != nil {
+		glog.Infof("Refusing to play %v: %v", strmID, err)
+		return nil, err
+	}
+
+	// glog.Infof("Got RTMP streamID as %v", strmID)
+	self.viz.LogConsume(strmID)
+
+	strm := self.streamer.GetNetworkStream(streaming.StreamID(strmID))
+	if strm == nil {
+		//Send subscribe request
+		glog.Infof("No local RTMP stream found - forwarding request to the network")
+		self.forwarder.Stream(strmID, kademlia.Address(ethCommon.HexToHash("")), lpmsStream.RTMP)
+	}
+	q := pubsub.NewQueue()
+	subID := streaming.RandomStreamID().Str()
+
+	err := self.streamer.SubscribeToRTMPStream(strmID, subID, q)
+	if err != nil {
+		glog.Errorf("Error subscribing to stream %v", err)
+		return nil, err
+	}
+
+	return strm, nil
+}
+
+// getMediaPlaylist returns the playlist of a live HLS stream, subscribing to
+// the stream and asking the network for it if it is not played here yet
+func (self *Server) getMediaPlaylist(url *url.URL) (*m3u8.MediaPlaylist, error) {
+	if self.ctx.Err() != nil {
+		return nil, ErrStopping
+	}
+	strmID := parseStreamID(url.Path)
+
+	//Validate the stream ID format
+	sid := streaming.StreamID(strmID)
+	nodeID, streamID := sid.SplitComponents()
+
+	if strmID == "" || streamID == "" {
+		glog.Errorf("Cannot find stream for %v", url.Path)
+		return nil, errors.New("Stream Not Found")
+	}
+	if err := self.authorize(url, sid); err != nil {
+		return nil, err
+	}
+
+	strm := self.streamer.GetNetworkStream(streaming.StreamID(strmID))
+	if strm == nil {
+		if self.streamer.SelfAddress != nodeID {
+			glog.Infof("Cannot find HLS stream:%v locally, forwarding request to the newtork", strmID)
+			self.forwarder.Stream(strmID, kademlia.Address(ethCommon.HexToHash("")), lpmsStream.HLS)
+		} else {
+			glog.Infof("Cannot find HLS stream:%v, returning 404", strmID)
+			return nil, ErrNotFound
+		}
+	} else {
+		// glog.Infof("Found HLS stream:%v locally", strmID)
+	}
+
+	subID := "local"
+	hlsBuffer := self.streamer.GetHLSMuxer(strmID, subID)
+	if hlsBuffer == nil {
+		glog.Infof("Creating new HLS buffer")
+		hlsBuffer = lpmsStream.NewHLSBuffer(HLSBufferWindow, HLSBufferCap)
+		err := self.streamer.SubscribeToHLSStream(strmID, subID, hlsBuffer)
+		if err != nil {
+			glog.Errorf("Error subscribing to hls stream:%v", url.Path)
+			return nil, err
+		}
+	} else {
+		// glog.Infof("Found HLS buffer for %v", reqPath)
+	}
+	// glog.Infof("Buffer subscribed to local stream:%v ", strmID)
+
+	startTime := time.Now()
+	for {
+		// _, err := hlsBuffer.(*lpmsStream.HLSBuffer).GeneratePlaylist(0)
+		_, err := hlsBuffer.(*lpmsStream.HLSBuffer).LatestPlaylist()
+		if err == nil {
+			self.hlsLock.Lock()
+			self.hlsSubTimer[streaming.StreamID(strmID)] = time.Now()
+			self.hlsLock.Unlock()
+			pl, err := hlsBuffer.(*lpmsStream.HLSBuffer).LatestPlaylist()
+			if err != nil || !self.params.RequireToken {
+				return pl, err
+			}
+			return withToken(pl, url.RawQuery)
+		} else {
+			glog.Errorf("Error generating pl: %v", err)
+		}
+		time.Sleep(time.Second * 2) //Sleep for 2 seconds so the segments start to get to the buffer
+		if time.Since(startTime) > HLSWaitTime {
+			return nil, ErrNotFound
+		}
+	}
+}
+
+// getSegment returns a segment of a live HLS stream that is played here
+func (self *Server) getSegment(url *url.URL) ([]byte, error) {
+	strmID := parseStreamID(url.Path)
+	if err := self.authorize(url, streaming.StreamID(strmID)); err != nil {
+		return nil, err
+	}
+	buftmp := self.streamer.GetHLSMuxer(strmID, "local")
+	if buftmp == nil {
+		return nil, ErrNotFound
+	}
+
+	buf, ok := buftmp.(*lpmsStream.HLSBuffer)
+	if !ok {
+		return nil, ErrHLSPlay
+	}
+	sn := parseSegName(url.Path)
+	return buf.WaitAndPopSegment(context.Background(), sn)
+}
+
+// authorize checks the playback token of a request to play strmID if tokens
+// are required.  The token is valid for its stream and the streams
+// transcoded from it.
+func (self *Server) authorize(u *url.URL, strmID streaming.StreamID) error {
+	if !self.params.RequireToken {
+		return nil
+	}
+	q := u.Query()
+	s := q.Get(streaming.TokenParam)
+	if s == "" {
+		return &HTTPError{Code: http.StatusUnauthorized, Err: streaming.ErrNoToken}
+	}
+	token, err := streaming.ParsePlaybackToken(s)
+	if err == nil {
+		err = token.Check(q.Get(streaming.ViewerParam))
+	}
+	if err == nil && token.StreamID != strmID && !self.transcodedFrom(strmID, token.StreamID) {
+		err = streaming.ErrTokenStream
+	}
+	if err != nil {
+		return &HTTPError{Code: http.StatusForbidden, Err: err}
+	}
+	return nil
+}
+
+func (self *Server) transcodedFrom(strmID, origID streaming.StreamID) bool {
+	for _, t := range self.streamdb.Transcoded(origID) {
+		if t.StreamID == strmID {
+			return true
+		}
+	}
+	return false
+}
+
+// withToken returns a copy of the playlist whose segment URIs carry the
+// query of the playlist request, so players pass the token on
+func withToken(pl *m3u8.MediaPlaylist, query string) (*m3u8.MediaPlaylist, error) {
+	out, err := m3u8.NewMediaPlaylist(0, pl.Count())
+	if err != nil {
+		return nil, err
+	}
+	out.SeqNo = pl.SeqNo
+	out.TargetDuration = pl.TargetDuration
+	for _, seg := range pl.Segments {
+		if seg == nil {
+			continue
+		}
+		if err := out.Append(seg.URI+"?"+query, seg.Duration, seg.Title); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// requestTranscode asks the network to transcode the HLS stream into the
+// ladder, the new streams show up in the StreamDB once a transcoder acks
+func requestTranscode(forwarder storage.CloudStore, strmID string, ladder []transcoding.Profile) ethCommon.Hash {
+	transcodeID := streaming.RandomStreamID()
+	go forwarder.Transcode(strmID, transcodeID, "", ladder)
+	return transcodeID
 }
 
 func parseStreamID(reqPath string) string {
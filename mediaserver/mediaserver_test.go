@@ -0,0 +1,230 @@
+package mediaserver
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/swarm/network/kademlia"
+	"github.com/livepeer/livepeer-swarm/livepeer/network"
+	"github.com/livepeer/livepeer-swarm/livepeer/storage"
+	"github.com/livepeer/livepeer-swarm/livepeer/streaming"
+	"github.com/livepeer/livepeer-swarm/livepeer/transcoding"
+	lpmsStream "github.com/livepeer/lpms/stream"
+	"github.com/nareix/joy4/av"
+	"github.com/nareix/joy4/codec/aacparser"
+	joy4rtmp "github.com/nareix/joy4/format/rtmp"
+)
+
+type testForwarder struct{}
+
+func (self *testForwarder) Store(*storage.Chunk)                                         {}
+func (self *testForwarder) Deliver(*storage.Chunk)                                       {}
+func (self *testForwarder) Retrieve(*storage.Chunk)                                      {}
+func (self *testForwarder) Stream(string, kademlia.Address, lpmsStream.VideoFormat)      {}
+func (self *testForwarder) StopStream(string, kademlia.Address, lpmsStream.VideoFormat)  {}
+func (self *testForwarder) Transcode(string, common.Hash, string, []transcoding.Profile) {}
+
+// testRecorder subscribes to an RTMP or HLS stream and records whether the
+// end of the stream was passed on
+type testRecorder struct {
+	lock  sync.Mutex
+	ended bool
+}
+
+func (self *testRecorder) WriteHeader([]av.CodecData) error                   { return nil }
+func (self *testRecorder) WritePacket(av.Packet) error                        { return nil }
+func (self *testRecorder) WriteSegment(uint64, string, float64, []byte) error { return nil }
+func (self *testRecorder) WriteTrailer() error                                { return self.end() }
+func (self *testRecorder) WriteEOF() error                                    { return self.end() }
+
+func (self *testRecorder) end() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.ended = true
+	return nil
+}
+
+func (self *testRecorder) isEnded() bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.ended
+}
+
+func newTestServer(params *ServerParams) *Server {
+	streamer, _ := streaming.NewStreamer(streaming.RandomStreamID())
+	return NewServer(params, streamer, &testForwarder{}, network.NewStreamDB(), nil, nil, nil, nil)
+}
+
+func freePort(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+}
+
+func TestServerRestart(t *testing.T) {
+	params := &ServerParams{RTMPPort: freePort(t), HTTPPort: freePort(t)}
+	for i := 0; i < 3; i++ {
+		s := newTestServer(params)
+		if err := s.Start(); err != nil {
+			t.Fatalf("start %d: %v", i, err)
+		}
+		if _, err := s.CheckListeners(); err != nil {
+			t.Fatalf("start %d: %v", i, err)
+		}
+		if err := s.Stop(); err != nil {
+			t.Fatalf("stop %d: %v", i, err)
+		}
+		for _, port := range []string{params.RTMPPort, params.HTTPPort} {
+			if conn, err := net.DialTimeout("tcp", "127.0.0.1:"+port, time.Second); err == nil {
+				conn.Close()
+				t.Errorf("stop %d: expected port %v to be closed", i, port)
+			}
+		}
+	}
+}
+
+func TestServersInOneProcess(t *testing.T) {
+	var servers []*Server
+	for i := 0; i < 2; i++ {
+		s := newTestServer(&ServerParams{RTMPPort: freePort(t), HTTPPort: freePort(t)})
+		if err := s.Start(); err != nil {
+			t.Fatal(err)
+		}
+		defer s.Stop()
+		servers = append(servers, s)
+	}
+	// each server serves its own streamer
+	resp, err := http.Get("http://127.0.0.1:" + servers[0].params.HTTPPort + "/createStream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	for i, exp := range []int{1, 0} {
+		if n := len(servers[i].streamer.GetAllNetworkStreams()); n != exp {
+			t.Errorf("server %d: expected %d streams, got %d", i, exp, n)
+		}
+	}
+	if err := servers[0].Start(); err != ErrStarted {
+		t.Errorf("expected ErrStarted, got %v", err)
+	}
+	taken := newTestServer(&ServerParams{RTMPPort: servers[0].params.RTMPPort})
+	if err := taken.Start(); err == nil {
+		taken.Stop()
+		t.Errorf("expected error starting on a port in use")
+	}
+}
+
+func TestStopEndsPublishes(t *testing.T) {
+	s := newTestServer(&ServerParams{RTMPPort: freePort(t), HTTPPort: freePort(t)})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	rtmpID := streaming.MakeStreamID(s.streamer.SelfAddress, "rtmp")
+	hlsID := streaming.MakeStreamID(s.streamer.SelfAddress, "hls")
+
+	conn, err := joy4rtmp.Dial("rtmp://127.0.0.1:" + s.params.RTMPPort + "/stream/" + rtmpID.String() + "?hlsStrmID=" + hlsID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	aac, err := aacparser.NewCodecDataFromMPEG4AudioConfigBytes([]byte{0x12, 0x10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteHeader([]av.CodecData{aac}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; ; i++ {
+		s.publishLock.Lock()
+		published := s.publishes[rtmpID] == hlsID
+		s.publishLock.Unlock()
+		if published {
+			break
+		}
+		if i == 100 {
+			t.Fatal("publish did not start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	player, recording := &testRecorder{}, &testRecorder{}
+	if err := s.streamer.SubscribeToRTMPStream(rtmpID.String(), "player", player); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.streamer.SubscribeToHLSStream(hlsID.String(), "recording", recording); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Stop(); err != nil {
+		t.Fatalf("expected the publish to end within StopTimeout, got %v", err)
+	}
+	if !player.isEnded() {
+		t.Errorf("expected the RTMP subscriber to get the trailer")
+	}
+	if !recording.isEnded() {
+		t.Errorf("expected the HLS subscriber to get an EOF")
+	}
+	for _, id := range []streaming.StreamID{rtmpID, hlsID} {
+		if s.streamer.GetNetworkStream(id) != nil {
+			t.Errorf("expected %v to be removed", id)
+		}
+	}
+}
+
+func TestPlaybackAuthorization(t *testing.T) {
+	prvKey, _ := crypto.GenerateKey()
+	owner := crypto.Sha3Hash(crypto.FromECDSAPub(&prvKey.PublicKey))
+	streamer, _ := streaming.NewStreamer(owner)
+	s := NewServer(&ServerParams{RequireToken: true}, streamer, &testForwarder{}, network.NewStreamDB(), nil, nil, nil, nil)
+	id := streaming.MakeStreamID(owner, "1234")
+	sign := func(strmID streaming.StreamID, ttl time.Duration) string {
+		token, err := streaming.NewPlaybackToken(strmID, ttl, "").Sign(prvKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return "?" + streaming.TokenParam + "=" + token
+	}
+
+	for _, test := range []struct {
+		name  string
+		query string
+		code  int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"expired token", sign(id, -time.Minute), http.StatusForbidden},
+		{"token of another stream", sign(streaming.MakeStreamID(owner, "5678"), time.Minute), http.StatusForbidden},
+		// the stream is not published
+		{"valid token", sign(id, time.Minute), http.StatusNotFound},
+	} {
+		for _, path := range []string{"/stream/" + id.String() + ".m3u8", "/stream/" + id.String() + "_1.ts"} {
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, httptest.NewRequest("GET", path+test.query, nil))
+			if w.Code != test.code {
+				t.Errorf("%v, %v: expected %d, got %d", test.name, path, test.code, w.Code)
+			}
+		}
+
+		u, _ := url.Parse(fmt.Sprintf("rtmp://127.0.0.1/stream/%v%v", id, test.query))
+		_, err := s.getRTMPStream(u)
+		herr, ok := err.(*HTTPError)
+		if test.code == http.StatusNotFound {
+			if ok {
+				t.Errorf("%v, RTMP: expected to be authorized, got %v", test.name, err)
+			}
+		} else if !ok || herr.Code != test.code {
+			t.Errorf("%v, RTMP: expected %d, got %v", test.name, test.code, err)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package mediaserver
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/logger/glog"
+	joy4rtmp "github.com/nareix/joy4/format/rtmp"
+)
+
+/*
+joy4's rtmp.Server binds its own listener and offers no way to close it, so a
+stopped media server would keep its RTMP port.  Server side connections are
+only set up inside ListenAndServe, so a Server cannot run joy4 on a listener of
+its own either, and one joy4 server per Server would leak a port on every Stop.
+
+Instead a single joy4 server on a loopback port handles the RTMP connections
+of all media servers in the process.  Each Server owns its public listener,
+which it closes on Stop, and proxies the accepted connections to the backend.
+The backend hands every connection to the publish and play handlers of the
+Server it came in on.  The handlers only get the URL of the connection, not the
+loopback address it came from.
+*/
+var backend = &rtmpBackend{routes: make(map[string]*joy4rtmp.Server)}
+
+// number of ports tried for the backend
+const backendStartAttempts = 3
+
+type rtmpBackend struct {
+	startLock sync.Mutex
+	addr      string // empty until started
+
+	lock   sync.Mutex
+	routes map[string]*joy4rtmp.Server // handlers by the local address of the proxy connection
+}
+
+// start starts the backend once and returns its address
+func (self *rtmpBackend) start() (string, error) {
+	self.startLock.Lock()
+	defer self.startLock.Unlock()
+	if self.addr != "" {
+		return self.addr, nil
+	}
+	var err error
+	// the port is picked before joy4 binds it, another process may take it
+	// in between
+	for i := 0; i < backendStartAttempts; i++ {
+		var addr string
+		if addr, err = self.listen(); err == nil {
+			glog.Infof("RTMP backend listening at %v", addr)
+			self.addr = addr
+			return addr, nil
+		}
+		glog.Errorf("Cannot start RTMP backend: %v", err)
+	}
+	return "", err
+}
+
+// listen starts a joy4 server on a free loopback port
+func (self *rtmpBackend) listen() (string, error) {
+	// joy4 does not tell which port it bound, so pick a free one first
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	server := &joy4rtmp.Server{
+		Addr: addr,
+		HandlePublish: func(conn *joy4rtmp.Conn) {
+			if h := self.route(conn); h != nil && h.HandlePublish != nil {
+				h.HandlePublish(conn)
+			}
+		},
+		HandlePlay: func(conn *joy4rtmp.Conn) {
+			if h := self.route(conn); h != nil && h.HandlePlay != nil {
+				h.HandlePlay(conn)
+			}
+		},
+	}
+	ec := make(chan error, 1)
+	go func() { ec <- server.ListenAndServe() }()
+	for i := 0; i < 100; i++ {
+		select {
+		case err := <-ec:
+			return "", fmt.Errorf("RTMP backend at %v: %v", addr, err)
+		default:
+		}
+		if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+			conn.Close()
+			// the dial may have reached whoever took the port, in which case
+			// joy4 fails to bind
+			select {
+			case err := <-ec:
+				return "", fmt.Errorf("RTMP backend at %v: %v", addr, err)
+			case <-time.After(10 * time.Millisecond):
+			}
+			return addr, nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return "", fmt.Errorf("RTMP backend did not start at %v", addr)
+}
+
+// route returns the handlers of the media server that proxied conn.  Connections
+// that did not come through a media server, e.g. from another local process
+// dialing the backend directly, get none and are closed by joy4.
+func (self *rtmpBackend) route(conn *joy4rtmp.Conn) *joy4rtmp.Server {
+	from := conn.NetConn().RemoteAddr().String()
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	h := self.routes[from]
+	if h == nil {
+		glog.Errorf("RTMP connection from %v does not belong to a media server", from)
+	}
+	return h
+}
+
+func (self *rtmpBackend) addRoute(from string, handlers *joy4rtmp.Server) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.routes[from] = handlers
+}
+
+func (self *rtmpBackend) removeRoute(from string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	delete(self.routes, from)
+}
+
+// rtmpListener accepts the RTMP connections of a Server and proxies them to
+// the backend
+type rtmpListener struct {
+	listener    net.Listener
+	handlers    *joy4rtmp.Server // HandlePublish and HandlePlay are set by lpms
+	backendAddr string
+
+	lock   sync.Mutex
+	conns  map[net.Conn]bool // open client and backend connections
+	closed bool
+}
+
+func listenRTMP(addr string, handlers *joy4rtmp.Server) (*rtmpListener, error) {
+	backendAddr, err := backend.start()
+	if err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	glog.Infof("Starting RTMP Server at %v", l.Addr())
+	return &rtmpListener{
+		listener:    l,
+		handlers:    handlers,
+		backendAddr: backendAddr,
+		conns:       make(map[net.Conn]bool),
+	}, nil
+}
+
+// serve accepts connections until the listener is closed
+func (self *rtmpListener) serve() error {
+	for {
+		conn, err := self.listener.Accept()
+		if err != nil {
+			return err
+		}
+		go self.proxy(conn)
+	}
+}
+
+func (self *rtmpListener) proxy(conn net.Conn) {
+	upstream, err := net.Dial("tcp", self.backendAddr)
+	if err != nil {
+		glog.Errorf("Cannot reach RTMP backend: %v", err)
+		conn.Close()
+		return
+	}
+	if !self.track(conn, upstream) {
+		conn.Close()
+		upstream.Close()
+		return
+	}
+	from := upstream.LocalAddr().String()
+	backend.addRoute(from, self.handlers)
+	defer func() {
+		backend.removeRoute(from)
+		self.untrack(conn, upstream)
+	}()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(upstream, conn)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, upstream)
+		done <- struct{}{}
+	}()
+	<-done
+	conn.Close()
+	upstream.Close()
+	<-done
+}
+
+// track registers open connections, it returns false once the listener is
+// closed
+func (self *rtmpListener) track(conns ...net.Conn) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.closed {
+		return false
+	}
+	for _, conn := range conns {
+		self.conns[conn] = true
+	}
+	return true
+}
+
+func (self *rtmpListener) untrack(conns ...net.Conn) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	for _, conn := range conns {
+		delete(self.conns, conn)
+	}
+}
+
+// Close closes the listener and all open connections, which ends the
+// publishes and plays running over them
+func (self *rtmpListener) Close() error {
+	self.lock.Lock()
+	self.closed = true
+	for conn := range self.conns {
+		conn.Close()
+	}
+	self.lock.Unlock()
+	return self.listener.Close()
+}